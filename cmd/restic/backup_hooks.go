@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/quinn/restic/internal/backend"
+	"github.com/quinn/restic/internal/errors"
+)
+
+// backupHooks runs the commands given with --pre-command, --post-command and
+// --failure-command.
+type backupHooks struct {
+	opts  BackupOptions
+	gopts GlobalOptions
+	paths []string
+}
+
+// env returns the environment for a hook command.
+func (h backupHooks) env(hook string, extra []string) []string {
+	env := append(os.Environ(),
+		"RESTIC_BACKUP_HOOK="+hook,
+		"RESTIC_REPOSITORY="+h.gopts.Repo,
+		"RESTIC_BACKUP_PATHS="+strings.Join(h.paths, "\n"),
+		"RESTIC_BACKUP_HOST="+h.opts.Host,
+		"RESTIC_BACKUP_TAGS="+strings.Join(h.opts.Tags, ","),
+		"RESTIC_BACKUP_DRY_RUN="+strconv.FormatBool(h.opts.DryRun),
+	)
+	return append(env, extra...)
+}
+
+// run runs command for hook, nothing is done if command is empty.
+func (h backupHooks) run(hook, command string, env []string) error {
+	if command == "" {
+		return nil
+	}
+
+	args, err := backend.SplitShellStrings(command)
+	if err != nil {
+		return errors.Fatalf("invalid %v command: %v", hook, err)
+	}
+	if len(args) == 0 {
+		return errors.Fatalf("%v command is empty", hook)
+	}
+
+	if !h.gopts.JSON {
+		Verbosef("running %v command %v\n", hook, command)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = h.env(hook, env)
+	cmd.Stdout = h.gopts.stdout
+	cmd.Stderr = h.gopts.stderr
+
+	// the output of the command must not be mixed with the JSON messages
+	if h.gopts.JSON {
+		cmd.Stdout = h.gopts.stderr
+	}
+
+	if err = cmd.Run(); err != nil {
+		return errors.Fatalf("%v command failed: %v", hook, err)
+	}
+
+	return nil
+}
+
+// failed runs the failure command for the error err. An error of the failure
+// command itself is only printed, err is what the backup returns.
+func (h backupHooks) failed(err error) {
+	herr := h.run("failure", h.opts.FailureCommand, []string{
+		"RESTIC_BACKUP_ERROR=" + err.Error(),
+	})
+	if herr != nil {
+		Warnf("%v\n", herr)
+	}
+}
+
+// failOnInterrupt registers a cleanup handler which runs the failure command
+// when restic is interrupted, e.g. by SIGINT, before the returned function has
+// been called.
+func (h backupHooks) failOnInterrupt() func() {
+	var once sync.Once
+
+	AddCleanupHandler(func() error {
+		once.Do(func() {
+			h.failed(errors.New("backup interrupted"))
+		})
+		return nil
+	})
+
+	return func() {
+		once.Do(func() {})
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/quinn/restic/internal/checker"
+	"github.com/quinn/restic/internal/errors"
+	"github.com/quinn/restic/internal/restic"
+)
+
+// Severities of the problems found by check. Errors mean that data is damaged
+// or missing, warnings and infos describe problems which do not affect any
+// snapshot.
+const (
+	checkError   = "error"
+	checkWarning = "warning"
+	checkInfo    = "info"
+)
+
+// Categories of the problems found by check.
+const (
+	checkIndexError       = "index_error"
+	checkOldIndexFormat   = "old_index_format"
+	checkDuplicatePack    = "duplicate_pack"
+	checkMissingPack      = "missing_pack"
+	checkOrphanedPack     = "orphaned_pack"
+	checkDamagedPack      = "damaged_pack"
+	checkDamagedBlob      = "damaged_blob"
+	checkBlobHashMismatch = "blob_hash_mismatch"
+	checkUnrepairablePack = "unrepairable_pack"
+	checkSnapshotError    = "snapshot_error"
+	checkTreeError        = "tree_error"
+	checkMetadataError    = "metadata_error"
+	checkInvalidSignature = "invalid_signature"
+	checkUnusedBlob       = "unused_blob"
+)
+
+// ErrCheckWarnings is returned by check if only warnings were found, restic
+// exits with status 4 in this case.
+var ErrCheckWarnings = errors.Fatal("check found warnings, but no errors")
+
+// checkProblem describes a problem found by check.
+type checkProblem struct {
+	MessageType string     `json:"message_type"` // "problem"
+	Category    string     `json:"category"`
+	Severity    string     `json:"severity"`
+	Pack        *restic.ID `json:"pack,omitempty"`
+	Index       *restic.ID `json:"index,omitempty"`
+	Snapshot    *restic.ID `json:"snapshot,omitempty"`
+	Tree        *restic.ID `json:"tree,omitempty"`
+	Blob        *restic.ID `json:"blob,omitempty"`
+	Path        string     `json:"path,omitempty"`
+	Message     string     `json:"message"`
+	Details     []string   `json:"details,omitempty"`
+}
+
+// checkSummary is printed at the end of check with --json.
+type checkSummary struct {
+	MessageType string         `json:"message_type"` // "summary"
+	Errors      int            `json:"errors"`
+	Warnings    int            `json:"warnings"`
+	Infos       int            `json:"infos"`
+	Problems    map[string]int `json:"problems"`
+	PacksRead   int            `json:"packs_read"`
+}
+
+// checkReporter prints the problems found by check, either as text or as one
+// JSON object per line.
+type checkReporter struct {
+	json    bool
+	summary checkSummary
+}
+
+func newCheckReporter(gopts GlobalOptions) *checkReporter {
+	return &checkReporter{
+		json: gopts.JSON,
+		summary: checkSummary{
+			MessageType: "summary",
+			Problems:    make(map[string]int),
+		},
+	}
+}
+
+func (r *checkReporter) printJSON(v interface{}) {
+	if err := json.NewEncoder(globalOptions.stdout).Encode(v); err != nil {
+		Warnf("unable to encode JSON: %v\n", err)
+	}
+}
+
+// verbosef prints a message, unless the output is JSON.
+func (r *checkReporter) verbosef(format string, args ...interface{}) {
+	if !r.json {
+		Verbosef(format, args...)
+	}
+}
+
+// printf prints a message, unless the output is JSON.
+func (r *checkReporter) printf(format string, args ...interface{}) {
+	if !r.json {
+		Printf(format, args...)
+	}
+}
+
+// record adds p to the summary and prints it as JSON if requested. It
+// returns false if p still needs to be printed as text.
+func (r *checkReporter) record(p checkProblem) bool {
+	p.MessageType = "problem"
+	r.summary.Problems[p.Category]++
+	switch p.Severity {
+	case checkError:
+		r.summary.Errors++
+	case checkWarning:
+		r.summary.Warnings++
+	default:
+		r.summary.Infos++
+	}
+
+	if r.json {
+		r.printJSON(p)
+	}
+	return r.json
+}
+
+// hint prints p, which is always shown even without --verbose, and records
+// it in the summary.
+func (r *checkReporter) hint(p checkProblem) {
+	if !r.record(p) {
+		Printf("%v\n", p.Message)
+	}
+}
+
+// report prints p and records it in the summary.
+func (r *checkReporter) report(p checkProblem) {
+	if r.record(p) {
+		return
+	}
+
+	switch {
+	case p.Severity == checkError && len(p.Details) > 0:
+		Warnf("%v:\n", p.Message)
+		for _, detail := range p.Details {
+			Warnf("  %v\n", detail)
+		}
+	case p.Severity == checkError:
+		Warnf("%v\n", p.Message)
+	default:
+		Verbosef("%v\n", p.Message)
+	}
+}
+
+// reportPackError reports an error returned by the checker for a pack.
+// Damaged and missing packs are reported with the given severity.
+func (r *checkReporter) reportPackError(err error, severity string) {
+	e, ok := errors.Cause(err).(checker.PackError)
+	if !ok {
+		r.report(checkProblem{Category: checkDamagedPack, Severity: severity, Message: err.Error()})
+		return
+	}
+
+	id := e.ID
+	switch {
+	case e.Orphaned:
+		r.report(checkProblem{Category: checkOrphanedPack, Severity: checkInfo, Pack: &id, Message: err.Error()})
+		return
+	case e.Missing:
+		r.report(checkProblem{Category: checkMissingPack, Severity: severity, Pack: &id, Message: err.Error()})
+		return
+	}
+
+	blobErrs, ok := errors.Cause(e.Err).(checker.BlobErrors)
+	if !ok {
+		r.report(checkProblem{Category: checkDamagedPack, Severity: severity, Pack: &id, Message: err.Error()})
+		return
+	}
+
+	for _, blobErr := range blobErrs {
+		blob := blobErr.ID
+		category := checkDamagedBlob
+		if blobErr.HashMismatch {
+			category = checkBlobHashMismatch
+		}
+		r.report(checkProblem{
+			Category: category,
+			Severity: severity,
+			Pack:     &id,
+			Blob:     &blob,
+			Message:  "pack " + id.Str() + ": " + blobErr.Error(),
+		})
+	}
+}
+
+// finish prints the summary for JSON output and returns the error check
+// exits with.
+func (r *checkReporter) finish() error {
+	if r.json {
+		r.printJSON(r.summary)
+	}
+
+	switch {
+	case r.summary.Errors > 0:
+		return errors.Fatal("repository contains errors")
+	case r.summary.Warnings > 0:
+		return ErrCheckWarnings
+	}
+
+	r.verbosef("no errors were found\n")
+	return nil
+}
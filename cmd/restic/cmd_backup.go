@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
@@ -18,6 +19,7 @@ import (
 	tomb "gopkg.in/tomb.v2"
 
 	"github.com/quinn/restic/internal/archiver"
+	"github.com/quinn/restic/internal/backend"
 	"github.com/quinn/restic/internal/debug"
 	"github.com/quinn/restic/internal/errors"
 	"github.com/quinn/restic/internal/fs"
@@ -36,6 +38,59 @@ var cmdBackup = &cobra.Command{
 The "backup" command creates a new snapshot and saves the files and directories
 given as the arguments.
 
+With --stdin-from-command name=command, the command is run and its output is
+saved as the file with the given name in the snapshot. The option can be
+specified multiple times to save the output of several commands in one
+snapshot, e.g. one dump per database. If any command exits with a non-zero
+status, no snapshot is created.
+
+With --tar FILE, the entries of the tar archive are saved in the snapshot with
+their original modes, owners, timestamps, symlinks, hard links and extended
+attributes, without extracting the archive. Use "--tar -" to read the archive
+from stdin. The entries are saved in the order in which they are stored, so the
+archive is read only once, gzip compressed archives are decompressed on the
+fly. A parent snapshot is only used if it is specified with --parent.
+
+With --dry-run, the files are scanned and compared to the parent snapshot as
+usual, and new and modified files are read and split into blobs to estimate how
+much data would be added to the repository. Nothing is written to the
+repository, not even a lock. Use -vv to list the new, modified and unchanged
+files.
+
+On Linux, files and directories with the nodump flag (chattr +d) are not
+saved, the other inode flags are saved with the files.
+
+With --skip-if-unchanged, no snapshot is saved if nothing has changed since the
+parent snapshot, i.e. the new snapshot would reference the same tree.
+
+The commands given with --pre-command, --post-command and --failure-command
+are run before the backup, after the backup and when the backup failed. This
+can be used to create and remove a file system snapshot, for example. The
+environment variables RESTIC_BACKUP_HOOK, RESTIC_REPOSITORY,
+RESTIC_BACKUP_PATHS, RESTIC_BACKUP_HOST, RESTIC_BACKUP_TAGS and
+RESTIC_BACKUP_DRY_RUN describe the backup. The post command additionally gets
+RESTIC_BACKUP_STATUS and RESTIC_SNAPSHOT_ID, the failure command gets
+RESTIC_BACKUP_ERROR. If the pre command fails, no backup is made. The failure
+command is also run when the backup is interrupted. With --json, the output of
+the commands is written to stderr.
+
+With --remap-path path=original, files below path are read from there but
+stored as if they were located below original, e.g. to back up a mounted file
+system snapshot under the original paths, so that the parent snapshot is found
+as usual. Exclude patterns are matched against the original paths.
+
+With --checkpoint-interval, the data saved so far is written to the repository
+together with the index at the given interval, and a checkpoint snapshot tagged
+"partial" is saved for it, replacing the previous checkpoint. If the backup is
+interrupted, the next backup of the same paths uses the checkpoint as the
+parent and does not upload the saved data again. The checkpoint is removed once
+a backup of the same paths has been completed. Snapshots tagged "partial" which
+are not checkpoints are never removed.
+
+With --error-log, the errors for all files and directories which could not be
+saved are written to the given file as JSON, one object per line, with the
+path, the failed operation, the error class and the error number.
+
 EXIT STATUS
 ===========
 
@@ -88,12 +143,24 @@ type BackupOptions struct {
 	ExcludeCaches       bool
 	Stdin               bool
 	StdinFilename       string
+	StdinCommands       []string
+	Tar                 string
 	Tags                []string
+	Labels              restic.LabelList
+	Description         string
 	Host                string
 	FilesFrom           []string
 	TimeStamp           string
 	WithAtime           bool
 	IgnoreInode         bool
+	DryRun              bool
+	SkipIfUnchanged     bool
+	PreCommand          string
+	PostCommand         string
+	FailureCommand      string
+	RemapPaths          []string
+	CheckpointInterval  time.Duration
+	ErrorLog            string
 }
 
 var backupOptions BackupOptions
@@ -115,7 +182,11 @@ func init() {
 	f.BoolVar(&backupOptions.ExcludeCaches, "exclude-caches", false, `excludes cache directories that are marked with a CACHEDIR.TAG file. See https://bford.info/cachedir/ for the Cache Directory Tagging Standard`)
 	f.BoolVar(&backupOptions.Stdin, "stdin", false, "read backup from stdin")
 	f.StringVar(&backupOptions.StdinFilename, "stdin-filename", "stdin", "`filename` to use when reading from stdin")
+	f.StringArrayVar(&backupOptions.StdinCommands, "stdin-from-command", nil, "save the output of a command as a file, takes `name=command` (can be specified multiple times)")
+	f.StringVar(&backupOptions.Tar, "tar", "", "save the entries of the tar archive `file` (use \"-\" to read it from stdin)")
 	f.StringArrayVar(&backupOptions.Tags, "tag", nil, "add a `tag` for the new snapshot (can be specified multiple times)")
+	f.Var(&backupOptions.Labels, "label", "add a label given as `key=value` to the new snapshot (can be specified multiple times)")
+	f.StringVar(&backupOptions.Description, "description", "", "set the `text` as the description of the new snapshot")
 
 	f.StringVarP(&backupOptions.Host, "host", "H", "", "set the `hostname` for the snapshot manually. To prevent an expensive rescan use the \"parent\" flag")
 	f.StringVar(&backupOptions.Host, "hostname", "", "set the `hostname` for the snapshot manually")
@@ -125,6 +196,14 @@ func init() {
 	f.StringVar(&backupOptions.TimeStamp, "time", "", "`time` of the backup (ex. '2012-11-01 22:08:41') (default: now)")
 	f.BoolVar(&backupOptions.WithAtime, "with-atime", false, "store the atime for all files and directories")
 	f.BoolVar(&backupOptions.IgnoreInode, "ignore-inode", false, "ignore inode number changes when checking for modified files")
+	f.BoolVarP(&backupOptions.DryRun, "dry-run", "n", false, "do not write anything to the repository, just report what would be done")
+	f.BoolVar(&backupOptions.SkipIfUnchanged, "skip-if-unchanged", false, "do not save a snapshot if nothing has changed since the parent snapshot")
+	f.StringVar(&backupOptions.PreCommand, "pre-command", "", "run `command` before the backup, the backup is aborted if it fails")
+	f.StringVar(&backupOptions.PostCommand, "post-command", "", "run `command` after the backup")
+	f.StringVar(&backupOptions.FailureCommand, "failure-command", "", "run `command` if the backup failed")
+	f.StringArrayVar(&backupOptions.RemapPaths, "remap-path", nil, "read files below `path=original` from path, but store them as original (can be specified multiple times)")
+	f.DurationVar(&backupOptions.CheckpointInterval, "checkpoint-interval", 0, "save a checkpoint snapshot of the data saved so far every `duration` (e.g. 30m), so that an interrupted backup can be resumed (default: disabled)")
+	f.StringVar(&backupOptions.ErrorLog, "error-log", "", "write the errors for all files which could not be saved to `file` as JSON, one per line")
 }
 
 // filterExisting returns a slice of all existing items, or an error if no
@@ -214,12 +293,163 @@ func (opts BackupOptions) Check(gopts GlobalOptions, args []string) error {
 		}
 	}
 
+	if len(opts.StdinCommands) > 0 {
+		if opts.Stdin {
+			return errors.Fatal("--stdin and --stdin-from-command cannot be used together")
+		}
+
+		if len(opts.FilesFrom) > 0 {
+			return errors.Fatal("--stdin-from-command and --files-from cannot be used together")
+		}
+
+		if len(args) > 0 {
+			return errors.Fatal("--stdin-from-command was specified and files/dirs were listed as arguments")
+		}
+
+		if _, err := parseStdinCommands(opts.StdinCommands); err != nil {
+			return err
+		}
+	}
+
+	if opts.Tar != "" {
+		if opts.Stdin || len(opts.StdinCommands) > 0 {
+			return errors.Fatal("--tar cannot be used together with --stdin or --stdin-from-command")
+		}
+
+		if len(opts.FilesFrom) > 0 {
+			return errors.Fatal("--tar and --files-from cannot be used together")
+		}
+
+		if len(args) > 0 {
+			return errors.Fatal("--tar was specified and files/dirs were listed as arguments")
+		}
+
+		if opts.Tar == "-" && gopts.password == "" {
+			return errors.Fatal("unable to read password from stdin when data is to be read from stdin, use --password-file or $RESTIC_PASSWORD")
+		}
+	}
+
+	if len(opts.RemapPaths) > 0 {
+		if opts.Stdin || len(opts.StdinCommands) > 0 || opts.Tar != "" {
+			return errors.Fatal("--remap-path cannot be used together with --stdin, --stdin-from-command or --tar")
+		}
+
+		if _, err := parsePathMappings(opts.RemapPaths); err != nil {
+			return err
+		}
+	}
+
+	for _, command := range []string{opts.PreCommand, opts.PostCommand, opts.FailureCommand} {
+		if command == "" {
+			continue
+		}
+		if _, err := backend.SplitShellStrings(command); err != nil {
+			return errors.Fatalf("invalid command %q: %v", command, err)
+		}
+	}
+
 	return nil
 }
 
+// openTarArchive opens the tar archive filename, "-" reads the archive from
+// stdin. Gzip compressed archives are decompressed while reading. The entries
+// are read in the order in which they are stored, so the archive is read only
+// once. The returned function closes the archive.
+func openTarArchive(filename string, modTime time.Time) (*fs.TarReader, func(), error) {
+	f := os.Stdin
+	if filename != "-" {
+		var err error
+		f, err = os.Open(filename)
+		if err != nil {
+			return nil, nil, errors.Fatalf("unable to open tar archive: %v", err)
+		}
+	}
+
+	rd := bufio.NewReader(f)
+	magic, err := rd.Peek(2)
+	if err != nil && err != io.EOF {
+		_ = f.Close()
+		return nil, nil, errors.Fatalf("unable to read tar archive: %v", err)
+	}
+
+	var src io.Reader = rd
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(rd)
+		if err != nil {
+			_ = f.Close()
+			return nil, nil, errors.Fatalf("unable to decompress tar archive: %v", err)
+		}
+		src = gz
+	}
+
+	return fs.NewTarReader(src, modTime), func() { _ = f.Close() }, nil
+}
+
+// parsePathMappings parses the mappings given as path=original for
+// --remap-path. The files below path are read from there, but stored as if
+// they were located below original.
+func parsePathMappings(specs []string) ([]fs.PathMapping, error) {
+	var mappings []fs.PathMapping
+	for _, spec := range specs {
+		pos := strings.Index(spec, "=")
+		if pos <= 0 || pos == len(spec)-1 {
+			return nil, errors.Fatalf("invalid path mapping %q, must be path=original", spec)
+		}
+
+		actual, err := filepath.Abs(spec[:pos])
+		if err != nil {
+			return nil, errors.Fatalf("invalid path mapping %q: %v", spec, err)
+		}
+		original, err := filepath.Abs(spec[pos+1:])
+		if err != nil {
+			return nil, errors.Fatalf("invalid path mapping %q: %v", spec, err)
+		}
+
+		mappings = append(mappings, fs.PathMapping{Original: original, Actual: actual})
+	}
+
+	return mappings, nil
+}
+
+// parseStdinCommands parses the commands given as name=command. The output of
+// each command is saved as the file name in the root directory of the
+// snapshot.
+func parseStdinCommands(specs []string) ([]fs.Command, error) {
+	var cmds []fs.Command
+	names := make(map[string]struct{})
+	for _, spec := range specs {
+		pos := strings.Index(spec, "=")
+		if pos <= 0 {
+			return nil, errors.Fatalf("invalid command %q, must be name=command", spec)
+		}
+
+		name, command := spec[:pos], spec[pos+1:]
+		if strings.Contains(name, "/") || name == "." || name == ".." {
+			return nil, errors.Fatalf("invalid file name %q for command", name)
+		}
+
+		if _, ok := names[name]; ok {
+			return nil, errors.Fatalf("file name %q is used for several commands", name)
+		}
+		names[name] = struct{}{}
+
+		args, err := backend.SplitShellStrings(command)
+		if err != nil {
+			return nil, errors.Fatalf("invalid command for %v: %v", name, err)
+		}
+		if len(args) == 0 {
+			return nil, errors.Fatalf("command for %v is empty", name)
+		}
+
+		cmds = append(cmds, fs.Command{Name: path.Join("/", name), Args: args})
+	}
+
+	return cmds, nil
+}
+
 // collectRejectByNameFuncs returns a list of all functions which may reject data
 // from being saved in a snapshot based on path only
-func collectRejectByNameFuncs(opts BackupOptions, repo *repository.Repository, targets []string) (fs []RejectByNameFunc, err error) {
+func collectRejectByNameFuncs(opts BackupOptions, repo *repository.Repository, remap fs.Remap) (fs []RejectByNameFunc, err error) {
 	// exclude restic cache
 	if repo.Cache != nil {
 		f, err := rejectResticCache(repo)
@@ -257,7 +487,10 @@ func collectRejectByNameFuncs(opts BackupOptions, repo *repository.Repository, t
 			return nil, err
 		}
 
-		fs = append(fs, f)
+		// look for the file where the directory is actually read from
+		fs = append(fs, func(item string) bool {
+			return f(remap.Actual(item))
+		})
 	}
 
 	return fs, nil
@@ -265,14 +498,29 @@ func collectRejectByNameFuncs(opts BackupOptions, repo *repository.Repository, t
 
 // collectRejectFuncs returns a list of all functions which may reject data
 // from being saved in a snapshot based on path and file info
-func collectRejectFuncs(opts BackupOptions, repo *repository.Repository, targets []string) (fs []RejectFunc, err error) {
+func collectRejectFuncs(opts BackupOptions, repo *repository.Repository, remap fs.Remap, targets []string) (fs []RejectFunc, err error) {
 	// allowed devices
-	if opts.ExcludeOtherFS && !opts.Stdin {
-		f, err := rejectByDevice(targets)
+	if opts.ExcludeOtherFS && !opts.Stdin && len(opts.StdinCommands) == 0 && opts.Tar == "" {
+		actualTargets := make([]string, 0, len(targets))
+		for _, target := range targets {
+			actualTargets = append(actualTargets, remap.Actual(target))
+		}
+
+		f, err := rejectByDevice(actualTargets)
 		if err != nil {
 			return nil, err
 		}
-		fs = append(fs, f)
+		fs = append(fs, func(item string, fi os.FileInfo) bool {
+			return f(remap.Actual(item), fi)
+		})
+	}
+
+	// files and directories marked with the nodump flag
+	if !opts.Stdin && len(opts.StdinCommands) == 0 && opts.Tar == "" {
+		f := rejectNoDump()
+		fs = append(fs, func(item string, fi os.FileInfo) bool {
+			return f(remap.Actual(item), fi)
+		})
 	}
 
 	return fs, nil
@@ -327,7 +575,7 @@ func readExcludePatternsFromFiles(excludeFiles []string) ([]string, error) {
 
 // collectTargets returns a list of target files/dirs from several sources.
 func collectTargets(opts BackupOptions, args []string) (targets []string, err error) {
-	if opts.Stdin {
+	if opts.Stdin || len(opts.StdinCommands) > 0 || opts.Tar != "" {
 		return nil, nil
 	}
 
@@ -382,9 +630,10 @@ func findParentSnapshot(ctx context.Context, repo restic.Repository, opts Backup
 		parentID = &id
 	}
 
-	// Find last snapshot to set it as parent, if not already set
-	if !opts.Force && parentID == nil {
-		id, err := restic.FindLatestSnapshot(ctx, repo, targets, []restic.TagList{}, []string{opts.Host})
+	// Find last snapshot to set it as parent, if not already set. The paths
+	// of a tar archive are only known once it has been read.
+	if !opts.Force && parentID == nil && opts.Tar == "" {
+		id, err := restic.FindLatestSnapshot(ctx, repo, targets, []restic.TagList{}, nil, []string{opts.Host})
 		if err == nil {
 			parentID = &id
 		} else if err != restic.ErrNoSnapshotFound {
@@ -395,23 +644,111 @@ func findParentSnapshot(ctx context.Context, repo restic.Repository, opts Backup
 	return parentID, nil
 }
 
+// runBackup runs the pre command, creates the snapshot and runs the post or
+// failure command afterwards.
 func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Terminal, args []string) error {
 	err := opts.Check(gopts, args)
 	if err != nil {
 		return err
 	}
 
+	hooks := backupHooks{opts: opts, gopts: gopts, paths: args}
+
+	if err = hooks.run("pre", opts.PreCommand, nil); err != nil {
+		hooks.failed(err)
+		return err
+	}
+
+	finished := hooks.failOnInterrupt()
+	id, err := createSnapshot(opts, gopts, term, args)
+	finished()
+	if err != nil && err != InvalidSourceData {
+		hooks.failed(err)
+		return err
+	}
+
+	status := "success"
+	if err == InvalidSourceData {
+		status = "incomplete"
+	}
+
+	snapshotID := ""
+	if !id.IsNull() {
+		snapshotID = id.String()
+	}
+
+	herr := hooks.run("post", opts.PostCommand, []string{
+		"RESTIC_BACKUP_STATUS=" + status,
+		"RESTIC_SNAPSHOT_ID=" + snapshotID,
+	})
+	if herr != nil {
+		return herr
+	}
+
+	return err
+}
+
+// createSnapshot runs the backup and returns the ID of the new snapshot. The ID
+// is null if no snapshot was saved, because of --dry-run or
+// --skip-if-unchanged.
+func createSnapshot(opts BackupOptions, gopts GlobalOptions, term *termstatus.Terminal, args []string) (restic.ID, error) {
 	targets, err := collectTargets(opts, args)
 	if err != nil {
-		return err
+		return restic.ID{}, err
+	}
+
+	// the targets are saved under their original paths, but all files are
+	// read from the actual paths
+	mappings, err := parsePathMappings(opts.RemapPaths)
+	if err != nil {
+		return restic.ID{}, err
+	}
+	remap := fs.Remap{FS: fs.Local{}, Mappings: mappings}
+	if len(mappings) > 0 {
+		for i, target := range targets {
+			abs, err := filepath.Abs(target)
+			if err != nil {
+				return restic.ID{}, errors.Fatalf("unable to resolve %v: %v", target, err)
+			}
+			targets[i] = remap.Original(abs)
+		}
 	}
 
 	timeStamp := time.Now()
 	if opts.TimeStamp != "" {
 		timeStamp, err = time.ParseInLocation(TimeFormat, opts.TimeStamp, time.Local)
 		if err != nil {
-			return errors.Fatalf("error in time option: %v\n", err)
+			return restic.ID{}, errors.Fatalf("error in time option: %v\n", err)
+		}
+	}
+
+	signKey, err := signingKey(gopts)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	var errorLog *ui.ErrorLog
+	if opts.ErrorLog != "" {
+		f, err := os.Create(opts.ErrorLog)
+		if err != nil {
+			return restic.ID{}, errors.Fatalf("unable to create error log: %v", err)
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				Warnf("unable to close error log: %v\n", err)
+			}
+		}()
+		errorLog = ui.NewErrorLog(f)
+	}
+
+	var tarFS *fs.TarReader
+	if opts.Tar != "" {
+		var closeTar func()
+		tarFS, closeTar, err = openTarArchive(opts.Tar, timeStamp)
+		if err != nil {
+			return restic.ID{}, err
 		}
+		defer closeTar()
 	}
 
 	var t tomb.Tomb
@@ -422,7 +759,7 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 
 	repo, err := OpenRepository(gopts)
 	if err != nil {
-		return err
+		return restic.ID{}, err
 	}
 
 	type ArchiveProgressReporter interface {
@@ -434,7 +771,8 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		SetMinUpdatePause(d time.Duration)
 		Run(ctx context.Context) error
 		Error(item string, fi os.FileInfo, err error) error
-		Finish(snapshotID restic.ID)
+		Finish(snapshotID restic.ID, skipped bool)
+		SetDryRun()
 
 		// ui.StdioWrapper
 		Stdout() io.WriteCloser
@@ -473,25 +811,30 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 
 	t.Go(func() error { return p.Run(t.Context(gopts.ctx)) })
 
-	if !gopts.JSON {
-		p.V("lock repository")
-	}
-	lock, err := lockRepo(repo)
-	defer unlockRepo(lock)
-	if err != nil {
-		return err
+	if opts.DryRun {
+		repo.SetDryRun()
+		p.SetDryRun()
+	} else {
+		if !gopts.JSON {
+			p.V("lock repository")
+		}
+		lock, err := lockRepo(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return restic.ID{}, err
+		}
 	}
 
 	// rejectByNameFuncs collect functions that can reject items from the backup based on path only
-	rejectByNameFuncs, err := collectRejectByNameFuncs(opts, repo, targets)
+	rejectByNameFuncs, err := collectRejectByNameFuncs(opts, repo, remap)
 	if err != nil {
-		return err
+		return restic.ID{}, err
 	}
 
 	// rejectFuncs collect functions that can reject items from the backup based on path and file info
-	rejectFuncs, err := collectRejectFuncs(opts, repo, targets)
+	rejectFuncs, err := collectRejectFuncs(opts, repo, remap, targets)
 	if err != nil {
-		return err
+		return restic.ID{}, err
 	}
 
 	if !gopts.JSON {
@@ -499,12 +842,12 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	}
 	err = repo.LoadIndex(gopts.ctx)
 	if err != nil {
-		return err
+		return restic.ID{}, err
 	}
 
 	parentSnapshotID, err := findParentSnapshot(gopts.ctx, repo, opts, targets)
 	if err != nil {
-		return err
+		return restic.ID{}, err
 	}
 
 	if !gopts.JSON && parentSnapshotID != nil {
@@ -530,6 +873,9 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	}
 
 	var targetFS fs.FS = fs.Local{}
+	if len(mappings) > 0 {
+		targetFS = remap
+	}
 	if opts.Stdin {
 		if !gopts.JSON {
 			p.V("read data from stdin")
@@ -544,16 +890,46 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 		targets = []string{filename}
 	}
 
-	sc := archiver.NewScanner(targetFS)
-	sc.SelectByName = selectByNameFilter
-	sc.Select = selectFilter
-	sc.Error = p.ScannerError
-	sc.Result = p.ReportTotal
+	if len(opts.StdinCommands) > 0 {
+		cmds, err := parseStdinCommands(opts.StdinCommands)
+		if err != nil {
+			return restic.ID{}, err
+		}
+
+		if !gopts.JSON {
+			p.V("read data from %d commands", len(cmds))
+		}
+		targetFS = &fs.CommandReader{
+			Commands: cmds,
+			ModTime:  timeStamp,
+			Mode:     0644,
+			Stderr:   gopts.stderr,
+		}
+		targets = nil
+		for _, cmd := range cmds {
+			targets = append(targets, cmd.Name)
+		}
+	}
 
-	if !gopts.JSON {
-		p.V("start scan on %v", targets)
+	if tarFS != nil {
+		if !gopts.JSON {
+			p.V("read data from tar archive %v", opts.Tar)
+		}
+		targetFS = tarFS
+	} else {
+		// the entries of a tar archive can only be read once, so the total
+		// is not known in advance
+		sc := archiver.NewScanner(targetFS)
+		sc.SelectByName = selectByNameFilter
+		sc.Select = selectFilter
+		sc.Error = p.ScannerError
+		sc.Result = p.ReportTotal
+
+		if !gopts.JSON {
+			p.V("start scan on %v", targets)
+		}
+		t.Go(func() error { return sc.Scan(t.Context(gopts.ctx), targets) })
 	}
-	t.Go(func() error { return sc.Scan(t.Context(gopts.ctx), targets) })
 
 	arch := archiver.New(repo, targetFS, archiver.Options{})
 	arch.SelectByName = selectByNameFilter
@@ -562,6 +938,16 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	success := true
 	arch.Error = func(item string, fi os.FileInfo, err error) error {
 		success = false
+		if errorLog != nil {
+			if lerr := errorLog.Add(item, "archival", err); lerr != nil {
+				p.E("unable to write to error log: %v\n", lerr)
+			}
+		}
+		// the output of a failed command is incomplete, so the snapshot
+		// must not be saved
+		if _, ok := errors.Cause(err).(*fs.CommandError); ok {
+			return err
+		}
 		return p.Error(item, fi, err)
 	}
 	arch.CompleteItem = p.CompleteItem
@@ -574,19 +960,34 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	}
 
 	snapshotOpts := archiver.SnapshotOptions{
-		Excludes:       opts.Excludes,
-		Tags:           opts.Tags,
-		Time:           timeStamp,
-		Hostname:       opts.Host,
-		ParentSnapshot: *parentSnapshotID,
-	}
-
-	if !gopts.JSON {
-		p.V("start backup on %v", targets)
+		Excludes:        opts.Excludes,
+		Tags:            opts.Tags,
+		Labels:          opts.Labels.Map(),
+		Description:     opts.Description,
+		Time:            timeStamp,
+		Hostname:        opts.Host,
+		ParentSnapshot:  *parentSnapshotID,
+		SkipIfUnchanged: opts.SkipIfUnchanged,
+		SigningKey:      signKey,
+	}
+
+	// checkpoints are of no use if nothing is written to the repository
+	if !opts.DryRun {
+		snapshotOpts.CheckpointInterval = opts.CheckpointInterval
+	}
+
+	var sn *restic.Snapshot
+	var id restic.ID
+	if tarFS != nil {
+		sn, id, err = arch.SnapshotTar(gopts.ctx, snapshotOpts)
+	} else {
+		if !gopts.JSON {
+			p.V("start backup on %v", targets)
+		}
+		sn, id, err = arch.Snapshot(gopts.ctx, targets, snapshotOpts)
 	}
-	_, id, err := arch.Snapshot(gopts.ctx, targets, snapshotOpts)
 	if err != nil {
-		return errors.Fatalf("unable to save snapshot: %v", err)
+		return restic.ID{}, errors.Fatalf("unable to save snapshot: %v", err)
 	}
 
 	// cleanly shutdown all running goroutines
@@ -595,15 +996,27 @@ func runBackup(opts BackupOptions, gopts GlobalOptions, term *termstatus.Termina
 	// let's see if one returned an error
 	err = t.Wait()
 
-	// Report finished execution
-	p.Finish(id)
-	if !gopts.JSON {
+	// Report finished execution, no snapshot is returned if it was skipped
+	// because nothing changed
+	skipped := sn == nil
+	p.Finish(id, skipped)
+	if !gopts.JSON && !opts.DryRun && !skipped {
 		p.P("snapshot %s saved\n", id.Str())
+
+		if repo.Config().Padding {
+			padding, total := repo.PaddingOverhead()
+			p.V("padding overhead: %s of %s added to the repo (%s)\n",
+				formatBytes(padding), formatBytes(total), formatPercent(padding, total))
+		}
+	}
+	if opts.DryRun {
+		id = restic.ID{}
 	}
+
 	if !success {
-		return InvalidSourceData
+		return id, InvalidSourceData
 	}
 
 	// Return error if any
-	return err
+	return id, err
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 
 	"github.com/spf13/cobra"
@@ -82,6 +83,20 @@ func runCat(gopts GlobalOptions, args []string) error {
 			return err
 		}
 
+		// print binary indexes as JSON
+		if repository.IsBinaryIndex(buf) {
+			idx, err := repository.DecodeBinaryIndex(buf)
+			if err != nil {
+				return err
+			}
+
+			wr := bytes.NewBuffer(nil)
+			if err = idx.Encode(wr); err != nil {
+				return err
+			}
+			buf = bytes.TrimSpace(wr.Bytes())
+		}
+
 		Println(string(buf))
 		return nil
 	case "snapshot":
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"crypto/ed25519"
 	"fmt"
 	"io/ioutil"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -12,7 +14,9 @@ import (
 	"github.com/quinn/restic/internal/checker"
 	"github.com/quinn/restic/internal/errors"
 	"github.com/quinn/restic/internal/fs"
+	"github.com/quinn/restic/internal/repository"
 	"github.com/quinn/restic/internal/restic"
+	"github.com/quinn/restic/internal/ui/table"
 )
 
 var cmdCheck = &cobra.Command{
@@ -25,17 +29,42 @@ finds. It can also be used to read all data and therefore simulate a restore.
 By default, the "check" command will always load all data directly from the
 repository and not use a local cache.
 
+If parity files have been written for the repository (see the "parity"
+migration), the option --repair-packs reconstructs damaged or missing packs
+from the parity files of their group.
+
+If a trusted keys file is specified (--trusted-keys), the signatures of all
+snapshots are verified as well. Snapshots which are not signed by a trusted
+key are reported as errors.
+
+The option --check-metadata additionally verifies the nodes in all snapshots:
+the size of each file must match its content, hardlinked files must have the
+same content and the names within a directory must be unique and sorted.
+Problems are reported with the snapshot and the path of the node.
+
+The option --read-data-budget reads the packs which were verified least
+recently, up to the given amount of data, either as an absolute size or as a
+percentage of the repository. The time each pack was verified is recorded in
+the repository, unless --no-lock is given. Running "check" regularly with this
+option eventually verifies all data in the repository. Afterwards, a summary of
+how long ago the packs were verified is printed.
+
+With --json, each problem is printed as a JSON object on a separate line,
+followed by a summary with the number of problems per category.
+
 EXIT STATUS
 ===========
 
-Exit status is 0 if the command was successful, and non-zero if there was any error.
+Exit status is 0 if no problems were found, 1 if the repository contains
+errors or the check failed, and 4 if only warnings (such as unused blobs)
+were found.
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return runCheck(checkOptions, globalOptions, args)
 	},
 	PreRunE: func(cmd *cobra.Command, args []string) error {
-		return checkFlags(checkOptions)
+		return checkFlags(checkOptions, globalOptions)
 	},
 }
 
@@ -43,8 +72,11 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 type CheckOptions struct {
 	ReadData       bool
 	ReadDataSubset string
+	ReadDataBudget string
 	CheckUnused    bool
+	CheckMetadata  bool
 	WithCache      bool
+	RepairPacks    bool
 }
 
 var checkOptions CheckOptions
@@ -55,14 +87,28 @@ func init() {
 	f := cmdCheck.Flags()
 	f.BoolVar(&checkOptions.ReadData, "read-data", false, "read all data blobs")
 	f.StringVar(&checkOptions.ReadDataSubset, "read-data-subset", "", "read subset n of m data packs (format: `n/m`)")
+	f.StringVar(&checkOptions.ReadDataBudget, "read-data-budget", "", "read the least recently verified data packs up to `budget` (e.g. 10% or 5G)")
 	f.BoolVar(&checkOptions.CheckUnused, "check-unused", false, "find unused blobs")
+	f.BoolVar(&checkOptions.CheckMetadata, "check-metadata", false, "check the sizes, hardlinks and names of the files in all snapshots")
 	f.BoolVar(&checkOptions.WithCache, "with-cache", false, "use the cache")
+	f.BoolVar(&checkOptions.RepairPacks, "repair-packs", false, "repair damaged and missing packs using parity files")
 }
 
-func checkFlags(opts CheckOptions) error {
+func checkFlags(opts CheckOptions, gopts GlobalOptions) error {
 	if opts.ReadData && opts.ReadDataSubset != "" {
 		return errors.Fatalf("check flags --read-data and --read-data-subset cannot be used together")
 	}
+	if opts.ReadDataBudget != "" {
+		if opts.ReadData || opts.ReadDataSubset != "" {
+			return errors.Fatalf("check flag --read-data-budget cannot be used together with --read-data or --read-data-subset")
+		}
+		if _, _, err := parseReadDataBudget(opts.ReadDataBudget); err != nil {
+			return err
+		}
+	}
+	if opts.RepairPacks && gopts.NoLock {
+		return errors.Fatal("check flag --repair-packs cannot be used together with --no-lock")
+	}
 	if opts.ReadDataSubset != "" {
 		dataSubset, err := stringToIntSlice(opts.ReadDataSubset)
 		if err != nil || len(dataSubset) != 2 {
@@ -79,6 +125,106 @@ func checkFlags(opts CheckOptions) error {
 	return nil
 }
 
+// parseReadDataBudget parses the value of --read-data-budget, which is either a
+// percentage or a size. For a size, percent is negative.
+func parseReadDataBudget(s string) (percent float64, size uint64, err error) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || p <= 0 || p > 100 {
+			return 0, 0, errors.Fatalf("invalid percentage %q passed for --read-data-budget", s)
+		}
+		return p, 0, nil
+	}
+
+	size, err = parseSizeStr(s)
+	if err != nil || size == 0 {
+		return 0, 0, errors.Fatalf("invalid value %q passed for --read-data-budget", s)
+	}
+	return -1, size, nil
+}
+
+// selectPacksByAge returns the packs which were verified least recently and
+// whose total size does not exceed budget. Packs which were never verified come
+// first. At least one pack is selected, even if it is larger than budget.
+func selectPacksByAge(packs map[restic.ID]int64, verified map[restic.ID]time.Time, budget uint64) restic.IDSet {
+	ids := make(restic.IDs, 0, len(packs))
+	for id := range packs {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		ti, tj := verified[ids[i]], verified[ids[j]]
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return ids[i].String() < ids[j].String()
+	})
+
+	selected := restic.NewIDSet()
+	var total uint64
+	for _, id := range ids {
+		size := uint64(packs[id])
+		if len(selected) > 0 && total+size > budget {
+			break
+		}
+		selected.Insert(id)
+		total += size
+	}
+
+	return selected
+}
+
+// printVerificationAge prints how long ago the packs were verified.
+func printVerificationAge(packs map[restic.ID]int64, verified map[restic.ID]time.Time, now time.Time) error {
+	type ageGroup struct {
+		Age   string
+		Packs int
+		bytes uint64
+		Size  string
+	}
+
+	groups := []ageGroup{
+		{Age: "less than a day"},
+		{Age: "less than a week"},
+		{Age: "less than 30 days"},
+		{Age: "30 days or more"},
+		{Age: "never"},
+	}
+	for id, size := range packs {
+		t, ok := verified[id]
+		age := now.Sub(t)
+
+		var i int
+		switch {
+		case !ok:
+			i = 4
+		case age < 24*time.Hour:
+			i = 0
+		case age < 7*24*time.Hour:
+			i = 1
+		case age < 30*24*time.Hour:
+			i = 2
+		default:
+			i = 3
+		}
+
+		groups[i].Packs++
+		groups[i].bytes += uint64(size)
+	}
+
+	tab := table.New()
+	tab.AddColumn("Verified", "{{ .Age }}")
+	tab.AddColumn("Packs", "{{ .Packs }}")
+	tab.AddColumn("Size", "{{ .Size }}")
+	for _, g := range groups {
+		g.Size = formatBytes(g.bytes)
+		tab.AddRow(g)
+	}
+
+	return tab.Write(globalOptions.stdout)
+}
+
 // See doReadData in runCheck below for why this is 256.
 const totalBucketsMax = 256
 
@@ -100,7 +246,7 @@ func stringToIntSlice(param string) (split []uint, err error) {
 }
 
 func newReadProgress(gopts GlobalOptions, todo restic.Stat) *restic.Progress {
-	if gopts.Quiet {
+	if gopts.Quiet || gopts.JSON {
 		return nil
 	}
 
@@ -176,6 +322,11 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("check has no arguments")
 	}
 
+	trusted, verifySignatures, err := trustedPublicKeys(gopts)
+	if err != nil {
+		return err
+	}
+
 	cleanup := prepareCheckCache(opts, &gopts)
 	AddCleanupHandler(func() error {
 		cleanup()
@@ -187,8 +338,10 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	r := newCheckReporter(gopts)
+
 	if !gopts.NoLock {
-		Verbosef("create exclusive lock for repository\n")
+		r.verbosef("create exclusive lock for repository\n")
 		lock, err := lockRepoExclusive(repo)
 		defer unlockRepo(lock)
 		if err != nil {
@@ -197,70 +350,113 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 	}
 
 	chkr := checker.New(repo)
+	if gopts.DiskIndex || gopts.IndexMemoryLimit > 0 {
+		chkr.UseDiskIndex(diskIndexSettings(gopts, repo))
+	}
 
-	Verbosef("load indexes\n")
+	r.verbosef("load indexes\n")
 	hints, errs := chkr.LoadIndex(gopts.ctx)
 
 	dupFound := false
 	for _, hint := range hints {
-		Printf("%v\n", hint)
-		if _, ok := hint.(checker.ErrDuplicatePacks); ok {
+		switch h := hint.(type) {
+		case checker.ErrDuplicatePacks:
 			dupFound = true
+			id := h.PackID
+			r.hint(checkProblem{Category: checkDuplicatePack, Severity: checkInfo, Pack: &id, Message: hint.Error()})
+		case checker.ErrOldIndexFormat:
+			id := h.ID
+			r.hint(checkProblem{Category: checkOldIndexFormat, Severity: checkInfo, Index: &id, Message: hint.Error()})
+		default:
+			r.hint(checkProblem{Category: checkIndexError, Severity: checkInfo, Message: hint.Error()})
 		}
 	}
 
 	if dupFound {
-		Printf("This is non-critical, you can run `restic rebuild-index' to correct this\n")
+		r.printf("This is non-critical, you can run `restic rebuild-index' to correct this\n")
 	}
 
 	if len(errs) > 0 {
 		for _, err := range errs {
-			Warnf("error: %v\n", err)
+			r.report(checkProblem{Category: checkIndexError, Severity: checkError, Message: "error: " + err.Error()})
 		}
+		_ = r.finish()
 		return errors.Fatal("LoadIndex returned errors")
 	}
 
-	errorsFound := false
 	orphanedPacks := 0
+	markedPacks := 0
+	damagedPacks := restic.NewIDSet()
 	errChan := make(chan error)
 
-	Verbosef("check all packs\n")
+	// packError reports damaged and missing packs. They are recorded so they
+	// can be repaired later on, which is not an error by itself.
+	packError := func(err error) {
+		if e, ok := errors.Cause(err).(checker.PackError); ok && opts.RepairPacks {
+			damagedPacks.Insert(e.ID)
+			r.reportPackError(err, checkInfo)
+			return
+		}
+		r.reportPackError(err, checkError)
+	}
+
+	// packs marked for deletion by prune are expected to be missing from the index
+	tombstones, _, err := restic.LoadTombstones(gopts.ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	r.verbosef("check all packs\n")
 	go chkr.Packs(gopts.ctx, errChan)
 
 	for err := range errChan {
+		if e, ok := errors.Cause(err).(checker.PackError); ok && e.Orphaned {
+			if _, marked := tombstones[e.ID]; marked {
+				markedPacks++
+				continue
+			}
+		}
 		if checker.IsOrphanedPack(err) {
 			orphanedPacks++
-			Verbosef("%v\n", err)
-			continue
 		}
-		errorsFound = true
-		Warnf("%v\n", err)
+		packError(err)
 	}
 
+	if markedPacks > 0 {
+		r.verbosef("%d packs are marked for deletion by prune\n", markedPacks)
+	}
 	if orphanedPacks > 0 {
-		Verbosef("%d additional files were found in the repo, which likely contain duplicate data.\nYou can run `restic prune` to correct this.\n", orphanedPacks)
+		r.verbosef("%d additional files were found in the repo, which likely contain duplicate data.\nYou can run `restic prune` to correct this.\n", orphanedPacks)
 	}
 
-	Verbosef("check snapshots, trees and blobs\n")
-	errChan = make(chan error)
-	go chkr.Structure(gopts.ctx, errChan)
+	// packs whose data was read without errors
+	verified := make(map[restic.ID]time.Time)
 
-	for err := range errChan {
-		errorsFound = true
-		if e, ok := err.(checker.TreeError); ok {
-			Warnf("error for tree %v:\n", e.ID.Str())
-			for _, treeErr := range e.Errors {
-				Warnf("  %v\n", treeErr)
+	readPacks := func(packs restic.IDSet) {
+		p := newReadProgress(gopts, restic.Stat{Blobs: uint64(len(packs))})
+		errChan := make(chan error)
+
+		go chkr.ReadPacks(gopts.ctx, packs, p, errChan)
+
+		failed := restic.NewIDSet()
+		for err := range errChan {
+			if e, ok := errors.Cause(err).(checker.PackError); ok {
+				failed.Insert(e.ID)
 			}
-		} else {
-			Warnf("error: %v\n", err)
+			packError(err)
 		}
-	}
 
-	if opts.CheckUnused {
-		for _, id := range chkr.UnusedBlobs() {
-			Verbosef("unused blob %v\n", id)
-			errorsFound = true
+		// packs were skipped if check was interrupted
+		if gopts.ctx.Err() != nil {
+			return
+		}
+
+		r.summary.PacksRead += len(packs)
+		now := time.Now()
+		for id := range packs {
+			if !failed.Has(id) {
+				verified[id] = now
+			}
 		}
 	}
 
@@ -276,35 +472,243 @@ func runCheck(opts CheckOptions, gopts GlobalOptions, args []string) error {
 		packCount := uint64(len(packs))
 
 		if packCount < chkr.CountPacks() {
-			Verbosef(fmt.Sprintf("read group #%d of %d data packs (out of total %d packs in %d groups)\n", bucket, packCount, chkr.CountPacks(), totalBuckets))
+			r.verbosef(fmt.Sprintf("read group #%d of %d data packs (out of total %d packs in %d groups)\n", bucket, packCount, chkr.CountPacks(), totalBuckets))
 		} else {
-			Verbosef("read all data\n")
+			r.verbosef("read all data\n")
 		}
 
-		p := newReadProgress(gopts, restic.Stat{Blobs: packCount})
-		errChan := make(chan error)
+		readPacks(packs)
+	}
 
-		go chkr.ReadPacks(gopts.ctx, packs, p, errChan)
+	doReadDataBudget := func(packSize map[restic.ID]int64, lastVerified map[restic.ID]time.Time) {
+		percent, budget, _ := parseReadDataBudget(opts.ReadDataBudget)
+		if percent >= 0 {
+			var total uint64
+			for _, size := range packSize {
+				total += uint64(size)
+			}
+			budget = uint64(percent / 100 * float64(total))
+		}
+
+		packs := selectPacksByAge(packSize, lastVerified, budget)
+		var size uint64
+		for id := range packs {
+			size += uint64(packSize[id])
+		}
+
+		r.verbosef("read %d least recently verified data packs (%s) out of %d packs\n", len(packs), formatBytes(size), len(packSize))
+		readPacks(packs)
+	}
+
+	readData := func() error {
+		if !opts.ReadData && opts.ReadDataSubset == "" && opts.ReadDataBudget == "" {
+			return nil
+		}
+
+		// the verification times are only used to select the packs for
+		// --read-data-budget
+		var lastVerified map[restic.ID]time.Time
+		var verificationFiles restic.IDs
+		var err error
+		if opts.ReadDataBudget != "" {
+			lastVerified, verificationFiles, err = restic.LoadVerifications(gopts.ctx, repo)
+			if err != nil {
+				return err
+			}
+		}
+
+		packSize := make(map[restic.ID]int64)
+		err = repo.List(gopts.ctx, restic.DataFile, func(id restic.ID, size int64) error {
+			if chkr.GetPacks().Has(id) {
+				packSize[id] = size
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case opts.ReadData:
+			doReadData(1, 1)
+		case opts.ReadDataSubset != "":
+			dataSubset, _ := stringToIntSlice(opts.ReadDataSubset)
+			doReadData(dataSubset[0], dataSubset[1])
+		case opts.ReadDataBudget != "":
+			doReadDataBudget(packSize, lastVerified)
+		}
+
+		if opts.ReadDataBudget == "" || len(verified) == 0 {
+			return nil
+		}
+
+		// record the verification times of all existing packs in a new file,
+		// which replaces the previous ones
+		now := time.Now()
+		for id, t := range lastVerified {
+			if _, ok := verified[id]; !ok {
+				verified[id] = t
+			}
+		}
+		for id := range verified {
+			if _, ok := packSize[id]; !ok {
+				delete(verified, id)
+			}
+		}
+
+		// the repository may be read-only, so failing to record the times is
+		// not an error
+		if gopts.NoLock {
+			r.verbosef("verification times are not recorded with --no-lock\n")
+		} else if _, err = restic.SaveVerification(gopts.ctx, repo, verified); err != nil {
+			Warnf("unable to save verification times: %v\n", err)
+		} else {
+			for _, id := range verificationFiles {
+				h := restic.Handle{Type: restic.VerificationFile, Name: id.String()}
+				if err = repo.Backend().Remove(gopts.ctx, h); err != nil {
+					Warnf("unable to remove verification file %v: %v\n", id.Str(), err)
+				}
+			}
+		}
+
+		if !gopts.JSON {
+			return printVerificationAge(packSize, verified, now)
+		}
+		return nil
+	}
+
+	// damaged packs are repaired before checking the structure, so that
+	// trees stored in them can be checked afterwards
+	if opts.RepairPacks {
+		if err = readData(); err != nil {
+			return err
+		}
+
+		if len(damagedPacks) > 0 {
+			r.verbosef("repair %d packs using parity files\n", len(damagedPacks))
+			repairPacks(gopts, repo, r, damagedPacks)
+		}
+	}
+
+	r.verbosef("check snapshots, trees and blobs\n")
+	errChan = make(chan error)
+	go chkr.Structure(gopts.ctx, errChan)
+
+	for err := range errChan {
+		if e, ok := err.(checker.TreeError); ok {
+			id := e.ID
+			problem := checkProblem{
+				Category: checkTreeError,
+				Severity: checkError,
+				Tree:     &id,
+				Message:  fmt.Sprintf("error for tree %v", e.ID.Str()),
+			}
+			for _, treeErr := range e.Errors {
+				problem.Details = append(problem.Details, treeErr.Error())
+			}
+			r.report(problem)
+		} else {
+			r.report(checkProblem{Category: checkSnapshotError, Severity: checkError, Message: "error: " + err.Error()})
+		}
+	}
+
+	if opts.CheckMetadata {
+		r.verbosef("check metadata\n")
+		errChan = make(chan error)
+		go chkr.Metadata(gopts.ctx, errChan)
 
 		for err := range errChan {
-			errorsFound = true
-			Warnf("%v\n", err)
+			if e, ok := err.(checker.MetadataError); ok {
+				id := e.Snapshot
+				r.report(checkProblem{
+					Category: checkMetadataError,
+					Severity: checkError,
+					Snapshot: &id,
+					Path:     e.Path,
+					Message:  "error: " + err.Error(),
+				})
+			} else {
+				r.report(checkProblem{Category: checkSnapshotError, Severity: checkError, Message: "error: " + err.Error()})
+			}
 		}
 	}
 
-	switch {
-	case opts.ReadData:
-		doReadData(1, 1)
-	case opts.ReadDataSubset != "":
-		dataSubset, _ := stringToIntSlice(opts.ReadDataSubset)
-		doReadData(dataSubset[0], dataSubset[1])
+	if verifySignatures {
+		r.verbosef("check snapshot signatures\n")
+		if err = checkSnapshotSignatures(gopts, repo, r, trusted); err != nil {
+			return err
+		}
 	}
 
-	if errorsFound {
-		return errors.Fatal("repository contains errors")
+	if opts.CheckUnused {
+		for _, h := range chkr.UnusedBlobs() {
+			id := h.ID
+			r.report(checkProblem{Category: checkUnusedBlob, Severity: checkWarning, Blob: &id, Message: fmt.Sprintf("unused blob %v", h)})
+		}
+	}
+
+	if !opts.RepairPacks {
+		if err = readData(); err != nil {
+			return err
+		}
 	}
 
-	Verbosef("no errors were found\n")
+	return r.finish()
+}
 
-	return nil
+// checkSnapshotSignatures verifies that all snapshots are signed by one of
+// the trusted keys and reports the ones which are not.
+func checkSnapshotSignatures(gopts GlobalOptions, repo *repository.Repository, r *checkReporter, trusted []ed25519.PublicKey) error {
+	return repo.List(gopts.ctx, restic.SnapshotFile, func(id restic.ID, size int64) error {
+		sn, err := restic.LoadSnapshot(gopts.ctx, repo, id)
+		if err != nil {
+			// loading errors are already reported by the structure check
+			return nil
+		}
+
+		err = sn.VerifySignature(trusted)
+		if err != nil {
+			r.report(checkProblem{
+				Category: checkInvalidSignature,
+				Severity: checkError,
+				Snapshot: &id,
+				Message:  fmt.Sprintf("snapshot %v: %v", id.Str(), err),
+			})
+		}
+		return nil
+	})
+}
+
+// repairPacks reconstructs the packs from the parity files of their groups and
+// reports the ones which cannot be repaired.
+func repairPacks(gopts GlobalOptions, repo *repository.Repository, r *checkReporter, packs restic.IDSet) {
+	repaired := restic.NewIDSet()
+	for id := range packs {
+		if repaired.Has(id) {
+			continue
+		}
+
+		ids, err := repo.RepairPack(gopts.ctx, id)
+		for _, repairedID := range ids {
+			r.printf("pack %v repaired\n", repairedID.Str())
+			repaired.Insert(repairedID)
+		}
+
+		packID := id
+		if err == repository.ErrNoParity {
+			r.report(checkProblem{
+				Category: checkUnrepairablePack,
+				Severity: checkError,
+				Pack:     &packID,
+				Message:  fmt.Sprintf("pack %v cannot be repaired: no parity files exist for it", id.Str()),
+			})
+		} else if err != nil {
+			r.report(checkProblem{
+				Category: checkUnrepairablePack,
+				Severity: checkError,
+				Pack:     &packID,
+				Message:  fmt.Sprintf("pack %v cannot be repaired: %v", id.Str(), err),
+			})
+		}
+	}
 }
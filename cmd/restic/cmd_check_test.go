@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/quinn/restic/internal/restic"
+	rtest "github.com/quinn/restic/internal/test"
+)
+
+func TestParseReadDataBudget(t *testing.T) {
+	for _, test := range []struct {
+		s       string
+		percent float64
+		size    uint64
+	}{
+		{"10%", 10, 0},
+		{"100%", 100, 0},
+		{"500", -1, 500},
+		{"5G", -1, 5 << 30},
+	} {
+		percent, size, err := parseReadDataBudget(test.s)
+		rtest.OK(t, err)
+		rtest.Equals(t, test.percent, percent)
+		rtest.Equals(t, test.size, size)
+	}
+
+	for _, s := range []string{"", "0", "0%", "101%", "foo", "unlimited"} {
+		if _, _, err := parseReadDataBudget(s); err == nil {
+			t.Errorf("budget %q did not return an error", s)
+		}
+	}
+}
+
+func TestSelectPacksByAge(t *testing.T) {
+	now := time.Now()
+	never, old, recent := restic.NewRandomID(), restic.NewRandomID(), restic.NewRandomID()
+	packs := map[restic.ID]int64{never: 100, old: 100, recent: 100}
+	verified := map[restic.ID]time.Time{
+		old:    now.Add(-48 * time.Hour),
+		recent: now.Add(-time.Hour),
+	}
+
+	rtest.Equals(t, restic.NewIDSet(never), selectPacksByAge(packs, verified, 150))
+	rtest.Equals(t, restic.NewIDSet(never, old), selectPacksByAge(packs, verified, 200))
+	rtest.Equals(t, restic.NewIDSet(never, old, recent), selectPacksByAge(packs, verified, 1000))
+
+	// at least one pack is selected
+	rtest.Equals(t, restic.NewIDSet(never), selectPacksByAge(packs, verified, 1))
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/quinn/restic/internal/backend/location"
+	"github.com/quinn/restic/internal/errors"
+	"github.com/quinn/restic/internal/repository"
+	"github.com/quinn/restic/internal/restic"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdCleanup = &cobra.Command{
+	Use:   "cleanup [flags]",
+	Short: "Remove files left behind by interrupted operations",
+	Long: `
+The "cleanup" command lists files which were left behind in the repository by
+interrupted operations:
+
+ * temporary files of uploads which did not complete, these are only written
+   by the local and sftp backends
+ * packs which are not referenced by any index
+
+With --remove, temporary files older than --max-age are deleted. Unreferenced
+packs are marked for deletion in the same way as "prune" does, and are deleted
+by a later run once they have been marked for longer than --grace-period. Packs
+which are added to an index again in the meantime are kept. If a snapshot uses
+data in an unreferenced pack, the index is incomplete and nothing is removed,
+run "rebuild-index" first in this case. Packs which cannot be read are kept. Removing files
+requires an exclusive lock on the repository, so "cleanup" does not interfere
+with operations which are still running.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCleanup(cleanupOptions, globalOptions, args)
+	},
+}
+
+// CleanupOptions collects all options for the cleanup command.
+type CleanupOptions struct {
+	Remove      bool
+	MaxAge      time.Duration
+	GracePeriod time.Duration
+}
+
+var cleanupOptions CleanupOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdCleanup)
+
+	f := cmdCleanup.Flags()
+	f.BoolVar(&cleanupOptions.Remove, "remove", false, "remove the files instead of only listing them")
+	f.DurationVar(&cleanupOptions.MaxAge, "max-age", 24*time.Hour, "remove temporary files older than `duration`")
+	f.DurationVar(&cleanupOptions.GracePeriod, "grace-period", 24*time.Hour, "delete unreferenced packs only after they have been marked for deletion for `duration` (0 deletes them immediately)")
+}
+
+func runCleanup(opts CleanupOptions, gopts GlobalOptions, args []string) error {
+	if len(args) != 0 {
+		return errors.Fatal("cleanup has no arguments")
+	}
+	if opts.MaxAge < 0 || opts.GracePeriod < 0 {
+		return errors.Fatal("--max-age and --grace-period must not be negative")
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	var lock *restic.Lock
+	if opts.Remove {
+		lock, err = lockRepoExclusive(repo)
+	} else {
+		lock, err = lockRepo(repo)
+	}
+	defer unlockRepo(lock)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if writesTempFiles(gopts.Repo) {
+		if err = cleanupTempFiles(opts, gopts, repo, now); err != nil {
+			return err
+		}
+	}
+
+	return cleanupUnreferencedPacks(opts, gopts, repo, now)
+}
+
+// writesTempFiles returns true if the backend for the repository at repo writes
+// temporary files during uploads. Only the local and sftp backends do, the
+// other backends need not support listing them.
+func writesTempFiles(repo string) bool {
+	loc, err := location.Parse(repo)
+	if err != nil {
+		return false
+	}
+
+	switch loc.Scheme {
+	case "local", "sftp":
+		return true
+	}
+	return false
+}
+
+// cleanupTempFiles lists the temporary files of interrupted uploads and
+// removes the ones older than opts.MaxAge. Files whose age is unknown are
+// treated as old, no upload can be running while the repository is locked
+// exclusively.
+func cleanupTempFiles(opts CleanupOptions, gopts GlobalOptions, repo *repository.Repository, now time.Time) error {
+	var stale []restic.FileInfo
+	var size uint64
+	err := repo.Backend().List(gopts.ctx, restic.TempFile, func(fi restic.FileInfo) error {
+		age := now.Sub(fi.ModTime)
+		if !fi.ModTime.IsZero() && age < opts.MaxAge {
+			Verbosef("temporary file %v is only %v old, keeping it\n", fi.Name, age.Round(time.Second))
+			return nil
+		}
+
+		Printf("temporary file %v (%s)\n", fi.Name, formatBytes(uint64(fi.Size)))
+		stale = append(stale, fi)
+		size += uint64(fi.Size)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(stale) == 0 {
+		Verbosef("no stale temporary files found\n")
+		return nil
+	}
+
+	if !opts.Remove {
+		Printf("%d stale temporary files (%s) found, use --remove to delete them\n", len(stale), formatBytes(size))
+		return nil
+	}
+
+	removed := 0
+	for _, fi := range stale {
+		h := restic.Handle{Type: restic.TempFile, Name: fi.Name}
+		if err = repo.Backend().Remove(gopts.ctx, h); err != nil {
+			Warnf("unable to remove temporary file %v: %v\n", fi.Name, err)
+			continue
+		}
+		removed++
+	}
+	Printf("removed %d stale temporary files\n", removed)
+
+	return nil
+}
+
+// cleanupUnreferencedPacks lists the packs which are not referenced by any
+// index. They are marked for deletion and deleted once they have been marked
+// for longer than opts.GracePeriod. Nothing is removed if a snapshot
+// references blobs in one of these packs.
+func cleanupUnreferencedPacks(opts CleanupOptions, gopts GlobalOptions, repo *repository.Repository, now time.Time) error {
+	ctx := gopts.ctx
+
+	Verbosef("load indexes\n")
+	if err := repo.LoadIndex(ctx); err != nil {
+		return err
+	}
+	indexed := repo.Index().(*repository.MasterIndex).Packs()
+
+	tombstones, tombstoneFiles, err := restic.LoadTombstones(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	unreferenced := restic.NewIDSet()
+	sizes := make(map[restic.ID]int64)
+	var size uint64
+	err = repo.List(ctx, restic.DataFile, func(id restic.ID, packSize int64) error {
+		if indexed.Has(id) {
+			return nil
+		}
+
+		if t, ok := tombstones[id]; ok {
+			Printf("unreferenced pack %v (%s), marked for deletion %v ago\n", id.Str(), formatBytes(uint64(packSize)), now.Sub(t).Round(time.Second))
+		} else {
+			Printf("unreferenced pack %v (%s)\n", id.Str(), formatBytes(uint64(packSize)))
+		}
+		unreferenced.Insert(id)
+		sizes[id] = packSize
+		size += uint64(packSize)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(unreferenced) == 0 {
+		Verbosef("no unreferenced packs found\n")
+	} else if !opts.Remove {
+		Printf("%d unreferenced packs (%s) found, use --remove to delete them\n", len(unreferenced), formatBytes(size))
+	}
+
+	if !opts.Remove {
+		return nil
+	}
+
+	if len(unreferenced) > 0 {
+		if err = checkUnreferencedPacks(ctx, repo, unreferenced, sizes); err != nil {
+			return err
+		}
+	}
+
+	deletePacks, marked := splitRemovePacks(unreferenced, tombstones, opts.GracePeriod, now)
+	if len(marked) > 0 {
+		Printf("marking %d packs for deletion, they will be deleted after %v\n", len(marked), opts.GracePeriod)
+		if _, err = restic.SaveTombstone(ctx, repo, marked); err != nil {
+			return errors.Fatalf("unable to save tombstone: %v", err)
+		}
+	}
+
+	// the new tombstone replaces all older ones, packs which are referenced
+	// again or no longer exist are dropped from it
+	for _, id := range tombstoneFiles {
+		h := restic.Handle{Type: restic.TombstoneFile, Name: id.String()}
+		if err = repo.Backend().Remove(ctx, h); err != nil {
+			Warnf("unable to remove tombstone %v from the repository\n", id.Str())
+		}
+	}
+
+	removed := 0
+	for id := range deletePacks {
+		h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+		if err = repo.Backend().Remove(ctx, h); err != nil {
+			Warnf("unable to remove pack %v from the repository: %v\n", id.Str(), err)
+			continue
+		}
+		removed++
+	}
+	if len(deletePacks) > 0 {
+		Printf("removed %d unreferenced packs\n", removed)
+	}
+
+	return nil
+}
+
+// checkUnreferencedPacks returns an error if a snapshot references any blob in
+// the packs in unreferenced, whose sizes are given in sizes. The index is
+// incomplete in this case and must be rebuilt before the packs can be removed.
+// Packs which cannot be listed are removed from unreferenced and kept, their
+// blobs may still be salvaged.
+func checkUnreferencedPacks(ctx context.Context, repo *repository.Repository, unreferenced restic.IDSet, sizes map[restic.ID]int64) error {
+	Verbosef("find blobs referenced by snapshots\n")
+
+	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	used := restic.NewBlobSet()
+	seen := restic.NewBlobSet()
+	for _, sn := range snapshots {
+		err = restic.FindUsedBlobs(ctx, repo, *sn.Tree, used, seen)
+		if err != nil {
+			return errors.Fatalf("unable to load the trees of snapshot %v, run `restic rebuild-index` first: %v", sn.ID().Str(), err)
+		}
+	}
+
+	referenced := 0
+	for id := range unreferenced {
+		blobs, _, err := repo.ListPack(ctx, id, sizes[id])
+		if err != nil {
+			Warnf("unable to list pack %v, keeping it: %v\n", id.Str(), err)
+			unreferenced.Delete(id)
+			continue
+		}
+
+		for _, blob := range blobs {
+			if used.Has(restic.BlobHandle{ID: blob.ID, Type: blob.Type}) {
+				Printf("unreferenced pack %v contains data used by snapshots\n", id.Str())
+				referenced++
+				break
+			}
+		}
+	}
+
+	if referenced > 0 {
+		return errors.Fatalf("%d packs which are not referenced by the index contain data used by snapshots, run `restic rebuild-index` first", referenced)
+	}
+
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	rtest "github.com/quinn/restic/internal/test"
+)
+
+func TestWritesTempFiles(t *testing.T) {
+	for _, test := range []struct {
+		repo string
+		temp bool
+	}{
+		{"/srv/repo", true},
+		{"local:/srv/repo", true},
+		{"sftp:user@host:/srv/repo", true},
+		{"rest:http://localhost:8000/", false},
+		{"s3:s3.amazonaws.com/bucket", false},
+		{"b2:bucket:repo", false},
+	} {
+		rtest.Equals(t, test.temp, writesTempFiles(test.repo))
+	}
+}
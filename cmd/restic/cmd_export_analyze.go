@@ -76,7 +76,7 @@ func runExportAnalyze(opts CmdOptions, gopts GlobalOptions, args []string) error
 
 	var id restic.ID
 
-	id, err = restic.FindLatestSnapshot(ctx, repo, opts.Paths, opts.Tags, opts.Hosts)
+	id, err = restic.FindLatestSnapshot(ctx, repo, opts.Paths, opts.Tags, nil, opts.Hosts)
 	if err != nil {
 		Exitf(1, "latest snapshot for criteria not found: %v Paths:%v Hosts:%v", err, opts.Paths, opts.Hosts)
 	}
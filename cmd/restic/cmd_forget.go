@@ -42,6 +42,7 @@ type ForgetOptions struct {
 
 	Hosts   []string
 	Tags    restic.TagLists
+	Labels  restic.LabelList
 	Paths   []string
 	Compact bool
 
@@ -71,6 +72,7 @@ func init() {
 	f.MarkDeprecated("hostname", "use --host")
 
 	f.Var(&forgetOptions.Tags, "tag", "only consider snapshots which include this `taglist` in the format `tag[,tag,...]` (can be specified multiple times)")
+	f.Var(&forgetOptions.Labels, "label", "only consider snapshots which have this label given as `key=value` (can be specified multiple times)")
 
 	f.StringArrayVar(&forgetOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` (can be specified multiple times)")
 	f.BoolVarP(&forgetOptions.Compact, "compact", "c", false, "use compact format")
@@ -80,9 +82,16 @@ func init() {
 	f.BoolVar(&forgetOptions.Prune, "prune", false, "automatically run the 'prune' command if snapshots have been removed")
 
 	f.SortFlags = false
+	addPruneOptions(cmdForget)
 }
 
 func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
+	if opts.Prune {
+		if err := verifyPruneOptions(&pruneOptions); err != nil {
+			return err
+		}
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
@@ -101,7 +110,7 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 
 	var snapshots restic.Snapshots
 
-	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args) {
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Labels, opts.Paths, args) {
 		snapshots = append(snapshots, sn)
 	}
 
@@ -218,7 +227,7 @@ func runForget(opts ForgetOptions, gopts GlobalOptions, args []string) error {
 			Verbosef("%d snapshots have been removed, running prune\n", removeSnapshots)
 		}
 		if !opts.DryRun {
-			return pruneRepository(gopts, repo)
+			return pruneRepository(gopts, pruneOptions, repo)
 		}
 	}
 
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"io/ioutil"
 	"os"
@@ -16,11 +18,23 @@ import (
 )
 
 var cmdKey = &cobra.Command{
-	Use:   "key [list|add|remove|passwd] [ID]",
+	Use:   "key [list|add|remove|passwd|generate-signing|show-signing|trust|untrust|list-trusted] [ID|PUBKEY]",
 	Short: "Manage keys (passwords)",
 	Long: `
 The "key" command manages keys (passwords) for accessing the repository.
 
+It also manages the keys used to sign snapshots, which are stored on the client
+and not in the repository:
+
+  generate-signing   create a new signing key in the file given by --signing-key
+  show-signing       print the public key of the signing key
+  trust PUBKEY       add a public key to the file given by --trusted-keys
+  untrust PUBKEY     remove a public key from the trusted keys
+  list-trusted       list the trusted public keys
+
+When --trusted-keys is set, the commands "snapshots", "restore" and "check"
+verify that snapshots are signed by one of the trusted keys.
+
 EXIT STATUS
 ===========
 
@@ -168,6 +182,13 @@ func changePassword(gopts GlobalOptions, repo *repository.Repository) error {
 }
 
 func runKey(gopts GlobalOptions, args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "generate-signing", "show-signing", "trust", "untrust", "list-trusted":
+			return runSigningKey(gopts, args)
+		}
+	}
+
 	if len(args) < 1 || (args[0] == "remove" && len(args) != 2) || (args[0] != "remove" && len(args) != 1) {
 		return errors.Fatal("wrong number of arguments")
 	}
@@ -223,6 +244,138 @@ func runKey(gopts GlobalOptions, args []string) error {
 	return nil
 }
 
+// runSigningKey handles the subcommands which manage the signing keys, none
+// of them needs to access the repository.
+func runSigningKey(gopts GlobalOptions, args []string) error {
+	needsArg := args[0] == "trust" || args[0] == "untrust"
+	if (needsArg && len(args) != 2) || (!needsArg && len(args) != 1) {
+		return errors.Fatal("wrong number of arguments")
+	}
+
+	switch args[0] {
+	case "generate-signing", "show-signing":
+		if gopts.SigningKeyFile == "" {
+			return errors.Fatal("no signing key file specified (--signing-key)")
+		}
+	default:
+		if gopts.TrustedKeysFile == "" {
+			return errors.Fatal("no trusted keys file specified (--trusted-keys)")
+		}
+	}
+
+	switch args[0] {
+	case "generate-signing":
+		pub, key, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			return errors.Wrap(err, "GenerateKey")
+		}
+
+		err = saveSigningKey(gopts.SigningKeyFile, key)
+		if err != nil {
+			return err
+		}
+
+		Verbosef("saved new signing key to %s, public key:\n", gopts.SigningKeyFile)
+		Printf("%s\n", restic.EncodePublicKey(pub))
+	case "show-signing":
+		key, err := loadSigningKey(gopts.SigningKeyFile)
+		if err != nil {
+			return err
+		}
+
+		Printf("%s\n", restic.EncodePublicKey(key.Public().(ed25519.PublicKey)))
+	case "trust":
+		pub, err := restic.ParsePublicKey(args[1])
+		if err != nil {
+			return errors.Fatal(err.Error())
+		}
+
+		// the file is created when the first key is trusted
+		keys, err := loadTrustedKeys(gopts.TrustedKeysFile, true)
+		if err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			if bytes.Equal(k.Key, pub) {
+				Verbosef("key %s is already trusted\n", args[1])
+				return nil
+			}
+		}
+
+		keys = append(keys, trustedKey{Key: pub})
+		err = saveTrustedKeys(gopts.TrustedKeysFile, keys)
+		if err != nil {
+			return err
+		}
+
+		Verbosef("added trusted key %s\n", args[1])
+	case "untrust":
+		pub, err := restic.ParsePublicKey(args[1])
+		if err != nil {
+			return errors.Fatal(err.Error())
+		}
+
+		keys, err := loadTrustedKeys(gopts.TrustedKeysFile, false)
+		if err != nil {
+			return err
+		}
+
+		var remaining []trustedKey
+		for _, k := range keys {
+			if !bytes.Equal(k.Key, pub) {
+				remaining = append(remaining, k)
+			}
+		}
+
+		if len(remaining) == len(keys) {
+			return errors.Fatalf("key %s is not trusted", args[1])
+		}
+
+		err = saveTrustedKeys(gopts.TrustedKeysFile, remaining)
+		if err != nil {
+			return err
+		}
+
+		Verbosef("removed trusted key %s\n", args[1])
+	case "list-trusted":
+		keys, err := loadTrustedKeys(gopts.TrustedKeysFile, false)
+		if err != nil {
+			return err
+		}
+
+		return listTrustedKeys(gopts, keys)
+	}
+
+	return nil
+}
+
+func listTrustedKeys(gopts GlobalOptions, keys []trustedKey) error {
+	type keyInfo struct {
+		Key     string `json:"key"`
+		Comment string `json:"comment,omitempty"`
+	}
+
+	list := []keyInfo{}
+	for _, k := range keys {
+		list = append(list, keyInfo{Key: restic.EncodePublicKey(k.Key), Comment: k.Comment})
+	}
+
+	if gopts.JSON {
+		return json.NewEncoder(globalOptions.stdout).Encode(list)
+	}
+
+	tab := table.New()
+	tab.AddColumn("Key", "{{ .Key }}")
+	tab.AddColumn("Comment", "{{ .Comment }}")
+
+	for _, k := range list {
+		tab.AddRow(k)
+	}
+
+	return tab.Write(globalOptions.stdout)
+}
+
 func loadPasswordFromFile(pwdFile string) (string, error) {
 	s, err := ioutil.ReadFile(pwdFile)
 	if os.IsNotExist(err) {
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+
+	"github.com/spf13/cobra"
+
+	"github.com/quinn/restic/internal/errors"
+	"github.com/quinn/restic/internal/repository"
+	"github.com/quinn/restic/internal/restic"
+)
+
+var cmdLabel = &cobra.Command{
+	Use:   "label [flags] [snapshot-ID ...]",
+	Short: "Modify labels and the description of snapshots",
+	Long: `
+The "label" command allows you to modify the labels and the description of
+existing snapshots.
+
+Labels are key/value pairs, e.g. "ticket=OPS-1234". With --set, a label is
+added to the snapshots, an existing label with the same key is replaced. With
+--remove, the label with the given key is removed. The description is replaced
+with the text given with --description, an empty text removes it.
+
+When no snapshot-ID is given, all snapshots matching the host, tag, label and
+path filter criteria are modified.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		labelOptions.SetDescription = cmd.Flags().Changed("description")
+		return runLabel(labelOptions, globalOptions, args)
+	},
+}
+
+// LabelOptions bundles all options for the 'label' command.
+type LabelOptions struct {
+	Hosts          []string
+	Paths          []string
+	Tags           restic.TagLists
+	Labels         restic.LabelList
+	SetLabels      restic.LabelList
+	RemoveLabels   []string
+	Description    string
+	SetDescription bool
+}
+
+var labelOptions LabelOptions
+
+func init() {
+	cmdRoot.AddCommand(cmdLabel)
+
+	f := cmdLabel.Flags()
+	f.Var(&labelOptions.SetLabels, "set", "`key=value` label which will be added, replacing a label with the same key (can be given multiple times)")
+	f.StringArrayVar(&labelOptions.RemoveLabels, "remove", nil, "`key` of the label which will be removed (can be given multiple times)")
+	f.StringVar(&labelOptions.Description, "description", "", "`text` which will replace the description")
+
+	f.StringArrayVarP(&labelOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host`, when no snapshot ID is given (can be specified multiple times)")
+	f.Var(&labelOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot-ID is given")
+	f.Var(&labelOptions.Labels, "label", "only consider snapshots which have this label given as `key=value`, when no snapshot-ID is given (can be specified multiple times)")
+	f.StringArrayVar(&labelOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot-ID is given")
+}
+
+func changeLabels(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, key ed25519.PrivateKey, opts LabelOptions) (bool, error) {
+	changed := sn.SetLabels(opts.SetLabels)
+	if sn.RemoveLabels(opts.RemoveLabels) {
+		changed = true
+	}
+	if opts.SetDescription && sn.Description != opts.Description {
+		sn.Description = opts.Description
+		changed = true
+	}
+
+	if changed {
+		if err := replaceSnapshot(ctx, repo, sn, key); err != nil {
+			return false, err
+		}
+	}
+	return changed, nil
+}
+
+func runLabel(opts LabelOptions, gopts GlobalOptions, args []string) error {
+	if len(opts.SetLabels) == 0 && len(opts.RemoveLabels) == 0 && !opts.SetDescription {
+		return errors.Fatal("nothing to do!")
+	}
+
+	for _, label := range opts.SetLabels {
+		for _, k := range opts.RemoveLabels {
+			if label.Key == k {
+				return errors.Fatalf("label %q cannot be set and removed at the same time", k)
+			}
+		}
+	}
+
+	key, err := signingKey(gopts)
+	if err != nil {
+		return err
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		Verbosef("create exclusive lock for repository\n")
+		lock, err := lockRepoExclusive(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	changeCnt := 0
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Labels, opts.Paths, args) {
+		changed, err := changeLabels(ctx, repo, sn, key, opts)
+		if err != nil {
+			Warnf("unable to modify the labels for snapshot ID %q, ignoring: %v\n", sn.ID(), err)
+			continue
+		}
+		if changed {
+			changeCnt++
+		}
+	}
+	if changeCnt == 0 {
+		Verbosef("no snapshots were modified\n")
+	} else {
+		Verbosef("modified labels on %v snapshots\n", changeCnt)
+	}
+	return nil
+}
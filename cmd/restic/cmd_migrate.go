@@ -14,6 +14,9 @@ var cmdMigrate = &cobra.Command{
 The "migrate" command applies migrations to a repository. When no migration
 name is explicitly given, a list of migrations that can be applied is printed.
 
+If the config file of the repository is missing because changing it was
+interrupted, it is restored from its backup copy.
+
 EXIT STATUS
 ===========
 
@@ -105,6 +108,14 @@ func runMigrate(opts MigrateOptions, gopts GlobalOptions, args []string) error {
 		return err
 	}
 
+	// a missing config can only be restored under an exclusive lock
+	if repo.ConfigFromBackup() {
+		if err = repo.RecoverConfig(gopts.ctx); err != nil {
+			return err
+		}
+		Verbosef("config restored from its backup copy\n")
+	}
+
 	if len(args) == 0 {
 		return checkMigrations(opts, gopts, repo)
 	}
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/quinn/restic/internal/debug"
@@ -9,6 +15,7 @@ import (
 	"github.com/quinn/restic/internal/index"
 	"github.com/quinn/restic/internal/repository"
 	"github.com/quinn/restic/internal/restic"
+	"github.com/quinn/restic/internal/ui/table"
 
 	"github.com/spf13/cobra"
 )
@@ -20,6 +27,37 @@ var cmdPrune = &cobra.Command{
 The "prune" command checks the repository and removes data that is not
 referenced and therefore not needed any more.
 
+Packs which only contain unneeded data are deleted without downloading them.
+Packs which contain both needed and unneeded data are rewritten if the unneeded
+part is larger than the limit given by --max-unused, either as a percentage of
+the pack or as an absolute size. The default of 0% rewrites all such packs. The
+total size of the packs rewritten in one run can be limited with
+--max-repack-size, the packs with the largest unneeded part are rewritten first.
+Packs which contain both trees and data, or needed data which is also stored in
+other packs, are rewritten regardless of --max-unused.
+
+Packs are not deleted right away. Instead, they are removed from the index and
+marked for deletion in a tombstone file in the repository. A later run of
+"prune" deletes them once they have been marked for longer than --grace-period.
+This protects packs which another client has uploaded but not yet added to the
+index. When data in a marked pack is referenced again before it is deleted, the
+pack is added to the index again.
+
+By default, "prune" locks the repository exclusively. With --concurrent, other
+operations such as backup can continue while "prune" runs. It then only
+processes the packs listed in the index files present when it started and
+replaces only those index files. Data referenced by index files, trees or
+snapshots saved by other clients in the meantime is not removed. Clients which
+loaded the previous index may still reference any data listed in it. A later
+run of "prune" adds packs with such data to the index again, as long as they
+are only marked for deletion. This mode therefore requires a grace period,
+which must be longer than the longest running backup.
+
+With --dry-run, the repository is not modified. Instead, a report is printed
+which lists the packs that would be kept, rewritten and deleted, the amount of
+data that would be downloaded, uploaded and deleted, and the size of the
+repository afterwards. Use --json to get the report in JSON format.
+
 EXIT STATUS
 ===========
 
@@ -27,12 +65,125 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runPrune(globalOptions)
+		return runPrune(pruneOptions, globalOptions)
+	},
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return verifyPruneOptions(&pruneOptions)
 	},
 }
 
+// PruneOptions collects all options for the prune command.
+type PruneOptions struct {
+	DryRun        bool
+	Concurrent    bool
+	MaxUnused     string
+	MaxRepackSize string
+	GracePeriod   time.Duration
+
+	// parsed values, a negative maxUnusedPercent means that maxUnusedBytes
+	// is used instead, maxRepackBytes is zero for no limit
+	maxUnusedPercent float64
+	maxUnusedBytes   uint64
+	maxRepackBytes   uint64
+}
+
+var pruneOptions PruneOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdPrune)
+	f := cmdPrune.Flags()
+	f.BoolVarP(&pruneOptions.DryRun, "dry-run", "n", false, "do not modify the repository, just print what would be done")
+	f.BoolVar(&pruneOptions.Concurrent, "concurrent", false, "allow other operations like backup to run while pruning (requires a grace period)")
+	addPruneOptions(cmdPrune)
+}
+
+func addPruneOptions(c *cobra.Command) {
+	f := c.Flags()
+	f.StringVar(&pruneOptions.MaxUnused, "max-unused", "0%", "rewrite packs whose unneeded part is larger than `limit` (allowed suffixes: %, k/K, m/M, g/G, t/T or 'unlimited')")
+	f.StringVar(&pruneOptions.MaxRepackSize, "max-repack-size", "", "rewrite at most `size` of packs in one run (allowed suffixes: k/K, m/M, g/G, t/T)")
+	f.DurationVar(&pruneOptions.GracePeriod, "grace-period", 24*time.Hour, "delete packs only after they have been marked for deletion for `duration` (0 deletes them immediately)")
+}
+
+// parseSizeStr parses a size with an optional binary unit suffix.
+func parseSizeStr(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("empty size")
+	}
+
+	var unit uint64 = 1
+	switch s[len(s)-1] {
+	case 'b', 'B':
+		s = s[:len(s)-1]
+	case 'k', 'K':
+		unit = 1 << 10
+		s = s[:len(s)-1]
+	case 'm', 'M':
+		unit = 1 << 20
+		s = s[:len(s)-1]
+	case 'g', 'G':
+		unit = 1 << 30
+		s = s[:len(s)-1]
+	case 't', 'T':
+		unit = 1 << 40
+		s = s[:len(s)-1]
+	}
+
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if v > math.MaxUint64/unit {
+		return 0, errors.New("size is too large")
+	}
+
+	return v * unit, nil
+}
+
+// verifyPruneOptions checks and parses the limits in opts.
+func verifyPruneOptions(opts *PruneOptions) error {
+	maxUnused := strings.TrimSpace(opts.MaxUnused)
+	switch {
+	case maxUnused == "unlimited":
+		opts.maxUnusedPercent = -1
+		opts.maxUnusedBytes = math.MaxUint64
+	case strings.HasSuffix(maxUnused, "%"):
+		p, err := strconv.ParseFloat(strings.TrimSuffix(maxUnused, "%"), 64)
+		if err != nil || p < 0 || p > 100 {
+			return errors.Fatalf("invalid percentage %q passed for --max-unused", opts.MaxUnused)
+		}
+		opts.maxUnusedPercent = p
+	default:
+		size, err := parseSizeStr(maxUnused)
+		if err != nil {
+			return errors.Fatalf("invalid value %q passed for --max-unused: %v", opts.MaxUnused, err)
+		}
+		opts.maxUnusedPercent = -1
+		opts.maxUnusedBytes = size
+	}
+
+	opts.maxRepackBytes = 0
+	if opts.MaxRepackSize != "" {
+		size, err := parseSizeStr(opts.MaxRepackSize)
+		if err != nil {
+			return errors.Fatalf("invalid value %q passed for --max-repack-size: %v", opts.MaxRepackSize, err)
+		}
+		if size == 0 {
+			return errors.Fatal("--max-repack-size must be larger than zero")
+		}
+		opts.maxRepackBytes = size
+	}
+
+	if opts.GracePeriod < 0 {
+		return errors.Fatal("--grace-period must not be negative")
+	}
+
+	if opts.Concurrent && opts.GracePeriod == 0 {
+		return errors.Fatal("--concurrent requires a grace period")
+	}
+
+	return nil
 }
 
 func shortenStatus(maxLength int, s string) string {
@@ -75,13 +226,22 @@ func newProgressMax(show bool, max uint64, description string) *restic.Progress
 	return p
 }
 
-func runPrune(gopts GlobalOptions) error {
+func runPrune(opts PruneOptions, gopts GlobalOptions) error {
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
 	}
 
-	lock, err := lockRepoExclusive(repo)
+	var lock *restic.Lock
+	switch {
+	case opts.DryRun:
+		// a dry run does not modify the repository
+		lock, err = lockRepo(repo)
+	case opts.Concurrent:
+		lock, err = lockRepoPrune(repo)
+	default:
+		lock, err = lockRepoExclusive(repo)
+	}
 	defer unlockRepo(lock)
 	if err != nil {
 		return err
@@ -90,7 +250,7 @@ func runPrune(gopts GlobalOptions) error {
 	// we do not need index updates while pruning!
 	repo.DisableAutoIndexUpdate()
 
-	return pruneRepository(gopts, repo)
+	return pruneRepository(gopts, opts, repo)
 }
 
 func mixedBlobs(list []restic.Blob) bool {
@@ -112,10 +272,597 @@ func mixedBlobs(list []restic.Blob) bool {
 	return false
 }
 
-func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
+// prunePlan describes which packs are deleted and rewritten by prune.
+type prunePlan struct {
+	// packs which are deleted without downloading them
+	removePacks restic.IDSet
+	// packs which are rewritten, keepBlobs are copied from them to new packs
+	repackPacks restic.IDSet
+	keepBlobs   restic.BlobSet
+
+	keepPacks   int
+	keepBytes   uint64 // size of the kept packs
+	removeBytes uint64 // size of the deleted packs
+	repackBytes uint64 // size of the rewritten packs
+	copyBytes   uint64 // size of keepBlobs
+	freedBytes  uint64 // unneeded data removed by rewriting packs
+	unusedBytes uint64 // unneeded data which remains in the kept packs
+
+	// number of blobs in the kept, deleted and rewritten packs
+	keepBlobCount   int
+	removeBlobCount int
+	repackBlobCount int
+}
+
+// packInfo collects the used and unused data of a pack.
+type packInfo struct {
+	pack        index.Pack
+	usedBytes   uint64
+	unusedBytes uint64
+	mixed       bool
+	duplicate   bool
+}
+
+// exceedsLimit returns true if the pack should be rewritten according to the
+// --max-unused limit.
+func (p packInfo) exceedsLimit(opts PruneOptions) bool {
+	if p.unusedBytes == 0 {
+		return false
+	}
+
+	if opts.maxUnusedPercent < 0 {
+		return p.unusedBytes > opts.maxUnusedBytes
+	}
+
+	total := p.usedBytes + p.unusedBytes
+	return float64(p.unusedBytes) > opts.maxUnusedPercent/100*float64(total)
+}
+
+// ratio returns the unused part of the pack.
+func (p packInfo) ratio() float64 {
+	return float64(p.unusedBytes) / float64(p.usedBytes+p.unusedBytes)
+}
+
+// planPrune classifies all packs in idx: packs without used blobs are
+// removed, packs with mixed blob types, with used blobs which are also
+// contained in other packs or with an unused part exceeding the limit are
+// rewritten as long as the --max-repack-size limit permits, all other packs
+// are kept. Blobs contained in several packs are counted as used in all of
+// them, they are only copied when no kept pack contains them.
+func planPrune(opts PruneOptions, idx *index.Index, usedBlobs restic.BlobSet) prunePlan {
+	plan := prunePlan{
+		removePacks: restic.NewIDSet(),
+		repackPacks: restic.NewIDSet(),
+		keepBlobs:   restic.NewBlobSet(),
+	}
+
+	blobCount := make(map[restic.BlobHandle]int)
+	for _, pack := range idx.Packs {
+		for _, blob := range pack.Entries {
+			blobCount[restic.BlobHandle{ID: blob.ID, Type: blob.Type}]++
+		}
+	}
+
+	var candidates []packInfo
+	var keep []packInfo
+	for _, pack := range idx.Packs {
+		info := packInfo{pack: pack, mixed: mixedBlobs(pack.Entries)}
+		for _, blob := range pack.Entries {
+			h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+			if usedBlobs.Has(h) {
+				info.usedBytes += uint64(blob.Length)
+				if blobCount[h] > 1 {
+					info.duplicate = true
+				}
+			} else {
+				info.unusedBytes += uint64(blob.Length)
+			}
+		}
+
+		switch {
+		case info.usedBytes == 0:
+			plan.removePacks.Insert(pack.ID)
+			plan.removeBytes += uint64(pack.Size)
+			plan.removeBlobCount += len(pack.Entries)
+		case info.mixed || info.duplicate || info.exceedsLimit(opts):
+			candidates = append(candidates, info)
+		default:
+			keep = append(keep, info)
+		}
+	}
+
+	// rewrite packs with mixed blob types first, then packs with duplicate
+	// blobs and then the packs with the largest unused part
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].mixed != candidates[j].mixed {
+			return candidates[i].mixed
+		}
+		if candidates[i].duplicate != candidates[j].duplicate {
+			return candidates[i].duplicate
+		}
+		return candidates[i].ratio() > candidates[j].ratio()
+	})
+
+	var repack []packInfo
+	for _, info := range candidates {
+		if opts.maxRepackBytes > 0 && plan.repackBytes+uint64(info.pack.Size) > opts.maxRepackBytes {
+			keep = append(keep, info)
+			continue
+		}
+
+		repack = append(repack, info)
+		plan.repackBytes += uint64(info.pack.Size)
+	}
+
+	// blobs in kept packs need not be copied
+	keptBlobs := restic.NewBlobSet()
+	for _, info := range keep {
+		plan.keepPacks++
+		plan.keepBytes += uint64(info.pack.Size)
+		plan.keepBlobCount += len(info.pack.Entries)
+		plan.unusedBytes += info.unusedBytes
+		for _, blob := range info.pack.Entries {
+			keptBlobs.Insert(restic.BlobHandle{ID: blob.ID, Type: blob.Type})
+		}
+	}
+
+	for _, info := range repack {
+		needed := false
+		for _, blob := range info.pack.Entries {
+			h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+			if usedBlobs.Has(h) && !keptBlobs.Has(h) {
+				needed = true
+				if !plan.keepBlobs.Has(h) {
+					plan.keepBlobs.Insert(h)
+					plan.copyBytes += uint64(blob.Length)
+				}
+			}
+		}
+
+		// all used blobs are also contained in kept packs
+		if !needed {
+			plan.removePacks.Insert(info.pack.ID)
+			plan.repackBytes -= uint64(info.pack.Size)
+			plan.removeBytes += uint64(info.pack.Size)
+			plan.removeBlobCount += len(info.pack.Entries)
+			continue
+		}
+
+		plan.repackPacks.Insert(info.pack.ID)
+		plan.repackBlobCount += len(info.pack.Entries)
+		plan.freedBytes += info.unusedBytes
+	}
+
+	return plan
+}
+
+// completeIndex prepares idx loaded from the index files for a concurrent
+// prune: the sizes of the packs are set to the values in packSize, packs which
+// do not exist are dropped, and the packs marked for deletion in tombstones are
+// added as they are not contained in the index files.
+func completeIndex(ctx context.Context, repo restic.Repository, idx *index.Index, packSize map[restic.ID]int64, tombstones map[restic.ID]time.Time) error {
+	for id, pack := range idx.Packs {
+		size, ok := packSize[id]
+		if !ok {
+			Warnf("pack %v referenced in the index does not exist\n", id.Str())
+			delete(idx.Packs, id)
+			continue
+		}
+
+		pack.Size = size
+		idx.Packs[id] = pack
+	}
+
+	for id := range tombstones {
+		size, ok := packSize[id]
+		if _, indexed := idx.Packs[id]; !ok || indexed {
+			continue
+		}
+
+		entries, _, err := repo.ListPack(ctx, id, size)
+		if err != nil {
+			Warnf("unable to list pack %v marked for deletion: %v\n", id.Str(), err)
+			continue
+		}
+
+		if err = idx.AddPack(id, size, entries); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keepClientPacks removes the packs from plan which contain data referenced
+// by index files or snapshots saved by other clients since idx and the
+// snapshots of data were loaded, unless the data is also contained in other
+// packs or copied by rewriting the packs. This happens before any pack is
+// rewritten, so that the data of the kept packs is not copied needlessly.
+// The number of kept packs is returned.
+func keepClientPacks(ctx context.Context, repo restic.Repository, data *clientData, idx *index.Index, plan *prunePlan) (int, error) {
+	mi, ok := repo.Index().(*repository.MasterIndex)
+	if !ok {
+		return 0, errors.New("unsupported index type")
+	}
+
+	remove := restic.NewIDSet()
+	remove.Merge(plan.removePacks)
+	remove.Merge(plan.repackPacks)
+
+	retain, err := data.retainPacks(ctx, repo, mi, remove, plan.keepBlobs, idx.Packs)
+	if err != nil {
+		return 0, err
+	}
+
+	for id := range retain {
+		pack := idx.Packs[id]
+		if plan.removePacks.Has(id) {
+			plan.removePacks.Delete(id)
+			plan.removeBytes -= uint64(pack.Size)
+			plan.removeBlobCount -= len(pack.Entries)
+		} else {
+			plan.repackPacks.Delete(id)
+			plan.repackBytes -= uint64(pack.Size)
+			plan.repackBlobCount -= len(pack.Entries)
+		}
+
+		plan.keepPacks++
+		plan.keepBytes += uint64(pack.Size)
+		plan.keepBlobCount += len(pack.Entries)
+	}
+
+	return len(retain), nil
+}
+
+// saveConcurrentIndex replaces the index files idx was loaded from with a new
+// index which contains all packs in idx except remove. Packs in remove which
+// contain data referenced by index files or snapshots saved by other clients
+// while the index files were replaced are added to the index again, unless the
+// data is also contained in other packs, and are removed from remove. Clients
+// which loaded the previous index may still reference any data listed in it,
+// the packs in remove are only marked for deletion for this reason, and a
+// later prune adds them to the index again if they are referenced.
+func saveConcurrentIndex(ctx context.Context, repo restic.Repository, idx *index.Index, data *clientData, remove restic.IDSet) error {
+	mi, ok := repo.Index().(*repository.MasterIndex)
+	if !ok {
+		return errors.New("unsupported index type")
+	}
+
+	removed := &index.Index{Packs: make(map[restic.ID]index.Pack)}
+	for id := range remove {
+		removed.Packs[id] = idx.Packs[id]
+		delete(idx.Packs, id)
+	}
+
+	ids, err := saveIndex(ctx, repo, idx, idx.IndexIDs.List())
+	if err != nil || len(remove) == 0 {
+		return err
+	}
+
+	// clients which finished in the meantime may have saved new index files
+	// and snapshots, the data they reference was copied to new packs if it
+	// was needed by the snapshots prune knows about
+	data.indexes.Merge(restic.NewIDSet(ids...))
+	retain, err := data.retainPacks(ctx, repo, mi, remove, restic.NewBlobSet(), removed.Packs)
+	if err != nil {
+		return err
+	}
+
+	if len(retain) == 0 {
+		return nil
+	}
+
+	Verbosef("adding %d packs which are referenced by other clients to the index again\n", len(retain))
+	for id := range removed.Packs {
+		if !retain.Has(id) {
+			delete(removed.Packs, id)
+			continue
+		}
+		remove.Delete(id)
+	}
+
+	if _, err = removed.Save(ctx, repo, nil); err != nil {
+		return errors.Fatalf("unable to save index, last error was: %v", err)
+	}
+
+	return nil
+}
+
+// clientData collects the data referenced by index files and snapshots saved
+// by other clients while prune runs.
+type clientData struct {
+	indexes   restic.IDSet
+	snapshots restic.IDSet
+	needed    restic.BlobSet
+	seen      restic.BlobSet
+}
+
+// newClientData returns a clientData which ignores the given index files and
+// snapshots.
+func newClientData(indexes, snapshots restic.IDSet) *clientData {
+	data := &clientData{
+		indexes:   restic.NewIDSet(),
+		snapshots: restic.NewIDSet(),
+		needed:    restic.NewBlobSet(),
+		seen:      restic.NewBlobSet(),
+	}
+	data.indexes.Merge(indexes)
+	data.snapshots.Merge(snapshots)
+
+	return data
+}
+
+// retainPacks processes the index files and snapshots saved since the last
+// call and returns the packs in remove which are listed in these index files
+// or contain needed data which is neither contained in other packs nor copied
+// to new packs as listed in copied. All blobs listed in the index files are needed, as well as the data
+// referenced by the snapshots and by the trees in the index files, since a
+// snapshot referencing these trees may be saved later. The entries of the
+// packs are taken from packs.
+func (data *clientData) retainPacks(ctx context.Context, repo restic.Repository, mi *repository.MasterIndex, remove restic.IDSet, copied restic.BlobSet, packs map[restic.ID]index.Pack) (restic.IDSet, error) {
+	retain := restic.NewIDSet()
+	var trees restic.IDs
+
+	loaded := mi.IDs()
+	err := repo.List(ctx, restic.IndexFile, func(id restic.ID, size int64) error {
+		if data.indexes.Has(id) {
+			return nil
+		}
+		data.indexes.Insert(id)
+
+		newIdx, err := repository.LoadIndex(ctx, repo, id)
+		if err != nil {
+			return err
+		}
+
+		for pb := range newIdx.Each(ctx) {
+			data.needed.Insert(restic.BlobHandle{ID: pb.ID, Type: pb.Type})
+			if pb.Type == restic.TreeBlob {
+				trees = append(trees, pb.ID)
+			}
+			if remove.Has(pb.PackID) {
+				retain.Insert(pb.PackID)
+			}
+		}
+
+		// make the new trees available
+		if !loaded.Has(id) {
+			mi.Insert(newIdx)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = repo.List(ctx, restic.SnapshotFile, func(id restic.ID, size int64) error {
+		if data.snapshots.Has(id) {
+			return nil
+		}
+		data.snapshots.Insert(id)
+
+		sn, err := restic.LoadSnapshot(ctx, repo, id)
+		if err != nil {
+			return err
+		}
+
+		debug.Log("process new snapshot %v", id)
+		trees = append(trees, *sn.Tree)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range trees {
+		err = restic.FindUsedBlobs(ctx, repo, id, data.needed, data.seen)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// availableElsewhere returns true if the blob is contained in a pack which
+	// is not removed
+	availableElsewhere := func(h restic.BlobHandle) bool {
+		blobs, _ := repo.Index().Lookup(h.ID, h.Type)
+		for _, pb := range blobs {
+			if !remove.Has(pb.PackID) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for id := range remove {
+		for _, blob := range packs[id].Entries {
+			h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+			if data.needed.Has(h) && !copied.Has(h) && !availableElsewhere(h) {
+				retain.Insert(id)
+				break
+			}
+		}
+	}
+
+	return retain, nil
+}
+
+// splitRemovePacks returns the packs in remove which have been marked for
+// deletion for at least grace. The remaining packs are returned in marked along
+// with the time they were marked first, packs not in tombstones are marked now.
+func splitRemovePacks(remove restic.IDSet, tombstones map[restic.ID]time.Time, grace time.Duration, now time.Time) (deletePacks restic.IDSet, marked map[restic.ID]time.Time) {
+	deletePacks = restic.NewIDSet()
+	marked = make(map[restic.ID]time.Time)
+
+	for id := range remove {
+		t, ok := tombstones[id]
+		if !ok {
+			t = now
+		}
+
+		if t.Add(grace).After(now) {
+			marked[id] = t
+			continue
+		}
+
+		deletePacks.Insert(id)
+	}
+
+	return deletePacks, marked
+}
+
+// pruneReportEntry summarizes a category of packs in a pruneReport.
+type pruneReportEntry struct {
+	Packs int    `json:"packs"`
+	Blobs int    `json:"blobs"`
+	Bytes uint64 `json:"bytes"`
+}
+
+// pruneReport is printed by a dry run of prune.
+type pruneReport struct {
+	Keep         pruneReportEntry `json:"keep"`
+	Repack       pruneReportEntry `json:"repack"`
+	Remove       pruneReportEntry `json:"remove"`
+	InvalidFiles int              `json:"invalid_files"`
+
+	// packs which are only marked for deletion because of the grace period,
+	// and previously marked packs which are needed again
+	MarkedPacks      int `json:"marked_packs"`
+	ResurrectedPacks int `json:"resurrected_packs"`
+
+	// blobs which are copied from the rewritten packs to new packs
+	CopyBlobs int    `json:"copy_blobs"`
+	CopyBytes uint64 `json:"copy_bytes"`
+
+	DownloadBytes uint64 `json:"download_bytes"`
+	UploadBytes   uint64 `json:"upload_bytes"`
+	DeleteBytes   uint64 `json:"delete_bytes"`
+
+	// unneeded data which remains in the repository
+	UnusedBytes uint64 `json:"unused_bytes"`
+
+	SizeBefore uint64 `json:"size_before"`
+	SizeAfter  uint64 `json:"size_after"`
+}
+
+// newPruneReport returns the report for plan in a repository whose pack files
+// have a total size of size. The invalid files must be included in
+// plan.removePacks.
+func newPruneReport(plan prunePlan, size uint64, invalidFiles int) pruneReport {
+	return pruneReport{
+		Keep: pruneReportEntry{
+			Packs: plan.keepPacks,
+			Blobs: plan.keepBlobCount,
+			Bytes: plan.keepBytes,
+		},
+		Repack: pruneReportEntry{
+			Packs: len(plan.repackPacks),
+			Blobs: plan.repackBlobCount,
+			Bytes: plan.repackBytes,
+		},
+		Remove: pruneReportEntry{
+			Packs: len(plan.removePacks),
+			Blobs: plan.removeBlobCount,
+			Bytes: plan.removeBytes,
+		},
+		InvalidFiles: invalidFiles,
+
+		CopyBlobs: len(plan.keepBlobs),
+		CopyBytes: plan.copyBytes,
+
+		DownloadBytes: plan.repackBytes,
+		UploadBytes:   plan.copyBytes,
+		DeleteBytes:   plan.removeBytes + plan.repackBytes,
+
+		UnusedBytes: plan.unusedBytes,
+
+		SizeBefore: size,
+		SizeAfter:  size - plan.removeBytes - plan.repackBytes + plan.copyBytes,
+	}
+}
+
+// printPruneReport prints report as a table or as JSON.
+func printPruneReport(gopts GlobalOptions, report pruneReport) error {
+	if gopts.JSON {
+		return json.NewEncoder(globalOptions.stdout).Encode(report)
+	}
+
+	tab := table.New()
+	tab.AddColumn("Packs", "{{ .Category }}")
+	tab.AddColumn("Count", "{{ .Packs }}")
+	tab.AddColumn("Blobs", "{{ .Blobs }}")
+	tab.AddColumn("Size", "{{ .Size }}")
+
+	addRow := func(category string, e pruneReportEntry) {
+		tab.AddRow(struct {
+			Category     string
+			Packs, Blobs int
+			Size         string
+		}{category, e.Packs, e.Blobs, formatBytes(e.Bytes)})
+	}
+	addRow("keep", report.Keep)
+	addRow("rewrite", report.Repack)
+	addRow("delete", report.Remove)
+
+	Printf("would prune the repository as follows:\n\n")
+	if err := tab.Write(globalOptions.stdout); err != nil {
+		return err
+	}
+
+	Printf("\n")
+	if report.InvalidFiles > 0 {
+		Printf("%d invalid files would be deleted\n", report.InvalidFiles)
+	}
+	if report.MarkedPacks > 0 {
+		Printf("%d deleted or rewritten packs would only be marked for deletion\n", report.MarkedPacks)
+	}
+	if report.ResurrectedPacks > 0 {
+		Printf("%d packs marked for deletion earlier would be added to the index again\n", report.ResurrectedPacks)
+	}
+	Printf("%d blobs (%s) would be copied from rewritten packs to new packs\n",
+		report.CopyBlobs, formatBytes(report.CopyBytes))
+	Printf("would download %s, upload %s and delete %s\n",
+		formatBytes(report.DownloadBytes), formatBytes(report.UploadBytes), formatBytes(report.DeleteBytes))
+	Printf("repository size would change from %s to %s, %s of unneeded data would remain\n",
+		formatBytes(report.SizeBefore), formatBytes(report.SizeAfter), formatBytes(report.UnusedBytes))
+
+	return nil
+}
+
+func pruneRepository(gopts GlobalOptions, opts PruneOptions, repo restic.Repository) error {
 	ctx := gopts.ctx
 
-	err := repo.LoadIndex(ctx)
+	// the JSON report of a dry run is the only output in JSON mode
+	verbosef := func(format string, args ...interface{}) {
+		if !gopts.JSON {
+			Verbosef(format, args...)
+		}
+	}
+
+	// other clients may save snapshots and index files while a concurrent
+	// prune runs, the snapshots are loaded first so that the index files
+	// loaded afterwards contain all data referenced by them
+	verbosef("load all snapshots\n")
+	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	snapshotIDs := restic.NewIDSet()
+	for _, sn := range snapshots {
+		snapshotIDs.Insert(*sn.ID())
+	}
+
+	// the index files loaded now are the generation this prune works on
+	var idx *index.Index
+	if opts.Concurrent {
+		verbosef("loading index files\n")
+		idx, err = index.Load(ctx, repo, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = repo.LoadIndex(ctx)
 	if err != nil {
 		return err
 	}
@@ -127,19 +874,35 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 		bytes     int64
 	}
 
-	Verbosef("counting files in repo\n")
-	err = repo.List(ctx, restic.DataFile, func(restic.ID, int64) error {
+	tombstones, tombstoneFiles, err := restic.LoadTombstones(ctx, repo)
+	if err != nil {
+		return err
+	}
+	if len(tombstones) > 0 {
+		verbosef("%d packs are marked for deletion\n", len(tombstones))
+	}
+
+	verbosef("counting files in repo\n")
+	packSize := make(map[restic.ID]int64)
+	err = repo.List(ctx, restic.DataFile, func(id restic.ID, size int64) error {
 		stats.packs++
+		packSize[id] = size
 		return nil
 	})
 	if err != nil {
 		return err
 	}
 
-	Verbosef("building new index for repo\n")
+	var bar *restic.Progress
+	var invalidFiles restic.IDs
+	if opts.Concurrent {
+		err = completeIndex(ctx, repo, idx, packSize, tombstones)
+	} else {
+		verbosef("building new index for repo\n")
 
-	bar := newProgressMax(!gopts.Quiet, uint64(stats.packs), "packs")
-	idx, invalidFiles, err := index.New(ctx, repo, restic.NewIDSet(), bar)
+		bar = newProgressMax(!gopts.Quiet && !gopts.JSON, uint64(stats.packs), "packs")
+		idx, invalidFiles, err = index.New(ctx, repo, restic.NewIDSet(), bar)
+	}
 	if err != nil {
 		return err
 	}
@@ -148,12 +911,25 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 		Warnf("incomplete pack file (will be removed): %v\n", id)
 	}
 
+	// marked packs are not in the index, add them so that data which is
+	// referenced again can be found
+	if mi, ok := repo.Index().(*repository.MasterIndex); ok && len(tombstones) > 0 {
+		markedIndex := repository.NewIndex()
+		for id := range tombstones {
+			if pack, ok := idx.Packs[id]; ok {
+				markedIndex.StorePack(id, pack.Entries)
+			}
+		}
+		markedIndex.Finalize()
+		mi.Insert(markedIndex)
+	}
+
 	blobs := 0
 	for _, pack := range idx.Packs {
 		stats.bytes += pack.Size
 		blobs += len(pack.Entries)
 	}
-	Verbosef("repository contains %v packs (%v blobs) with %v\n",
+	verbosef("repository contains %v packs (%v blobs) with %v\n",
 		len(idx.Packs), blobs, formatBytes(uint64(stats.bytes)))
 
 	blobCount := make(map[restic.BlobHandle]int)
@@ -174,24 +950,17 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 		}
 	}
 
-	Verbosef("processed %d blobs: %d duplicate blobs, %v duplicate\n",
+	verbosef("processed %d blobs: %d duplicate blobs, %v duplicate\n",
 		stats.blobs, duplicateBlobs, formatBytes(uint64(duplicateBytes)))
-	Verbosef("load all snapshots\n")
-
-	// find referenced blobs
-	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
-	if err != nil {
-		return err
-	}
 
 	stats.snapshots = len(snapshots)
 
-	Verbosef("find data that is still in use for %d snapshots\n", stats.snapshots)
+	verbosef("find data that is still in use for %d snapshots\n", stats.snapshots)
 
 	usedBlobs := restic.NewBlobSet()
 	seenBlobs := restic.NewBlobSet()
 
-	bar = newProgressMax(!gopts.Quiet, uint64(len(snapshots)), "snapshots")
+	bar = newProgressMax(!gopts.Quiet && !gopts.JSON, uint64(len(snapshots)), "snapshots")
 	bar.Start()
 	for _, sn := range snapshots {
 		debug.Log("process snapshot %v", sn.ID())
@@ -216,74 +985,80 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 			"https://github.com/quinn/restic/issues/new")
 	}
 
-	Verbosef("found %d of %d data blobs still in use, removing %d blobs\n",
+	verbosef("found %d of %d data blobs still in use, removing %d blobs\n",
 		len(usedBlobs), stats.blobs, stats.blobs-len(usedBlobs))
 
-	// find packs that need a rewrite
-	rewritePacks := restic.NewIDSet()
-	for _, pack := range idx.Packs {
-		if mixedBlobs(pack.Entries) {
-			rewritePacks.Insert(pack.ID)
-			continue
-		}
-
-		for _, blob := range pack.Entries {
-			h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
-			if !usedBlobs.Has(h) {
-				rewritePacks.Insert(pack.ID)
-				continue
-			}
-
-			if blobCount[h] > 1 {
-				rewritePacks.Insert(pack.ID)
-			}
-		}
-	}
-
-	removeBytes := duplicateBytes
-
-	// find packs that are unneeded
-	removePacks := restic.NewIDSet()
+	plan := planPrune(opts, idx, usedBlobs)
 
-	Verbosef("will remove %d invalid files\n", len(invalidFiles))
+	verbosef("will remove %d invalid files\n", len(invalidFiles))
 	for _, id := range invalidFiles {
-		removePacks.Insert(id)
+		plan.removePacks.Insert(id)
+		plan.removeBytes += uint64(packSize[id])
 	}
 
-	for packID, p := range idx.Packs {
-
-		hasActiveBlob := false
-		for _, blob := range p.Entries {
-			h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
-			if usedBlobs.Has(h) {
-				hasActiveBlob = true
-				continue
-			}
-
-			removeBytes += uint64(blob.Length)
+	// data saved by other clients since the index and the snapshots were
+	// loaded is kept
+	var data *clientData
+	if opts.Concurrent {
+		verbosef("checking for data saved by other clients\n")
+		data = newClientData(idx.IndexIDs, snapshotIDs)
+		kept, err := keepClientPacks(ctx, repo, data, idx, &plan)
+		if err != nil {
+			return err
 		}
+		if kept > 0 {
+			verbosef("keeping %d packs which contain data saved by other clients\n", kept)
+		}
+	}
 
-		if hasActiveBlob {
+	// marked packs which are still needed are added to the index again
+	resurrected := 0
+	for id := range tombstones {
+		if _, ok := packSize[id]; !ok {
+			debug.Log("marked pack %v does not exist any more", id)
+			delete(tombstones, id)
 			continue
 		}
 
-		removePacks.Insert(packID)
+		if !plan.removePacks.Has(id) {
+			verbosef("pack %v is referenced again, it will not be deleted\n", id.Str())
+			resurrected++
+		}
+	}
 
-		if !rewritePacks.Has(packID) {
-			return errors.Fatalf("pack %v is unneeded, but not contained in rewritePacks", packID.Str())
+	now := time.Now()
+
+	if opts.DryRun {
+		var size uint64
+		for _, s := range packSize {
+			size += uint64(s)
 		}
 
-		rewritePacks.Delete(packID)
+		remove := restic.NewIDSet()
+		remove.Merge(plan.removePacks)
+		remove.Merge(plan.repackPacks)
+		_, marked := splitRemovePacks(remove, tombstones, opts.GracePeriod, now)
+
+		report := newPruneReport(plan, size, len(invalidFiles))
+		report.MarkedPacks = len(marked)
+		report.ResurrectedPacks = resurrected
+		return printPruneReport(gopts, report)
 	}
 
-	Verbosef("will delete %d packs and rewrite %d packs, this frees %s\n",
-		len(removePacks), len(rewritePacks), formatBytes(uint64(removeBytes)))
+	verbosef("will delete %d packs (%s) and rewrite %d packs (%s), this frees %s\n",
+		len(plan.removePacks), formatBytes(plan.removeBytes),
+		len(plan.repackPacks), formatBytes(plan.repackBytes),
+		formatBytes(plan.removeBytes+plan.freedBytes))
+	verbosef("keeping %d packs, which still contain %s of unneeded data\n",
+		plan.keepPacks, formatBytes(plan.unusedBytes))
+
+	removePacks := plan.removePacks
 
 	var obsoletePacks restic.IDSet
-	if len(rewritePacks) != 0 {
-		bar = newProgressMax(!gopts.Quiet, uint64(len(rewritePacks)), "packs rewritten")
+	if len(plan.repackPacks) != 0 {
+		bar = newProgressMax(!gopts.Quiet && !gopts.JSON, uint64(len(plan.repackPacks)), "packs rewritten")
 		bar.Start()
-		obsoletePacks, err = repository.Repack(ctx, repo, rewritePacks, usedBlobs, bar)
+		obsoletePacks, err = repository.Repack(ctx, repo, plan.repackPacks, plan.keepBlobs, bar)
 		if err != nil {
 			return err
 		}
@@ -292,14 +1067,35 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 
 	removePacks.Merge(obsoletePacks)
 
-	if err = rebuildIndex(ctx, repo, removePacks); err != nil {
+	if opts.Concurrent {
+		err = saveConcurrentIndex(ctx, repo, idx, data, removePacks)
+	} else {
+		err = rebuildIndex(ctx, repo, removePacks)
+	}
+	if err != nil {
 		return err
 	}
 
-	if len(removePacks) != 0 {
-		bar = newProgressMax(!gopts.Quiet, uint64(len(removePacks)), "packs deleted")
+	deletePacks, marked := splitRemovePacks(removePacks, tombstones, opts.GracePeriod, now)
+	if len(marked) > 0 {
+		verbosef("marking %d packs for deletion, they will be deleted after %v\n", len(marked), opts.GracePeriod)
+		if _, err = restic.SaveTombstone(ctx, repo, marked); err != nil {
+			return errors.Fatalf("unable to save tombstone: %v", err)
+		}
+	}
+
+	// the new tombstone replaces all older ones
+	for _, id := range tombstoneFiles {
+		h := restic.Handle{Type: restic.TombstoneFile, Name: id.String()}
+		if err = repo.Backend().Remove(ctx, h); err != nil {
+			Warnf("unable to remove tombstone %v from the repository\n", id.Str())
+		}
+	}
+
+	if len(deletePacks) != 0 {
+		bar = newProgressMax(!gopts.Quiet && !gopts.JSON, uint64(len(deletePacks)), "packs deleted")
 		bar.Start()
-		for packID := range removePacks {
+		for packID := range deletePacks {
 			h := restic.Handle{Type: restic.DataFile, Name: packID.String()}
 			err = repo.Backend().Remove(ctx, h)
 			if err != nil {
@@ -310,6 +1106,6 @@ func pruneRepository(gopts GlobalOptions, repo restic.Repository) error {
 		bar.Done()
 	}
 
-	Verbosef("done\n")
+	verbosef("done\n")
 	return nil
 }
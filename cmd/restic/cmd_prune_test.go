@@ -0,0 +1,163 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/quinn/restic/internal/index"
+	"github.com/quinn/restic/internal/restic"
+	rtest "github.com/quinn/restic/internal/test"
+)
+
+func TestVerifyPruneOptions(t *testing.T) {
+	for _, test := range []struct {
+		opts    PruneOptions
+		percent float64
+		bytes   uint64
+		repack  uint64
+	}{
+		{PruneOptions{MaxUnused: "0%"}, 0, 0, 0},
+		{PruneOptions{MaxUnused: "12.5%"}, 12.5, 0, 0},
+		{PruneOptions{MaxUnused: "100"}, -1, 100, 0},
+		{PruneOptions{MaxUnused: "10M", MaxRepackSize: "2G"}, -1, 10 << 20, 2 << 30},
+		{PruneOptions{MaxUnused: "unlimited", MaxRepackSize: "1t"}, -1, 1<<64 - 1, 1 << 40},
+	} {
+		opts := test.opts
+		rtest.OK(t, verifyPruneOptions(&opts))
+		rtest.Equals(t, test.percent, opts.maxUnusedPercent)
+		rtest.Equals(t, test.bytes, opts.maxUnusedBytes)
+		rtest.Equals(t, test.repack, opts.maxRepackBytes)
+	}
+
+	for _, opts := range []PruneOptions{
+		{MaxUnused: ""},
+		{MaxUnused: "foo"},
+		{MaxUnused: "101%"},
+		{MaxUnused: "-1%"},
+		{MaxUnused: "5%", MaxRepackSize: "0"},
+		{MaxUnused: "5%", MaxRepackSize: "1x"},
+	} {
+		if err := verifyPruneOptions(&opts); err == nil {
+			t.Errorf("options %+v did not return an error", opts)
+		}
+	}
+}
+
+// testPack returns a pack containing blobs with the given lengths.
+func testPack(tpe restic.BlobType, lengths ...uint) (index.Pack, []restic.BlobHandle) {
+	pack := index.Pack{ID: restic.NewRandomID()}
+	var handles []restic.BlobHandle
+	for _, length := range lengths {
+		blob := restic.Blob{ID: restic.NewRandomID(), Type: tpe, Length: length}
+		pack.Entries = append(pack.Entries, blob)
+		pack.Size += int64(length)
+		handles = append(handles, restic.BlobHandle{ID: blob.ID, Type: blob.Type})
+	}
+	return pack, handles
+}
+
+func TestPlanPrune(t *testing.T) {
+	idx := &index.Index{Packs: make(map[restic.ID]index.Pack)}
+	used := restic.NewBlobSet()
+
+	// fully used
+	full, blobs := testPack(restic.DataBlob, 100, 100)
+	used.Insert(blobs[0])
+	used.Insert(blobs[1])
+
+	// 10% unused
+	little, blobs := testPack(restic.DataBlob, 900, 100)
+	used.Insert(blobs[0])
+
+	// 90% unused
+	much, blobs := testPack(restic.DataBlob, 100, 900)
+	used.Insert(blobs[0])
+
+	// not used at all
+	unused, _ := testPack(restic.DataBlob, 1000)
+
+	// the used blob is also contained in the fully used pack
+	dup, _ := testPack(restic.DataBlob, 100, 400)
+	dup.Entries[0].ID = full.Entries[0].ID
+
+	for _, pack := range []index.Pack{full, little, much, unused, dup} {
+		idx.Packs[pack.ID] = pack
+	}
+
+	for _, test := range []struct {
+		opts   PruneOptions
+		remove restic.IDSet
+		repack restic.IDSet
+	}{
+		{
+			PruneOptions{MaxUnused: "0%"},
+			restic.NewIDSet(unused.ID),
+			restic.NewIDSet(full.ID, little.ID, much.ID, dup.ID),
+		},
+		{
+			PruneOptions{MaxUnused: "50%"},
+			restic.NewIDSet(unused.ID),
+			restic.NewIDSet(full.ID, much.ID, dup.ID),
+		},
+		{
+			PruneOptions{MaxUnused: "200"},
+			restic.NewIDSet(unused.ID),
+			restic.NewIDSet(full.ID, much.ID, dup.ID),
+		},
+		{
+			// packs with duplicate blobs are rewritten regardless of the limit
+			PruneOptions{MaxUnused: "unlimited"},
+			restic.NewIDSet(unused.ID),
+			restic.NewIDSet(full.ID, dup.ID),
+		},
+		{
+			// the packs with duplicate blobs are rewritten first, the others
+			// do not fit
+			PruneOptions{MaxUnused: "0%", MaxRepackSize: "1500"},
+			restic.NewIDSet(unused.ID),
+			restic.NewIDSet(full.ID, dup.ID),
+		},
+		{
+			// the fully used pack does not fit, so the duplicate blob is
+			// kept there and the other pack is removed
+			PruneOptions{MaxUnused: "0%", MaxRepackSize: "500"},
+			restic.NewIDSet(unused.ID, dup.ID),
+			restic.NewIDSet(),
+		},
+	} {
+		opts := test.opts
+		rtest.OK(t, verifyPruneOptions(&opts))
+
+		plan := planPrune(opts, idx, used)
+		rtest.Equals(t, test.remove, plan.removePacks)
+		rtest.Equals(t, test.repack, plan.repackPacks)
+		rtest.Equals(t, len(idx.Packs)-len(test.remove)-len(test.repack), plan.keepPacks)
+
+		for id := range plan.repackPacks {
+			for _, blob := range idx.Packs[id].Entries {
+				h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+				rtest.Equals(t, used.Has(h), plan.keepBlobs.Has(h))
+			}
+		}
+	}
+}
+
+func TestSplitRemovePacks(t *testing.T) {
+	now := time.Now()
+	fresh, old, unmarked := restic.NewRandomID(), restic.NewRandomID(), restic.NewRandomID()
+	tombstones := map[restic.ID]time.Time{
+		fresh: now.Add(-time.Minute),
+		old:   now.Add(-2 * time.Hour),
+		// not removed any more
+		restic.NewRandomID(): now.Add(-2 * time.Hour),
+	}
+	remove := restic.NewIDSet(fresh, old, unmarked)
+
+	deletePacks, marked := splitRemovePacks(remove, tombstones, time.Hour, now)
+	rtest.Equals(t, restic.NewIDSet(old), deletePacks)
+	rtest.Equals(t, map[restic.ID]time.Time{fresh: tombstones[fresh], unmarked: now}, marked)
+
+	deletePacks, marked = splitRemovePacks(remove, tombstones, 0, now)
+	rtest.Equals(t, remove, deletePacks)
+	rtest.Equals(t, 0, len(marked))
+}
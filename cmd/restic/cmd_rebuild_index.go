@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 
 	"github.com/quinn/restic/internal/errors"
 	"github.com/quinn/restic/internal/index"
+	"github.com/quinn/restic/internal/repository"
 	"github.com/quinn/restic/internal/restic"
 
 	"github.com/spf13/cobra"
@@ -17,6 +19,14 @@ var cmdRebuildIndex = &cobra.Command{
 The "rebuild-index" command creates a new index based on the pack files in the
 repository.
 
+Pack files whose header is damaged are skipped. With --salvage, the blobs in
+these pack files are recovered by decrypting the data in front of the header
+instead. After a damaged blob, the search continues at the offsets recorded in
+the damaged header and in the old index. The header stores the type of each
+blob, so only the recovered blobs which are referenced by the snapshots are
+saved to new pack files, with the type they are referenced as. The original
+pack files are not removed.
+
 EXIT STATUS
 ===========
 
@@ -24,15 +34,25 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 `,
 	DisableAutoGenTag: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runRebuildIndex(globalOptions)
+		return runRebuildIndex(rebuildIndexOptions, globalOptions)
 	},
 }
 
+// RebuildIndexOptions collects all options for the rebuild-index command.
+type RebuildIndexOptions struct {
+	Salvage bool
+}
+
+var rebuildIndexOptions RebuildIndexOptions
+
 func init() {
 	cmdRoot.AddCommand(cmdRebuildIndex)
+
+	f := cmdRebuildIndex.Flags()
+	f.BoolVar(&rebuildIndexOptions.Salvage, "salvage", false, "recover blobs from pack files with a damaged header")
 }
 
-func runRebuildIndex(gopts GlobalOptions) error {
+func runRebuildIndex(opts RebuildIndexOptions, gopts GlobalOptions) error {
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
@@ -46,25 +66,51 @@ func runRebuildIndex(gopts GlobalOptions) error {
 
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
-	return rebuildIndex(ctx, repo, restic.NewIDSet())
+
+	if !opts.Salvage {
+		return rebuildIndex(ctx, repo, restic.NewIDSet())
+	}
+
+	idx, _, packSize, err := buildIndex(ctx, repo, restic.NewIDSet())
+	if err != nil {
+		return err
+	}
+
+	if err = salvagePacks(ctx, repo, idx, idx.DamagedPacks, packSize); err != nil {
+		return err
+	}
+
+	return replaceIndex(ctx, repo, idx)
 }
 
 func rebuildIndex(ctx context.Context, repo restic.Repository, ignorePacks restic.IDSet) error {
+	idx, _, _, err := buildIndex(ctx, repo, ignorePacks)
+	if err != nil {
+		return err
+	}
+
+	return replaceIndex(ctx, repo, idx)
+}
+
+// buildIndex creates a new index from the pack files in the repository, except
+// for the ones in ignorePacks. The pack files which cannot be listed are
+// returned in invalidFiles, packSize contains the size of all pack files.
+func buildIndex(ctx context.Context, repo restic.Repository, ignorePacks restic.IDSet) (idx *index.Index, invalidFiles restic.IDs, packSize map[restic.ID]int64, err error) {
 	Verbosef("counting files in repo\n")
 
-	var packs uint64
-	err := repo.List(ctx, restic.DataFile, func(restic.ID, int64) error {
-		packs++
+	packSize = make(map[restic.ID]int64)
+	err = repo.List(ctx, restic.DataFile, func(id restic.ID, size int64) error {
+		packSize[id] = size
 		return nil
 	})
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 
-	bar := newProgressMax(!globalOptions.Quiet, packs-uint64(len(ignorePacks)), "packs")
-	idx, invalidFiles, err := index.New(ctx, repo, ignorePacks, bar)
+	bar := newProgressMax(!globalOptions.Quiet, uint64(len(packSize)-len(ignorePacks)), "packs")
+	idx, invalidFiles, err = index.New(ctx, repo, ignorePacks, bar)
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 
 	if globalOptions.verbosity >= 2 {
@@ -73,10 +119,195 @@ func rebuildIndex(ctx context.Context, repo restic.Repository, ignorePacks resti
 		}
 	}
 
+	return idx, invalidFiles, packSize, nil
+}
+
+// salvagePacks recovers the blobs from the pack files in packs, whose header
+// is damaged, and saves the ones missing in idx to new pack files. The type of
+// a blob is only recorded in the header, so only blobs which are referenced by
+// the trees of the snapshots are saved, with the type they are referenced as.
+// The new pack files are added to idx.
+func salvagePacks(ctx context.Context, repo *repository.Repository, idx *index.Index, packs restic.IDs, packSize map[restic.ID]int64) error {
+	if len(packs) == 0 {
+		return nil
+	}
+
+	// the offsets of the blobs in the old index are used to continue after
+	// damaged blobs
+	hints := make(map[restic.ID][]uint)
+	oldIdx, err := index.Load(ctx, repo, nil)
+	if err != nil {
+		Warnf("unable to load the old index, damaged blobs may hide the blobs after them: %v\n", err)
+	} else {
+		for _, id := range packs {
+			for _, blob := range oldIdx.Packs[id].Entries {
+				hints[id] = append(hints[id], blob.Offset)
+			}
+		}
+	}
+
+	// make the blobs in the intact pack files available to load trees
+	known := restic.NewBlobSet()
+	for id, pack := range idx.Packs {
+		repo.Index().(*repository.MasterIndex).StorePack(id, pack.Entries)
+		for _, blob := range pack.Entries {
+			known.Insert(restic.BlobHandle{ID: blob.ID, Type: blob.Type})
+		}
+	}
+
+	Verbosef("salvage blobs from %d damaged pack files\n", len(packs))
+
+	salvaged := make(map[restic.ID][]byte)
+	found := make(map[restic.ID]int)
+	var lost uint64
+	for _, id := range packs {
+		blobs, lostBytes, err := repo.SalvagePack(ctx, id, packSize[id], hints[id])
+		if err != nil {
+			Warnf("unable to salvage pack file %v: %v\n", id.Str(), err)
+			continue
+		}
+
+		for _, blob := range blobs {
+			salvaged[blob.ID] = blob.Plaintext
+		}
+		found[id] = len(blobs)
+		lost += uint64(lostBytes)
+	}
+
+	Verbosef("find blobs referenced by snapshots\n")
+	used, err := findSalvageReferences(ctx, repo, salvaged)
+	if err != nil {
+		return err
+	}
+
+	var saved, unreferenced int
+	for id, plaintext := range salvaged {
+		referenced := false
+		for _, t := range []restic.BlobType{restic.DataBlob, restic.TreeBlob} {
+			h := restic.BlobHandle{ID: id, Type: t}
+			if !used.Has(h) {
+				continue
+			}
+			referenced = true
+			if known.Has(h) {
+				continue
+			}
+
+			_, _, err = repo.SaveBlob(ctx, t, plaintext, id, false)
+			if err != nil {
+				return err
+			}
+			known.Insert(h)
+			saved++
+		}
+
+		if !referenced {
+			unreferenced++
+		}
+	}
+
+	for _, id := range packs {
+		if n, ok := found[id]; ok {
+			Verbosef("pack file %v: recovered %d blobs\n", id.Str(), n)
+		}
+	}
+
+	if err := repo.FlushPacks(ctx); err != nil {
+		return err
+	}
+
+	// add the new pack files to the index
+	entries := make(map[restic.ID][]restic.Blob)
+	for blob := range repo.Index().Each(ctx) {
+		if _, ok := idx.Packs[blob.PackID]; ok {
+			continue
+		}
+		entries[blob.PackID] = append(entries[blob.PackID], blob.Blob)
+	}
+	for id, blobs := range entries {
+		fi, err := repo.Backend().Stat(ctx, restic.Handle{Type: restic.DataFile, Name: id.String()})
+		if err != nil {
+			return err
+		}
+		if err = idx.AddPack(id, fi.Size, blobs); err != nil {
+			return err
+		}
+	}
+	idx.ParityGroups = append(idx.ParityGroups, repo.Index().(*repository.MasterIndex).ParityGroups()...)
+
+	Printf("salvaged %d blobs from %d damaged pack files, saved %d missing blobs to %d new pack files, %s could not be recovered\n",
+		len(salvaged), len(packs), saved, len(entries), formatBytes(lost))
+	if unreferenced > 0 {
+		Printf("%d salvaged blobs are not referenced by any snapshot and were not saved\n", unreferenced)
+	}
+	return nil
+}
+
+// findSalvageReferences returns the blobs referenced by the trees of all
+// snapshots. Trees are loaded from the salvaged blobs or from the repository,
+// trees which cannot be loaded are skipped.
+func findSalvageReferences(ctx context.Context, repo restic.Repository, salvaged map[restic.ID][]byte) (restic.BlobSet, error) {
+	snapshots, err := restic.LoadAllSnapshots(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	loadTree := func(id restic.ID) (*restic.Tree, error) {
+		if buf, ok := salvaged[id]; ok {
+			tree := &restic.Tree{}
+			if err := json.Unmarshal(buf, tree); err != nil {
+				return nil, err
+			}
+			return tree, nil
+		}
+		return repo.LoadTree(ctx, id)
+	}
+
+	used := restic.NewBlobSet()
+	var walk func(id restic.ID)
+	walk = func(id restic.ID) {
+		h := restic.BlobHandle{ID: id, Type: restic.TreeBlob}
+		if used.Has(h) {
+			return
+		}
+		used.Insert(h)
+
+		tree, err := loadTree(id)
+		if err != nil {
+			Warnf("unable to load tree %v: %v\n", id.Str(), err)
+			return
+		}
+
+		for _, node := range tree.Nodes {
+			switch node.Type {
+			case "file":
+				for _, blob := range node.Content {
+					used.Insert(restic.BlobHandle{ID: blob, Type: restic.DataBlob})
+				}
+			case "dir":
+				if node.Subtree != nil {
+					walk(*node.Subtree)
+				}
+			}
+		}
+	}
+
+	for _, sn := range snapshots {
+		if sn.Tree != nil {
+			walk(*sn.Tree)
+		}
+	}
+
+	return used, nil
+}
+
+// replaceIndex saves idx as the new index, which replaces all existing index
+// files. The parity groups of the existing index files are kept.
+func replaceIndex(ctx context.Context, repo restic.Repository, idx *index.Index) error {
 	Verbosef("finding old index files\n")
 
 	var supersedes restic.IDs
-	err = repo.List(ctx, restic.IndexFile, func(id restic.ID, size int64) error {
+	err := repo.List(ctx, restic.IndexFile, func(id restic.ID, size int64) error {
 		supersedes = append(supersedes, id)
 		return nil
 	})
@@ -84,9 +315,31 @@ func rebuildIndex(ctx context.Context, repo restic.Repository, ignorePacks resti
 		return err
 	}
 
+	groups, err := index.LoadParityGroups(ctx, repo)
+	if err != nil {
+		return err
+	}
+	idx.ParityGroups = append(groups, idx.ParityGroups...)
+
+	_, err = saveIndex(ctx, repo, idx, supersedes)
+	return err
+}
+
+// saveIndex saves idx as the new index, which replaces the index files in
+// supersedes. Parity groups which lost too many packs are removed together with
+// their parity files. The IDs of the new index files are returned.
+func saveIndex(ctx context.Context, repo restic.Repository, idx *index.Index, supersedes restic.IDs) (restic.IDs, error) {
+	available := restic.NewIDSet()
+	for id := range idx.Packs {
+		available.Insert(id)
+	}
+
+	var dropParity []repository.ParityGroup
+	idx.ParityGroups, dropParity = repository.FilterParityGroups(idx.ParityGroups, available)
+
 	ids, err := idx.Save(ctx, repo, supersedes)
 	if err != nil {
-		return errors.Fatalf("unable to save index, last error was: %v", err)
+		return nil, errors.Fatalf("unable to save index, last error was: %v", err)
 	}
 
 	Verbosef("saved new indexes as %v\n", ids)
@@ -102,5 +355,20 @@ func rebuildIndex(ctx context.Context, repo restic.Repository, ignorePacks resti
 		}
 	}
 
-	return nil
+	if len(dropParity) > 0 {
+		Verbosef("remove parity files of %d parity groups which lost too many packs\n", len(dropParity))
+	}
+
+	for _, group := range dropParity {
+		for _, id := range group.Parity {
+			if err := repo.Backend().Remove(ctx, restic.Handle{
+				Type: restic.ParityFile,
+				Name: id.String(),
+			}); err != nil {
+				Warnf("error removing parity file %v: %v\n", id.Str(), err)
+			}
+		}
+	}
+
+	return ids, nil
 }
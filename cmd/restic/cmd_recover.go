@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/quinn/restic/internal/errors"
+	"github.com/quinn/restic/internal/repository"
 	"github.com/quinn/restic/internal/restic"
 	"github.com/spf13/cobra"
 )
@@ -17,6 +18,10 @@ The "recover" command build a new snapshot from all directories it can find in
 the raw data of the repository. It can be used if, for example, a snapshot has
 been removed by accident with "forget".
 
+Packs which are referenced by the index but missing in the repository are
+reconstructed first, if parity files have been written for them and enough of
+their parity group is still intact.
+
 EXIT STATUS
 ===========
 
@@ -38,6 +43,11 @@ func runRecover(gopts GlobalOptions) error {
 		return err
 	}
 
+	key, err := signingKey(gopts)
+	if err != nil {
+		return err
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
@@ -54,6 +64,11 @@ func runRecover(gopts GlobalOptions) error {
 		return err
 	}
 
+	Verbosef("reconstruct missing packs\n")
+	if err = recoverMissingPacks(gopts, repo); err != nil {
+		return err
+	}
+
 	// trees maps a tree ID to whether or not it is referenced by a different
 	// tree. If it is not referenced, we have a root tree.
 	trees := make(map[restic.ID]bool)
@@ -137,6 +152,13 @@ func runRecover(gopts GlobalOptions) error {
 
 	sn.Tree = &treeID
 
+	if key != nil {
+		err = sn.Sign(key)
+		if err != nil {
+			return errors.Fatalf("unable to sign snapshot: %v", err)
+		}
+	}
+
 	id, err := repo.SaveJSONUnpacked(gopts.ctx, restic.SnapshotFile, sn)
 	if err != nil {
 		return errors.Fatalf("unable to save snapshot: %v", err)
@@ -146,3 +168,40 @@ func runRecover(gopts GlobalOptions) error {
 
 	return nil
 }
+
+// recoverMissingPacks reconstructs all packs referenced by the index which are
+// missing in the backend from the parity files of their group.
+func recoverMissingPacks(gopts GlobalOptions, repo *repository.Repository) error {
+	missing := restic.NewIDSet()
+	for blob := range repo.Index().Each(gopts.ctx) {
+		missing.Insert(blob.PackID)
+	}
+
+	err := repo.List(gopts.ctx, restic.DataFile, func(id restic.ID, size int64) error {
+		missing.Delete(id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for id := range missing {
+		if !missing.Has(id) {
+			// already reconstructed together with another pack of its group
+			continue
+		}
+
+		ids, err := repo.RepairPack(gopts.ctx, id)
+		for _, repairedID := range ids {
+			Verbosef("reconstructed pack %v\n", repairedID.Str())
+			missing.Delete(repairedID)
+		}
+		if err == repository.ErrNoParity {
+			Warnf("pack %v is missing, no parity files exist for it\n", id.Str())
+		} else if err != nil {
+			Warnf("pack %v is missing and cannot be reconstructed: %v\n", id.Str(), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var cmdRepair = &cobra.Command{
+	Use:   "repair",
+	Short: "Repair the repository",
+	Long: `
+The "repair" command contains subcommands which repair damaged parts of the
+repository.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+}
+
+func init() {
+	cmdRoot.AddCommand(cmdRepair)
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"path"
+
+	"github.com/spf13/cobra"
+
+	"github.com/quinn/restic/internal/crypto"
+	"github.com/quinn/restic/internal/debug"
+	"github.com/quinn/restic/internal/errors"
+	"github.com/quinn/restic/internal/repository"
+	"github.com/quinn/restic/internal/restic"
+)
+
+var cmdRepairSnapshots = &cobra.Command{
+	Use:   "snapshots [flags] [snapshot ID] [...]",
+	Short: "Repair snapshots which reference missing data",
+	Long: `
+The "repair snapshots" command rewrites snapshots which reference data that is
+missing in the repository, so that they can be restored again.
+
+Directories whose tree cannot be loaded are replaced by empty directories. By
+default, missing parts of a file are removed from it, which makes the file
+shorter. With --zero-fill they are replaced by zeros instead if their size is
+still known, so that the file keeps its size. The damage is recorded in the
+"error" field of the affected files and directories.
+
+For each damaged snapshot a new snapshot is saved, which references the
+original snapshot and has the tag "repaired". The original snapshots are kept
+unless --forget is given. With --dry-run the damage is only reported.
+
+When no snapshot ID is given, all snapshots matching the host, tag and path
+filter criteria are repaired.
+
+EXIT STATUS
+===========
+
+Exit status is 0 if the command was successful, and non-zero if there was any error.
+`,
+	DisableAutoGenTag: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRepairSnapshots(repairSnapshotsOptions, globalOptions, args)
+	},
+}
+
+// RepairSnapshotsOptions collects all options for the 'repair snapshots' command.
+type RepairSnapshotsOptions struct {
+	DryRun   bool
+	Forget   bool
+	ZeroFill bool
+
+	Hosts []string
+	Tags  restic.TagLists
+	Paths []string
+}
+
+var repairSnapshotsOptions RepairSnapshotsOptions
+
+func init() {
+	cmdRepair.AddCommand(cmdRepairSnapshots)
+
+	f := cmdRepairSnapshots.Flags()
+	f.BoolVarP(&repairSnapshotsOptions.DryRun, "dry-run", "n", false, "only report the damage, do not save new snapshots")
+	f.BoolVar(&repairSnapshotsOptions.Forget, "forget", false, "remove the original snapshots after saving the repaired ones")
+	f.BoolVar(&repairSnapshotsOptions.ZeroFill, "zero-fill", false, "replace missing parts of files by zeros instead of removing them")
+
+	f.StringArrayVarP(&repairSnapshotsOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host`, when no snapshot ID is given (can be specified multiple times)")
+	f.Var(&repairSnapshotsOptions.Tags, "tag", "only consider snapshots which include this `taglist`, when no snapshot ID is given")
+	f.StringArrayVar(&repairSnapshotsOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot ID is given")
+}
+
+// repairedTree is the result of repairing a tree. If the tree could not be
+// loaded, it is lost and replaced by an empty tree.
+type repairedTree struct {
+	id      restic.ID
+	changed bool
+	lost    bool
+}
+
+// treeRepairer rewrites trees so that they only reference data which is
+// available in the repository.
+type treeRepairer struct {
+	repo     restic.Repository
+	packs    restic.IDSet
+	zeroFill bool
+	dryRun   bool
+
+	trees     map[restic.ID]repairedTree
+	emptyTree *restic.ID
+}
+
+// blobSize returns the size of the plaintext of the blob and whether it is
+// stored in a pack which exists in the repository.
+func (r *treeRepairer) blobSize(id restic.ID, tpe restic.BlobType) (size uint, known, available bool) {
+	blobs, found := r.repo.Index().Lookup(id, tpe)
+	if !found {
+		return 0, false, false
+	}
+
+	size = blobs[0].Length - crypto.Extension
+	for _, blob := range blobs {
+		if r.packs.Has(blob.PackID) {
+			return size, true, true
+		}
+	}
+	return size, true, false
+}
+
+// saveTree saves tree unless this is a dry run.
+func (r *treeRepairer) saveTree(ctx context.Context, tree *restic.Tree) (restic.ID, error) {
+	if r.dryRun {
+		return restic.ID{}, nil
+	}
+	return r.repo.SaveTree(ctx, tree)
+}
+
+// replacementTree returns the ID of the empty tree used for directories which
+// cannot be loaded.
+func (r *treeRepairer) replacementTree(ctx context.Context) (restic.ID, error) {
+	if r.emptyTree == nil {
+		id, err := r.saveTree(ctx, restic.NewTree())
+		if err != nil {
+			return restic.ID{}, err
+		}
+		r.emptyTree = &id
+	}
+	return *r.emptyTree, nil
+}
+
+// repairFile removes missing blobs from the content of node or replaces them
+// by zeros. It returns whether node was changed.
+func (r *treeRepairer) repairFile(ctx context.Context, nodepath string, node *restic.Node) (bool, error) {
+	var content restic.IDs
+	var size uint64
+	var removed, filled int
+
+	for _, id := range node.Content {
+		blobSize, known, available := r.blobSize(id, restic.DataBlob)
+		switch {
+		case available:
+			content = append(content, id)
+			size += uint64(blobSize)
+		case known && r.zeroFill:
+			zeroID := restic.Hash(make([]byte, blobSize))
+			if !r.dryRun {
+				var err error
+				zeroID, _, err = r.repo.SaveBlob(ctx, restic.DataBlob, make([]byte, blobSize), restic.ID{}, false)
+				if err != nil {
+					return false, err
+				}
+			}
+			content = append(content, zeroID)
+			size += uint64(blobSize)
+			filled++
+		default:
+			removed++
+		}
+	}
+
+	if removed == 0 && filled == 0 {
+		return false, nil
+	}
+
+	node.Error = fmt.Sprintf("repaired: %d of %d parts were missing", removed+filled, len(node.Content))
+	switch {
+	case removed > 0 && filled > 0:
+		Verbosef("  file %q: removed %d and zero-filled %d missing parts\n", nodepath, removed, filled)
+	case removed > 0:
+		Verbosef("  file %q: removed %d missing parts\n", nodepath, removed)
+	default:
+		Verbosef("  file %q: zero-filled %d missing parts\n", nodepath, filled)
+	}
+
+	node.Content = content
+	node.Size = size
+	return true, nil
+}
+
+// repairTree returns the ID of the repaired tree with the given ID and whether
+// it differs from the original one. In a dry run, the ID of changed trees is
+// undefined.
+func (r *treeRepairer) repairTree(ctx context.Context, nodepath string, id restic.ID) (repairedTree, error) {
+	if res, ok := r.trees[id]; ok {
+		return res, nil
+	}
+
+	tree, err := r.repo.LoadTree(ctx, id)
+	if err != nil {
+		debug.Log("unable to load tree %v: %v", id, err)
+		empty, err := r.replacementTree(ctx)
+		if err != nil {
+			return repairedTree{}, err
+		}
+
+		res := repairedTree{id: empty, changed: true, lost: true}
+		r.trees[id] = res
+		return res, nil
+	}
+
+	changed := false
+	for _, node := range tree.Nodes {
+		nodepath := path.Join(nodepath, node.Name)
+
+		switch {
+		case node.Type == "file":
+			fileChanged, err := r.repairFile(ctx, nodepath, node)
+			if err != nil {
+				return repairedTree{}, err
+			}
+			changed = changed || fileChanged
+
+		case node.Type == "dir" && node.Subtree != nil:
+			res, err := r.repairTree(ctx, nodepath, *node.Subtree)
+			if err != nil {
+				return repairedTree{}, err
+			}
+
+			if res.lost {
+				Verbosef("  dir %q: replaced unreadable directory by an empty one\n", nodepath)
+				node.Error = fmt.Sprintf("repaired: tree %v could not be loaded, its content is lost", node.Subtree.Str())
+			}
+
+			if res.changed {
+				subtree := res.id
+				node.Subtree = &subtree
+				changed = true
+			}
+		}
+	}
+
+	res := repairedTree{id: id}
+	if changed {
+		res.changed = true
+		res.id, err = r.saveTree(ctx, tree)
+		if err != nil {
+			return repairedTree{}, err
+		}
+	}
+
+	r.trees[id] = res
+	return res, nil
+}
+
+// repairSnapshot repairs the tree of sn and saves a new snapshot if it was
+// changed. It returns whether the snapshot was damaged.
+func repairSnapshot(ctx context.Context, repo *repository.Repository, r *treeRepairer, sn *restic.Snapshot, key ed25519.PrivateKey, opts RepairSnapshotsOptions) (bool, error) {
+	if sn.Tree == nil {
+		return false, errors.Errorf("snapshot %v has no tree", sn.ID().Str())
+	}
+
+	res, err := r.repairTree(ctx, "/", *sn.Tree)
+	if err != nil {
+		return false, err
+	}
+	if res.lost {
+		Verbosef("  root tree %v could not be loaded, replaced by an empty one\n", sn.Tree.Str())
+	}
+
+	if !res.changed || opts.DryRun {
+		return res.changed, nil
+	}
+
+	if err = repo.Flush(ctx); err != nil {
+		return false, err
+	}
+
+	oldID := *sn.ID()
+	if sn.Original == nil {
+		sn.Original = &oldID
+	}
+	sn.Tree = &res.id
+	sn.AddTags([]string{"repaired"})
+
+	// The old signature does not match the repaired snapshot any more.
+	if key != nil {
+		if err = sn.Sign(key); err != nil {
+			return false, err
+		}
+	} else if sn.Signature != nil {
+		Warnf("repaired snapshot of %v is not signed, use --signing-key to sign it\n", oldID.Str())
+		sn.Signature = nil
+	}
+
+	newID, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+	if err != nil {
+		return false, err
+	}
+	Printf("snapshot %v repaired, saved as %v\n", oldID.Str(), newID.Str())
+
+	if opts.Forget {
+		h := restic.Handle{Type: restic.SnapshotFile, Name: oldID.String()}
+		if err = repo.Backend().Remove(ctx, h); err != nil {
+			return false, err
+		}
+		Verbosef("removed original snapshot %v\n", oldID.Str())
+	}
+
+	return true, nil
+}
+
+func runRepairSnapshots(opts RepairSnapshotsOptions, gopts GlobalOptions, args []string) error {
+	if opts.DryRun && opts.Forget {
+		return errors.Fatal("--dry-run and --forget cannot be given at the same time")
+	}
+
+	key, err := signingKey(gopts)
+	if err != nil {
+		return err
+	}
+
+	repo, err := OpenRepository(gopts)
+	if err != nil {
+		return err
+	}
+
+	if !gopts.NoLock {
+		lockFn := lockRepoExclusive
+		if opts.DryRun {
+			lockFn = lockRepo
+		}
+		lock, err := lockFn(repo)
+		defer unlockRepo(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	Verbosef("load index files\n")
+	if err = repo.LoadIndex(gopts.ctx); err != nil {
+		return err
+	}
+
+	packs := restic.NewIDSet()
+	err = repo.List(gopts.ctx, restic.DataFile, func(id restic.ID, size int64) error {
+		packs.Insert(id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r := &treeRepairer{
+		repo:     repo,
+		packs:    packs,
+		zeroFill: opts.ZeroFill,
+		dryRun:   opts.DryRun,
+		trees:    make(map[restic.ID]repairedTree),
+	}
+
+	ctx, cancel := context.WithCancel(gopts.ctx)
+	defer cancel()
+
+	damaged := 0
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, nil, opts.Paths, args) {
+		Verbosef("check snapshot %v\n", sn.ID().Str())
+		changed, err := repairSnapshot(ctx, repo, r, sn, key, opts)
+		if err != nil {
+			return errors.Fatalf("unable to repair snapshot %v: %v", sn.ID().Str(), err)
+		}
+		if changed {
+			damaged++
+		}
+	}
+
+	switch {
+	case damaged == 0:
+		Verbosef("no damaged snapshots were found\n")
+	case opts.DryRun:
+		Printf("%d snapshots are damaged and would be repaired\n", damaged)
+	default:
+		Verbosef("repaired %d snapshots\n", damaged)
+	}
+
+	return nil
+}
@@ -22,6 +22,9 @@ a directory.
 The special snapshot "latest" can be used to restore the latest snapshot in the
 repository.
 
+If a trusted keys file is specified (--trusted-keys), only snapshots signed by
+one of the trusted keys are restored unless --allow-unsigned is given.
+
 EXIT STATUS
 ===========
 
@@ -43,7 +46,9 @@ type RestoreOptions struct {
 	Hosts              []string
 	Paths              []string
 	Tags               restic.TagLists
+	Labels             restic.LabelList
 	Verify             bool
+	AllowUnsigned      bool
 }
 
 var restoreOptions RestoreOptions
@@ -60,8 +65,10 @@ func init() {
 
 	flags.StringArrayVarP(&restoreOptions.Hosts, "host", "H", nil, `only consider snapshots for this host when the snapshot ID is "latest" (can be specified multiple times)`)
 	flags.Var(&restoreOptions.Tags, "tag", "only consider snapshots which include this `taglist` for snapshot ID \"latest\"")
+	flags.Var(&restoreOptions.Labels, "label", "only consider snapshots which have this label given as `key=value` for snapshot ID \"latest\" (can be specified multiple times)")
 	flags.StringArrayVar(&restoreOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path` for snapshot ID \"latest\"")
 	flags.BoolVar(&restoreOptions.Verify, "verify", false, "verify restored files content")
+	flags.BoolVar(&restoreOptions.AllowUnsigned, "allow-unsigned", false, "restore snapshots which are not signed by a trusted key")
 }
 
 func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
@@ -92,6 +99,11 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("exclude and include patterns are mutually exclusive")
 	}
 
+	trusted, verifySignature, err := trustedPublicKeys(gopts)
+	if err != nil {
+		return err
+	}
+
 	snapshotIDString := args[0]
 
 	debug.Log("restore %v to %v", snapshotIDString, opts.Target)
@@ -117,7 +129,7 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 	var id restic.ID
 
 	if snapshotIDString == "latest" {
-		id, err = restic.FindLatestSnapshot(ctx, repo, opts.Paths, opts.Tags, opts.Hosts)
+		id, err = restic.FindLatestSnapshot(ctx, repo, opts.Paths, opts.Tags, opts.Labels, opts.Hosts)
 		if err != nil {
 			Exitf(1, "latest snapshot for criteria not found: %v Paths:%v Hosts:%v", err, opts.Paths, opts.Hosts)
 		}
@@ -133,6 +145,16 @@ func runRestore(opts RestoreOptions, gopts GlobalOptions, args []string) error {
 		Exitf(2, "creating restorer failed: %v\n", err)
 	}
 
+	if verifySignature {
+		err = res.Snapshot().VerifySignature(trusted)
+		if err != nil && !opts.AllowUnsigned {
+			return errors.Fatalf("snapshot %v: %v, use --allow-unsigned to restore it anyway", id.Str(), err)
+		}
+		if err != nil {
+			Warnf("snapshot %v: %v\n", id.Str(), err)
+		}
+	}
+
 	totalErrors := 0
 	res.Error = func(location string, err error) error {
 		Warnf("ignoring error for %s: %s\n", location, err)
@@ -19,6 +19,10 @@ var cmdSnapshots = &cobra.Command{
 	Long: `
 The "snapshots" command lists all snapshots stored in the repository.
 
+If a trusted keys file is specified (--trusted-keys), the signatures of the
+snapshots are verified and a warning is printed for each snapshot which is not
+signed by a trusted key.
+
 EXIT STATUS
 ===========
 
@@ -34,6 +38,7 @@ Exit status is 0 if the command was successful, and non-zero if there was any er
 type SnapshotOptions struct {
 	Hosts   []string
 	Tags    restic.TagLists
+	Labels  restic.LabelList
 	Paths   []string
 	Compact bool
 	Last    bool
@@ -48,6 +53,7 @@ func init() {
 	f := cmdSnapshots.Flags()
 	f.StringArrayVarP(&snapshotOptions.Hosts, "host", "H", nil, "only consider snapshots for this `host` (can be specified multiple times)")
 	f.Var(&snapshotOptions.Tags, "tag", "only consider snapshots which include this `taglist` (can be specified multiple times)")
+	f.Var(&snapshotOptions.Labels, "label", "only consider snapshots which have this label given as `key=value` (can be specified multiple times)")
 	f.StringArrayVar(&snapshotOptions.Paths, "path", nil, "only consider snapshots for this `path` (can be specified multiple times)")
 	f.BoolVarP(&snapshotOptions.Compact, "compact", "c", false, "use compact format")
 	f.BoolVar(&snapshotOptions.Last, "last", false, "only show the last snapshot for each host and path")
@@ -71,9 +77,27 @@ func runSnapshots(opts SnapshotOptions, gopts GlobalOptions, args []string) erro
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
 
+	trusted, verify, err := trustedPublicKeys(gopts)
+	if err != nil {
+		return err
+	}
+
 	var snapshots restic.Snapshots
-	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args) {
+	var signatures map[restic.ID]string
+	if verify {
+		signatures = make(map[restic.ID]string)
+	}
+
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Labels, opts.Paths, args) {
 		snapshots = append(snapshots, sn)
+
+		if verify {
+			err := sn.VerifySignature(trusted)
+			if err != nil {
+				Warnf("snapshot %v: %v\n", sn.ID().Str(), err)
+			}
+			signatures[*sn.ID()] = signatureStatus(err)
+		}
 	}
 	snapshotGroups, grouped, err := restic.GroupSnapshots(snapshots, opts.GroupBy)
 	if err != nil {
@@ -89,7 +113,7 @@ func runSnapshots(opts SnapshotOptions, gopts GlobalOptions, args []string) erro
 	}
 
 	if gopts.JSON {
-		err := printSnapshotGroupJSON(gopts.stdout, snapshotGroups, grouped)
+		err := printSnapshotGroupJSON(gopts.stdout, snapshotGroups, grouped, signatures)
 		if err != nil {
 			Warnf("error printing snapshots: %v\n", err)
 		}
@@ -165,6 +189,7 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 
 	// Determine the max widths for host and tag.
 	maxHost, maxTag := 10, 6
+	var hasLabels bool
 	for _, sn := range list {
 		if len(sn.Hostname) > maxHost {
 			maxHost = len(sn.Hostname)
@@ -174,6 +199,9 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 				maxTag = len(tag)
 			}
 		}
+		if len(sn.Labels) > 0 {
+			hasLabels = true
+		}
 	}
 
 	tab := table.New()
@@ -188,6 +216,9 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 		tab.AddColumn("Time", "{{ .Timestamp }}")
 		tab.AddColumn("Host      ", "{{ .Hostname }}")
 		tab.AddColumn("Tags      ", `{{ join .Tags "," }}`)
+		if hasLabels {
+			tab.AddColumn("Labels", `{{ join .Labels "\n" }}`)
+		}
 		if len(reasons) > 0 {
 			tab.AddColumn("Reasons", `{{ join .Reasons "\n" }}`)
 		}
@@ -199,6 +230,7 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 		Timestamp string
 		Hostname  string
 		Tags      []string
+		Labels    []string
 		Reasons   []string
 		Paths     []string
 	}
@@ -218,7 +250,12 @@ func PrintSnapshots(stdout io.Writer, list restic.Snapshots, reasons []restic.Ke
 			data.Reasons = keepReasons[*id].Matches
 		}
 
-		if len(sn.Paths) > 1 && !compact {
+		for k, v := range sn.Labels {
+			data.Labels = append(data.Labels, k+"="+v)
+		}
+		sort.Strings(data.Labels)
+
+		if (len(sn.Paths) > 1 || len(sn.Labels) > 1) && !compact {
 			multiline = true
 		}
 
@@ -288,6 +325,9 @@ type Snapshot struct {
 
 	ID      *restic.ID `json:"id"`
 	ShortID string     `json:"short_id"`
+
+	// SignatureStatus is only set when signatures are verified.
+	SignatureStatus string `json:"signature_status,omitempty"`
 }
 
 // SnapshotGroup helps to print SnaphotGroups as JSON with their GroupReasons included.
@@ -296,8 +336,9 @@ type SnapshotGroup struct {
 	Snapshots []Snapshot              `json:"snapshots"`
 }
 
-// printSnapshotsJSON writes the JSON representation of list to stdout.
-func printSnapshotGroupJSON(stdout io.Writer, snGroups map[string]restic.Snapshots, grouped bool) error {
+// printSnapshotsJSON writes the JSON representation of list to stdout. If
+// signatures is not nil, it contains the signature status of each snapshot.
+func printSnapshotGroupJSON(stdout io.Writer, snGroups map[string]restic.Snapshots, grouped bool, signatures map[restic.ID]string) error {
 	if grouped {
 		var snapshotGroups []SnapshotGroup
 
@@ -313,9 +354,10 @@ func printSnapshotGroupJSON(stdout io.Writer, snGroups map[string]restic.Snapsho
 
 			for _, sn := range list {
 				k := Snapshot{
-					Snapshot: sn,
-					ID:       sn.ID(),
-					ShortID:  sn.ID().Str(),
+					Snapshot:        sn,
+					ID:              sn.ID(),
+					ShortID:         sn.ID().Str(),
+					SignatureStatus: signatures[*sn.ID()],
 				}
 				snapshots = append(snapshots, k)
 			}
@@ -336,9 +378,10 @@ func printSnapshotGroupJSON(stdout io.Writer, snGroups map[string]restic.Snapsho
 	for _, list := range snGroups {
 		for _, sn := range list {
 			k := Snapshot{
-				Snapshot: sn,
-				ID:       sn.ID(),
-				ShortID:  sn.ID().Str(),
+				Snapshot:        sn,
+				ID:              sn.ID(),
+				ShortID:         sn.ID().Str(),
+				SignatureStatus: signatures[*sn.ID()],
 			}
 			snapshots = append(snapshots, k)
 		}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
 
 	"github.com/spf13/cobra"
 
@@ -58,7 +59,7 @@ func init() {
 	tagFlags.StringArrayVar(&tagOptions.Paths, "path", nil, "only consider snapshots which include this (absolute) `path`, when no snapshot-ID is given")
 }
 
-func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, setTags, addTags, removeTags []string) (bool, error) {
+func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, key ed25519.PrivateKey, setTags, addTags, removeTags []string) (bool, error) {
 	var changed bool
 
 	if len(setTags) != 0 {
@@ -76,32 +77,51 @@ func changeTags(ctx context.Context, repo *repository.Repository, sn *restic.Sna
 	}
 
 	if changed {
-		// Retain the original snapshot id over all tag changes.
-		if sn.Original == nil {
-			sn.Original = sn.ID()
-		}
-
-		// Save the new snapshot.
-		id, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
-		if err != nil {
+		if err := replaceSnapshot(ctx, repo, sn, key); err != nil {
 			return false, err
 		}
+	}
+	return changed, nil
+}
 
-		debug.Log("new snapshot saved as %v", id)
+// replaceSnapshot saves the modified snapshot sn as a new snapshot and removes
+// the old one.
+func replaceSnapshot(ctx context.Context, repo *repository.Repository, sn *restic.Snapshot, key ed25519.PrivateKey) error {
+	// Retain the original snapshot id over all changes.
+	if sn.Original == nil {
+		sn.Original = sn.ID()
+	}
 
-		if err = repo.Flush(ctx); err != nil {
-			return false, err
+	// The old signature does not match the changed snapshot any more.
+	if key != nil {
+		if err := sn.Sign(key); err != nil {
+			return err
 		}
+	} else if sn.Signature != nil {
+		Warnf("snapshot %v is no longer signed, use --signing-key to sign it\n", sn.ID().Str())
+		sn.Signature = nil
+	}
 
-		// Remove the old snapshot.
-		h := restic.Handle{Type: restic.SnapshotFile, Name: sn.ID().String()}
-		if err = repo.Backend().Remove(ctx, h); err != nil {
-			return false, err
-		}
+	// Save the new snapshot.
+	id, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+	if err != nil {
+		return err
+	}
+
+	debug.Log("new snapshot saved as %v", id)
 
-		debug.Log("old snapshot %v removed", sn.ID())
+	if err = repo.Flush(ctx); err != nil {
+		return err
 	}
-	return changed, nil
+
+	// Remove the old snapshot.
+	h := restic.Handle{Type: restic.SnapshotFile, Name: sn.ID().String()}
+	if err = repo.Backend().Remove(ctx, h); err != nil {
+		return err
+	}
+
+	debug.Log("old snapshot %v removed", sn.ID())
+	return nil
 }
 
 func runTag(opts TagOptions, gopts GlobalOptions, args []string) error {
@@ -112,6 +132,11 @@ func runTag(opts TagOptions, gopts GlobalOptions, args []string) error {
 		return errors.Fatal("--set and --add/--remove cannot be given at the same time")
 	}
 
+	key, err := signingKey(gopts)
+	if err != nil {
+		return err
+	}
+
 	repo, err := OpenRepository(gopts)
 	if err != nil {
 		return err
@@ -129,8 +154,8 @@ func runTag(opts TagOptions, gopts GlobalOptions, args []string) error {
 	changeCnt := 0
 	ctx, cancel := context.WithCancel(gopts.ctx)
 	defer cancel()
-	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, opts.Paths, args) {
-		changed, err := changeTags(ctx, repo, sn, opts.SetTags, opts.AddTags, opts.RemoveTags)
+	for sn := range FindFilteredSnapshots(ctx, repo, opts.Hosts, opts.Tags, nil, opts.Paths, args) {
+		changed, err := changeTags(ctx, repo, sn, key, opts.SetTags, opts.AddTags, opts.RemoveTags)
 		if err != nil {
 			Warnf("unable to modify the tags for snapshot ID %q, ignoring: %v\n", sn.ID(), err)
 			continue
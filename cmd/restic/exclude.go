@@ -14,6 +14,7 @@ import (
 	"github.com/quinn/restic/internal/filter"
 	"github.com/quinn/restic/internal/fs"
 	"github.com/quinn/restic/internal/repository"
+	"github.com/quinn/restic/internal/restic"
 )
 
 type rejectionCache struct {
@@ -269,6 +270,30 @@ func rejectByDevice(samples []string) (RejectFunc, error) {
 	}, nil
 }
 
+// rejectNoDump returns a RejectFunc that rejects files and directories which
+// have the nodump inode flag set (chattr +d), like dump(8) does. The flags
+// read by the archiver for the node are reused.
+func rejectNoDump() RejectFunc {
+	return func(item string, fi os.FileInfo) bool {
+		if fi == nil || (!fi.Mode().IsRegular() && !fi.IsDir()) {
+			return false
+		}
+
+		flags, err := restic.InodeFlags(item, fi)
+		if err != nil {
+			debug.Log("unable to get inode flags of %v: %v", item, err)
+			return false
+		}
+
+		if flags&restic.InodeFlagNoDump != 0 {
+			debug.Log("%v has the nodump flag set", item)
+			return true
+		}
+
+		return false
+	}
+}
+
 // rejectResticCache returns a RejectByNameFunc that rejects the restic cache
 // directory (if set).
 func rejectResticCache(repo *repository.Repository) (RejectByNameFunc, error) {
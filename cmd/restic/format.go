@@ -88,8 +88,48 @@ func formatNode(path string, n *restic.Node, long bool) string {
 		mode = os.ModeSocket
 	}
 
-	return fmt.Sprintf("%s %5d %5d %6d %s %s%s",
-		mode|n.Mode, n.UID, n.GID, n.Size,
+	// like ls, a '+' after the mode marks files with an ACL, the entries are
+	// listed below the file
+	var aclMarker string
+	entries := formatACL(n)
+	if len(entries) > 0 {
+		aclMarker = "+"
+	}
+
+	line := fmt.Sprintf("%s%s %5d %5d %6d %s %s%s",
+		mode|n.Mode, aclMarker, n.UID, n.GID, n.Size,
 		n.ModTime.Local().Format(TimeFormat), path,
 		target)
+
+	for _, entry := range entries {
+		line += "\n    " + entry
+	}
+
+	return line
+}
+
+// formatACL returns the entries of the POSIX ACLs saved in the extended
+// attributes of n, the entries of the default ACL of a directory are prefixed
+// with "default:".
+func formatACL(n *restic.Node) []string {
+	var entries []string
+	for _, attr := range []struct {
+		name, prefix string
+	}{
+		{"system.posix_acl_access", ""},
+		{"system.posix_acl_default", "default:"},
+	} {
+		value := n.GetExtendedAttribute(attr.name)
+		if value == nil {
+			continue
+		}
+
+		a := acl{}
+		a.decode(value)
+		for _, elem := range a.List {
+			entries = append(entries, attr.prefix+elem.String())
+		}
+	}
+
+	return entries
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quinn/restic/internal/restic"
+	rtest "github.com/quinn/restic/internal/test"
+)
+
+func TestFormatNodeACL(t *testing.T) {
+	node := &restic.Node{
+		Name:    "dir",
+		Type:    "dir",
+		Mode:    0750,
+		ModTime: time.Unix(1500000000, 0),
+		ExtendedAttributes: []restic.ExtendedAttribute{
+			{
+				Name:  "system.posix_acl_access",
+				Value: []byte{2, 0, 0, 0, 1, 0, 7, 0, 255, 255, 255, 255, 2, 0, 5, 0, 232, 3, 0, 0, 4, 0, 5, 0, 255, 255, 255, 255, 16, 0, 5, 0, 255, 255, 255, 255, 32, 0, 0, 0, 255, 255, 255, 255},
+			},
+			{
+				Name:  "system.posix_acl_default",
+				Value: []byte{2, 0, 0, 0, 1, 0, 7, 0, 255, 255, 255, 255, 4, 0, 5, 0, 255, 255, 255, 255, 32, 0, 0, 0, 255, 255, 255, 255},
+			},
+		},
+	}
+
+	lines := strings.Split(formatNode("/dir", node, true), "\n")
+	rtest.Assert(t, strings.HasPrefix(lines[0], "drwxr-x---+ "), "ACL not marked in %q", lines[0])
+	rtest.Equals(t, []string{
+		"    user::rwx",
+		"    user:1000:r-x",
+		"    group::r-x",
+		"    mask::r-x",
+		"    other::---",
+		"    default:user::rwx",
+		"    default:group::r-x",
+		"    default:other::---",
+	}, lines[1:])
+
+	// files without ACL are formatted as before
+	node.ExtendedAttributes = nil
+	line := formatNode("/dir", node, true)
+	rtest.Assert(t, strings.HasPrefix(line, "drwxr-x--- ") && !strings.Contains(line, "\n"),
+		"unexpected output %q", line)
+}
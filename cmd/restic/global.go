@@ -60,6 +60,12 @@ type GlobalOptions struct {
 	TLSClientCert   string
 	CleanupCache    bool
 
+	DiskIndex        bool
+	IndexMemoryLimit int
+
+	SigningKeyFile  string
+	TrustedKeysFile string
+
 	LimitUploadKb   int
 	LimitDownloadKb int
 
@@ -109,6 +115,10 @@ func init() {
 	f.StringSliceVar(&globalOptions.CACerts, "cacert", nil, "`file` to load root certificates from (default: use system certificates)")
 	f.StringVar(&globalOptions.TLSClientCert, "tls-client-cert", "", "path to a `file` containing PEM encoded TLS client certificate and private key")
 	f.BoolVar(&globalOptions.CleanupCache, "cleanup-cache", false, "auto remove old cache directories")
+	f.BoolVar(&globalOptions.DiskIndex, "disk-index", false, "keep the index in temporary files in the cache directory instead of in memory")
+	f.IntVar(&globalOptions.IndexMemoryLimit, "index-memory-limit", 0, "move the index to temporary files when it would use more than `n` MiB of memory (default: unlimited)")
+	f.StringVar(&globalOptions.SigningKeyFile, "signing-key", os.Getenv("RESTIC_SIGNING_KEY_FILE"), "sign new snapshots with the key stored in `file` (default: $RESTIC_SIGNING_KEY_FILE)")
+	f.StringVar(&globalOptions.TrustedKeysFile, "trusted-keys", os.Getenv("RESTIC_TRUSTED_KEYS_FILE"), "verify snapshot signatures with the public keys listed in `file` (default: $RESTIC_TRUSTED_KEYS_FILE)")
 	f.IntVar(&globalOptions.LimitUploadKb, "limit-upload", 0, "limits uploads to a maximum rate in KiB/s. (default: unlimited)")
 	f.IntVar(&globalOptions.LimitDownloadKb, "limit-download", 0, "limits downloads to a maximum rate in KiB/s. (default: unlimited)")
 	f.StringSliceVarP(&globalOptions.Options, "option", "o", []string{}, "set extended option (`key=value`, can be specified multiple times)")
@@ -425,6 +435,10 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 		return nil, errors.Fatalf("%s", err)
 	}
 
+	if s.ConfigFromBackup() {
+		Warnf("config file is missing and has been loaded from its backup copy, run `restic migrate` to restore it\n")
+	}
+
 	if stdoutIsTerminal() && !opts.JSON {
 		id := s.Config().ID
 		if len(id) > 8 {
@@ -435,6 +449,14 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 		}
 	}
 
+	if opts.DiskIndex || opts.IndexMemoryLimit > 0 {
+		// configure the disk index when returning, so the temporary files
+		// are stored in the cache directory if one is used
+		defer func() {
+			s.UseDiskIndex(diskIndexSettings(opts, s))
+		}()
+	}
+
 	if opts.NoCache {
 		return s, nil
 	}
@@ -483,6 +505,29 @@ func OpenRepository(opts GlobalOptions) (*repository.Repository, error) {
 	return s, nil
 }
 
+// indexBytesPerBlob is an estimate of the memory used for each blob in the
+// in-memory index.
+const indexBytesPerBlob = 64
+
+// defaultDiskIndexBlobs is the number of blobs which are kept in memory before
+// the index is moved to disk when --disk-index is used.
+var defaultDiskIndexBlobs uint = 256 * 1024
+
+// diskIndexSettings returns the directory for the temporary files of the disk
+// index and the number of blobs after which the index is moved to disk.
+func diskIndexSettings(opts GlobalOptions, repo *repository.Repository) (dir string, maxBlobs uint) {
+	if c, ok := repo.Cache.(*cache.Cache); ok {
+		dir = c.Path
+	}
+
+	maxBlobs = defaultDiskIndexBlobs
+	if opts.IndexMemoryLimit > 0 {
+		maxBlobs = uint(opts.IndexMemoryLimit) * 1024 * 1024 / indexBytesPerBlob
+	}
+
+	return dir, maxBlobs
+}
+
 func parseConfig(loc location.Location, opts options.Options) (interface{}, error) {
 	// only apply options for a particular backend here
 	opts = opts.Extract(loc.Scheme)
@@ -677,8 +722,14 @@ func open(s string, gopts GlobalOptions, opts options.Options) (restic.Backend,
 		return nil, errors.Fatalf("unable to open repo at %v: %v", s, err)
 	}
 
-	// check if config is there
+	// check if config is there, a missing config is loaded from its backup
+	// copy when the repository is opened
 	fi, err := be.Stat(globalOptions.ctx, restic.Handle{Type: restic.ConfigFile})
+	if err != nil && be.IsNotExist(err) {
+		if found, _ := repository.HasConfigBackup(globalOptions.ctx, be); found {
+			return be, nil
+		}
+	}
 	if err != nil {
 		return nil, errors.Fatalf("unable to open config file: %v\nIs there a repository at the following location?\n%v", err, s)
 	}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
@@ -22,6 +25,8 @@ import (
 	"github.com/quinn/restic/internal/errors"
 	"github.com/quinn/restic/internal/filter"
 	"github.com/quinn/restic/internal/fs"
+	"github.com/quinn/restic/internal/index"
+	"github.com/quinn/restic/internal/pack"
 	"github.com/quinn/restic/internal/repository"
 	"github.com/quinn/restic/internal/restic"
 	rtest "github.com/quinn/restic/internal/test"
@@ -160,7 +165,7 @@ func testRunRebuildIndex(t testing.TB, gopts GlobalOptions) {
 		globalOptions.stdout = os.Stdout
 	}()
 
-	rtest.OK(t, runRebuildIndex(gopts))
+	rtest.OK(t, runRebuildIndex(RebuildIndexOptions{}, gopts))
 }
 
 func testRunLs(t testing.TB, gopts GlobalOptions, snapshotID string) []string {
@@ -256,8 +261,25 @@ func testRunForgetJSON(t testing.TB, gopts GlobalOptions, args ...string) {
 	return
 }
 
-func testRunPrune(t testing.TB, gopts GlobalOptions) {
-	rtest.OK(t, runPrune(gopts))
+func testRunPrune(t testing.TB, gopts GlobalOptions, opts PruneOptions) {
+	rtest.OK(t, verifyPruneOptions(&opts))
+	rtest.OK(t, runPrune(opts, gopts))
+}
+
+func testRunPruneDryRun(t testing.TB, gopts GlobalOptions, opts PruneOptions) pruneReport {
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	gopts.JSON = true
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	opts.DryRun = true
+	testRunPrune(t, gopts, opts)
+
+	var report pruneReport
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &report))
+	return report
 }
 
 func TestBackup(t *testing.T) {
@@ -325,6 +347,229 @@ func TestBackup(t *testing.T) {
 	testRunCheck(t, env.gopts)
 }
 
+func TestBackupPadding(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.OK(t, runMigrate(MigrateOptions{}, env.gopts, []string{"padding"}))
+
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	for _, id := range testRunList(t, "packs", env.gopts) {
+		fi, err := os.Stat(filepath.Join(env.repo, "data", id.String()[:2], id.String()))
+		rtest.OK(t, err)
+		rtest.Equals(t, pack.PaddedSize(uint(fi.Size())), uint(fi.Size()))
+	}
+
+	// the index can be rebuilt from the padded packs
+	testRunRebuildIndex(t, env.gopts)
+	testRunCheck(t, env.gopts)
+
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+	rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, "testdata")),
+		"directories are not equal")
+}
+
+func TestBackupDiskIndex(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	fd, err := os.Open(datafile)
+	if os.IsNotExist(errors.Cause(err)) {
+		t.Skipf("unable to find data file %q, skipping", datafile)
+		return
+	}
+	rtest.OK(t, err)
+	rtest.OK(t, fd.Close())
+
+	// move every index to disk
+	defer func(blobs uint) {
+		defaultDiskIndexBlobs = blobs
+	}(defaultDiskIndexBlobs)
+	defaultDiskIndexBlobs = 1
+	env.gopts.DiskIndex = true
+
+	testRunInit(t, env.gopts)
+
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	opts := BackupOptions{}
+
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, opts, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Assert(t, len(snapshotIDs) == 2,
+		"expected two snapshots, got %v", snapshotIDs)
+
+	testRunCheck(t, env.gopts)
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[1])
+	rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, "testdata")),
+		"directories are not equal")
+}
+
+func TestCheckRepairPacks(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	fd, err := os.Open(datafile)
+	if os.IsNotExist(errors.Cause(err)) {
+		t.Skipf("unable to find data file %q, skipping", datafile)
+		return
+	}
+	rtest.OK(t, err)
+	rtest.OK(t, fd.Close())
+
+	testRunInit(t, env.gopts)
+	rtest.OK(t, runMigrate(MigrateOptions{}, env.gopts, []string{"parity"}))
+
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+
+	packs := testRunList(t, "packs", env.gopts)
+	parity := testRunList(t, "parity", env.gopts)
+	rtest.Assert(t, len(packs) >= 2, "expected at least two packs, got %v", packs)
+	rtest.Assert(t, len(parity) > 0, "no parity files were written")
+
+	packFile := func(id restic.ID) string {
+		return filepath.Join(env.repo, "data", id.String()[:2], id.String())
+	}
+
+	// flip a byte in a pack
+	buf, err := ioutil.ReadFile(packFile(packs[0]))
+	rtest.OK(t, err)
+	buf[len(buf)/2] ^= 0xff
+	rtest.OK(t, os.Chmod(packFile(packs[0]), 0600))
+	rtest.OK(t, ioutil.WriteFile(packFile(packs[0]), buf, 0600))
+
+	_, err = testRunCheckOutput(env.gopts)
+	rtest.Assert(t, err != nil, "check did not detect the damaged pack")
+
+	rtest.OK(t, runCheck(CheckOptions{ReadData: true, RepairPacks: true}, env.gopts, nil))
+	testRunCheck(t, env.gopts)
+
+	// recover reconstructs missing packs
+	rtest.OK(t, os.Remove(packFile(packs[1])))
+	rtest.OK(t, runRecover(env.gopts))
+	testRunCheck(t, env.gopts)
+}
+
+func TestRepairSnapshots(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	snapshots := testRunList(t, "snapshots", env.gopts)
+
+	// remove a pack which only contains file data
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(env.gopts.ctx))
+	dataPacks := repo.Index().(*repository.MasterIndex).Packs()
+	for id := range repo.Index().(*repository.MasterIndex).TreePacks() {
+		dataPacks.Delete(id)
+	}
+	rtest.Assert(t, len(dataPacks) > 0, "no data packs found")
+	id := dataPacks.List()[0].String()
+	rtest.OK(t, os.Remove(filepath.Join(env.repo, "data", id[:2], id)))
+
+	// a dry run does not modify the repository
+	rtest.OK(t, runRepairSnapshots(RepairSnapshotsOptions{DryRun: true}, env.gopts, nil))
+	rtest.Equals(t, snapshots, testRunList(t, "snapshots", env.gopts))
+
+	rtest.OK(t, runRepairSnapshots(RepairSnapshotsOptions{Forget: true, ZeroFill: true}, env.gopts, nil))
+	repaired := testRunList(t, "snapshots", env.gopts)
+	rtest.Equals(t, 1, len(repaired))
+	rtest.Assert(t, repaired[0] != snapshots[0], "snapshot was not replaced")
+
+	sn, err := restic.LoadSnapshot(env.gopts.ctx, repo, repaired[0])
+	rtest.OK(t, err)
+	rtest.Equals(t, snapshots[0], *sn.Original)
+	rtest.Assert(t, sn.HasTags([]string{"repaired"}), "repaired snapshot has tags %v", sn.Tags)
+
+	// after the index is rebuilt, the repository is consistent again, the
+	// trees of the original snapshot are unused until the next prune
+	testRunRebuildIndex(t, env.gopts)
+	_, err = testRunCheckOutput(env.gopts)
+	rtest.OK(t, err)
+
+	// the damaged files keep their size
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, repaired[0])
+	err = filepath.Walk(env.testdata, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(filepath.Dir(env.testdata), p)
+		rtest.OK(t, err)
+		restored, err := os.Lstat(filepath.Join(restoredir, rel))
+		rtest.OK(t, err)
+		rtest.Equals(t, fi.Size(), restored.Size())
+		return nil
+	})
+	rtest.OK(t, err)
+}
+
+func TestRebuildIndexSalvage(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	testRunBackup(t, filepath.Dir(env.testdata), []string{"testdata"}, BackupOptions{}, env.gopts)
+	snapshots := testRunList(t, "snapshots", env.gopts)
+
+	// damage the header of all data packs, tree packs would still be listed
+	// from the cache
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(env.gopts.ctx))
+	packs := repo.Index().(*repository.MasterIndex).Packs()
+	for id := range repo.Index().(*repository.MasterIndex).TreePacks() {
+		packs.Delete(id)
+	}
+	rtest.Assert(t, len(packs) > 0, "no data packs found")
+
+	for id := range packs {
+		packFile := filepath.Join(env.repo, "data", id.String()[:2], id.String())
+		buf, err := ioutil.ReadFile(packFile)
+		rtest.OK(t, err)
+		buf[len(buf)-10] ^= 0xff
+		rtest.OK(t, os.Chmod(packFile, 0600))
+		rtest.OK(t, ioutil.WriteFile(packFile, buf, 0600))
+	}
+
+	globalOptions.stdout = ioutil.Discard
+	err = runRebuildIndex(RebuildIndexOptions{Salvage: true}, env.gopts)
+	globalOptions.stdout = os.Stdout
+	rtest.OK(t, err)
+
+	// all data can be restored from the new packs
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshots[0])
+	rtest.Assert(t, directoriesEqualContents(env.testdata, filepath.Join(restoredir, "testdata")),
+		"restored directory is not equal to the original one")
+
+	// the damaged packs are not referenced any more
+	for id := range packs {
+		rtest.OK(t, os.Remove(filepath.Join(env.repo, "data", id.String()[:2], id.String())))
+	}
+	testRunCheck(t, env.gopts)
+}
+
 func TestBackupNonExistingFile(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -424,25 +669,449 @@ func loadSnapshotMap(t testing.TB, gopts GlobalOptions) map[string]struct{} {
 		m[id.String()] = struct{}{}
 	}
 
-	return m
-}
+	return m
+}
+
+func lastSnapshot(old, new map[string]struct{}) (map[string]struct{}, string) {
+	for k := range new {
+		if _, ok := old[k]; !ok {
+			old[k] = struct{}{}
+			return old, k
+		}
+	}
+
+	return old, ""
+}
+
+var backupExcludeFilenames = []string{
+	"testfile1",
+	"foo.tar.gz",
+	"private/secret/passwords.txt",
+	"work/source/test.c",
+}
+
+func TestBackupStdinFromCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test requires a POSIX shell")
+	}
+
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	opts := BackupOptions{
+		StdinCommands: []string{
+			"first.txt=echo first output",
+			"second.txt=sh -c 'printf \"second output\"'",
+		},
+	}
+	testRunBackup(t, "", nil, opts, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Equals(t, 1, len(snapshotIDs))
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+	for name, want := range map[string]string{
+		"first.txt":  "first output\n",
+		"second.txt": "second output",
+	} {
+		buf, err := ioutil.ReadFile(filepath.Join(restoredir, name))
+		rtest.OK(t, err)
+		rtest.Equals(t, want, string(buf))
+	}
+
+	// no snapshot is saved if a command fails
+	opts.StdinCommands = append(opts.StdinCommands, "failed.txt=sh -c 'echo partial; exit 1'")
+	err := testRunBackupAssumeFailure(t, "", nil, opts, env.gopts)
+	rtest.Assert(t, err != nil, "expected backup with failed command to fail")
+	rtest.Equals(t, 1, len(testRunList(t, "snapshots", env.gopts)))
+
+	// invalid names are rejected
+	opts.StdinCommands = []string{"dir/file=echo"}
+	err = testRunBackupAssumeFailure(t, "", nil, opts, env.gopts)
+	rtest.Assert(t, err != nil, "expected error for name containing a slash")
+}
+
+func writeTestTar(t testing.TB, w io.Writer) {
+	mtime := time.Unix(1500000000, 0)
+	tw := tar.NewWriter(w)
+	for _, entry := range []struct {
+		hdr     tar.Header
+		content string
+	}{
+		{hdr: tar.Header{Name: "data/", Typeflag: tar.TypeDir, Mode: 0750}},
+		{hdr: tar.Header{Name: "data/file", Typeflag: tar.TypeReg, Mode: 0640}, content: "file content"},
+		{hdr: tar.Header{Name: "data/hardlink", Typeflag: tar.TypeLink, Linkname: "data/file", Mode: 0640}},
+		{hdr: tar.Header{Name: "data/symlink", Typeflag: tar.TypeSymlink, Linkname: "file"}},
+	} {
+		hdr := entry.hdr
+		hdr.ModTime = mtime
+		hdr.Uid, hdr.Gid = os.Getuid(), os.Getgid()
+		hdr.Size = int64(len(entry.content))
+		rtest.OK(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write([]byte(entry.content))
+		rtest.OK(t, err)
+	}
+	rtest.OK(t, tw.Close())
+}
+
+func TestBackupTar(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	archive := filepath.Join(env.base, "archive.tar")
+	f, err := os.Create(archive)
+	rtest.OK(t, err)
+	writeTestTar(t, f)
+	rtest.OK(t, f.Close())
+
+	testRunBackup(t, "", nil, BackupOptions{Tar: archive}, env.gopts)
+	snapshotIDs := testRunList(t, "snapshots", env.gopts)
+	rtest.Equals(t, 1, len(snapshotIDs))
+
+	restoredir := filepath.Join(env.base, "restore")
+	testRunRestore(t, env.gopts, restoredir, snapshotIDs[0])
+
+	buf, err := ioutil.ReadFile(filepath.Join(restoredir, "data", "file"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "file content", string(buf))
+
+	fi, err := os.Lstat(filepath.Join(restoredir, "data"))
+	rtest.OK(t, err)
+	rtest.Assert(t, fi.ModTime().Equal(time.Unix(1500000000, 0)), "wrong mtime %v", fi.ModTime())
+	if runtime.GOOS != "windows" {
+		rtest.Equals(t, os.ModeDir|0750, fi.Mode())
+
+		target, err := os.Readlink(filepath.Join(restoredir, "data", "symlink"))
+		rtest.OK(t, err)
+		rtest.Equals(t, "file", target)
+
+		fi1, err := os.Lstat(filepath.Join(restoredir, "data", "file"))
+		rtest.OK(t, err)
+		fi2, err := os.Lstat(filepath.Join(restoredir, "data", "hardlink"))
+		rtest.OK(t, err)
+		rtest.Assert(t, os.SameFile(fi1, fi2), "hard link was not restored")
+	}
+
+	// a compressed archive yields the same tree
+	gzArchive := filepath.Join(env.base, "archive.tar.gz")
+	f, err = os.Create(gzArchive)
+	rtest.OK(t, err)
+	gz := gzip.NewWriter(f)
+	writeTestTar(t, gz)
+	rtest.OK(t, gz.Close())
+	rtest.OK(t, f.Close())
+
+	testRunBackup(t, "", nil, BackupOptions{Tar: gzArchive}, env.gopts)
+	snapshots := loadSnapshotMap(t, env.gopts)
+	rtest.Equals(t, 2, len(snapshots))
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	var trees []restic.ID
+	for id := range snapshots {
+		sn, err := restic.LoadSnapshot(env.gopts.ctx, repo, restic.TestParseID(id))
+		rtest.OK(t, err)
+		rtest.Equals(t, []string{"/data"}, sn.Paths)
+		trees = append(trees, *sn.Tree)
+	}
+	rtest.Equals(t, trees[0], trees[1])
+
+	err = testRunBackupAssumeFailure(t, "", []string{"foo"}, BackupOptions{Tar: archive}, env.gopts)
+	rtest.Assert(t, err != nil, "expected error for --tar with arguments")
+}
+
+type testBackupSummary struct {
+	FilesNew        uint   `json:"files_new"`
+	FilesChanged    uint   `json:"files_changed"`
+	FilesUnmodified uint   `json:"files_unmodified"`
+	DataAdded       uint64 `json:"data_added"`
+	SnapshotID      string `json:"snapshot_id"`
+	DryRun          bool   `json:"dry_run"`
+	SnapshotSkipped bool   `json:"snapshot_skipped"`
+}
+
+func testRunBackupJSON(t testing.TB, dir string, target []string, opts BackupOptions, gopts GlobalOptions) (summary testBackupSummary) {
+	buf := bytes.NewBuffer(nil)
+	gopts.stdout = buf
+	gopts.JSON = true
+	testRunBackup(t, dir, target, opts, gopts)
+
+	sc := bufio.NewScanner(buf)
+	for sc.Scan() {
+		if !strings.Contains(sc.Text(), `"message_type":"summary"`) {
+			continue
+		}
+		rtest.OK(t, json.Unmarshal(sc.Bytes(), &summary))
+		return summary
+	}
+	t.Fatalf("no summary found in output:\n%s", buf)
+	return summary
+}
+
+func TestBackupDryRun(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	datadir := filepath.Join(env.base, "data")
+	rtest.OK(t, os.Mkdir(datadir, 0755))
+	rtest.OK(t, appendRandomData(filepath.Join(datadir, "changed"), 1000))
+	rtest.OK(t, appendRandomData(filepath.Join(datadir, "unchanged"), 1000))
+	testRunBackup(t, env.base, []string{"data"}, BackupOptions{}, env.gopts)
+
+	// make sure the modification time changes
+	time.Sleep(10 * time.Millisecond)
+	rtest.OK(t, appendRandomData(filepath.Join(datadir, "changed"), 200000))
+	rtest.OK(t, appendRandomData(filepath.Join(datadir, "new"), 300000))
+
+	repoBefore := dirStats(env.repo)
+	summary := testRunBackupJSON(t, env.base, []string{"data"}, BackupOptions{DryRun: true}, env.gopts)
+	rtest.Equals(t, uint(1), summary.FilesNew)
+	rtest.Equals(t, uint(1), summary.FilesChanged)
+	rtest.Equals(t, uint(1), summary.FilesUnmodified)
+	rtest.Assert(t, summary.DataAdded >= 500000, "expected at least 500000 bytes to be added, got %v", summary.DataAdded)
+	rtest.Assert(t, summary.DryRun, "summary is not marked as dry run")
+	rtest.Equals(t, "", summary.SnapshotID)
+
+	// nothing is written to the repository
+	rtest.Equals(t, repoBefore, dirStats(env.repo))
+	rtest.Equals(t, 1, len(testRunList(t, "snapshots", env.gopts)))
+	testRunCheck(t, env.gopts)
+
+	testRunBackup(t, env.base, []string{"data"}, BackupOptions{}, env.gopts)
+	summary = testRunBackupJSON(t, env.base, []string{"data"}, BackupOptions{DryRun: true}, env.gopts)
+	rtest.Equals(t, uint(0), summary.FilesNew+summary.FilesChanged)
+	rtest.Equals(t, uint(3), summary.FilesUnmodified)
+	rtest.Equals(t, uint64(0), summary.DataAdded)
+}
+
+func TestBackupSkipIfUnchanged(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	rtest.OK(t, os.MkdirAll(filepath.Join(env.testdata, "dir"), 0755))
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "dir", "file"), 1000))
+
+	opts := BackupOptions{SkipIfUnchanged: true}
+	summary := testRunBackupJSON(t, env.testdata, []string{"."}, opts, env.gopts)
+	rtest.Assert(t, !summary.SnapshotSkipped, "first snapshot was skipped")
+	rtest.Assert(t, summary.SnapshotID != "", "no snapshot ID reported")
+
+	summary = testRunBackupJSON(t, env.testdata, []string{"."}, opts, env.gopts)
+	rtest.Assert(t, summary.SnapshotSkipped, "unchanged snapshot was not skipped")
+	rtest.Equals(t, "", summary.SnapshotID)
+	rtest.Equals(t, 1, len(testRunList(t, "snapshots", env.gopts)))
+
+	// without the option a snapshot is saved
+	testRunBackup(t, env.testdata, []string{"."}, BackupOptions{}, env.gopts)
+	rtest.Equals(t, 2, len(testRunList(t, "snapshots", env.gopts)))
+
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "new"), 100))
+	summary = testRunBackupJSON(t, env.testdata, []string{"."}, opts, env.gopts)
+	rtest.Assert(t, !summary.SnapshotSkipped, "snapshot with a new file was skipped")
+	rtest.Equals(t, 3, len(testRunList(t, "snapshots", env.gopts)))
+
+	testRunCheck(t, env.gopts)
+}
+
+func TestBackupHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are run with sh")
+	}
+
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	rtest.OK(t, os.MkdirAll(env.testdata, 0755))
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "file"), 1000))
+
+	hookfile := filepath.Join(env.base, "hook")
+	record := func(vars ...string) string {
+		return fmt.Sprintf(`sh -c "echo $%v >> %v"`, strings.Join(vars, " $"), hookfile)
+	}
+	readHookfile := func() string {
+		buf, err := ioutil.ReadFile(hookfile)
+		rtest.OK(t, err)
+		rtest.OK(t, os.Remove(hookfile))
+		return strings.TrimSpace(string(buf))
+	}
+
+	opts := BackupOptions{
+		Tags:           []string{"foo", "bar"},
+		PreCommand:     record("RESTIC_BACKUP_HOOK", "RESTIC_BACKUP_TAGS", "RESTIC_BACKUP_PATHS"),
+		PostCommand:    record("RESTIC_BACKUP_HOOK", "RESTIC_BACKUP_STATUS", "RESTIC_SNAPSHOT_ID"),
+		FailureCommand: record("RESTIC_BACKUP_HOOK"),
+	}
+	testRunBackup(t, env.testdata, []string{"."}, opts, env.gopts)
+
+	var id string
+	for id = range loadSnapshotMap(t, env.gopts) {
+	}
+	rtest.Equals(t, "pre foo,bar .\npost success "+id, readHookfile())
+
+	// the backup is aborted if the pre command fails
+	opts.PreCommand = "false"
+	err := testRunBackupAssumeFailure(t, env.testdata, []string{"."}, opts, env.gopts)
+	rtest.Assert(t, err != nil, "expected error for failed pre command")
+	rtest.Equals(t, "failure", readHookfile())
+	rtest.Equals(t, 1, len(testRunList(t, "snapshots", env.gopts)))
+
+	// the failure command is run if the backup fails
+	opts.PreCommand = ""
+	opts.FailureCommand = record("RESTIC_BACKUP_HOOK", "RESTIC_BACKUP_ERROR")
+	err = testRunBackupAssumeFailure(t, env.testdata, []string{"nonexistent"}, opts, env.gopts)
+	rtest.Assert(t, err != nil, "expected error for nonexistent target")
+	rtest.Assert(t, strings.HasPrefix(readHookfile(), "failure "), "failure command not run")
+
+	// no snapshot ID is passed for a dry run
+	opts.DryRun = true
+	testRunBackup(t, env.testdata, []string{"."}, opts, env.gopts)
+	rtest.Equals(t, "post success", readHookfile())
+
+	// the failure command is run by the cleanup handler when the backup is
+	// interrupted, but not once it has finished
+	lastCleanupHandler := func() func() error {
+		cleanupHandlers.Lock()
+		defer cleanupHandlers.Unlock()
+		return cleanupHandlers.list[len(cleanupHandlers.list)-1]
+	}
+	hooks := backupHooks{opts: opts, gopts: env.gopts}
+	hooks.failOnInterrupt()
+	rtest.OK(t, lastCleanupHandler()())
+	rtest.Equals(t, "failure backup interrupted", readHookfile())
+
+	hooks.failOnInterrupt()()
+	rtest.OK(t, lastCleanupHandler()())
+	_, err = os.Stat(hookfile)
+	rtest.Assert(t, os.IsNotExist(err), "failure command run after the backup finished")
+
+	// with --json, the output of the commands is written to stderr
+	stdout, stderr := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	gopts := env.gopts
+	gopts.JSON = true
+	gopts.stdout, gopts.stderr = stdout, stderr
+	hooks = backupHooks{opts: opts, gopts: gopts}
+	rtest.OK(t, hooks.run("post", "echo hook output", nil))
+	rtest.Equals(t, "", stdout.String())
+	rtest.Equals(t, "hook output\n", stderr.String())
+}
+
+func TestBackupRemapPath(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	mount := filepath.Join(env.base, "mount")
+	rtest.OK(t, os.MkdirAll(filepath.Join(mount, "dir"), 0755))
+	rtest.OK(t, appendRandomData(filepath.Join(mount, "dir", "file"), 1000))
+	rtest.OK(t, appendRandomData(filepath.Join(mount, "excluded"), 1000))
+
+	original := filepath.Join(env.base, "original")
+	opts := BackupOptions{
+		RemapPaths: []string{"mount=original"},
+		Excludes:   []string{filepath.Join(original, "excluded")},
+	}
+	testRunBackup(t, env.base, []string{"mount"}, opts, env.gopts)
+
+	snapshots := loadSnapshotMap(t, env.gopts)
+	rtest.Equals(t, 1, len(snapshots))
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	for id := range snapshots {
+		sn, err := restic.LoadSnapshot(env.gopts.ctx, repo, restic.TestParseID(id))
+		rtest.OK(t, err)
+		rtest.Equals(t, []string{original}, sn.Paths)
+
+		files := strings.Join(testRunLs(t, env.gopts, id), "\n")
+		rtest.Assert(t, strings.Contains(files, filepath.ToSlash(filepath.Join(original, "dir", "file"))),
+			"file not stored under the original path:\n%v", files)
+		rtest.Assert(t, !strings.Contains(files, "excluded"), "excluded file was saved:\n%v", files)
+	}
+
+	// the parent snapshot is found by the original path
+	summary := testRunBackupJSON(t, env.base, []string{"mount"}, opts, env.gopts)
+	rtest.Equals(t, uint(0), summary.FilesNew+summary.FilesChanged)
+	rtest.Equals(t, uint(1), summary.FilesUnmodified)
+
+	err = testRunBackupAssumeFailure(t, env.base, []string{"mount"}, BackupOptions{RemapPaths: []string{"mount"}}, env.gopts)
+	rtest.Assert(t, err != nil, "expected error for invalid path mapping")
+
+	testRunCheck(t, env.gopts)
+}
+
+func TestBackupInodeFlags(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("inode flags are only supported on Linux")
+	}
+
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+
+	rtest.OK(t, os.MkdirAll(filepath.Join(env.testdata, "nodump"), 0755))
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "nodump", "file"), 100))
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "nodump-file"), 100))
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "noatime"), 100))
+
+	rtest.OK(t, restic.SetInodeFlags(filepath.Join(env.testdata, "nodump"), restic.InodeFlagNoDump))
+	rtest.OK(t, restic.SetInodeFlags(filepath.Join(env.testdata, "nodump-file"), restic.InodeFlagNoDump))
+	rtest.OK(t, restic.SetInodeFlags(filepath.Join(env.testdata, "noatime"), restic.InodeFlagNoAtime))
+	flags, err := restic.GetInodeFlags(filepath.Join(env.testdata, "noatime"))
+	rtest.OK(t, err)
+	if flags&restic.InodeFlagNoAtime == 0 {
+		t.Skip("file system does not support inode flags")
+	}
+
+	// the immutable flag can only be set by root, restoring it must not
+	// prevent restoring hard links to the file
+	restoredir := filepath.Join(env.base, "restore")
+	immutable := os.Geteuid() == 0
+	if immutable {
+		rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "immutable"), 100))
+		rtest.OK(t, os.Link(filepath.Join(env.testdata, "immutable"), filepath.Join(env.testdata, "link")))
+		rtest.OK(t, restic.SetInodeFlags(filepath.Join(env.testdata, "immutable"), restic.InodeFlagImmutable))
+		defer func() {
+			for _, dir := range []string{env.testdata, filepath.Join(restoredir, "testdata")} {
+				_ = restic.SetInodeFlags(filepath.Join(dir, "immutable"), 0)
+			}
+		}()
+	}
+
+	testRunBackup(t, env.base, []string{"testdata"}, BackupOptions{}, env.gopts)
+	testRunCheck(t, env.gopts)
 
-func lastSnapshot(old, new map[string]struct{}) (map[string]struct{}, string) {
-	for k := range new {
-		if _, ok := old[k]; !ok {
-			old[k] = struct{}{}
-			return old, k
-		}
+	var id string
+	for id = range loadSnapshotMap(t, env.gopts) {
 	}
+	files := strings.Join(testRunLs(t, env.gopts, id), "\n")
+	rtest.Assert(t, !strings.Contains(files, "nodump"), "files with the nodump flag were saved:\n%v", files)
 
-	return old, ""
-}
+	testRunRestore(t, env.gopts, restoredir, restic.TestParseID(id))
+	flags, err = restic.GetInodeFlags(filepath.Join(restoredir, "testdata", "noatime"))
+	rtest.OK(t, err)
+	rtest.Assert(t, flags&restic.InodeFlagNoAtime != 0, "noatime flag was not restored")
 
-var backupExcludeFilenames = []string{
-	"testfile1",
-	"foo.tar.gz",
-	"private/secret/passwords.txt",
-	"work/source/test.c",
+	if immutable {
+		flags, err = restic.GetInodeFlags(filepath.Join(restoredir, "testdata", "immutable"))
+		rtest.OK(t, err)
+		rtest.Assert(t, flags&restic.InodeFlagImmutable != 0, "immutable flag was not restored")
+
+		fi1, err := os.Lstat(filepath.Join(restoredir, "testdata", "immutable"))
+		rtest.OK(t, err)
+		fi2, err := os.Lstat(filepath.Join(restoredir, "testdata", "link"))
+		rtest.OK(t, err)
+		rtest.Assert(t, os.SameFile(fi1, fi2), "hard link was not restored")
+	}
 }
 
 func TestBackupExclude(t *testing.T) {
@@ -692,6 +1361,163 @@ func TestTag(t *testing.T) {
 		"expected original ID to be set to the first snapshot id")
 }
 
+func testRunSnapshotsLabels(t testing.TB, gopts GlobalOptions, labels ...string) []Snapshot {
+	buf := bytes.NewBuffer(nil)
+	gopts.stdout = buf
+	gopts.JSON = true
+
+	opts := SnapshotOptions{}
+	for _, label := range labels {
+		rtest.OK(t, opts.Labels.Set(label))
+	}
+	rtest.OK(t, runSnapshots(opts, gopts, nil))
+
+	snapshots := []Snapshot{}
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &snapshots))
+	return snapshots
+}
+
+func TestLabels(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, filepath.Join("testdata", "backup-data.tar.gz"))
+
+	opts := BackupOptions{Description: "before the upgrade"}
+	rtest.OK(t, opts.Labels.Set("ticket=OPS-1234"))
+	rtest.OK(t, opts.Labels.Set("version=1.0"))
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+
+	rtest.OK(t, ioutil.WriteFile(filepath.Join(env.testdata, "new"), []byte("new file"), 0644))
+
+	opts = BackupOptions{}
+	rtest.OK(t, opts.Labels.Set("version=2.0"))
+	testRunBackup(t, "", []string{env.testdata}, opts, env.gopts)
+
+	snapshots := testRunSnapshotsLabels(t, env.gopts, "version=1.0")
+	rtest.Assert(t, len(snapshots) == 1, "expected one snapshot with version=1.0, got %v", len(snapshots))
+	rtest.Equals(t, map[string]string{"ticket": "OPS-1234", "version": "1.0"}, snapshots[0].Labels)
+	rtest.Equals(t, "before the upgrade", snapshots[0].Description)
+
+	snapshots = testRunSnapshotsLabels(t, env.gopts, "version=1.0", "ticket=OPS-9999")
+	rtest.Assert(t, len(snapshots) == 0, "expected no snapshot for a different ticket, got %v", len(snapshots))
+
+	// modify the labels and remove the description of the first snapshot
+	labelOpts := LabelOptions{RemoveLabels: []string{"ticket"}, SetDescription: true}
+	rtest.OK(t, labelOpts.Labels.Set("version=1.0"))
+	rtest.OK(t, labelOpts.SetLabels.Set("approved=yes"))
+	rtest.OK(t, runLabel(labelOpts, env.gopts, nil))
+	testRunCheck(t, env.gopts)
+
+	snapshots = testRunSnapshotsLabels(t, env.gopts, "approved=yes")
+	rtest.Assert(t, len(snapshots) == 1, "expected one approved snapshot, got %v", len(snapshots))
+	rtest.Equals(t, map[string]string{"approved": "yes", "version": "1.0"}, snapshots[0].Labels)
+	rtest.Equals(t, "", snapshots[0].Description)
+	rtest.Assert(t, snapshots[0].Original != nil, "expected original snapshot id, got nil")
+
+	// restoring the latest snapshot with a label filter must not restore the new file
+	restoreOpts := RestoreOptions{Target: filepath.Join(env.base, "restore")}
+	rtest.OK(t, restoreOpts.Labels.Set("version=1.0"))
+	rtest.OK(t, runRestore(restoreOpts, env.gopts, []string{"latest"}))
+	_, err := os.Lstat(filepath.Join(restoreOpts.Target, env.testdata, "new"))
+	rtest.Assert(t, os.IsNotExist(err), "file from the newer snapshot was restored: %v", err)
+
+	// forget only considers the snapshots with the label
+	forgetOpts := ForgetOptions{KeepTags: restic.TagLists{{"nonexistent"}}}
+	rtest.OK(t, forgetOpts.Labels.Set("version=2.0"))
+	rtest.OK(t, runForget(forgetOpts, env.gopts, nil))
+
+	snapshots = testRunSnapshotsLabels(t, env.gopts)
+	rtest.Assert(t, len(snapshots) == 1, "expected one remaining snapshot, got %v", len(snapshots))
+	rtest.Equals(t, "1.0", snapshots[0].Labels["version"])
+}
+
+func testRunSnapshotsSignatures(t testing.TB, gopts GlobalOptions) map[restic.ID]string {
+	buf := bytes.NewBuffer(nil)
+	gopts.stdout = buf
+	gopts.JSON = true
+
+	rtest.OK(t, runSnapshots(SnapshotOptions{}, gopts, nil))
+
+	snapshots := []Snapshot{}
+	rtest.OK(t, json.Unmarshal(buf.Bytes(), &snapshots))
+
+	status := make(map[restic.ID]string)
+	for _, sn := range snapshots {
+		status[*sn.ID] = sn.SignatureStatus
+	}
+	return status
+}
+
+func TestSignedSnapshots(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	gopts := env.gopts
+	gopts.SigningKeyFile = filepath.Join(env.base, "signing-key")
+	gopts.TrustedKeysFile = filepath.Join(env.base, "trusted-keys")
+
+	rtest.OK(t, runKey(gopts, []string{"generate-signing"}))
+	rtest.Assert(t, runKey(gopts, []string{"generate-signing"}) != nil,
+		"existing signing key was overwritten")
+
+	key, err := loadSigningKey(gopts.SigningKeyFile)
+	rtest.OK(t, err)
+	pub := restic.EncodePublicKey(key.Public().(ed25519.PublicKey))
+
+	// a missing trusted keys file is only accepted when a key is trusted
+	rtest.Assert(t, runKey(gopts, []string{"list-trusted"}) != nil,
+		"missing trusted keys file was accepted")
+	rtest.OK(t, runKey(gopts, []string{"trust", pub}))
+
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, gopts)
+	testRunCheck(t, gopts)
+
+	snapshotIDs := testRunList(t, "snapshots", gopts)
+	rtest.Assert(t, len(snapshotIDs) == 1, "expected one snapshot, got %v", snapshotIDs)
+	rtest.Equals(t, "valid", testRunSnapshotsSignatures(t, gopts)[snapshotIDs[0]])
+	testRunRestore(t, gopts, filepath.Join(env.base, "restore0"), snapshotIDs[0])
+
+	// changing the tags without the signing key removes the signature
+	unsigned := gopts
+	unsigned.SigningKeyFile = ""
+	testRunTag(t, TagOptions{AddTags: []string{"foo"}}, unsigned)
+
+	snapshotIDs = testRunList(t, "snapshots", gopts)
+	rtest.Equals(t, "unsigned", testRunSnapshotsSignatures(t, gopts)[snapshotIDs[0]])
+
+	_, err = testRunCheckOutput(gopts)
+	rtest.Assert(t, err != nil, "check did not report the unsigned snapshot")
+
+	restoreOpts := RestoreOptions{Target: filepath.Join(env.base, "restore1")}
+	rtest.Assert(t, runRestore(restoreOpts, gopts, []string{snapshotIDs[0].String()}) != nil,
+		"unsigned snapshot was restored")
+	restoreOpts.AllowUnsigned = true
+	rtest.OK(t, runRestore(restoreOpts, gopts, []string{snapshotIDs[0].String()}))
+
+	// with the signing key the snapshot is signed again
+	testRunTag(t, TagOptions{AddTags: []string{"bar"}}, gopts)
+	testRunCheck(t, gopts)
+
+	rtest.OK(t, runKey(gopts, []string{"untrust", pub}))
+	snapshotIDs = testRunList(t, "snapshots", gopts)
+	rtest.Equals(t, "untrusted", testRunSnapshotsSignatures(t, gopts)[snapshotIDs[0]])
+	_, err = testRunCheckOutput(gopts)
+	rtest.Assert(t, err != nil, "check did not report the untrusted snapshot")
+
+	// a typo in the name of the trusted keys file is an error
+	missing := gopts
+	missing.TrustedKeysFile = filepath.Join(env.base, "trusted-keys.typo")
+	_, err = testRunCheckOutput(missing)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "does not exist"),
+		"missing trusted keys file was not reported, err %v", err)
+}
+
 func testRunKeyListOtherIDs(t testing.TB, gopts GlobalOptions) []string {
 	buf := bytes.NewBuffer(nil)
 
@@ -1108,6 +1934,163 @@ func TestRebuildIndexAlwaysFull(t *testing.T) {
 	TestRebuildIndex(t)
 }
 
+func TestCheckReadDataBudget(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	packs := testRunList(t, "packs", env.gopts)
+	rtest.Assert(t, len(packs) >= 2, "expected at least two packs, got %d", len(packs))
+
+	loadVerified := func(numFiles int) map[restic.ID]time.Time {
+		repo, err := OpenRepository(env.gopts)
+		rtest.OK(t, err)
+		verified, files, err := restic.LoadVerifications(env.gopts.ctx, repo)
+		rtest.OK(t, err)
+		rtest.Equals(t, numFiles, len(files))
+		return verified
+	}
+
+	// the times are only recorded for --read-data-budget
+	rtest.OK(t, runCheck(CheckOptions{ReadData: true}, env.gopts, nil))
+	loadVerified(0)
+
+	// each run verifies a single pack which was not verified before
+	opts := CheckOptions{ReadDataBudget: "1"}
+	rtest.OK(t, runCheck(opts, env.gopts, nil))
+	first := loadVerified(1)
+	rtest.Equals(t, 1, len(first))
+
+	rtest.OK(t, runCheck(opts, env.gopts, nil))
+	second := loadVerified(1)
+	rtest.Equals(t, 2, len(second))
+	for id, ts := range first {
+		rtest.Assert(t, second[id].Equal(ts), "pack %v was verified again", id.Str())
+	}
+
+	// without a lock, the times are not recorded
+	gopts := env.gopts
+	gopts.NoLock = true
+	rtest.OK(t, runCheck(opts, gopts, nil))
+	rtest.Equals(t, second, loadVerified(1))
+
+	// the whole repository is covered eventually
+	rtest.OK(t, runCheck(CheckOptions{ReadDataBudget: "100%"}, env.gopts, nil))
+	rtest.Equals(t, len(packs), len(loadVerified(1)))
+}
+
+func TestCheckMetadata(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	// hardlinked files must be stored with the same content
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "file"), 4096))
+	rtest.OK(t, os.Link(filepath.Join(env.testdata, "file"), filepath.Join(env.testdata, "hardlink")))
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	problems, summary, err := testRunCheckJSON(t, env.gopts, CheckOptions{CheckMetadata: true})
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(problems))
+	rtest.Equals(t, 0, summary.Errors)
+}
+
+func testRunCheckJSON(t testing.TB, gopts GlobalOptions, opts CheckOptions) ([]checkProblem, checkSummary, error) {
+	buf := bytes.NewBuffer(nil)
+	globalOptions.stdout = buf
+	gopts.JSON = true
+	defer func() {
+		globalOptions.stdout = os.Stdout
+	}()
+
+	err := runCheck(opts, gopts, nil)
+
+	var problems []checkProblem
+	var summary checkSummary
+	sc := bufio.NewScanner(buf)
+	for sc.Scan() {
+		var msg struct {
+			MessageType string `json:"message_type"`
+		}
+		rtest.OK(t, json.Unmarshal(sc.Bytes(), &msg))
+
+		switch msg.MessageType {
+		case "problem":
+			var p checkProblem
+			rtest.OK(t, json.Unmarshal(sc.Bytes(), &p))
+			problems = append(problems, p)
+		case "summary":
+			rtest.OK(t, json.Unmarshal(sc.Bytes(), &summary))
+		default:
+			t.Fatalf("unexpected message %q", sc.Text())
+		}
+	}
+	rtest.OK(t, sc.Err())
+	rtest.Equals(t, "summary", summary.MessageType)
+
+	return problems, summary, err
+}
+
+func TestCheckJSON(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+
+	problems, summary, err := testRunCheckJSON(t, env.gopts, CheckOptions{ReadData: true, CheckUnused: true})
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(problems))
+	rtest.Equals(t, len(testRunList(t, "packs", env.gopts)), summary.PacksRead)
+
+	// data only referenced by a forgotten snapshot is reported as a warning
+	snapshotIDs := loadSnapshotMap(t, env.gopts)
+	rtest.OK(t, appendRandomData(filepath.Join(env.testdata, "unused"), 4096))
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	_, newSnapshotID := lastSnapshot(snapshotIDs, loadSnapshotMap(t, env.gopts))
+	testRunForget(t, env.gopts, newSnapshotID)
+
+	problems, summary, err = testRunCheckJSON(t, env.gopts, CheckOptions{CheckUnused: true})
+	rtest.Equals(t, ErrCheckWarnings, err)
+	rtest.Assert(t, len(problems) > 0, "expected unused blobs to be reported")
+	for _, p := range problems {
+		rtest.Equals(t, checkUnusedBlob, p.Category)
+		rtest.Equals(t, checkWarning, p.Severity)
+		rtest.Assert(t, p.Blob != nil, "blob is missing for problem %v", p.Message)
+	}
+	rtest.Equals(t, 0, summary.Errors)
+	rtest.Equals(t, len(problems), summary.Warnings)
+	rtest.Equals(t, len(problems), summary.Problems[checkUnusedBlob])
+
+	// a missing pack is an error
+	packs := testRunList(t, "packs", env.gopts)
+	rtest.OK(t, os.Remove(filepath.Join(env.repo, "data", packs[0].String()[:2], packs[0].String())))
+
+	problems, summary, err = testRunCheckJSON(t, env.gopts, CheckOptions{})
+	rtest.Assert(t, err != nil && err != ErrCheckWarnings, "expected check to fail, got %v", err)
+	rtest.Assert(t, summary.Errors > 0, "expected errors in summary %+v", summary)
+	rtest.Equals(t, 1, summary.Problems[checkMissingPack])
+
+	found := false
+	for _, p := range problems {
+		if p.Category == checkMissingPack {
+			found = true
+			rtest.Equals(t, checkError, p.Severity)
+			rtest.Equals(t, packs[0], *p.Pack)
+		}
+	}
+	rtest.Assert(t, found, "missing pack was not reported")
+}
+
 func TestCheckRestoreNoLock(t *testing.T) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
@@ -1136,6 +2119,32 @@ func TestCheckRestoreNoLock(t *testing.T) {
 }
 
 func TestPrune(t *testing.T) {
+	t.Run("0", func(t *testing.T) {
+		opts := PruneOptions{MaxUnused: "0%"}
+		checkOpts := CheckOptions{ReadData: true, CheckUnused: true}
+		testPrune(t, opts, checkOpts)
+	})
+
+	t.Run("50", func(t *testing.T) {
+		opts := PruneOptions{MaxUnused: "50%"}
+		checkOpts := CheckOptions{ReadData: true}
+		testPrune(t, opts, checkOpts)
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		opts := PruneOptions{MaxUnused: "unlimited"}
+		checkOpts := CheckOptions{ReadData: true}
+		testPrune(t, opts, checkOpts)
+	})
+
+	t.Run("MaxRepackSize", func(t *testing.T) {
+		opts := PruneOptions{MaxUnused: "0", MaxRepackSize: "1K"}
+		checkOpts := CheckOptions{ReadData: true}
+		testPrune(t, opts, checkOpts)
+	})
+}
+
+func testPrune(t *testing.T, pruneOpts PruneOptions, checkOpts CheckOptions) {
 	env, cleanup := withTestEnvironment(t)
 	defer cleanup()
 
@@ -1167,7 +2176,284 @@ func TestPrune(t *testing.T) {
 
 	testRunForgetJSON(t, env.gopts)
 	testRunForget(t, env.gopts, firstSnapshot[0].String())
-	testRunPrune(t, env.gopts)
+	testRunPrune(t, env.gopts, pruneOpts)
+	rtest.OK(t, runCheck(checkOpts, env.gopts, nil))
+}
+
+func TestPruneDryRun(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	opts := BackupOptions{}
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, opts, env.gopts)
+	firstSnapshot := testRunList(t, "snapshots", env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, opts, env.gopts)
+	testRunForget(t, env.gopts, firstSnapshot[0].String())
+
+	packsBefore := testRunList(t, "packs", env.gopts)
+	report := testRunPruneDryRun(t, env.gopts, PruneOptions{MaxUnused: "0%"})
+	rtest.Equals(t, packsBefore, testRunList(t, "packs", env.gopts))
+
+	rtest.Equals(t, len(packsBefore), report.Keep.Packs+report.Repack.Packs+report.Remove.Packs)
+	rtest.Assert(t, report.Remove.Packs+report.Repack.Packs > 0,
+		"expected packs to be deleted or rewritten, got %+v", report)
+	rtest.Equals(t, report.Repack.Bytes, report.DownloadBytes)
+	rtest.Equals(t, report.Remove.Bytes+report.Repack.Bytes, report.DeleteBytes)
+	rtest.Equals(t, report.SizeBefore-report.DeleteBytes+report.UploadBytes, report.SizeAfter)
+
+	testRunPrune(t, env.gopts, PruneOptions{MaxUnused: "0%"})
+	packsAfter := testRunList(t, "packs", env.gopts)
+	rtest.Assert(t, len(packsAfter) >= report.Keep.Packs && len(packsAfter) < len(packsBefore),
+		"expected at least %d and less than %d packs, got %d", report.Keep.Packs, len(packsBefore), len(packsAfter))
+	testRunCheck(t, env.gopts)
+}
+
+func TestPruneGracePeriod(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	opts := BackupOptions{}
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9")}, opts, env.gopts)
+	firstSnapshot := testRunList(t, "snapshots", env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, opts, env.gopts)
+
+	// keep a copy of the first snapshot to make its data referenced again later
+	snapshotFile := filepath.Join(env.repo, "snapshots", firstSnapshot[0].String())
+	buf, err := ioutil.ReadFile(snapshotFile)
+	rtest.OK(t, err)
+	testRunForget(t, env.gopts, firstSnapshot[0].String())
+
+	pruneOpts := PruneOptions{MaxUnused: "0%", GracePeriod: time.Hour}
+	packs := testRunList(t, "packs", env.gopts)
+	testRunPrune(t, env.gopts, pruneOpts)
+	remaining := restic.NewIDSet(testRunList(t, "packs", env.gopts)...)
+	rtest.Equals(t, 0, len(restic.NewIDSet(packs...).Sub(remaining)))
+	rtest.Equals(t, 1, len(testRunList(t, "tombstones", env.gopts)))
+	testRunCheck(t, env.gopts)
+
+	// the grace period has not passed yet
+	testRunPrune(t, env.gopts, pruneOpts)
+	rtest.Equals(t, 1, len(testRunList(t, "tombstones", env.gopts)))
+
+	// marked packs referenced by the restored snapshot are resurrected
+	rtest.OK(t, ioutil.WriteFile(snapshotFile, buf, 0600))
+	report := testRunPruneDryRun(t, env.gopts, pruneOpts)
+	rtest.Assert(t, report.ResurrectedPacks > 0, "expected resurrected packs, got %+v", report)
+	testRunPrune(t, env.gopts, pruneOpts)
+	testRunCheck(t, env.gopts)
+
+	testRunForget(t, env.gopts, firstSnapshot[0].String())
+	testRunPrune(t, env.gopts, pruneOpts)
+	rtest.Equals(t, 1, len(testRunList(t, "tombstones", env.gopts)))
+
+	// without a grace period, all marked packs are deleted
+	pruneOpts.GracePeriod = 0
+	testRunPrune(t, env.gopts, pruneOpts)
+	rtest.Equals(t, 0, len(testRunList(t, "tombstones", env.gopts)))
+	rtest.Assert(t, len(testRunList(t, "packs", env.gopts)) < len(packs),
+		"expected packs to be deleted")
+	testRunCheck(t, env.gopts)
+}
+
+func TestCleanup(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+	testRunBackup(t, "", []string{env.testdata}, BackupOptions{}, env.gopts)
+	packs := testRunList(t, "packs", env.gopts)
+
+	// leftovers of an interrupted upload and of an interrupted backup
+	tmpdir := filepath.Join(env.repo, "tmp")
+	rtest.OK(t, os.MkdirAll(tmpdir, 0700))
+	oldTemp := filepath.Join(tmpdir, "data-old")
+	rtest.OK(t, ioutil.WriteFile(oldTemp, []byte("incomplete"), 0600))
+	old := time.Now().Add(-48 * time.Hour)
+	rtest.OK(t, os.Chtimes(oldTemp, old, old))
+	newTemp := filepath.Join(tmpdir, "data-new")
+	rtest.OK(t, ioutil.WriteFile(newTemp, []byte("in progress"), 0600))
+
+	tempFiles := func() (names []string) {
+		entries, err := ioutil.ReadDir(tmpdir)
+		rtest.OK(t, err)
+		for _, fi := range entries {
+			names = append(names, fi.Name())
+		}
+		return names
+	}
+
+	// a pack with data which is not used by any snapshot, an index may be
+	// saved for it if IndexFull was replaced by another test
+	indexes := restic.NewIDSet(testRunList(t, "index", env.gopts)...)
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	_, _, err = repo.SaveBlob(env.gopts.ctx, restic.DataBlob, rtest.Random(23, 1000), restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.FlushPacks(env.gopts.ctx))
+	for _, id := range testRunList(t, "index", env.gopts) {
+		if !indexes.Has(id) {
+			rtest.OK(t, os.Remove(filepath.Join(env.repo, "index", id.String())))
+		}
+	}
+
+	// a file which cannot be read as a pack is kept
+	junk := restic.NewRandomID()
+	junkFile := filepath.Join(env.repo, "data", junk.String()[:2], junk.String())
+	rtest.OK(t, ioutil.WriteFile(junkFile, []byte("unreferenced pack"), 0600))
+	packs = append(packs, junk)
+
+	// without --remove, nothing is deleted
+	opts := CleanupOptions{MaxAge: 24 * time.Hour, GracePeriod: time.Hour}
+	rtest.OK(t, runCleanup(opts, env.gopts, nil))
+	rtest.Equals(t, []string{"data-new", "data-old"}, tempFiles())
+	rtest.Equals(t, len(packs)+1, len(testRunList(t, "packs", env.gopts)))
+
+	// the unreferenced pack is only marked for deletion during the grace period
+	opts.Remove = true
+	rtest.OK(t, runCleanup(opts, env.gopts, nil))
+	rtest.Equals(t, []string{"data-new"}, tempFiles())
+	rtest.Equals(t, len(packs)+1, len(testRunList(t, "packs", env.gopts)))
+	rtest.Equals(t, 1, len(testRunList(t, "tombstones", env.gopts)))
+
+	opts.GracePeriod = 0
+	rtest.OK(t, runCleanup(opts, env.gopts, nil))
+	rtest.Equals(t, restic.NewIDSet(packs...), restic.NewIDSet(testRunList(t, "packs", env.gopts)...))
+	rtest.Equals(t, 0, len(testRunList(t, "tombstones", env.gopts)))
+	rtest.OK(t, os.Remove(junkFile))
+	testRunCheck(t, env.gopts)
+
+	// nothing is removed if a snapshot uses data in packs missing from the
+	// index
+	for _, id := range testRunList(t, "index", env.gopts) {
+		rtest.OK(t, os.Remove(filepath.Join(env.repo, "index", id.String())))
+	}
+	err = runCleanup(opts, env.gopts, nil)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "rebuild-index"),
+		"cleanup with an incomplete index did not fail, err %v", err)
+	rtest.Equals(t, len(packs)-1, len(testRunList(t, "packs", env.gopts)))
+
+	testRunRebuildIndex(t, env.gopts)
+	rtest.OK(t, runCleanup(opts, env.gopts, nil))
+	rtest.Equals(t, len(packs)-1, len(testRunList(t, "packs", env.gopts)))
+	testRunCheck(t, env.gopts)
+}
+
+func TestPruneConcurrent(t *testing.T) {
+	env, cleanup := withTestEnvironment(t)
+	defer cleanup()
+
+	datafile := filepath.Join("testdata", "backup-data.tar.gz")
+	testRunInit(t, env.gopts)
+	rtest.SetupTarTestFixture(t, env.testdata, datafile)
+
+	opts := BackupOptions{}
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "3")}, opts, env.gopts)
+	firstSnapshot := testRunList(t, "snapshots", env.gopts)
+	testRunBackup(t, "", []string{filepath.Join(env.testdata, "0", "0", "9", "2")}, opts, env.gopts)
+	snapshotFile := filepath.Join(env.repo, "snapshots", firstSnapshot[0].String())
+	buf, err := ioutil.ReadFile(snapshotFile)
+	rtest.OK(t, err)
+
+	ctx := context.TODO()
+	repo, err := OpenRepository(env.gopts)
+	rtest.OK(t, err)
+	sn, err := restic.LoadSnapshot(ctx, repo, firstSnapshot[0])
+	rtest.OK(t, err)
+	testRunForget(t, env.gopts, firstSnapshot[0].String())
+
+	// a running backup does not prevent a concurrent prune
+	lock, err := lockRepo(repo)
+	rtest.OK(t, err)
+	err = runPrune(PruneOptions{MaxUnused: "0%"}, env.gopts)
+	rtest.Assert(t, restic.IsAlreadyLocked(err), "expected lock error, got %v", err)
+	testRunPruneDryRun(t, env.gopts, PruneOptions{MaxUnused: "0%", GracePeriod: time.Hour, Concurrent: true})
+	unlockRepo(lock)
+
+	pruneOpts := PruneOptions{MaxUnused: "0%", GracePeriod: time.Hour, Concurrent: true}
+	rtest.OK(t, verifyPruneOptions(&pruneOpts))
+
+	// planConcurrent loads the index and snapshots like a concurrent prune
+	// and returns the plan
+	planConcurrent := func() (*repository.Repository, *index.Index, *clientData, prunePlan) {
+		repo, err := OpenRepository(env.gopts)
+		rtest.OK(t, err)
+		rtest.OK(t, repo.LoadIndex(ctx))
+		idx, err := index.Load(ctx, repo, nil)
+		rtest.OK(t, err)
+		packSize := make(map[restic.ID]int64)
+		rtest.OK(t, repo.List(ctx, restic.DataFile, func(id restic.ID, size int64) error {
+			packSize[id] = size
+			return nil
+		}))
+		rtest.OK(t, completeIndex(ctx, repo, idx, packSize, nil))
+
+		snapshotIDs := restic.NewIDSet(testRunList(t, "snapshots", env.gopts)...)
+		used := restic.NewBlobSet()
+		for id := range snapshotIDs {
+			sn, err := restic.LoadSnapshot(ctx, repo, id)
+			rtest.OK(t, err)
+			rtest.OK(t, restic.FindUsedBlobs(ctx, repo, *sn.Tree, used, restic.NewBlobSet()))
+		}
+
+		plan := planPrune(pruneOpts, idx, used)
+		rtest.Assert(t, len(plan.removePacks) > 0, "expected packs to be removed")
+		return repo, idx, newClientData(idx.IndexIDs, snapshotIDs), plan
+	}
+
+	// simulate another client saving a snapshot which references data prune
+	// is about to remove, after prune has loaded the snapshots, the packs
+	// are kept before any pack is rewritten
+	repo, idx, data, plan := planConcurrent()
+	rtest.OK(t, ioutil.WriteFile(snapshotFile, buf, 0600))
+	kept, err := keepClientPacks(ctx, repo, data, idx, &plan)
+	rtest.OK(t, err)
+	rtest.Assert(t, kept > 0, "no packs kept")
+	rtest.Equals(t, 0, len(plan.removePacks))
+	rtest.OK(t, saveConcurrentIndex(ctx, repo, idx, data, plan.removePacks))
+	testRunCheck(t, env.gopts)
+
+	// a tree saved by another client while the index is replaced, which
+	// references the data but has no snapshot yet, keeps the data as well
+	testRunForget(t, env.gopts, firstSnapshot[0].String())
+	repo, idx, data, plan = planConcurrent()
+	kept, err = keepClientPacks(ctx, repo, data, idx, &plan)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, kept)
+	tree := restic.NewTree()
+	rtest.OK(t, tree.Insert(&restic.Node{Name: "old", Type: "dir", Subtree: sn.Tree}))
+	treeID, err := repo.SaveTree(ctx, tree)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(ctx))
+	remove := plan.removePacks
+	rtest.OK(t, saveConcurrentIndex(ctx, repo, idx, data, remove))
+	rtest.Equals(t, 0, len(remove))
+
+	// the other client finishes its backup
+	newSn, err := restic.NewSnapshot([]string{"/old"}, nil, "other", time.Now())
+	rtest.OK(t, err)
+	newSn.Tree = &treeID
+	newSnID, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, newSn)
+	rtest.OK(t, err)
+	testRunCheck(t, env.gopts)
+	testRunForget(t, env.gopts, newSnID.String())
+
+	// other clients holding a lock do not prevent marking packs for
+	// deletion, data they still reference is protected by the grace period
+	lock, err = lockRepo(repo)
+	rtest.OK(t, err)
+	testRunPrune(t, env.gopts, pruneOpts)
+	unlockRepo(lock)
+	rtest.Equals(t, 1, len(testRunList(t, "tombstones", env.gopts)))
 	testRunCheck(t, env.gopts)
 }
 
@@ -26,17 +26,25 @@ func lockRepoExclusive(repo *repository.Repository) (*restic.Lock, error) {
 	return lockRepository(repo, true)
 }
 
+func lockRepoPrune(repo *repository.Repository) (*restic.Lock, error) {
+	return acquireLock(repo, restic.NewPruneLock)
+}
+
 func lockRepository(repo *repository.Repository, exclusive bool) (*restic.Lock, error) {
 	lockFn := restic.NewLock
 	if exclusive {
 		lockFn = restic.NewExclusiveLock
 	}
 
+	return acquireLock(repo, lockFn)
+}
+
+func acquireLock(repo *repository.Repository, lockFn func(context.Context, restic.Repository) (*restic.Lock, error)) (*restic.Lock, error) {
 	lock, err := lockFn(context.TODO(), repo)
 	if err != nil {
 		return nil, errors.WithMessage(err, "unable to create lock in backend")
 	}
-	debug.Log("create lock %p (exclusive %v)", lock, exclusive)
+	debug.Log("create lock %p (exclusive %v, prune %v)", lock, lock.Exclusive, lock.Prune)
 
 	globalLocks.Lock()
 	if globalLocks.cancelRefresh == nil {
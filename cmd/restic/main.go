@@ -108,6 +108,8 @@ func main() {
 		exitCode = 0
 	case InvalidSourceData:
 		exitCode = 3
+	case ErrCheckWarnings:
+		exitCode = 4
 	default:
 		exitCode = 1
 	}
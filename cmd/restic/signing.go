@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/quinn/restic/internal/errors"
+	"github.com/quinn/restic/internal/restic"
+)
+
+// trustedKey is an entry of the trusted keys file.
+type trustedKey struct {
+	Key     ed25519.PublicKey
+	Comment string
+}
+
+// loadSigningKey reads the private key used to sign snapshots from filename.
+func loadSigningKey(filename string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, errors.Fatalf("signing key %s does not exist", filename)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFile")
+	}
+
+	buf, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(buf) != ed25519.PrivateKeySize {
+		return nil, errors.Fatalf("signing key %s is invalid", filename)
+	}
+
+	return ed25519.PrivateKey(buf), nil
+}
+
+// saveSigningKey writes key to filename, which must not exist yet.
+func saveSigningKey(filename string, key ed25519.PrivateKey) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return errors.Fatalf("signing key %s already exists", filename)
+	}
+	if err != nil {
+		return errors.Wrap(err, "OpenFile")
+	}
+
+	_, err = fmt.Fprintln(f, base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, "Write")
+	}
+
+	return errors.Wrap(f.Close(), "Close")
+}
+
+// loadTrustedKeys reads the trusted keys file. Each line contains a public key
+// optionally followed by a comment, empty lines and lines starting with # are
+// ignored. A missing file is an error, unless allowMissing is set, then it is
+// treated as an empty list.
+func loadTrustedKeys(filename string, allowMissing bool) ([]trustedKey, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		if allowMissing {
+			return nil, nil
+		}
+		return nil, errors.Fatalf("trusted keys file %s does not exist", filename)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFile")
+	}
+
+	var keys []trustedKey
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		s := strings.TrimSpace(sc.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(s, " ", 2)
+		key, err := restic.ParsePublicKey(fields[0])
+		if err != nil {
+			return nil, errors.Fatalf("%s:%d: %v", filename, line, err)
+		}
+
+		k := trustedKey{Key: key}
+		if len(fields) == 2 {
+			k.Comment = strings.TrimSpace(fields[1])
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, errors.Wrap(sc.Err(), "Scan")
+}
+
+// saveTrustedKeys replaces the trusted keys file with keys.
+func saveTrustedKeys(filename string, keys []trustedKey) error {
+	buf := bytes.NewBuffer(nil)
+	for _, k := range keys {
+		buf.WriteString(restic.EncodePublicKey(k.Key))
+		if k.Comment != "" {
+			buf.WriteString(" " + k.Comment)
+		}
+		buf.WriteString("\n")
+	}
+
+	return errors.Wrap(ioutil.WriteFile(filename, buf.Bytes(), 0644), "WriteFile")
+}
+
+// signingKey returns the key configured for signing snapshots, or nil if no
+// key is configured.
+func signingKey(gopts GlobalOptions) (ed25519.PrivateKey, error) {
+	if gopts.SigningKeyFile == "" {
+		return nil, nil
+	}
+
+	return loadSigningKey(gopts.SigningKeyFile)
+}
+
+// trustedPublicKeys returns the public keys snapshot signatures are verified
+// with. If no trusted keys file is configured, verify is false.
+func trustedPublicKeys(gopts GlobalOptions) (keys []ed25519.PublicKey, verify bool, err error) {
+	if gopts.TrustedKeysFile == "" {
+		return nil, false, nil
+	}
+
+	list, err := loadTrustedKeys(gopts.TrustedKeysFile, false)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, k := range list {
+		keys = append(keys, k.Key)
+	}
+
+	return keys, true, nil
+}
+
+// signatureStatus returns a short description of the result of
+// Snapshot.VerifySignature.
+func signatureStatus(err error) string {
+	switch err {
+	case nil:
+		return "valid"
+	case restic.ErrSnapshotUnsigned:
+		return "unsigned"
+	case restic.ErrUntrustedSigningKey:
+		return "untrusted"
+	case restic.ErrInvalidSignature:
+		return "invalid"
+	}
+	return "error"
+}
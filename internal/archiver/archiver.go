@@ -2,6 +2,7 @@ package archiver
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"os"
 	"path"
@@ -57,6 +58,9 @@ type Archiver struct {
 	fileSaver *FileSaver
 	treeSaver *TreeSaver
 
+	// checkpoint collects the items saved so far if checkpoints are enabled
+	checkpoint *checkpoint
+
 	// Error is called for all errors that occur during backup.
 	Error ErrorFunc
 
@@ -183,6 +187,12 @@ func (arch *Archiver) saveTree(ctx context.Context, t *restic.Tree) (restic.ID,
 
 // nodeFromFileInfo returns the restic node from an os.FileInfo.
 func (arch *Archiver) nodeFromFileInfo(filename string, fi os.FileInfo) (*restic.Node, error) {
+	// the link target and extended attributes must be read from the
+	// location the file is actually read from
+	if remap, ok := arch.FS.(fs.Remap); ok {
+		filename = remap.Actual(filename)
+	}
+
 	node, err := restic.NodeFromFileInfo(filename, fi)
 	if !arch.WithAtime {
 		node.AccessTime = node.ModTime
@@ -190,6 +200,16 @@ func (arch *Archiver) nodeFromFileInfo(filename string, fi os.FileInfo) (*restic
 	return node, errors.Wrap(err, "NodeFromFileInfo")
 }
 
+// withInodeFlags returns fi together with the inode flags of filename, so that
+// they are read only once for the select functions and the node.
+func (arch *Archiver) withInodeFlags(filename string, fi os.FileInfo) os.FileInfo {
+	if remap, ok := arch.FS.(fs.Remap); ok {
+		filename = remap.Actual(filename)
+	}
+
+	return restic.WithInodeFlags(filename, fi)
+}
+
 // loadSubtree tries to load the subtree referenced by node. In case of an error, nil is returned.
 func (arch *Archiver) loadSubtree(ctx context.Context, node *restic.Node) *restic.Tree {
 	if node == nil || node.Type != "dir" || node.Subtree == nil {
@@ -215,6 +235,7 @@ func (arch *Archiver) SaveDir(ctx context.Context, snPath string, fi os.FileInfo
 	if err != nil {
 		return FutureTree{}, err
 	}
+	arch.checkpoint.startDir(snPath, treeNode)
 
 	names, err := readdirnames(arch.FS, dir, fs.O_NOFOLLOW)
 	if err != nil {
@@ -345,6 +366,9 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 
 	// get file info and run remaining select functions that require file information
 	fi, err := arch.FS.Lstat(target)
+	if err == nil {
+		fi = arch.withInodeFlags(target, fi)
+	}
 	if !arch.Select(abstarget, fi) {
 		debug.Log("%v is excluded", target)
 		return FutureNode{}, true, nil
@@ -364,6 +388,9 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 		debug.Log("  %v regular file", target)
 		start := time.Now()
 
+		// keep the inode flags which have been read for the select functions
+		lfi := fi
+
 		// reopen file and do an fstat() on the open file to check it is still
 		// a file (and has not been exchanged for e.g. a symlink)
 		file, err := arch.FS.OpenFile(target, fs.O_RDONLY|fs.O_NOFOLLOW, 0)
@@ -387,6 +414,10 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 			return FutureNode{}, true, nil
 		}
 
+		if ifi, ok := lfi.(restic.InodeFlagsFileInfo); ok {
+			fi = restic.InodeFlagsFileInfo{FileInfo: fi, InodeFlags: ifi.InodeFlags}
+		}
+
 		// make sure it's still a file
 		if !fs.IsRegularFile(fi) {
 			err = errors.Errorf("file %v changed type, refusing to archive")
@@ -411,6 +442,7 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 
 				// copy list of blobs
 				fn.node.Content = previous.Content
+				arch.checkpoint.complete(snPath, fn.node)
 
 				_ = file.Close()
 				return fn, false, nil
@@ -427,6 +459,7 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 		fn.file = arch.fileSaver.Save(ctx, snPath, file, fi, func() {
 			arch.StartFile(snPath)
 		}, func(node *restic.Node, stats ItemStats) {
+			arch.checkpoint.complete(snPath, node)
 			arch.CompleteItem(snPath, previous, node, stats, time.Since(start))
 		})
 
@@ -611,6 +644,7 @@ func (arch *Archiver) SaveTree(ctx context.Context, snPath string, atree *Tree,
 			return nil, err
 		}
 
+		arch.checkpoint.complete(join(snPath, name), node)
 		arch.CompleteItem(snItem, oldNode, node, nodeStats, time.Since(start))
 	}
 
@@ -643,6 +677,8 @@ func (arch *Archiver) SaveTree(ctx context.Context, snPath string, atree *Tree,
 		if err != nil {
 			return nil, err
 		}
+
+		arch.checkpoint.complete(fn.snPath, fn.node)
 	}
 
 	return tree, nil
@@ -702,10 +738,26 @@ func resolveRelativeTargets(filesys fs.FS, targets []string) ([]string, error) {
 // SnapshotOptions collect attributes for a new snapshot.
 type SnapshotOptions struct {
 	Tags           []string
+	Labels         map[string]string
+	Description    string
 	Hostname       string
 	Excludes       []string
 	Time           time.Time
 	ParentSnapshot restic.ID
+
+	// SkipIfUnchanged omits saving the snapshot if its tree is the same as
+	// the tree of ParentSnapshot.
+	SkipIfUnchanged bool
+
+	// SigningKey is used to sign the snapshot and the checkpoint snapshots
+	// if set.
+	SigningKey ed25519.PrivateKey
+
+	// CheckpointInterval configures how often a checkpoint snapshot is
+	// saved while the backup is running, it is disabled if set to zero. A
+	// checkpoint snapshot contains the items saved so far, each checkpoint
+	// replaces the previous one.
+	CheckpointInterval time.Duration
 }
 
 // loadParentTree loads a tree referenced by snapshot id. If id is null, nil is returned.
@@ -747,9 +799,12 @@ func (arch *Archiver) runWorkers(ctx context.Context, t *tomb.Tomb) {
 	arch.fileSaver.NodeFromFileInfo = arch.nodeFromFileInfo
 
 	arch.treeSaver = NewTreeSaver(ctx, t, arch.Options.SaveTreeConcurrency, arch.saveTree, arch.Error)
+	arch.treeSaver.CompleteNode = arch.checkpoint.complete
 }
 
-// Snapshot saves several targets and returns a snapshot.
+// Snapshot saves several targets and returns a snapshot. If
+// opts.SkipIfUnchanged is set and nothing has changed since the parent
+// snapshot, no snapshot is saved and a nil snapshot is returned.
 func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts SnapshotOptions) (*restic.Snapshot, restic.ID, error) {
 	cleanTargets, err := resolveRelativeTargets(arch.FS, targets)
 	if err != nil {
@@ -761,16 +816,41 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 		return nil, restic.ID{}, err
 	}
 
+	paths := func() []string { return targets }
+	return arch.snapshot(ctx, opts, paths, func(ctx context.Context, parent *restic.Tree) (*restic.Tree, error) {
+		return arch.SaveTree(ctx, "/", atree, parent)
+	})
+}
+
+// snapshot runs save with the worker pools started and saves a snapshot for
+// the returned tree. save is called with the tree of the parent snapshot.
+// paths returns the paths of the snapshot, it is also called for the
+// checkpoints saved while save is running.
+func (arch *Archiver) snapshot(ctx context.Context, opts SnapshotOptions, paths func() []string, save func(context.Context, *restic.Tree) (*restic.Tree, error)) (*restic.Snapshot, restic.ID, error) {
 	var t tomb.Tomb
 	wctx := t.Context(ctx)
 
+	arch.checkpoint = nil
+	if opts.CheckpointInterval > 0 {
+		arch.checkpoint = &checkpoint{}
+	}
+
 	arch.runWorkers(wctx, &t)
 
+	// checkpoints are saved with ctx, so that a checkpoint in progress is
+	// not interrupted when the workers are stopped
+	var lastCheckpoint restic.ID
+	if arch.checkpoint != nil {
+		t.Go(func() error {
+			return arch.runCheckpoints(ctx, t.Dying(), paths, opts, &lastCheckpoint)
+		})
+	}
+
 	start := time.Now()
 
 	debug.Log("starting snapshot")
 	rootTreeID, stats, err := func() (restic.ID, ItemStats, error) {
-		tree, err := arch.SaveTree(wctx, "/", atree, arch.loadParentTree(wctx, opts.ParentSnapshot))
+		tree, err := save(wctx, arch.loadParentTree(wctx, opts.ParentSnapshot))
 		if err != nil {
 			return restic.ID{}, ItemStats{}, err
 		}
@@ -802,17 +882,60 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 		return nil, restic.ID{}, err
 	}
 
-	sn, err := restic.NewSnapshot(targets, opts.Tags, opts.Hostname, opts.Time)
+	if opts.SkipIfUnchanged && !opts.ParentSnapshot.IsNull() {
+		parent, err := restic.LoadSnapshot(ctx, arch.Repo, opts.ParentSnapshot)
+		if err != nil {
+			return nil, restic.ID{}, err
+		}
+
+		if parent.Tree != nil && rootTreeID.Equal(*parent.Tree) {
+			debug.Log("tree %v is unchanged since parent snapshot %v", rootTreeID, opts.ParentSnapshot)
+			return nil, restic.ID{}, arch.removeCheckpoints(ctx, lastCheckpoint, restic.ID{})
+		}
+	}
+
+	sn, id, err := arch.saveSnapshot(ctx, paths(), opts, false, rootTreeID)
 	if err != nil {
 		return nil, restic.ID{}, err
 	}
 
+	err = arch.removeCheckpoints(ctx, lastCheckpoint, opts.ParentSnapshot)
+	if err != nil {
+		return nil, restic.ID{}, err
+	}
+
+	return sn, id, nil
+}
+
+// saveSnapshot saves a snapshot for the tree treeID. If checkpoint is set, the
+// snapshot is marked as a checkpoint and tagged with CheckpointTag.
+func (arch *Archiver) saveSnapshot(ctx context.Context, targets []string, opts SnapshotOptions, checkpoint bool, treeID restic.ID) (*restic.Snapshot, restic.ID, error) {
+	tags := opts.Tags
+	if checkpoint {
+		tags = append(append([]string{}, opts.Tags...), CheckpointTag)
+	}
+
+	sn, err := restic.NewSnapshot(targets, tags, opts.Hostname, opts.Time)
+	if err != nil {
+		return nil, restic.ID{}, err
+	}
+	sn.Checkpoint = checkpoint
+
 	sn.Excludes = opts.Excludes
+	sn.Labels = opts.Labels
+	sn.Description = opts.Description
 	if !opts.ParentSnapshot.IsNull() {
 		id := opts.ParentSnapshot
 		sn.Parent = &id
 	}
-	sn.Tree = &rootTreeID
+	sn.Tree = &treeID
+
+	if opts.SigningKey != nil {
+		err = sn.Sign(opts.SigningKey)
+		if err != nil {
+			return nil, restic.ID{}, err
+		}
+	}
 
 	id, err := arch.Repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
 	if err != nil {
@@ -821,3 +944,33 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 
 	return sn, id, nil
 }
+
+// removeCheckpoints removes the checkpoint snapshot saved last, and the parent
+// snapshot if it is a checkpoint snapshot, both are superseded by the snapshot
+// which has just been completed.
+func (arch *Archiver) removeCheckpoints(ctx context.Context, last, parent restic.ID) error {
+	if !last.IsNull() {
+		err := arch.removeSnapshot(ctx, last)
+		if err != nil {
+			return err
+		}
+	}
+
+	if parent.IsNull() {
+		return nil
+	}
+
+	sn, err := restic.LoadSnapshot(ctx, arch.Repo, parent)
+	if err != nil {
+		debug.Log("unable to load parent snapshot %v: %v", parent, err)
+		return nil
+	}
+
+	// only snapshots saved as checkpoints are removed, the tag may have
+	// been chosen by the user as well
+	if !sn.Checkpoint {
+		return nil
+	}
+
+	return arch.removeSnapshot(ctx, parent)
+}
@@ -1657,6 +1657,55 @@ func TestArchiverParent(t *testing.T) {
 	}
 }
 
+func TestArchiverSkipIfUnchanged(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, TestDir{
+		"dir": TestDir{
+			"file": TestFile{Content: "foo"},
+		},
+	})
+	defer cleanup()
+
+	back := fs.TestChdir(t, tempdir)
+	defer back()
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+
+	_, parentID, err := arch.Snapshot(ctx, []string{"dir"}, SnapshotOptions{Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := SnapshotOptions{
+		Time:            time.Now(),
+		ParentSnapshot:  parentID,
+		SkipIfUnchanged: true,
+	}
+	sn, id, err := arch.Snapshot(ctx, []string{"dir"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sn != nil || !id.IsNull() {
+		t.Fatalf("snapshot %v was saved although nothing changed", id.Str())
+	}
+
+	// a new file changes the tree
+	if err = ioutil.WriteFile(filepath.Join("dir", "new"), []byte("bar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sn, id, err = arch.Snapshot(ctx, []string{"dir"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sn == nil || id.IsNull() {
+		t.Fatal("no snapshot saved for changed files")
+	}
+
+	checker.TestCheckRepo(t, repo)
+}
+
 func TestArchiverErrorReporting(t *testing.T) {
 	ignoreErrorForBasename := func(basename string) ErrorFunc {
 		return func(item string, fi os.FileInfo, err error) error {
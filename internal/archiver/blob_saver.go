@@ -60,6 +60,14 @@ type FutureBlob struct {
 	res    saveBlobResponse
 }
 
+// newKnownBlob returns a FutureBlob for the blob id which has already been
+// saved.
+func newKnownBlob(id restic.ID, length int) FutureBlob {
+	ch := make(chan saveBlobResponse)
+	close(ch)
+	return FutureBlob{ch: ch, length: length, res: saveBlobResponse{id: id, known: true}}
+}
+
 // Wait blocks until the result is available or the context is cancelled.
 func (s *FutureBlob) Wait(ctx context.Context) {
 	select {
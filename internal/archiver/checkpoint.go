@@ -0,0 +1,259 @@
+package archiver
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quinn/restic/internal/debug"
+	"github.com/quinn/restic/internal/restic"
+)
+
+// CheckpointTag is added to the tags of checkpoint snapshots, so that they can
+// be recognized in the list of snapshots. A checkpoint snapshot contains the
+// files which have been saved so far by a backup that has not finished yet.
+// Checkpoints are identified by Snapshot.Checkpoint, not by the tag.
+const CheckpointTag = "partial"
+
+// checkpointItem is a file or directory in the tree of the items saved so far.
+type checkpointItem struct {
+	// node is set once the item has been saved completely
+	node *restic.Node
+
+	// dir is the node for a directory which is still being saved
+	dir *restic.Node
+
+	children map[string]*checkpointItem
+}
+
+// checkpoint collects the items which have been saved completely, so that a
+// snapshot of the data saved so far can be written while the backup is still
+// running. The children of a directory are dropped once the directory itself
+// has been saved. All methods can be called on a nil checkpoint, they do
+// nothing in this case.
+type checkpoint struct {
+	m    sync.Mutex
+	root checkpointItem
+}
+
+// item returns the item for snPath, it is created if it does not exist yet.
+// c.m must be held by the caller.
+func (c *checkpoint) item(snPath string) *checkpointItem {
+	item := &c.root
+	for _, name := range strings.Split(snPath, "/") {
+		if name == "" {
+			continue
+		}
+
+		if item.children == nil {
+			item.children = make(map[string]*checkpointItem)
+		}
+
+		child, ok := item.children[name]
+		if !ok {
+			child = &checkpointItem{}
+			item.children[name] = child
+		}
+		item = child
+	}
+
+	return item
+}
+
+// startDir records the node for the directory snPath, which is used until the
+// directory has been saved completely.
+func (c *checkpoint) startDir(snPath string, node *restic.Node) {
+	if c == nil {
+		return
+	}
+
+	// node is modified when the directory has been saved, keep a copy
+	dir := *node
+
+	c.m.Lock()
+	c.item(snPath).dir = &dir
+	c.m.Unlock()
+}
+
+// complete records that the item snPath has been saved as node.
+func (c *checkpoint) complete(snPath string, node *restic.Node) {
+	if c == nil || node == nil {
+		return
+	}
+
+	// the name of node may be modified later, keep a copy
+	n := *node
+
+	c.m.Lock()
+	item := c.item(snPath)
+	item.node = &n
+	item.dir = nil
+	item.children = nil
+	c.m.Unlock()
+}
+
+// copy returns a copy of the tree of items saved so far.
+func (c *checkpoint) copy() *checkpointItem {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var cp func(item *checkpointItem) *checkpointItem
+	cp = func(item *checkpointItem) *checkpointItem {
+		res := &checkpointItem{node: item.node, dir: item.dir}
+		if len(item.children) > 0 {
+			res.children = make(map[string]*checkpointItem, len(item.children))
+			for name, child := range item.children {
+				res.children[name] = cp(child)
+			}
+		}
+		return res
+	}
+
+	return cp(&c.root)
+}
+
+// saveCheckpointTree saves the trees for all directories below item which
+// have not been saved completely, and returns the tree for item. Directories
+// without any saved items are left out. The node for a directory without
+// recorded metadata is created with modTime.
+func saveCheckpointTree(ctx context.Context, repo restic.Repository, item *checkpointItem, modTime time.Time) (*restic.Tree, error) {
+	names := make([]string, 0, len(item.children))
+	for name := range item.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tree := restic.NewTree()
+	for _, name := range names {
+		child := item.children[name]
+
+		var node restic.Node
+		switch {
+		case child.node != nil:
+			node = *child.node
+		case len(child.children) > 0:
+			subtree, err := saveCheckpointTree(ctx, repo, child, modTime)
+			if err != nil {
+				return nil, err
+			}
+			if len(subtree.Nodes) == 0 {
+				continue
+			}
+
+			id, err := repo.SaveTree(ctx, subtree)
+			if err != nil {
+				return nil, err
+			}
+
+			if child.dir != nil {
+				node = *child.dir
+			} else {
+				node = restic.Node{
+					Type:       "dir",
+					Mode:       os.ModeDir | 0755,
+					ModTime:    modTime,
+					AccessTime: modTime,
+					ChangeTime: modTime,
+					UID:        uint32(os.Getuid()),
+					GID:        uint32(os.Getgid()),
+				}
+			}
+			node.Subtree = &id
+		default:
+			continue
+		}
+
+		node.Name = name
+		err := tree.Insert(&node)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tree, nil
+}
+
+// runCheckpoints saves a checkpoint snapshot every opts.CheckpointInterval
+// until done is closed. Each checkpoint replaces the previous one, the ID of
+// the checkpoint saved last is stored in last. paths returns the paths of the
+// checkpoint snapshots.
+func (arch *Archiver) runCheckpoints(ctx context.Context, done <-chan struct{}, paths func() []string, opts SnapshotOptions, last *restic.ID) error {
+	ticker := time.NewTicker(opts.CheckpointInterval)
+	defer ticker.Stop()
+
+	var lastTree restic.ID
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+		}
+
+		id, treeID, err := arch.saveCheckpoint(ctx, paths(), opts, lastTree)
+		if err != nil {
+			return err
+		}
+		if id.IsNull() {
+			continue
+		}
+		lastTree = treeID
+
+		if !last.IsNull() {
+			err = arch.removeSnapshot(ctx, *last)
+			if err != nil {
+				return err
+			}
+		}
+		*last = id
+	}
+}
+
+// saveCheckpoint writes all data saved so far to the repository together with
+// the index and saves a checkpoint snapshot for it, the IDs of the snapshot and
+// its tree are returned. If nothing has been saved yet or nothing has changed
+// since the checkpoint with the tree lastTree, no snapshot is saved and the
+// null ID is returned.
+func (arch *Archiver) saveCheckpoint(ctx context.Context, targets []string, opts SnapshotOptions, lastTree restic.ID) (restic.ID, restic.ID, error) {
+	debug.Log("saving checkpoint")
+
+	// all blobs referenced by the items in the copy have been handed to the
+	// repository, so they are contained in the packs written by Flush below
+	tree, err := saveCheckpointTree(ctx, arch.Repo, arch.checkpoint.copy(), opts.Time)
+	if err != nil {
+		return restic.ID{}, restic.ID{}, err
+	}
+
+	if len(tree.Nodes) == 0 {
+		debug.Log("nothing saved yet, skipping checkpoint")
+		return restic.ID{}, restic.ID{}, nil
+	}
+
+	treeID, err := arch.Repo.SaveTree(ctx, tree)
+	if err != nil {
+		return restic.ID{}, restic.ID{}, err
+	}
+
+	if treeID.Equal(lastTree) {
+		debug.Log("nothing changed since the last checkpoint")
+		return restic.ID{}, restic.ID{}, nil
+	}
+
+	err = arch.Repo.Flush(ctx)
+	if err != nil {
+		return restic.ID{}, restic.ID{}, err
+	}
+
+	_, id, err := arch.saveSnapshot(ctx, targets, opts, true, treeID)
+	debug.Log("saved checkpoint snapshot %v, err %v", id, err)
+	return id, treeID, err
+}
+
+// removeSnapshot removes the snapshot with the given ID from the repository.
+func (arch *Archiver) removeSnapshot(ctx context.Context, id restic.ID) error {
+	debug.Log("remove snapshot %v", id)
+	h := restic.Handle{Type: restic.SnapshotFile, Name: id.String()}
+	return arch.Repo.Backend().Remove(ctx, h)
+}
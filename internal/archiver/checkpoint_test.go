@@ -0,0 +1,213 @@
+package archiver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quinn/restic/internal/checker"
+	"github.com/quinn/restic/internal/fs"
+	"github.com/quinn/restic/internal/repository"
+	"github.com/quinn/restic/internal/restic"
+	restictest "github.com/quinn/restic/internal/test"
+)
+
+// stallingRepo blocks saving data blobs after the first n blobs until the
+// context is cancelled, like a stalled connection.
+type stallingRepo struct {
+	restic.Repository
+	n   int32
+	cnt int32
+}
+
+func (repo *stallingRepo) SaveBlob(ctx context.Context, t restic.BlobType, buf []byte, id restic.ID, storeDuplicate bool) (restic.ID, bool, error) {
+	if t == restic.DataBlob && atomic.AddInt32(&repo.cnt, 1) > repo.n {
+		<-ctx.Done()
+		return restic.ID{}, false, ctx.Err()
+	}
+
+	return repo.Repository.SaveBlob(ctx, t, buf, id, storeDuplicate)
+}
+
+// listSnapshots returns the IDs of all snapshots in repo.
+func listSnapshots(t testing.TB, repo restic.Repository) restic.IDs {
+	var ids restic.IDs
+	err := repo.List(context.TODO(), restic.SnapshotFile, func(id restic.ID, size int64) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestArchiverCheckpoint(t *testing.T) {
+	files := TestDir{}
+	for i := 0; i < 6; i++ {
+		files[fmt.Sprintf("file%d", i)] = TestFile{Content: string(restictest.Random(i, 1024))}
+	}
+	src := TestDir{"dir": files}
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := fs.TestChdir(t, tempdir)
+	defer back()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pub, key, err := ed25519.GenerateKey(nil)
+	restictest.OK(t, err)
+
+	// the backup stalls after the first three files have been saved
+	arch := New(&stallingRepo{Repository: repo, n: 3}, fs.Track{FS: fs.Local{}}, Options{
+		FileReadConcurrency: 1,
+		SaveBlobConcurrency: 1,
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := arch.Snapshot(ctx, []string{"dir"}, SnapshotOptions{
+			Time:               time.Now(),
+			SigningKey:         key,
+			CheckpointInterval: 10 * time.Millisecond,
+		})
+		errCh <- err
+	}()
+
+	want := TestDir{"dir": TestDir{
+		"file0": files["file0"],
+		"file1": files["file1"],
+		"file2": files["file2"],
+	}}
+
+	// wait for a checkpoint containing the first three files
+	var checkpointID restic.ID
+	for start := time.Now(); checkpointID.IsNull(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("no checkpoint with all saved files found")
+		}
+
+		for _, id := range listSnapshots(t, repo) {
+			sn, err := restic.LoadSnapshot(ctx, repo, id)
+			if err != nil {
+				continue
+			}
+			tree, err := repo.LoadTree(ctx, *sn.Tree)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := tree.Find("dir")
+			if dir == nil {
+				t.Fatalf("dir not found in checkpoint")
+			}
+			subtree, err := repo.LoadTree(ctx, *dir.Subtree)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(subtree.Nodes) == len(want["dir"].(TestDir)) {
+				checkpointID = id
+			}
+		}
+	}
+
+	cancel()
+	if err := <-errCh; err == nil {
+		t.Fatal("interrupted backup did not return an error")
+	}
+
+	ids := listSnapshots(t, repo)
+	if len(ids) != 1 || !ids[0].Equal(checkpointID) {
+		t.Fatalf("expected only checkpoint %v, got snapshots %v", checkpointID.Str(), ids)
+	}
+
+	// reopen the repository, the data which was not part of a checkpoint is lost
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	r := repository.New(repo.Backend())
+	restictest.OK(t, r.SearchKey(ctx, restictest.TestPassword, 10, ""))
+	restictest.OK(t, r.LoadIndex(ctx))
+
+	sn, err := restic.LoadSnapshot(ctx, r, checkpointID)
+	restictest.OK(t, err)
+	if !sn.Checkpoint || !sn.HasTags([]string{CheckpointTag}) {
+		t.Fatalf("snapshot is not marked as a checkpoint: %v %v", sn.Checkpoint, sn.Tags)
+	}
+	// the checkpoint is signed like the final snapshot, so it can be used as
+	// the parent when signatures are verified
+	restictest.OK(t, sn.VerifySignature([]ed25519.PublicKey{pub}))
+	TestEnsureSnapshot(t, r, checkpointID, want)
+	checker.TestCheckRepo(t, r)
+
+	// resume the backup with the checkpoint as the parent
+	countingRepo := &blobCountingRepo{
+		Repository: r,
+		saved:      make(map[restic.BlobHandle]uint),
+	}
+	arch = New(countingRepo, fs.Track{FS: fs.Local{}}, Options{})
+	sn, id, err := arch.Snapshot(ctx, []string{"dir"}, SnapshotOptions{
+		Time:           time.Now(),
+		ParentSnapshot: checkpointID,
+		SigningKey:     key,
+	})
+	restictest.OK(t, err)
+	restictest.OK(t, sn.VerifySignature([]ed25519.PublicKey{pub}))
+
+	dataBlobs := 0
+	for h := range countingRepo.saved {
+		if h.Type == restic.DataBlob {
+			dataBlobs++
+		}
+	}
+	if dataBlobs != 3 {
+		t.Errorf("wrong number of data blobs saved when resuming, want 3, got %v", dataBlobs)
+	}
+
+	// the checkpoint is superseded by the new snapshot
+	ids = listSnapshots(t, r)
+	if len(ids) != 1 || !ids[0].Equal(id) {
+		t.Fatalf("expected only snapshot %v, got snapshots %v", id.Str(), ids)
+	}
+
+	// the trees of the checkpoint are unused now, so the checker is not run
+	TestEnsureSnapshot(t, r, id, src)
+}
+
+func TestArchiverCheckpointUserTag(t *testing.T) {
+	src := TestDir{"file": TestFile{Content: "content"}}
+
+	tempdir, repo, cleanup := prepareTempdirRepoSrc(t, src)
+	defer cleanup()
+
+	back := fs.TestChdir(t, tempdir)
+	defer back()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a snapshot tagged like a checkpoint by the user is kept
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	_, parent, err := arch.Snapshot(ctx, []string{"file"}, SnapshotOptions{
+		Time: time.Now(),
+		Tags: []string{CheckpointTag},
+	})
+	restictest.OK(t, err)
+
+	_, id, err := arch.Snapshot(ctx, []string{"file"}, SnapshotOptions{
+		Time:               time.Now(),
+		ParentSnapshot:     parent,
+		CheckpointInterval: time.Hour,
+	})
+	restictest.OK(t, err)
+
+	ids := listSnapshots(t, repo)
+	if len(ids) != 2 || !restic.NewIDSet(ids...).Has(parent) || !restic.NewIDSet(ids...).Has(id) {
+		t.Fatalf("expected snapshots %v and %v, got %v", parent.Str(), id.Str(), ids)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"os"
+	"sync"
 
 	"github.com/restic/chunker"
 	"github.com/quinn/restic/internal/debug"
@@ -60,6 +61,11 @@ type FileSaver struct {
 
 	ch chan<- saveFileJob
 
+	// zeroBlobs maps the length of the blobs saved for holes in sparse files
+	// to their IDs
+	zeroBlobs  map[int]restic.ID
+	zeroBlobsM sync.Mutex
+
 	CompleteBlob func(filename string, bytes uint64)
 
 	NodeFromFileInfo func(filename string, fi os.FileInfo) (*restic.Node, error)
@@ -79,6 +85,7 @@ func NewFileSaver(ctx context.Context, t *tomb.Tomb, save SaveBlobFn, pol chunke
 		saveFilePool: NewBufferPool(ctx, int(poolSize), chunker.MaxSize),
 		pol:          pol,
 		ch:           ch,
+		zeroBlobs:    make(map[int]restic.ID),
 
 		CompleteBlob: func(string, uint64) {},
 	}
@@ -155,46 +162,76 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 		return saveFileResponse{err: errors.Errorf("node type %q is wrong", node.Type)}
 	}
 
-	// reuse the chunker
-	chnker.Reset(f, s.pol)
-
 	var results []FutureBlob
 
 	node.Content = []restic.ID{}
 	var size uint64
-	for {
-		buf := s.saveFilePool.Get()
-		chunk, err := chnker.Next(buf.Data)
-		if errors.Cause(err) == io.EOF {
-			buf.Release()
-			break
-		}
 
-		buf.Data = chunk.Data
+	// saveChunks splits the data read from rd into chunks and saves them
+	saveChunks := func(rd io.Reader) error {
+		// reuse the chunker
+		chnker.Reset(rd, s.pol)
 
-		size += uint64(chunk.Length)
+		for {
+			buf := s.saveFilePool.Get()
+			chunk, err := chnker.Next(buf.Data)
+			if errors.Cause(err) == io.EOF {
+				buf.Release()
+				return nil
+			}
 
-		if err != nil {
-			_ = f.Close()
-			return saveFileResponse{err: err}
-		}
+			buf.Data = chunk.Data
 
-		// test if the context has been cancelled, return the error
-		if ctx.Err() != nil {
-			_ = f.Close()
-			return saveFileResponse{err: ctx.Err()}
-		}
+			size += uint64(chunk.Length)
+
+			if err != nil {
+				return err
+			}
 
-		res := s.saveBlob(ctx, restic.DataBlob, buf)
-		results = append(results, res)
+			// test if the context has been cancelled, return the error
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 
-		// test if the context has been cancelled, return the error
-		if ctx.Err() != nil {
-			_ = f.Close()
-			return saveFileResponse{err: ctx.Err()}
+			res := s.saveBlob(ctx, restic.DataBlob, buf)
+			results = append(results, res)
+
+			// test if the context has been cancelled, return the error
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			s.CompleteBlob(f.Name(), uint64(len(chunk.Data)))
 		}
+	}
 
-		s.CompleteBlob(f.Name(), uint64(len(chunk.Data)))
+	// saveHole saves zero blobs for a hole of the given length without
+	// reading it
+	saveHole := func(length int64) error {
+		for length > 0 {
+			n := length
+			if n > chunker.MaxSize {
+				n = chunker.MaxSize
+			}
+
+			res, err := s.saveZeroBlob(ctx, int(n))
+			if err != nil {
+				return err
+			}
+			results = append(results, res)
+
+			size += uint64(n)
+			length -= n
+
+			s.CompleteBlob(f.Name(), uint64(n))
+		}
+		return nil
+	}
+
+	err = s.saveContent(f, fi.Size(), saveChunks, saveHole)
+	if err != nil {
+		_ = f.Close()
+		return saveFileResponse{err: err}
 	}
 
 	err = f.Close()
@@ -220,6 +257,101 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 	}
 }
 
+// minHoleSize is the minimal size of a hole in a sparse file which is saved
+// without reading it, smaller holes are read and chunked like data.
+const minHoleSize = chunker.MinSize
+
+// saveContent saves the content of the file f with the given size. The data is
+// passed to saveChunks, holes in sparse files are passed to saveHole.
+func (s *FileSaver) saveContent(f fs.File, size int64, saveChunks func(io.Reader) error, saveHole func(int64) error) error {
+	extents, err := fs.DataExtents(f, size)
+	if err != nil {
+		return err
+	}
+
+	// merge the extents separated by small holes
+	var merged []fs.Extent
+	for _, extent := range extents {
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			if extent.Offset-(last.Offset+last.Length) < minHoleSize {
+				last.Length = extent.Offset + extent.Length - last.Offset
+				continue
+			}
+		}
+		merged = append(merged, extent)
+	}
+
+	if len(merged) > 0 && merged[0].Offset < minHoleSize {
+		merged[0].Length += merged[0].Offset
+		merged[0].Offset = 0
+	}
+
+	// files without holes are read until EOF, even if they have grown
+	if len(merged) == 1 && merged[0].Offset == 0 && merged[0].Length >= size-minHoleSize {
+		return saveChunks(f)
+	}
+
+	var offset int64
+	for _, extent := range merged {
+		if extent.Offset > offset {
+			if err = saveHole(extent.Offset - offset); err != nil {
+				return err
+			}
+		}
+
+		if _, err = f.Seek(extent.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		if err = saveChunks(io.LimitReader(f, extent.Length)); err != nil {
+			return err
+		}
+
+		offset = extent.Offset + extent.Length
+	}
+
+	if offset < size {
+		return saveHole(size - offset)
+	}
+
+	return nil
+}
+
+// saveZeroBlob returns the blob for a hole of n bytes. Each zero blob is saved
+// once, afterwards the ID is reused without hashing the zeros again.
+func (s *FileSaver) saveZeroBlob(ctx context.Context, n int) (FutureBlob, error) {
+	s.zeroBlobsM.Lock()
+	id, ok := s.zeroBlobs[n]
+	s.zeroBlobsM.Unlock()
+
+	if ok {
+		return newKnownBlob(id, n), nil
+	}
+
+	buf := s.saveFilePool.Get()
+	buf.Data = buf.Data[:n]
+	for i := range buf.Data {
+		buf.Data[i] = 0
+	}
+
+	res := s.saveBlob(ctx, restic.DataBlob, buf)
+	res.Wait(ctx)
+	if ctx.Err() != nil {
+		return FutureBlob{}, ctx.Err()
+	}
+
+	// the ID is null if the blob saver failed, it must not be reused
+	if res.ID().IsNull() {
+		return FutureBlob{}, errors.New("saving zero blob failed")
+	}
+
+	s.zeroBlobsM.Lock()
+	s.zeroBlobs[n] = res.ID()
+	s.zeroBlobsM.Unlock()
+
+	return res, nil
+}
+
 func (s *FileSaver) worker(ctx context.Context, jobs <-chan saveFileJob) {
 	// a worker has one chunker which is reused for each file (because it contains a rather large buffer)
 	chnker := chunker.New(nil, s.pol)
@@ -1,11 +1,14 @@
 package archiver
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"testing"
 
 	"github.com/restic/chunker"
@@ -95,3 +98,151 @@ func TestFileSaver(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestFileSaverSparse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	// data, a hole of 20 MiB, data and a hole of 10 MiB at the end
+	data := test.Random(23, 1<<20)
+	filename := filepath.Join(tempdir, "sparse")
+	f, err := os.Create(filename)
+	test.OK(t, err)
+	_, err = f.WriteAt(data, 0)
+	test.OK(t, err)
+	_, err = f.WriteAt(data, 21<<20)
+	test.OK(t, err)
+	test.OK(t, f.Truncate(32<<20))
+	test.OK(t, f.Close())
+
+	want, err := ioutil.ReadFile(filename)
+	test.OK(t, err)
+
+	f, err = os.Open(filename)
+	test.OK(t, err)
+	extents, err := fs.DataExtents(f, int64(len(want)))
+	test.OK(t, err)
+	test.OK(t, f.Close())
+
+	tmb, ctx := tomb.WithContext(ctx)
+
+	var m sync.Mutex
+	blobs := make(map[restic.ID][]byte)
+	zeroBlobs := 0
+	saveBlob := func(ctx context.Context, tpe restic.BlobType, buf *Buffer) FutureBlob {
+		m.Lock()
+		defer m.Unlock()
+
+		id := restic.Hash(buf.Data)
+		blobs[id] = append([]byte{}, buf.Data...)
+		if bytes.Count(buf.Data, []byte{0}) == len(buf.Data) {
+			zeroBlobs++
+		}
+
+		ch := make(chan saveBlobResponse, 1)
+		ch <- saveBlobResponse{id: id}
+		close(ch)
+		return FutureBlob{ch: ch, length: len(buf.Data)}
+	}
+
+	pol, err := chunker.RandomPolynomial()
+	test.OK(t, err)
+
+	s := NewFileSaver(ctx, tmb, saveBlob, pol, 1, 1)
+	s.NodeFromFileInfo = restic.NodeFromFileInfo
+
+	var completed uint64
+	s.CompleteBlob = func(filename string, bytes uint64) {
+		completed += bytes
+	}
+
+	file, err := fs.Local{}.Open(filename)
+	test.OK(t, err)
+	fi, err := file.Stat()
+	test.OK(t, err)
+
+	ff := s.Save(ctx, filename, file, fi, func() {}, nil)
+	ff.Wait(ctx)
+	test.OK(t, ff.Err())
+
+	node := ff.Node()
+	test.Equals(t, uint64(len(want)), node.Size)
+	test.Equals(t, uint64(len(want)), completed)
+
+	var content []byte
+	for _, id := range node.Content {
+		content = append(content, blobs[id]...)
+	}
+	test.Assert(t, bytes.Equal(want, content), "wrong content saved")
+
+	if len(extents) > 1 {
+		// the holes are split into blobs of 8, 8, 4, 8 and 2 MiB, each length
+		// is only saved once
+		test.Equals(t, 3, zeroBlobs)
+	}
+
+	tmb.Kill(nil)
+	test.OK(t, tmb.Wait())
+}
+
+func TestFileSaverSparseError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tempdir, cleanup := test.TempDir(t)
+	defer cleanup()
+
+	// data followed by a hole of 10 MiB
+	filename := filepath.Join(tempdir, "sparse")
+	f, err := os.Create(filename)
+	test.OK(t, err)
+	_, err = f.Write(test.Random(23, 1<<20))
+	test.OK(t, err)
+	test.OK(t, f.Truncate(11<<20))
+
+	extents, err := fs.DataExtents(f, 11<<20)
+	test.OK(t, err)
+	test.OK(t, f.Close())
+	if len(extents) != 1 || extents[0].Length >= 11<<20 {
+		t.Skip("sparse files are not supported")
+	}
+
+	tmb, ctx := tomb.WithContext(ctx)
+
+	// saving blobs of zeros fails, the result channel is closed without a
+	// response like it is when the blob saver fails
+	saveBlob := func(ctx context.Context, tpe restic.BlobType, buf *Buffer) FutureBlob {
+		ch := make(chan saveBlobResponse, 1)
+		if bytes.Count(buf.Data, []byte{0}) != len(buf.Data) {
+			ch <- saveBlobResponse{id: restic.Hash(buf.Data)}
+		}
+		close(ch)
+		return FutureBlob{ch: ch, length: len(buf.Data)}
+	}
+
+	pol, err := chunker.RandomPolynomial()
+	test.OK(t, err)
+
+	s := NewFileSaver(ctx, tmb, saveBlob, pol, 1, 1)
+	s.NodeFromFileInfo = restic.NodeFromFileInfo
+
+	file, err := fs.Local{}.Open(filename)
+	test.OK(t, err)
+	fi, err := file.Stat()
+	test.OK(t, err)
+
+	ff := s.Save(ctx, filename, file, fi, func() {}, nil)
+	ff.Wait(ctx)
+	test.Assert(t, ff.Err() != nil, "failed zero blob was not reported")
+
+	// the null ID is not reused for later holes
+	for n, id := range s.zeroBlobs {
+		test.Assert(t, !id.IsNull(), "null ID cached for zero blob of %d bytes", n)
+	}
+
+	tmb.Kill(nil)
+	_ = tmb.Wait()
+}
@@ -0,0 +1,335 @@
+package archiver
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/quinn/restic/internal/debug"
+	"github.com/quinn/restic/internal/errors"
+	"github.com/quinn/restic/internal/fs"
+	"github.com/quinn/restic/internal/restic"
+)
+
+// tarDir is a directory in the tree built from the entries of a tar archive.
+type tarDir struct {
+	// node is nil if the archive does not contain the directory itself
+	node *restic.Node
+
+	nodes   map[string]*restic.Node
+	subdirs map[string]*tarDir
+}
+
+// subdir returns the subdirectory name, it is created if it does not exist.
+func (d *tarDir) subdir(name string) *tarDir {
+	sub, ok := d.subdirs[name]
+	if !ok {
+		sub = &tarDir{nodes: make(map[string]*restic.Node), subdirs: make(map[string]*tarDir)}
+		d.subdirs[name] = sub
+		delete(d.nodes, name)
+	}
+	return sub
+}
+
+// insert adds the node for the entry name, it replaces an earlier entry with
+// the same name as when the archive is extracted.
+func (d *tarDir) insert(name string, node *restic.Node) {
+	delete(d.subdirs, name)
+	d.nodes[name] = node
+}
+
+// tarImport saves the entries of a tar archive in the order in which they are
+// stored.
+type tarImport struct {
+	arch *Archiver
+	rd   *fs.TarReader
+	root *tarDir
+
+	// parents caches the trees of the parent snapshot by path
+	parents map[string]*restic.Tree
+
+	// excluded records for directories whether they are excluded, everything
+	// below an excluded directory is excluded as well
+	excluded map[string]bool
+
+	// saved contains the nodes of the entries saved so far, hard links are
+	// saved with the content of their target
+	saved map[string]*restic.Node
+
+	// names contains the top-level entries saved so far, it is read by the
+	// checkpoints
+	m     sync.Mutex
+	names map[string]struct{}
+}
+
+// SnapshotTar saves the entries of the tar archive read by the file system of
+// the archiver, which must be a TarReader. The entries are saved in the order
+// in which they are stored, so the archive is read only once and never needs
+// to be stored anywhere. The trees are saved once the whole archive has been
+// read. The paths of the snapshot are the top-level entries of the archive.
+// The parent snapshot and opts are handled as for Snapshot.
+func (arch *Archiver) SnapshotTar(ctx context.Context, opts SnapshotOptions) (*restic.Snapshot, restic.ID, error) {
+	rd, ok := arch.FS.(*fs.TarReader)
+	if !ok {
+		return nil, restic.ID{}, errors.New("file system is not a tar archive")
+	}
+
+	ti := &tarImport{
+		arch:     arch,
+		rd:       rd,
+		root:     &tarDir{nodes: make(map[string]*restic.Node), subdirs: make(map[string]*tarDir)},
+		parents:  make(map[string]*restic.Tree),
+		excluded: make(map[string]bool),
+		saved:    make(map[string]*restic.Node),
+		names:    make(map[string]struct{}),
+	}
+
+	return arch.snapshot(ctx, opts, ti.paths, func(ctx context.Context, parent *restic.Tree) (*restic.Tree, error) {
+		ti.parents["/"] = parent
+
+		for {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			name, fi, err := rd.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			// the root directory has no metadata in a snapshot
+			if name == "/" {
+				continue
+			}
+
+			err = ti.save(ctx, name, fi)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return ti.saveDir(ctx, "/", ti.root)
+	})
+}
+
+// paths returns the top-level entries saved so far.
+func (ti *tarImport) paths() []string {
+	ti.m.Lock()
+	defer ti.m.Unlock()
+
+	paths := make([]string, 0, len(ti.names))
+	for name := range ti.names {
+		paths = append(paths, name)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// dir returns the directory name, it is created if it does not exist.
+func (ti *tarImport) dir(name string) *tarDir {
+	if name == "/" {
+		return ti.root
+	}
+	return ti.dir(path.Dir(name)).subdir(path.Base(name))
+}
+
+// parentTree returns the tree for the directory name in the parent snapshot,
+// or nil if there is none.
+func (ti *tarImport) parentTree(ctx context.Context, name string) *restic.Tree {
+	tree, ok := ti.parents[name]
+	if !ok {
+		node := ti.parentTree(ctx, path.Dir(name)).Find(path.Base(name))
+		tree = ti.arch.loadSubtree(ctx, node)
+		ti.parents[name] = tree
+	}
+	return tree
+}
+
+// isExcluded returns true if a directory containing name is excluded. The
+// directories which are not contained in the archive are checked as well.
+func (ti *tarImport) isExcluded(name string) bool {
+	dir := path.Dir(name)
+	if dir == "/" {
+		return false
+	}
+
+	excluded, ok := ti.excluded[dir]
+	if !ok {
+		excluded = ti.isExcluded(dir) || !ti.selected(dir, ti.rd.DirInfo(dir))
+		ti.excluded[dir] = excluded
+	}
+	return excluded
+}
+
+// selected returns true if the entry name is selected by the archiver.
+func (ti *tarImport) selected(name string, fi os.FileInfo) bool {
+	return ti.arch.SelectByName(name) && ti.arch.Select(name, fi)
+}
+
+// save saves the current entry name of the archive. Directories are only
+// recorded, their trees are saved by saveDir.
+func (ti *tarImport) save(ctx context.Context, name string, fi os.FileInfo) error {
+	arch := ti.arch
+
+	if ti.isExcluded(name) {
+		debug.Log("%v is in an excluded directory", name)
+		return nil
+	}
+
+	selected := ti.selected(name, fi)
+	if fi.IsDir() {
+		ti.excluded[name] = !selected
+	}
+	if !selected {
+		debug.Log("%v is excluded", name)
+		return nil
+	}
+
+	start := time.Now()
+	dir := ti.dir(path.Dir(name))
+	base := path.Base(name)
+	previous := ti.parentTree(ctx, path.Dir(name)).Find(base)
+
+	var node *restic.Node
+	var err error
+	switch hdr := fi.Sys().(*fs.TarEntry).Header; {
+	case fi.IsDir():
+		node, err = arch.nodeFromFileInfo(name, fi)
+		if err != nil {
+			return arch.error(name, fi, err)
+		}
+
+		dir.subdir(base).node = node
+		arch.checkpoint.startDir(name, node)
+		ti.addName(name)
+		return nil
+
+	case hdr.Typeflag == tar.TypeLink:
+		target, ok := ti.saved[path.Clean("/"+hdr.Linkname)]
+		if !ok {
+			return arch.error(name, fi, errors.Errorf("hard link target %v not found in archive", hdr.Linkname))
+		}
+
+		node, err = arch.nodeFromFileInfo(name, fi)
+		if err != nil {
+			return arch.error(name, fi, err)
+		}
+		node.Content = target.Content
+		arch.CompleteItem(name, previous, node, ItemStats{}, time.Since(start))
+
+	default:
+		var fn FutureNode
+		var excluded bool
+		fn, excluded, err = arch.Save(ctx, name, name, previous)
+		if err != nil {
+			return arch.error(name, fi, err)
+		}
+		if excluded {
+			return nil
+		}
+
+		// the content must be read completely before the next entry
+		fn.wait(ctx)
+		if fn.err != nil {
+			return arch.error(fn.target, fn.fi, fn.err)
+		}
+
+		// when the error is ignored, the node could not be saved
+		if fn.node == nil {
+			debug.Log("%v excluded", name)
+			return nil
+		}
+		node = fn.node
+	}
+
+	node.Name = base
+	dir.insert(base, node)
+	ti.saved[name] = node
+	arch.checkpoint.complete(name, node)
+	ti.addName(name)
+	return nil
+}
+
+// addName records the top-level entry of name.
+func (ti *tarImport) addName(name string) {
+	for path.Dir(name) != "/" {
+		name = path.Dir(name)
+	}
+
+	ti.m.Lock()
+	ti.names[name] = struct{}{}
+	ti.m.Unlock()
+}
+
+// saveDir saves the trees for all subdirectories of dir and returns the tree
+// for dir. The number of links of the entries is set now that the whole
+// archive has been read.
+func (ti *tarImport) saveDir(ctx context.Context, snPath string, dir *tarDir) (*restic.Tree, error) {
+	arch := ti.arch
+	tree := restic.NewTree()
+
+	for _, node := range dir.nodes {
+		if node.Links > 0 {
+			node.Links = ti.rd.Links(node.Inode)
+		}
+
+		err := tree.Insert(node)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(dir.subdirs))
+	for name := range dir.subdirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		start := time.Now()
+		sub := dir.subdirs[name]
+		subPath := join(snPath, name)
+
+		subtree, err := ti.saveDir(ctx, subPath, sub)
+		if err != nil {
+			return nil, err
+		}
+
+		id, stats, err := arch.saveTree(ctx, subtree)
+		if err != nil {
+			return nil, err
+		}
+
+		node := sub.node
+		if node == nil {
+			node, err = arch.nodeFromFileInfo(subPath, ti.rd.DirInfo(subPath))
+			if err != nil {
+				return nil, err
+			}
+		}
+		node.Name = name
+		node.Subtree = &id
+
+		err = tree.Insert(node)
+		if err != nil {
+			return nil, err
+		}
+
+		arch.checkpoint.complete(subPath, node)
+		arch.CompleteItem(subPath+"/", ti.parentTree(ctx, snPath).Find(name), node, stats, time.Since(start))
+	}
+
+	return tree, nil
+}
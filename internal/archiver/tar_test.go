@@ -0,0 +1,147 @@
+package archiver
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quinn/restic/internal/fs"
+	"github.com/quinn/restic/internal/repository"
+	"github.com/quinn/restic/internal/restic"
+)
+
+// testTar returns a tar archive which contains the entries for hdrs, the
+// content of regular files is their name.
+func testTar(t testing.TB, hdrs []tar.Header) []byte {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for _, hdr := range hdrs {
+		var content string
+		if hdr.Typeflag == tar.TypeReg {
+			content = hdr.Name
+		}
+		hdr.Size = int64(len(content))
+		hdr.ModTime = time.Unix(1500000000, 0)
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestArchiverSnapshotTar(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	archive := testTar(t, []tar.Header{
+		{Name: "data/sub/file", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "data/", Typeflag: tar.TypeDir, Mode: 0700},
+		{Name: "data/hardlink", Typeflag: tar.TypeLink, Linkname: "data/sub/file", Mode: 0644},
+		{Name: "data/symlink", Typeflag: tar.TypeSymlink, Linkname: "sub/file"},
+		{Name: "data/excluded/file", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "data/replaced", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "data/replaced", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "other", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+
+	var read []string
+	snapshot := func(parent restic.ID, archive []byte) (*restic.Snapshot, restic.ID, error) {
+		read = nil
+		arch := New(repo, fs.NewTarReader(bytes.NewReader(archive), time.Unix(1600000000, 0)), Options{})
+		arch.SelectByName = func(item string) bool {
+			return !strings.HasSuffix(item, "/excluded")
+		}
+		arch.StartFile = func(filename string) {
+			read = append(read, filename)
+		}
+		return arch.SnapshotTar(ctx, SnapshotOptions{Time: time.Now(), ParentSnapshot: parent})
+	}
+
+	sn, id, err := snapshot(restic.ID{}, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(sn.Paths, " ") != "/data /other" {
+		t.Fatalf("wrong paths %v", sn.Paths)
+	}
+	if len(read) != 4 {
+		t.Fatalf("wrong files read: %v", read)
+	}
+
+	TestEnsureSnapshot(t, repo, id, TestDir{
+		"data": TestDir{
+			"sub":      TestDir{"file": TestFile{Content: "data/sub/file"}},
+			"hardlink": TestFile{Content: "data/sub/file"},
+			"symlink":  TestSymlink{Target: "sub/file"},
+			"replaced": TestFile{Content: "data/replaced"},
+		},
+		"other": TestFile{Content: "other"},
+	})
+
+	tree, err := repo.LoadTree(ctx, *sn.Tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := tree.Find("data")
+	if data.Mode != os.ModeDir|0700 {
+		t.Fatalf("wrong mode %v for directory", data.Mode)
+	}
+
+	tree, err = repo.LoadTree(ctx, *data.Subtree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hardlink := tree.Find("hardlink")
+	if hardlink.Links != 2 {
+		t.Fatalf("wrong number of links %v for hard link", hardlink.Links)
+	}
+	if tree.Find("replaced").Mode != 0600 {
+		t.Fatalf("earlier entry was not replaced")
+	}
+
+	sub, err := repo.LoadTree(ctx, *tree.Find("sub").Subtree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := sub.Find("file")
+	if file.Inode != hardlink.Inode || file.Links != 2 {
+		t.Fatalf("hard link does not share the inode: %v %v", file, hardlink)
+	}
+
+	// the same archive yields the same tree with the first snapshot as
+	// parent, only the replaced entry has a different inode than the file in
+	// the parent snapshot and is read again
+	sn2, _, err := snapshot(id, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(read, " ") != "/data/replaced" {
+		t.Fatalf("unmodified files were read again: %v", read)
+	}
+	if !sn2.Tree.Equal(*sn.Tree) {
+		t.Fatalf("tree of the second snapshot differs: %v != %v", sn2.Tree, sn.Tree)
+	}
+
+	// a hard link to an entry stored later in the archive cannot be resolved
+	_, _, err = snapshot(restic.ID{}, testTar(t, []tar.Header{
+		{Name: "hardlink", Typeflag: tar.TypeLink, Linkname: "file"},
+		{Name: "file", Typeflag: tar.TypeReg, Mode: 0644},
+	}))
+	if err == nil || !strings.Contains(err.Error(), "hard link target file not found") {
+		t.Fatalf("expected error for unresolvable hard link, got %v", err)
+	}
+}
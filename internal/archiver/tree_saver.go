@@ -43,6 +43,10 @@ type TreeSaver struct {
 	errFn    ErrorFunc
 
 	ch chan<- saveTreeJob
+
+	// CompleteNode is called for the nodes in a tree once they have been
+	// saved completely.
+	CompleteNode func(snPath string, node *restic.Node)
 }
 
 // NewTreeSaver returns a new tree saver. A worker pool with treeWorkers is
@@ -54,6 +58,8 @@ func NewTreeSaver(ctx context.Context, t *tomb.Tomb, treeWorkers uint, saveTree
 		ch:       ch,
 		saveTree: saveTree,
 		errFn:    errFn,
+
+		CompleteNode: func(string, *restic.Node) {},
 	}
 
 	for i := uint(0); i < treeWorkers; i++ {
@@ -128,6 +134,8 @@ func (s *TreeSaver) save(ctx context.Context, snPath string, node *restic.Node,
 		if err != nil {
 			return nil, stats, err
 		}
+
+		s.CompleteNode(fn.snPath, fn.node)
 	}
 
 	id, treeStats, err := s.saveTree(ctx, tree)
@@ -0,0 +1,78 @@
+package dryrun
+
+import (
+	"context"
+	"io"
+
+	"github.com/quinn/restic/internal/debug"
+	"github.com/quinn/restic/internal/restic"
+)
+
+// Backend passes reads through to an underlying backend and accepts all
+// writes without storing anything, removes are ignored as well. It is used
+// for operations which should not modify the repository, like
+// `backup --dry-run`.
+type Backend struct {
+	b restic.Backend
+}
+
+// statically ensure that Backend implements restic.Backend.
+var _ restic.Backend = &Backend{}
+
+// New returns a new dry-run backend wrapping be.
+func New(be restic.Backend) *Backend {
+	return &Backend{b: be}
+}
+
+// Save accepts the data without storing it.
+func (be *Backend) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader) error {
+	debug.Log("faked saving %v bytes at %v", rd.Length(), h)
+	return nil
+}
+
+// Remove does nothing.
+func (be *Backend) Remove(ctx context.Context, h restic.Handle) error {
+	debug.Log("faked removing %v", h)
+	return nil
+}
+
+// Delete does nothing.
+func (be *Backend) Delete(ctx context.Context) error {
+	return nil
+}
+
+// Location returns the location of the underlying backend.
+func (be *Backend) Location() string {
+	return "DRY:" + be.b.Location()
+}
+
+// Close closes the underlying backend.
+func (be *Backend) Close() error {
+	return be.b.Close()
+}
+
+// IsNotExist returns true if the error was caused by a non-existing file in
+// the underlying backend.
+func (be *Backend) IsNotExist(err error) bool {
+	return be.b.IsNotExist(err)
+}
+
+// List lists the files in the underlying backend.
+func (be *Backend) List(ctx context.Context, t restic.FileType, fn func(restic.FileInfo) error) error {
+	return be.b.List(ctx, t, fn)
+}
+
+// Load loads a file from the underlying backend.
+func (be *Backend) Load(ctx context.Context, h restic.Handle, length int, offset int64, fn func(io.Reader) error) error {
+	return be.b.Load(ctx, h, length, offset, fn)
+}
+
+// Stat returns information about a file in the underlying backend.
+func (be *Backend) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, error) {
+	return be.b.Stat(ctx, h)
+}
+
+// Test checks whether a file exists in the underlying backend.
+func (be *Backend) Test(ctx context.Context, h restic.Handle) (bool, error) {
+	return be.b.Test(ctx, h)
+}
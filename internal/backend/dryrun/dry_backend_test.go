@@ -0,0 +1,53 @@
+package dryrun_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/quinn/restic/internal/backend/dryrun"
+	"github.com/quinn/restic/internal/backend/mem"
+	"github.com/quinn/restic/internal/restic"
+	rtest "github.com/quinn/restic/internal/test"
+)
+
+func TestDryBackend(t *testing.T) {
+	ctx := context.TODO()
+	m := mem.New()
+
+	existing := restic.Handle{Type: restic.DataFile, Name: "existing"}
+	rtest.OK(t, m.Save(ctx, existing, restic.NewByteReader([]byte("foo"))))
+
+	be := dryrun.New(m)
+
+	// writes are accepted, but not stored
+	h := restic.Handle{Type: restic.DataFile, Name: "new"}
+	rtest.OK(t, be.Save(ctx, h, restic.NewByteReader([]byte("bar"))))
+	ok, err := m.Test(ctx, h)
+	rtest.OK(t, err)
+	rtest.Assert(t, !ok, "file was saved in the underlying backend")
+
+	rtest.OK(t, be.Remove(ctx, existing))
+	rtest.OK(t, be.Delete(ctx))
+
+	// reads are passed through
+	ok, err = be.Test(ctx, existing)
+	rtest.OK(t, err)
+	rtest.Assert(t, ok, "existing file was removed")
+
+	var buf []byte
+	err = be.Load(ctx, existing, 0, 0, func(rd io.Reader) (err error) {
+		buf, err = ioutil.ReadAll(rd)
+		return err
+	})
+	rtest.OK(t, err)
+	rtest.Equals(t, "foo", string(buf))
+
+	var names []string
+	rtest.OK(t, be.List(ctx, restic.DataFile, func(fi restic.FileInfo) error {
+		names = append(names, fi.Name)
+		return nil
+	}))
+	rtest.Equals(t, []string{"existing"}, names)
+}
@@ -16,11 +16,16 @@ type DefaultLayout struct {
 }
 
 var defaultLayoutPaths = map[restic.FileType]string{
-	restic.DataFile:     "data",
-	restic.SnapshotFile: "snapshots",
-	restic.IndexFile:    "index",
-	restic.LockFile:     "locks",
-	restic.KeyFile:      "keys",
+	restic.DataFile:         "data",
+	restic.SnapshotFile:     "snapshots",
+	restic.IndexFile:        "index",
+	restic.LockFile:         "locks",
+	restic.KeyFile:          "keys",
+	restic.ParityFile:       "parity",
+	restic.TombstoneFile:    "tombstones",
+	restic.VerificationFile: "verification",
+	restic.TempFile:         "tmp",
+	restic.ConfigBackupFile: "config-backup",
 }
 
 func (l *DefaultLayout) String() string {
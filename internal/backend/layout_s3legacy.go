@@ -11,11 +11,16 @@ type S3LegacyLayout struct {
 }
 
 var s3LayoutPaths = map[restic.FileType]string{
-	restic.DataFile:     "data",
-	restic.SnapshotFile: "snapshot",
-	restic.IndexFile:    "index",
-	restic.LockFile:     "lock",
-	restic.KeyFile:      "key",
+	restic.DataFile:         "data",
+	restic.SnapshotFile:     "snapshot",
+	restic.IndexFile:        "index",
+	restic.LockFile:         "lock",
+	restic.KeyFile:          "key",
+	restic.ParityFile:       "parity",
+	restic.TombstoneFile:    "tombstone",
+	restic.VerificationFile: "verification",
+	restic.TempFile:         "tmp",
+	restic.ConfigBackupFile: "config-backup",
 }
 
 func (l *S3LegacyLayout) String() string {
@@ -110,6 +110,11 @@ func TestDefaultLayout(t *testing.T) {
 			filepath.Join(tempdir, "index"),
 			filepath.Join(tempdir, "locks"),
 			filepath.Join(tempdir, "keys"),
+			filepath.Join(tempdir, "parity"),
+			filepath.Join(tempdir, "tombstones"),
+			filepath.Join(tempdir, "verification"),
+			filepath.Join(tempdir, "tmp"),
+			filepath.Join(tempdir, "config-backup"),
 		}
 
 		for i := 0; i < 256; i++ {
@@ -187,6 +192,11 @@ func TestRESTLayout(t *testing.T) {
 			filepath.Join(path, "index"),
 			filepath.Join(path, "locks"),
 			filepath.Join(path, "keys"),
+			filepath.Join(path, "parity"),
+			filepath.Join(path, "tombstones"),
+			filepath.Join(path, "verification"),
+			filepath.Join(path, "tmp"),
+			filepath.Join(path, "config-backup"),
 		}
 
 		sort.Strings(want)
@@ -333,6 +343,11 @@ func TestS3LegacyLayout(t *testing.T) {
 			filepath.Join(path, "index"),
 			filepath.Join(path, "lock"),
 			filepath.Join(path, "key"),
+			filepath.Join(path, "parity"),
+			filepath.Join(path, "tombstone"),
+			filepath.Join(path, "verification"),
+			filepath.Join(path, "tmp"),
+			filepath.Join(path, "config-backup"),
 		}
 
 		sort.Strings(want)
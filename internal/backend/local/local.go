@@ -87,19 +87,81 @@ func (b *Local) Save(ctx context.Context, h restic.Handle, rd restic.RewindReade
 
 	filename := b.Filename(h)
 
-	// create new file
-	f, err := fs.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, backend.Modes.File)
+	// the data is written to a temporary file first, which is moved to
+	// filename once it is complete
+	tmpname := b.Filename(backend.TempHandle(h))
+	err := b.saveTemp(tmpname, rd)
+	if err != nil {
+		_ = fs.Remove(tmpname)
+		return err
+	}
 
+	err = publish(tmpname, filename)
 	if b.IsNotExist(err) {
 		debug.Log("error %v: creating dir", err)
 
 		// error is caused by a missing directory, try to create it
-		mkdirErr := os.MkdirAll(filepath.Dir(filename), backend.Modes.Dir)
+		mkdirErr := fs.MkdirAll(filepath.Dir(filename), backend.Modes.Dir)
 		if mkdirErr != nil {
 			debug.Log("error creating dir %v: %v", filepath.Dir(filename), mkdirErr)
 		} else {
 			// try again
-			f, err = fs.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, backend.Modes.File)
+			err = publish(tmpname, filename)
+		}
+	}
+
+	if err != nil {
+		_ = fs.Remove(tmpname)
+		return errors.Wrap(err, "Link")
+	}
+
+	return nil
+}
+
+// publish moves the file tmpname to filename. Like creating filename with
+// O_EXCL, this fails if filename already exists: the file is hard linked to
+// filename, which never replaces an existing file, and tmpname is removed
+// afterwards. If the filesystem does not support hard links, the file is
+// renamed if filename does not exist yet.
+func publish(tmpname, filename string) error {
+	err := fs.Link(tmpname, filename)
+	if err == nil {
+		if err = fs.Remove(tmpname); err != nil {
+			// the file was saved, the temporary file is removed by cleanup
+			debug.Log("unable to remove %v: %v", tmpname, err)
+		}
+		return nil
+	}
+
+	if os.IsExist(err) || os.IsNotExist(err) {
+		return err
+	}
+
+	debug.Log("unable to link %v: %v, renaming it", tmpname, err)
+	if _, err = fs.Lstat(filename); err == nil {
+		return &os.LinkError{Op: "rename", Old: tmpname, New: filename, Err: os.ErrExist}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return fs.Rename(tmpname, filename)
+}
+
+// saveTemp writes the data from rd to the new temporary file tmpname.
+func (b *Local) saveTemp(tmpname string, rd io.Reader) error {
+	f, err := fs.OpenFile(tmpname, os.O_CREATE|os.O_EXCL|os.O_WRONLY, backend.Modes.File)
+
+	if b.IsNotExist(err) {
+		debug.Log("error %v: creating dir", err)
+
+		// error is caused by a missing directory, e.g. in repositories
+		// created by older versions, try to create it
+		mkdirErr := fs.MkdirAll(filepath.Dir(tmpname), backend.Modes.Dir)
+		if mkdirErr != nil {
+			debug.Log("error creating dir %v: %v", filepath.Dir(tmpname), mkdirErr)
+		} else {
+			// try again
+			f, err = fs.OpenFile(tmpname, os.O_CREATE|os.O_EXCL|os.O_WRONLY, backend.Modes.File)
 		}
 	}
 
@@ -124,7 +186,7 @@ func (b *Local) Save(ctx context.Context, h restic.Handle, rd restic.RewindReade
 		return errors.Wrap(err, "Close")
 	}
 
-	return setNewFileMode(filename, backend.Modes.File)
+	return setNewFileMode(tmpname, backend.Modes.File)
 }
 
 // Load runs fn with a reader that yields the contents of the file at h at the
@@ -175,7 +237,7 @@ func (b *Local) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, err
 		return restic.FileInfo{}, errors.Wrap(err, "Stat")
 	}
 
-	return restic.FileInfo{Size: fi.Size(), Name: h.Name}, nil
+	return restic.FileInfo{Size: fi.Size(), Name: h.Name, ModTime: fi.ModTime()}, nil
 }
 
 // Test returns true if a blob of the given type and name exists in the backend.
@@ -237,8 +299,9 @@ func (b *Local) List(ctx context.Context, t restic.FileType, fn func(restic.File
 		debug.Log("send %v\n", filepath.Base(path))
 
 		rfi := restic.FileInfo{
-			Name: filepath.Base(path),
-			Size: fi.Size(),
+			Name:    filepath.Base(path),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
 		}
 
 		if ctx.Err() != nil {
@@ -1,6 +1,8 @@
 package local_test
 
 import (
+	"context"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -8,6 +10,7 @@ import (
 
 	"github.com/quinn/restic/internal/backend/local"
 	"github.com/quinn/restic/internal/backend/test"
+	"github.com/quinn/restic/internal/errors"
 	"github.com/quinn/restic/internal/restic"
 	rtest "github.com/quinn/restic/internal/test"
 )
@@ -134,3 +137,52 @@ func TestOpenNotExistingDirectory(t *testing.T) {
 	removeAll(t, filepath.Join(dir, "data"))
 	empty(t, dir)
 }
+
+// failingReader returns an error after the data has been read.
+type failingReader struct {
+	rd *restic.ByteReader
+}
+
+func (f failingReader) Read(p []byte) (int, error) {
+	n, err := f.rd.Read(p)
+	if err == io.EOF {
+		return n, errors.New("upload interrupted")
+	}
+	return n, err
+}
+
+func (f failingReader) Rewind() error { return f.rd.Rewind() }
+func (f failingReader) Length() int64 { return f.rd.Length() }
+
+func TestSaveTemporaryFile(t *testing.T) {
+	dir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	be, err := local.Create(local.Config{Path: dir})
+	rtest.OK(t, err)
+
+	data := []byte("test data")
+	h := restic.Handle{Type: restic.DataFile, Name: restic.Hash(data).String()}
+
+	// a failed upload neither leaves the file nor the temporary file behind
+	err = be.Save(context.TODO(), h, failingReader{restic.NewByteReader(data)})
+	rtest.Assert(t, err != nil, "expected error for failed upload")
+	found, err := be.Test(context.TODO(), h)
+	rtest.OK(t, err)
+	rtest.Assert(t, !found, "file of failed upload was saved")
+	empty(t, filepath.Join(dir, "tmp"))
+
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(data)))
+	buf, err := ioutil.ReadFile(be.Filename(h))
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+	empty(t, filepath.Join(dir, "tmp"))
+
+	// an existing file is not replaced
+	err = be.Save(context.TODO(), h, restic.NewByteReader([]byte("other data")))
+	rtest.Assert(t, os.IsExist(errors.Cause(err)), "expected error for existing file, got %v", err)
+	buf, err = ioutil.ReadFile(be.Filename(h))
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+	empty(t, filepath.Join(dir, "tmp"))
+}
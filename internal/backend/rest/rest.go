@@ -446,7 +446,12 @@ func (b *Backend) Delete(ctx context.Context) error {
 		restic.KeyFile,
 		restic.LockFile,
 		restic.SnapshotFile,
-		restic.IndexFile}
+		restic.IndexFile,
+		restic.ParityFile,
+		restic.TombstoneFile,
+		restic.VerificationFile,
+		restic.TempFile,
+		restic.ConfigBackupFile}
 
 	for _, t := range alltypes {
 		err := b.removeKeys(ctx, t)
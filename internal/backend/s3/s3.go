@@ -457,7 +457,12 @@ func (be *Backend) Delete(ctx context.Context) error {
 		restic.KeyFile,
 		restic.LockFile,
 		restic.SnapshotFile,
-		restic.IndexFile}
+		restic.IndexFile,
+		restic.ParityFile,
+		restic.TombstoneFile,
+		restic.VerificationFile,
+		restic.TempFile,
+		restic.ConfigBackupFile}
 
 	for _, t := range alltypes {
 		err := be.removeKeys(ctx, t)
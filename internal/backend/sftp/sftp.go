@@ -268,9 +268,18 @@ func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader
 
 	filename := r.Filename(h)
 
-	// create new file
-	f, err := r.c.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+	// the data is written to a temporary file first, which is renamed once it
+	// is complete. Unlike the posix-rename extension, the rename of the SFTP
+	// protocol fails if filename already exists.
+	tmp := backend.TempHandle(h)
+	tmpname := r.Filename(tmp)
+	err := r.saveTemp(tmp, rd)
+	if err != nil {
+		_ = r.c.Remove(tmpname)
+		return err
+	}
 
+	err = r.c.Rename(tmpname, filename)
 	if r.IsNotExist(err) {
 		// error is caused by a missing directory, try to create it
 		mkdirErr := r.c.MkdirAll(r.Dirname(h))
@@ -278,7 +287,32 @@ func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader
 			debug.Log("error creating dir %v: %v", r.Dirname(h), mkdirErr)
 		} else {
 			// try again
-			f, err = r.c.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+			err = r.c.Rename(tmpname, filename)
+		}
+	}
+
+	if err != nil {
+		_ = r.c.Remove(tmpname)
+		return errors.Wrap(err, "Rename")
+	}
+
+	return nil
+}
+
+// saveTemp writes the data from rd to the new temporary file tmp.
+func (r *SFTP) saveTemp(tmp restic.Handle, rd io.Reader) error {
+	tmpname := r.Filename(tmp)
+	f, err := r.c.OpenFile(tmpname, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+
+	if r.IsNotExist(err) {
+		// error is caused by a missing directory, e.g. in repositories
+		// created by older versions, try to create it
+		mkdirErr := r.c.MkdirAll(r.Dirname(tmp))
+		if mkdirErr != nil {
+			debug.Log("error creating dir %v: %v", r.Dirname(tmp), mkdirErr)
+		} else {
+			// try again
+			f, err = r.c.OpenFile(tmpname, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
 		}
 	}
 
@@ -298,7 +332,7 @@ func (r *SFTP) Save(ctx context.Context, h restic.Handle, rd restic.RewindReader
 		return errors.Wrap(err, "Close")
 	}
 
-	return errors.Wrap(r.c.Chmod(filename, backend.Modes.File), "Chmod")
+	return errors.Wrap(r.c.Chmod(tmpname, backend.Modes.File), "Chmod")
 }
 
 // Load runs fn with a reader that yields the contents of the file at h at the
@@ -353,7 +387,7 @@ func (r *SFTP) Stat(ctx context.Context, h restic.Handle) (restic.FileInfo, erro
 		return restic.FileInfo{}, errors.Wrap(err, "Lstat")
 	}
 
-	return restic.FileInfo{Size: fi.Size(), Name: h.Name}, nil
+	return restic.FileInfo{Size: fi.Size(), Name: h.Name, ModTime: fi.ModTime()}, nil
 }
 
 // Test returns true if a blob of the given type and name exists in the backend.
@@ -418,8 +452,9 @@ func (r *SFTP) List(ctx context.Context, t restic.FileType, fn func(restic.FileI
 		debug.Log("send %v\n", path.Base(walker.Path()))
 
 		rfi := restic.FileInfo{
-			Name: path.Base(walker.Path()),
-			Size: fi.Size(),
+			Name:    path.Base(walker.Path()),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
 		}
 
 		if ctx.Err() != nil {
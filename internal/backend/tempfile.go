@@ -0,0 +1,19 @@
+package backend
+
+import "github.com/quinn/restic/internal/restic"
+
+// TempHandle returns a handle for a temporary file to which the file h is
+// uploaded before it is renamed. An interrupted upload leaves the temporary
+// file behind instead of an incomplete file h.
+func TempHandle(h restic.Handle) restic.Handle {
+	name := string(h.Type)
+	if h.Name != "" {
+		name += "-" + h.Name
+	}
+
+	id := restic.NewRandomID()
+	return restic.Handle{
+		Type: restic.TempFile,
+		Name: name + "-" + id.Str(),
+	}
+}
@@ -699,7 +699,8 @@ func (s *Suite) TestBackend(t *testing.T) {
 
 	for _, tpe := range []restic.FileType{
 		restic.DataFile, restic.KeyFile, restic.LockFile,
-		restic.SnapshotFile, restic.IndexFile,
+		restic.SnapshotFile, restic.IndexFile, restic.ParityFile, restic.TombstoneFile,
+		restic.VerificationFile, restic.TempFile, restic.ConfigBackupFile,
 	} {
 		// detect non-existing files
 		for _, ts := range testStrings {
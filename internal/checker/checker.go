@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"sync"
 
 	"github.com/quinn/restic/internal/debug"
@@ -21,11 +22,13 @@ import (
 // A Checker only tests for internal errors within the data structures of the
 // repository (e.g. missing blobs), and needs a valid Repository to work on.
 type Checker struct {
-	packs    restic.IDSet
+	packs restic.IDSet
+
+	// blobRefs contains the referenced blobs, whether a blob exists is looked
+	// up in the index, so that no entry is needed for unreferenced blobs
 	blobRefs struct {
 		sync.Mutex
-		// see flags below
-		M map[restic.BlobHandle]blobStatus
+		M restic.BlobSet
 	}
 
 	masterIndex *repository.MasterIndex
@@ -33,13 +36,6 @@ type Checker struct {
 	repo restic.Repository
 }
 
-type blobStatus uint8
-
-const (
-	blobStatusExists blobStatus = 1 << iota
-	blobStatusReferenced
-)
-
 // New returns a new checker which runs on repo.
 func New(repo restic.Repository) *Checker {
 	c := &Checker{
@@ -48,13 +44,19 @@ func New(repo restic.Repository) *Checker {
 		repo:        repo,
 	}
 
-	c.blobRefs.M = make(map[restic.BlobHandle]blobStatus)
+	c.blobRefs.M = restic.NewBlobSet()
 
 	return c
 }
 
 const defaultParallelism = 5
 
+// UseDiskIndex configures the checker to move the index to temporary files in
+// dir as soon as it contains more than maxBlobs blobs.
+func (c *Checker) UseDiskIndex(dir string, maxBlobs uint) {
+	c.masterIndex.UseDisk(dir, maxBlobs)
+}
+
 // ErrDuplicatePacks is returned when a pack is found in more than one index.
 type ErrDuplicatePacks struct {
 	PackID  restic.ID
@@ -162,8 +164,6 @@ func (c *Checker) LoadIndex(ctx context.Context) (hints []error, errs []error) {
 			cnt := 0
 			for blob := range res.Index.Each(ctx) {
 				c.packs.Insert(blob.PackID)
-				h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
-				c.blobRefs.M[h] = blobStatusExists
 				cnt++
 
 				if _, ok := packToIndex[blob.PackID]; !ok {
@@ -173,8 +173,16 @@ func (c *Checker) LoadIndex(ctx context.Context) (hints []error, errs []error) {
 			}
 
 			debug.Log("%d blobs processed", cnt)
+
+			// merge the indexes right away, so they can be moved to disk
+			// before all index files have been loaded
+			if c.masterIndex.UsesDisk() {
+				if err := c.masterIndex.MergeFinalIndexes(); err != nil {
+					return err
+				}
+			}
 		}
-		return nil
+		return c.masterIndex.MergeFinalIndexes()
 	})
 
 	err := wg.Wait()
@@ -193,8 +201,6 @@ func (c *Checker) LoadIndex(ctx context.Context) (hints []error, errs []error) {
 		}
 	}
 
-	c.masterIndex.MergeFinalIndexes()
-
 	err = c.repo.SetIndex(c.masterIndex)
 	if err != nil {
 		debug.Log("SetIndex returned error: %v", err)
@@ -208,6 +214,7 @@ func (c *Checker) LoadIndex(ctx context.Context) (hints []error, errs []error) {
 type PackError struct {
 	ID       restic.ID
 	Orphaned bool
+	Missing  bool
 	Err      error
 }
 
@@ -259,7 +266,7 @@ func (c *Checker) Packs(ctx context.Context, errChan chan<- error) {
 		select {
 		case <-ctx.Done():
 			return
-		case errChan <- PackError{ID: missingID, Err: errors.New("does not exist")}:
+		case errChan <- PackError{ID: missingID, Missing: true, Err: errors.New("does not exist")}:
 		}
 	}
 }
@@ -503,9 +510,9 @@ func (c *Checker) filterTrees(ctx context.Context, backlog restic.IDs, loaderCha
 			// even when a file references a tree blob
 			c.blobRefs.Lock()
 			h := restic.BlobHandle{ID: nextTreeID, Type: restic.TreeBlob}
-			status := c.blobRefs.M[h]
+			processed := c.blobRefs.M.Has(h)
 			c.blobRefs.Unlock()
-			if (status & blobStatusReferenced) != 0 {
+			if processed {
 				continue
 			}
 
@@ -526,7 +533,7 @@ func (c *Checker) filterTrees(ctx context.Context, backlog restic.IDs, loaderCha
 			loadCh = nil
 			c.blobRefs.Lock()
 			h := restic.BlobHandle{ID: nextTreeID, Type: restic.TreeBlob}
-			c.blobRefs.M[h] |= blobStatusReferenced
+			c.blobRefs.M.Insert(h)
 			c.blobRefs.Unlock()
 
 		case j, ok := <-inCh:
@@ -658,13 +665,13 @@ func (c *Checker) checkTree(id restic.ID, tree *restic.Tree) (errs []error) {
 	}
 
 	for _, blobID := range blobs {
-		c.blobRefs.Lock()
-		h := restic.BlobHandle{ID: blobID, Type: restic.DataBlob}
-		if (c.blobRefs.M[h] & blobStatusExists) == 0 {
+		if !c.masterIndex.Has(blobID, restic.DataBlob) {
 			debug.Log("tree %v references blob %v which isn't contained in index", id, blobID)
 			errs = append(errs, Error{TreeID: id, BlobID: blobID, Err: errors.New("not found in index")})
 		}
-		c.blobRefs.M[h] |= blobStatusReferenced
+
+		c.blobRefs.Lock()
+		c.blobRefs.M.Insert(restic.BlobHandle{ID: blobID, Type: restic.DataBlob})
 		debug.Log("blob %v is referenced", blobID)
 		c.blobRefs.Unlock()
 	}
@@ -672,17 +679,243 @@ func (c *Checker) checkTree(id restic.ID, tree *restic.Tree) (errs []error) {
 	return errs
 }
 
-// UnusedBlobs returns all blobs that have never been referenced.
+// MetadataError describes a node in a snapshot whose metadata is not
+// consistent.
+type MetadataError struct {
+	Snapshot restic.ID
+	Path     string
+	Err      error
+}
+
+func (e MetadataError) Error() string {
+	return fmt.Sprintf("snapshot %v: %v: %v", e.Snapshot.Str(), e.Path, e.Err)
+}
+
+type metadataProblem struct {
+	path string
+	err  error
+}
+
+type hardlinkNode struct {
+	path string
+	node *restic.Node
+}
+
+// treeMetadata collects the problems and hardlinked files found in a single
+// tree, the paths are relative to the tree. Only the subtrees which contain
+// problems or hardlinked files are recorded.
+type treeMetadata struct {
+	problems  []metadataProblem
+	hardlinks []hardlinkNode
+	subtrees  []metadataSubtree
+}
+
+type metadataSubtree struct {
+	name string
+	id   restic.ID
+}
+
+func (md *treeMetadata) empty() bool {
+	return len(md.problems) == 0 && len(md.hardlinks) == 0 && len(md.subtrees) == 0
+}
+
+// Metadata checks that the nodes in all snapshots are consistent: the size of
+// a file matches its content, hardlinked files have the same content and the
+// names within a tree are unique and sorted. Problems are reported for each
+// snapshot, errChan is closed afterwards.
+func (c *Checker) Metadata(ctx context.Context, errChan chan<- error) {
+	defer close(errChan)
+
+	trees := make(map[restic.ID]*treeMetadata)
+	err := c.repo.List(ctx, restic.SnapshotFile, func(id restic.ID, size int64) error {
+		sn, err := restic.LoadSnapshot(ctx, c.repo, id)
+		if err != nil || sn.Tree == nil {
+			// reported by the structure check
+			return nil
+		}
+
+		// the hardlinked files are collected for the whole snapshot
+		var problems []metadataProblem
+		var hardlinks []hardlinkNode
+		var walk func(dir string, id restic.ID)
+		walk = func(dir string, id restic.ID) {
+			md := c.checkTreeMetadata(ctx, id, trees)
+			if md == nil {
+				return
+			}
+
+			for _, p := range md.problems {
+				problems = append(problems, metadataProblem{path: path.Join(dir, p.path), err: p.err})
+			}
+			for _, h := range md.hardlinks {
+				hardlinks = append(hardlinks, hardlinkNode{path: path.Join(dir, h.path), node: h.node})
+			}
+			for _, sub := range md.subtrees {
+				walk(path.Join(dir, sub.name), sub.id)
+			}
+		}
+		walk("", *sn.Tree)
+
+		problems = append(problems, checkHardlinks(hardlinks)...)
+		for _, p := range problems {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case errChan <- MetadataError{Snapshot: id, Path: path.Join("/", p.path), Err: p.err}:
+			}
+		}
+		return ctx.Err()
+	})
+
+	if err != nil && ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case errChan <- err:
+		}
+	}
+}
+
+// checkTreeMetadata checks the tree id and its subtrees. The results for each
+// tree are cached in trees, so each tree is only loaded once. For trees
+// without problems and hardlinked files in them or their subtrees, nil is
+// returned.
+func (c *Checker) checkTreeMetadata(ctx context.Context, id restic.ID, trees map[restic.ID]*treeMetadata) *treeMetadata {
+	if md, ok := trees[id]; ok {
+		return md
+	}
+
+	tree, err := c.repo.LoadTree(ctx, id)
+	if err != nil {
+		// reported by the structure check
+		debug.Log("unable to load tree %v: %v", id, err)
+		if ctx.Err() == nil {
+			trees[id] = nil
+		}
+		return nil
+	}
+
+	md := &treeMetadata{}
+	for i, node := range tree.Nodes {
+		if i > 0 {
+			prev := tree.Nodes[i-1].Name
+			if prev == node.Name {
+				md.problems = append(md.problems, metadataProblem{err: errors.Errorf("duplicate name %q", node.Name)})
+			} else if prev > node.Name {
+				md.problems = append(md.problems, metadataProblem{err: errors.Errorf("name %q is sorted before %q", prev, node.Name)})
+			}
+		}
+
+		switch node.Type {
+		case "file":
+			var size uint64
+			complete := true
+			for _, blobID := range node.Content {
+				blobSize, found := c.repo.LookupBlobSize(blobID, restic.DataBlob)
+				if !found {
+					// missing blobs are reported by the structure check
+					complete = false
+					break
+				}
+				size += uint64(blobSize)
+			}
+
+			if complete && size != node.Size {
+				md.problems = append(md.problems, metadataProblem{
+					path: node.Name,
+					err:  errors.Errorf("size %d does not match content size %d", node.Size, size),
+				})
+			}
+
+			if node.Links > 1 {
+				md.hardlinks = append(md.hardlinks, hardlinkNode{path: node.Name, node: node})
+			}
+
+		case "dir":
+			if node.Subtree == nil || node.Subtree.IsNull() {
+				continue
+			}
+
+			if c.checkTreeMetadata(ctx, *node.Subtree, trees) != nil {
+				md.subtrees = append(md.subtrees, metadataSubtree{name: node.Name, id: *node.Subtree})
+			}
+		}
+	}
+
+	if md.empty() {
+		md = nil
+	}
+	if ctx.Err() == nil {
+		trees[id] = md
+	}
+	return md
+}
+
+// checkHardlinks returns a problem for each hardlinked file which does not
+// agree with the first file with the same device and inode.
+func checkHardlinks(hardlinks []hardlinkNode) (problems []metadataProblem) {
+	type inode struct {
+		device, inode uint64
+	}
+
+	first := make(map[inode]hardlinkNode)
+	for _, h := range hardlinks {
+		key := inode{h.node.DeviceID, h.node.Inode}
+		other, ok := first[key]
+		if !ok {
+			first[key] = h
+			continue
+		}
+
+		if h.node.Size != other.node.Size {
+			problems = append(problems, metadataProblem{
+				path: h.path,
+				err:  errors.Errorf("size %d differs from hardlink %q with size %d", h.node.Size, "/"+other.path, other.node.Size),
+			})
+			continue
+		}
+
+		if !sameContent(h.node.Content, other.node.Content) {
+			problems = append(problems, metadataProblem{
+				path: h.path,
+				err:  errors.Errorf("content differs from hardlink %q", "/"+other.path),
+			})
+		}
+	}
+
+	return problems
+}
+
+func sameContent(a, b restic.IDs) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// UnusedBlobs returns all blobs in the index that have never been referenced.
 func (c *Checker) UnusedBlobs() (blobs restic.BlobHandles) {
 	c.blobRefs.Lock()
 	defer c.blobRefs.Unlock()
 
-	debug.Log("checking %d blobs", len(c.blobRefs.M))
-	for id, flags := range c.blobRefs.M {
-		if (flags & blobStatusReferenced) == 0 {
-			debug.Log("blob %v not referenced", id)
-			blobs = append(blobs, id)
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	debug.Log("checking blobs, %d are referenced", len(c.blobRefs.M))
+	unused := restic.NewBlobSet()
+	for blob := range c.masterIndex.Each(ctx) {
+		h := restic.BlobHandle{ID: blob.ID, Type: blob.Type}
+		if c.blobRefs.M.Has(h) || unused.Has(h) {
+			continue
 		}
+
+		debug.Log("blob %v not referenced", h)
+		unused.Insert(h)
+		blobs = append(blobs, h)
 	}
 
 	return blobs
@@ -698,6 +931,25 @@ func (c *Checker) GetPacks() restic.IDSet {
 	return c.packs
 }
 
+// BlobError describes a blob in a pack which cannot be read or decrypted, or
+// whose content does not match its ID.
+type BlobError struct {
+	ID           restic.ID
+	HashMismatch bool
+	Err          error
+}
+
+func (e BlobError) Error() string {
+	return "blob " + e.ID.Str() + ": " + e.Err.Error()
+}
+
+// BlobErrors is returned by checkPack if some blobs of a pack are damaged.
+type BlobErrors []BlobError
+
+func (e BlobErrors) Error() string {
+	return fmt.Sprintf("contains %v errors: %v", len(e), []BlobError(e))
+}
+
 // checkPack reads a pack and checks the integrity of all blobs.
 func checkPack(ctx context.Context, r restic.Repository, id restic.ID) error {
 	debug.Log("checking pack %v", id)
@@ -725,7 +977,7 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID) error {
 		return err
 	}
 
-	var errs []error
+	var errs BlobErrors
 	var buf []byte
 	for i, blob := range blobs {
 		debug.Log("  check blob %d: %v", i, blob)
@@ -744,7 +996,7 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID) error {
 		_, err = io.ReadFull(packfile, buf)
 		if err != nil {
 			debug.Log("  error loading blob %v: %v", blob.ID, err)
-			errs = append(errs, errors.Errorf("blob %v: %v", i, err))
+			errs = append(errs, BlobError{ID: blob.ID, Err: err})
 			continue
 		}
 
@@ -752,20 +1004,24 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID) error {
 		plaintext, err := r.Key().Open(ciphertext[:0], nonce, ciphertext, nil)
 		if err != nil {
 			debug.Log("  error decrypting blob %v: %v", blob.ID, err)
-			errs = append(errs, errors.Errorf("blob %v: %v", i, err))
+			errs = append(errs, BlobError{ID: blob.ID, Err: err})
 			continue
 		}
 
 		hash := restic.Hash(plaintext)
 		if !hash.Equal(blob.ID) {
 			debug.Log("  Blob ID does not match, want %v, got %v", blob.ID, hash)
-			errs = append(errs, errors.Errorf("Blob ID does not match, want %v, got %v", blob.ID.Str(), hash.Str()))
+			errs = append(errs, BlobError{
+				ID:           blob.ID,
+				HashMismatch: true,
+				Err:          errors.Errorf("Blob ID does not match, want %v, got %v", blob.ID.Str(), hash.Str()),
+			})
 			continue
 		}
 	}
 
 	if len(errs) > 0 {
-		return errors.Errorf("pack %v contains %v errors: %v", id.Str(), len(errs), errs)
+		return errs
 	}
 
 	return nil
@@ -776,7 +1032,8 @@ func (c *Checker) ReadData(ctx context.Context, p *restic.Progress, errChan chan
 	c.ReadPacks(ctx, c.packs, p, errChan)
 }
 
-// ReadPacks loads data from specified packs and checks the integrity.
+// ReadPacks loads data from specified packs and checks the integrity. Errors
+// for individual packs are sent as PackError.
 func (c *Checker) ReadPacks(ctx context.Context, packs restic.IDSet, p *restic.Progress, errChan chan<- error) {
 	defer close(errChan)
 
@@ -811,7 +1068,7 @@ func (c *Checker) ReadPacks(ctx context.Context, packs restic.IDSet, p *restic.P
 				select {
 				case <-ctx.Done():
 					return nil
-				case errChan <- err:
+				case errChan <- PackError{ID: id, Err: err}:
 				}
 			}
 		})
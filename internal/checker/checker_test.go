@@ -618,3 +618,95 @@ func BenchmarkCheckerSnapshotScaling(b *testing.B) {
 		})
 	}
 }
+
+func checkMetadata(chkr *checker.Checker) []error {
+	return collectErrors(context.TODO(), chkr.Metadata)
+}
+
+func TestCheckerMetadata(t *testing.T) {
+	ctx := context.TODO()
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	buf := []byte("hardlinked file content")
+	blobA, _, err := repo.SaveBlob(ctx, restic.DataBlob, buf, restic.ID{}, false)
+	test.OK(t, err)
+	blobB, _, err := repo.SaveBlob(ctx, restic.DataBlob, []byte("other content"), restic.ID{}, false)
+	test.OK(t, err)
+
+	size := uint64(len(buf))
+	subTree := &restic.Tree{
+		Nodes: []*restic.Node{
+			{Name: "x", Type: "symlink", LinkTarget: "a"},
+			{Name: "x", Type: "symlink", LinkTarget: "b"},
+			{Name: "link", Type: "file", Size: 13, Links: 2, Inode: 23, DeviceID: 1, Content: restic.IDs{blobB}},
+			{Name: "z", Type: "fifo"},
+		},
+	}
+	subID, err := repo.SaveTree(ctx, subTree)
+	test.OK(t, err)
+
+	rootTree := &restic.Tree{
+		Nodes: []*restic.Node{
+			{Name: "good", Type: "file", Size: size, Links: 2, Inode: 23, DeviceID: 1, Content: restic.IDs{blobA}},
+			{Name: "size", Type: "file", Size: size + 1, Content: restic.IDs{blobA}},
+			{Name: "sub", Type: "dir", Subtree: &subID},
+		},
+	}
+	rootID, err := repo.SaveTree(ctx, rootTree)
+	test.OK(t, err)
+
+	// the subtree is shared with another snapshot, in which the hardlinked
+	// file has no other link
+	otherTree := &restic.Tree{
+		Nodes: []*restic.Node{
+			{Name: "other", Type: "dir", Subtree: &subID},
+		},
+	}
+	otherID, err := repo.SaveTree(ctx, otherTree)
+	test.OK(t, err)
+
+	test.OK(t, repo.Flush(ctx))
+	test.OK(t, repo.SaveIndex(ctx))
+
+	saveSnapshot := func(tree restic.ID) restic.ID {
+		sn, err := restic.NewSnapshot([]string{"/"}, nil, "foo", time.Now())
+		test.OK(t, err)
+		sn.Tree = &tree
+		id, err := repo.SaveJSONUnpacked(ctx, restic.SnapshotFile, sn)
+		test.OK(t, err)
+		return id
+	}
+	snID := saveSnapshot(rootID)
+	otherSnID := saveSnapshot(otherID)
+
+	chkr := checker.New(repo)
+	_, errs := chkr.LoadIndex(ctx)
+	if len(errs) > 0 {
+		t.Fatalf("expected no errors, got %v: %v", len(errs), errs)
+	}
+
+	got := make(map[restic.ID]map[string]int)
+	for _, err := range checkMetadata(chkr) {
+		e, ok := err.(checker.MetadataError)
+		if !ok {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if got[e.Snapshot] == nil {
+			got[e.Snapshot] = make(map[string]int)
+		}
+		got[e.Snapshot][e.Path]++
+	}
+
+	want := map[restic.ID]map[string]int{
+		snID: {
+			"/size":     1, // size does not match the content
+			"/sub":      2, // duplicate and unsorted names
+			"/sub/link": 1, // differs from hardlinked file /good
+		},
+		otherSnID: {
+			"/other": 2,
+		},
+	}
+	test.Equals(t, want, got)
+}
@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
@@ -365,6 +366,71 @@ func (k *Key) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error
 	return ret, nil
 }
 
+// OpenUnauthenticated decrypts ciphertext like Open, but without verifying the
+// MAC, so the plaintext may have been modified. It must only be used to
+// recover data from damaged files, and the plaintext must be verified by other
+// means before it is used.
+func (k *Key) OpenUnauthenticated(dst, nonce, ciphertext []byte) ([]byte, error) {
+	if !k.Valid() {
+		return nil, errors.New("invalid key")
+	}
+
+	if len(nonce) != ivSize {
+		panic("incorrect nonce length")
+	}
+
+	if len(ciphertext) < macSize {
+		return nil, errors.Errorf("trying to decrypt invalid data: ciphertext too small")
+	}
+
+	ct := ciphertext[:len(ciphertext)-macSize]
+	ret, out := sliceForAppend(dst, len(ct))
+
+	c, err := aes.NewCipher(k.EncryptionKey[:])
+	if err != nil {
+		panic(fmt.Sprintf("unable to create cipher: %v", err))
+	}
+	e := cipher.NewCTR(c, nonce)
+	e.XORKeyStream(out, ct)
+
+	return ret, nil
+}
+
+// FindCiphertext returns the length of the shortest prefix of buf which is
+// authenticated with k, consisting of the nonce, the ciphertext and the MAC.
+// This allows finding the end of encrypted data whose length is unknown, e.g.
+// in a pack with a damaged header. The prefix can be decrypted with Open.
+func (k *Key) FindCiphertext(buf []byte) (int, bool) {
+	if !k.Valid() || len(buf) < Extension {
+		return 0, false
+	}
+
+	nonce, ciphertext := buf[:ivSize], buf[ivSize:]
+	if !validNonce(nonce) {
+		return 0, false
+	}
+
+	key := poly1305PrepareKey(nonce, &k.MACKey)
+	mac := poly1305.New(&key)
+
+	// compute the MAC for each possible length of the ciphertext, the state
+	// is copied so that the MAC can be updated afterwards
+	var tag [macSize]byte
+	for l := 0; l+macSize <= len(ciphertext); l++ {
+		if l > 0 {
+			_, _ = mac.Write(ciphertext[l-1 : l])
+		}
+
+		m := *mac
+		m.Sum(tag[:0])
+		if bytes.Equal(tag[:], ciphertext[l:l+macSize]) {
+			return ivSize + l + macSize, true
+		}
+	}
+
+	return 0, false
+}
+
 // Valid tests if the key is valid.
 func (k *Key) Valid() bool {
 	return k.EncryptionKey.Valid() && k.MACKey.Valid()
@@ -300,3 +300,51 @@ func BenchmarkDecrypt(b *testing.B) {
 		rtest.OK(b, err)
 	}
 }
+
+func TestFindCiphertext(t *testing.T) {
+	k := crypto.NewRandomKey()
+
+	for _, size := range []int{0, 5, 23, 2<<18 + 23} {
+		var buf []byte
+		for i := 0; i < 2; i++ {
+			nonce := crypto.NewRandomNonce()
+			buf = append(buf, nonce...)
+			buf = k.Seal(buf, nonce, rtest.Random(size+i, size), nil)
+		}
+
+		n, ok := k.FindCiphertext(buf)
+		rtest.Assert(t, ok, "ciphertext of size %d not found", size)
+		rtest.Equals(t, size+crypto.Extension, n)
+
+		// the second ciphertext is found as well
+		n, ok = k.FindCiphertext(buf[n:])
+		rtest.Assert(t, ok, "second ciphertext of size %d not found", size)
+		rtest.Equals(t, size+crypto.Extension, n)
+
+		// a different key does not authenticate the data
+		_, ok = crypto.NewRandomKey().FindCiphertext(buf)
+		rtest.Assert(t, !ok, "ciphertext of size %d found with a different key", size)
+
+		// neither does damaged data
+		buf[crypto.Extension/2] ^= 0xff
+		_, ok = k.FindCiphertext(buf)
+		rtest.Assert(t, !ok, "damaged ciphertext of size %d found", size)
+	}
+}
+
+func TestOpenUnauthenticated(t *testing.T) {
+	k := crypto.NewRandomKey()
+	plaintext := rtest.Random(23, 1000)
+
+	nonce := crypto.NewRandomNonce()
+	ciphertext := k.Seal(nil, nonce, plaintext, nil)
+
+	// damage the MAC, the data can still be decrypted
+	ciphertext[len(ciphertext)-1] ^= 0xff
+	_, err := k.Open(nil, nonce, ciphertext, nil)
+	rtest.Assert(t, err != nil, "damaged MAC was not detected")
+
+	buf, err := k.OpenUnauthenticated(nil, nonce, ciphertext)
+	rtest.OK(t, err)
+	rtest.Equals(t, plaintext, buf)
+}
@@ -0,0 +1,269 @@
+package fs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/quinn/restic/internal/errors"
+)
+
+// Command is a command whose output is provided as the file Name by
+// CommandReader.
+type Command struct {
+	Name string
+	Args []string
+}
+
+// CommandError is returned when a command run by CommandReader cannot be
+// started or exits with a non-zero status.
+type CommandError struct {
+	Name string
+	Err  error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("command for %v failed: %v", e.Name, e.Err)
+}
+
+// CommandReader is a file system which provides a directory with one file for
+// each command. When a file is opened, its command is started and the file
+// yields the command's stdout. Reading the end of the file returns a
+// *CommandError instead of io.EOF if the command exits with a non-zero status.
+// Each file can be opened once, all subsequent open calls return syscall.EIO.
+type CommandReader struct {
+	Commands []Command
+
+	// for FileInfo
+	Mode    os.FileMode
+	ModTime time.Time
+
+	// Stderr receives the stderr of all commands.
+	Stderr io.Writer
+
+	m      sync.Mutex
+	opened map[string]struct{}
+}
+
+// statically ensure that CommandReader implements FS.
+var _ FS = &CommandReader{}
+
+// VolumeName returns leading volume name, for the CommandReader file system
+// it's always the empty string.
+func (fs *CommandReader) VolumeName(path string) string {
+	return ""
+}
+
+func (fs *CommandReader) command(name string) (Command, bool) {
+	for _, cmd := range fs.Commands {
+		if cmd.Name == name {
+			return cmd, true
+		}
+	}
+	return Command{}, false
+}
+
+func (fs *CommandReader) fi(name string) os.FileInfo {
+	return fakeFileInfo{
+		name:    fs.Base(name),
+		mode:    fs.Mode,
+		modtime: fs.ModTime,
+	}
+}
+
+// Open opens a file for reading.
+func (fs *CommandReader) Open(name string) (File, error) {
+	switch name {
+	case "/", ".":
+		entries := make([]os.FileInfo, 0, len(fs.Commands))
+		for _, cmd := range fs.Commands {
+			entries = append(entries, fs.fi(cmd.Name))
+		}
+		return fakeDir{
+			entries:  entries,
+			fakeFile: fakeFile{name: name, FileInfo: fs.dirInfo(name)},
+		}, nil
+	}
+
+	cmd, ok := fs.command(name)
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+
+	fs.m.Lock()
+	if fs.opened == nil {
+		fs.opened = make(map[string]struct{})
+	}
+	_, opened := fs.opened[name]
+	fs.opened[name] = struct{}{}
+	fs.m.Unlock()
+
+	if opened {
+		return nil, syscall.EIO
+	}
+
+	rd, err := fs.start(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	// use the full path as the name of the file, so that no metadata is read
+	// from a file with the same name in the current directory
+	f := newReaderFile(rd, fs.fi(name), true)
+	f.fakeFile.name = name
+	return f, nil
+}
+
+// start runs cmd and returns a reader for its output.
+func (fs *CommandReader) start(cmd Command) (io.ReadCloser, error) {
+	if len(cmd.Args) == 0 {
+		return nil, &CommandError{Name: cmd.Name, Err: errors.New("command is empty")}
+	}
+
+	c := exec.Command(cmd.Args[0], cmd.Args[1:]...)
+	c.Stderr = fs.Stderr
+
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return nil, &CommandError{Name: cmd.Name, Err: err}
+	}
+
+	if err = c.Start(); err != nil {
+		return nil, &CommandError{Name: cmd.Name, Err: err}
+	}
+
+	return &commandOutput{name: cmd.Name, cmd: c, stdout: stdout}, nil
+}
+
+// OpenFile is the generalized open call; most users will use Open
+// or Create instead.  It opens the named file with specified flag
+// (O_RDONLY etc.) and perm, (0666 etc.) if applicable.  If successful,
+// methods on the returned File can be used for I/O.
+// If there is an error, it will be of type *PathError.
+func (fs *CommandReader) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag & ^(O_RDONLY|O_NOFOLLOW) != 0 {
+		return nil, errors.Errorf("invalid combination of flags 0x%x", flag)
+	}
+
+	return fs.Open(name)
+}
+
+// Stat returns a FileInfo describing the named file. If there is an error, it
+// will be of type *PathError.
+func (fs *CommandReader) Stat(name string) (os.FileInfo, error) {
+	return fs.Lstat(name)
+}
+
+func (fs *CommandReader) dirInfo(name string) os.FileInfo {
+	return fakeFileInfo{
+		name:    fs.Base(name),
+		mode:    os.ModeDir | 0755,
+		modtime: fs.ModTime,
+	}
+}
+
+// Lstat returns the FileInfo structure describing the named file.
+// If the file is a symbolic link, the returned FileInfo
+// describes the symbolic link.  Lstat makes no attempt to follow the link.
+// If there is an error, it will be of type *PathError.
+func (fs *CommandReader) Lstat(name string) (os.FileInfo, error) {
+	switch name {
+	case "/", ".":
+		return fs.dirInfo(name), nil
+	}
+
+	if _, ok := fs.command(name); ok {
+		return fs.fi(name), nil
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// Join joins any number of path elements into a single path, adding a
+// Separator if necessary. Join calls Clean on the result; in particular, all
+// empty strings are ignored.
+func (fs *CommandReader) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// Separator returns the OS and FS dependent separator for dirs/subdirs/files.
+func (fs *CommandReader) Separator() string {
+	return "/"
+}
+
+// IsAbs reports whether the path is absolute. For the CommandReader, this is
+// always the case.
+func (fs *CommandReader) IsAbs(p string) bool {
+	return true
+}
+
+// Abs returns an absolute representation of path. For the CommandReader, all
+// paths are absolute.
+func (fs *CommandReader) Abs(p string) (string, error) {
+	return path.Clean(p), nil
+}
+
+// Clean returns the cleaned path. For details, see filepath.Clean.
+func (fs *CommandReader) Clean(p string) string {
+	return path.Clean(p)
+}
+
+// Base returns the last element of p.
+func (fs *CommandReader) Base(p string) string {
+	return path.Base(p)
+}
+
+// Dir returns p without the last element.
+func (fs *CommandReader) Dir(p string) string {
+	return path.Dir(p)
+}
+
+// commandOutput reads the stdout of a running command.
+type commandOutput struct {
+	name   string
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	done   bool
+}
+
+// wait waits for the command to exit and returns a *CommandError if it
+// failed.
+func (c *commandOutput) wait() error {
+	if c.done {
+		return nil
+	}
+	c.done = true
+
+	if err := c.cmd.Wait(); err != nil {
+		return &CommandError{Name: c.name, Err: err}
+	}
+	return nil
+}
+
+func (c *commandOutput) Read(p []byte) (int, error) {
+	// the pipe is closed once the command has exited
+	if c.done {
+		return 0, io.EOF
+	}
+
+	n, err := c.stdout.Read(p)
+	if err == io.EOF {
+		if werr := c.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// Close stops the command if the output was not read completely.
+func (c *commandOutput) Close() error {
+	if !c.done {
+		_ = c.cmd.Process.Kill()
+	}
+	return c.wait()
+}
@@ -0,0 +1,76 @@
+// +build !windows
+
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/quinn/restic/internal/errors"
+)
+
+func TestCommandReader(t *testing.T) {
+	fs := &CommandReader{
+		Commands: []Command{
+			{Name: "/first", Args: []string{"echo", "first output"}},
+			{Name: "/second", Args: []string{"sh", "-c", "printf second"}},
+			{Name: "/empty", Args: []string{"true"}},
+		},
+		Mode:    0644,
+		ModTime: time.Now(),
+	}
+
+	verifyDirectoryContents(t, fs, "/", []string{"first", "second", "empty"})
+	verifyFileContentOpen(t, fs, "/first", []byte("first output\n"))
+	verifyFileContentOpenFile(t, fs, "/second", []byte("second"))
+	verifyFileContentOpen(t, fs, "/empty", []byte{})
+
+	// each command is run only once
+	_, err := fs.Open("/first")
+	if err != syscall.EIO {
+		t.Fatalf("expected EIO when opening a file twice, got %v", err)
+	}
+
+	fi, err := fs.Lstat("/second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name() != "second" || fi.Mode() != 0644 {
+		t.Fatalf("wrong file info for second file: %v %v", fi.Name(), fi.Mode())
+	}
+
+	if _, err = fs.Lstat("/missing"); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error for missing file, got %v", err)
+	}
+}
+
+func TestCommandReaderFailure(t *testing.T) {
+	fs := &CommandReader{
+		Commands: []Command{
+			{Name: "/failed", Args: []string{"sh", "-c", "echo partial; exit 2"}},
+			{Name: "/missing", Args: []string{"/nonexistent/command"}},
+		},
+	}
+
+	f, err := fs.Open("/failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadAll(f)
+	if _, ok := errors.Cause(err).(*CommandError); !ok {
+		t.Fatalf("expected CommandError for failed command, got %v", err)
+	}
+	if string(buf) != "partial\n" {
+		t.Fatalf("wrong output %q", buf)
+	}
+	_ = f.Close()
+
+	_, err = fs.Open("/missing")
+	if _, ok := errors.Cause(err).(*CommandError); !ok {
+		t.Fatalf("expected CommandError for missing command, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PathMapping maps the directory Original to the directory Actual.
+type PathMapping struct {
+	Original string
+	Actual   string
+}
+
+// Remap is a wrapper around another file system which reads the files below
+// each mapped Original directory from the Actual directory instead, e.g. from
+// the mount point of a file system snapshot. All methods expect original
+// paths, the names of opened files are original paths as well.
+type Remap struct {
+	FS
+	Mappings []PathMapping
+}
+
+// remapFile is a file opened by Remap, Name returns the original path.
+type remapFile struct {
+	File
+	name string
+}
+
+func (f remapFile) Name() string {
+	return f.name
+}
+
+// replacePrefix replaces the directory prefix from of p with to. If p is not
+// below from, false is returned.
+func replacePrefix(p, from, to string) (string, bool) {
+	if p == from {
+		return to, true
+	}
+
+	prefix := from
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	if !strings.HasPrefix(p, prefix) {
+		return p, false
+	}
+
+	return filepath.Join(to, p[len(prefix):]), true
+}
+
+// Actual returns the path p is read from. The longest matching Original
+// directory is used.
+func (fs Remap) Actual(p string) string {
+	var match PathMapping
+	for _, m := range fs.Mappings {
+		if _, ok := replacePrefix(p, m.Original, m.Actual); ok && len(m.Original) > len(match.Original) {
+			match = m
+		}
+	}
+
+	if match.Original == "" {
+		return p
+	}
+
+	p, _ = replacePrefix(p, match.Original, match.Actual)
+	return p
+}
+
+// Original returns the path the file read from p is stored as. The longest
+// matching Actual directory is used.
+func (fs Remap) Original(p string) string {
+	var match PathMapping
+	for _, m := range fs.Mappings {
+		if _, ok := replacePrefix(p, m.Actual, m.Original); ok && len(m.Actual) > len(match.Actual) {
+			match = m
+		}
+	}
+
+	if match.Actual == "" {
+		return p
+	}
+
+	p, _ = replacePrefix(p, match.Actual, match.Original)
+	return p
+}
+
+// Open opens the file from its actual location.
+func (fs Remap) Open(name string) (File, error) {
+	f, err := fs.FS.Open(fs.Actual(name))
+	if err != nil {
+		return nil, err
+	}
+	return remapFile{File: f, name: name}, nil
+}
+
+// OpenFile opens the file from its actual location.
+func (fs Remap) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := fs.FS.OpenFile(fs.Actual(name), flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return remapFile{File: f, name: name}, nil
+}
+
+// Stat returns the FileInfo of the file at its actual location.
+func (fs Remap) Stat(name string) (os.FileInfo, error) {
+	return fs.FS.Stat(fs.Actual(name))
+}
+
+// Lstat returns the FileInfo of the file at its actual location.
+func (fs Remap) Lstat(name string) (os.FileInfo, error) {
+	return fs.FS.Lstat(fs.Actual(name))
+}
@@ -0,0 +1,90 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemapPaths(t *testing.T) {
+	sep := string(filepath.Separator)
+	p := func(s string) string {
+		return filepath.FromSlash(s)
+	}
+
+	fs := Remap{Mappings: []PathMapping{
+		{Original: p("/home"), Actual: p("/mnt/snap/home")},
+		{Original: p("/home/user/data"), Actual: p("/mnt/data")},
+	}}
+
+	var tests = []struct {
+		original, actual string
+	}{
+		{p("/home"), p("/mnt/snap/home")},
+		{p("/home/user/file"), p("/mnt/snap/home/user/file")},
+		{p("/home/user/data"), p("/mnt/data")},
+		{p("/home/user/data/file"), p("/mnt/data/file")},
+		{p("/homes/file"), p("/homes/file")},
+		{p("/etc/passwd"), p("/etc/passwd")},
+		{sep, sep},
+	}
+
+	for _, test := range tests {
+		if got := fs.Actual(test.original); got != test.actual {
+			t.Errorf("Actual(%v): want %v, got %v", test.original, test.actual, got)
+		}
+		if got := fs.Original(test.actual); got != test.original {
+			t.Errorf("Original(%v): want %v, got %v", test.actual, test.original, got)
+		}
+	}
+}
+
+func TestRemap(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "restic-test-remap-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	actual := filepath.Join(tempdir, "snapshot")
+	if err = os.MkdirAll(filepath.Join(actual, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(actual, "dir", "file"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	original := filepath.Join(tempdir, "original")
+	fs := Remap{
+		FS:       Local{},
+		Mappings: []PathMapping{{Original: original, Actual: actual}},
+	}
+
+	verifyDirectoryContents(t, fs, original, []string{"dir"})
+	verifyFileContentOpen(t, fs, filepath.Join(original, "dir", "file"), []byte("content"))
+	verifyFileContentOpenFile(t, fs, filepath.Join(original, "dir", "file"), []byte("content"))
+
+	fi, err := fs.Lstat(filepath.Join(original, "dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.IsDir() {
+		t.Fatalf("%v is not a directory", fi.Name())
+	}
+
+	f, err := fs.Open(filepath.Join(original, "dir", "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name() != filepath.Join(original, "dir", "file") {
+		t.Fatalf("wrong name for opened file: %v", f.Name())
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = fs.Stat(filepath.Join(original, "missing")); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+}
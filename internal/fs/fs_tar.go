@@ -0,0 +1,299 @@
+package fs
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/quinn/restic/internal/errors"
+)
+
+// TarEntry is returned by Sys() of the os.FileInfo for entries read by a
+// TarReader. Header is the header of the entry in the archive. Hard links in
+// the archive share the same Inode. Links is the number of entries sharing the
+// inode, it is one for entries returned by TarReader.Next.
+type TarEntry struct {
+	Header *tar.Header
+	Inode  uint64
+	Links  uint64
+}
+
+// extendedStat returns an ExtendedFileInfo for the entry.
+func (e *TarEntry) extendedStat(fi os.FileInfo) ExtendedFileInfo {
+	extFI := ExtendedFileInfo{
+		FileInfo: fi,
+		Inode:    e.Inode,
+		Links:    e.Links,
+		UID:      uint32(e.Header.Uid),
+		GID:      uint32(e.Header.Gid),
+		Size:     fi.Size(),
+
+		AccessTime: e.Header.AccessTime,
+		ModTime:    e.Header.ModTime,
+		ChangeTime: e.Header.ChangeTime,
+	}
+
+	// archives in the ustar format do not contain atime and ctime
+	if extFI.AccessTime.IsZero() {
+		extFI.AccessTime = extFI.ModTime
+	}
+	if extFI.ChangeTime.IsZero() {
+		extFI.ChangeTime = extFI.ModTime
+	}
+
+	return extFI
+}
+
+// TarReader reads the entries of a tar archive in the order in which they are
+// stored, so the archive can be read from a stream. Next advances to the next
+// entry, and the file system only contains the current entry. The content of
+// a regular file can be read once until Next is called again. PAX and GNU
+// extensions (long names, extended attributes, sparse files) are supported.
+type TarReader struct {
+	tr      *tar.Reader
+	modTime time.Time
+
+	// name and fi describe the current entry
+	name string
+	fi   os.FileInfo
+
+	inode uint64
+	links map[uint64]uint64
+
+	// targets holds the inode and size of the entries read so far, which
+	// may be the target of a hard link
+	targets map[string]tarTarget
+}
+
+// tarTarget is an entry which may be referenced by a hard link.
+type tarTarget struct {
+	inode uint64
+	size  int64
+}
+
+// statically ensure that TarReader implements FS.
+var _ FS = &TarReader{}
+
+// NewTarReader returns a TarReader for the tar archive read from rd.
+// Directories which are not contained in the archive are created with the
+// modification time modTime.
+func NewTarReader(rd io.Reader, modTime time.Time) *TarReader {
+	return &TarReader{
+		tr:      tar.NewReader(rd),
+		modTime: modTime,
+		links:   make(map[uint64]uint64),
+		targets: make(map[string]tarTarget),
+	}
+}
+
+// Next advances to the next entry in the archive and returns its absolute
+// path and file info. Entries which do not describe files (e.g. global
+// headers) are skipped. At the end of the archive, io.EOF is returned.
+//
+// Hard links share the inode of their target, the size is that of the
+// target. The number of links of an inode is only known once the whole
+// archive has been read, use Links to get it.
+func (fs *TarReader) Next() (string, os.FileInfo, error) {
+	fs.name, fs.fi = "", nil
+
+	for {
+		hdr, err := fs.tr.Next()
+		if err == io.EOF {
+			return "", nil, err
+		}
+		if err != nil {
+			return "", nil, errors.Wrap(err, "tar.Next")
+		}
+
+		entry := &TarEntry{Header: hdr, Links: 1}
+		name := tarPath(hdr.Name)
+
+		var size int64
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse, tar.TypeDir, tar.TypeSymlink,
+			tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			fs.inode++
+			entry.Inode = fs.inode
+			if hasContent(hdr) {
+				size = hdr.Size
+			}
+			fs.targets[name] = tarTarget{inode: entry.Inode, size: size}
+		case tar.TypeLink:
+			target, ok := fs.targets[tarPath(hdr.Linkname)]
+			if ok {
+				entry.Inode = target.inode
+				size = target.size
+			} else {
+				fs.inode++
+				entry.Inode = fs.inode
+			}
+		default:
+			// global headers, volume labels and other entries which do not
+			// describe files
+			continue
+		}
+		fs.links[entry.Inode]++
+
+		fs.name = name
+		fs.fi = fakeFileInfo{
+			name:    path.Base(name),
+			size:    size,
+			mode:    hdr.FileInfo().Mode(),
+			modtime: hdr.ModTime,
+			sys:     entry,
+		}
+		return fs.name, fs.fi, nil
+	}
+}
+
+// Links returns the number of entries read so far which share inode.
+func (fs *TarReader) Links(inode uint64) uint64 {
+	return fs.links[inode]
+}
+
+// DirInfo returns the file info for a directory which is not contained in the
+// archive.
+func (fs *TarReader) DirInfo(name string) os.FileInfo {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeDir,
+		Mode:     0755,
+		ModTime:  fs.modTime,
+		Uid:      os.Getuid(),
+		Gid:      os.Getgid(),
+	}
+	return fakeFileInfo{
+		name:    path.Base(tarPath(name)),
+		mode:    hdr.FileInfo().Mode(),
+		modtime: hdr.ModTime,
+		sys:     &TarEntry{Header: hdr, Links: 1},
+	}
+}
+
+// hasContent returns true if the content of the entry described by hdr is
+// stored in the archive.
+func hasContent(hdr *tar.Header) bool {
+	switch hdr.Typeflag {
+	case tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo:
+		return false
+	}
+	return true
+}
+
+// tarPath returns the absolute path for a name in the archive.
+func tarPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// current returns the file info of the current entry if name refers to it.
+func (fs *TarReader) current(name string) (os.FileInfo, bool) {
+	if fs.fi == nil || tarPath(name) != fs.name {
+		return nil, false
+	}
+	return fs.fi, true
+}
+
+// VolumeName returns leading volume name, for the TarReader file system it's
+// always the empty string.
+func (fs *TarReader) VolumeName(path string) string {
+	return ""
+}
+
+// Open opens the current entry for reading. The content of the entry is read
+// from the archive directly, so it can only be read once.
+func (fs *TarReader) Open(name string) (File, error) {
+	fi, ok := fs.current(name)
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOENT}
+	}
+
+	if !IsRegularFile(fi) {
+		return fakeFile{name: name, FileInfo: fi}, nil
+	}
+
+	if hdr := fi.Sys().(*TarEntry).Header; hdr.Typeflag == tar.TypeLink {
+		return nil, &os.PathError{
+			Op:   "open",
+			Path: name,
+			Err:  errors.Errorf("content of hard link to %v is stored with the target", hdr.Linkname),
+		}
+	}
+
+	f := newReaderFile(ioutil.NopCloser(fs.tr), fi, true)
+	f.fakeFile.name = name
+	return f, nil
+}
+
+// OpenFile is the generalized open call; most users will use Open
+// or Create instead.  It opens the named file with specified flag
+// (O_RDONLY etc.) and perm, (0666 etc.) if applicable.  If successful,
+// methods on the returned File can be used for I/O.
+// If there is an error, it will be of type *PathError.
+func (fs *TarReader) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag & ^(O_RDONLY|O_NOFOLLOW) != 0 {
+		return nil, errors.Errorf("invalid combination of flags 0x%x", flag)
+	}
+
+	return fs.Open(name)
+}
+
+// Stat returns a FileInfo describing the named file. If there is an error, it
+// will be of type *PathError. Symlinks are not followed.
+func (fs *TarReader) Stat(name string) (os.FileInfo, error) {
+	return fs.Lstat(name)
+}
+
+// Lstat returns the FileInfo structure describing the named file.
+// If the file is a symbolic link, the returned FileInfo
+// describes the symbolic link.  Lstat makes no attempt to follow the link.
+// If there is an error, it will be of type *PathError.
+func (fs *TarReader) Lstat(name string) (os.FileInfo, error) {
+	fi, ok := fs.current(name)
+	if !ok {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+	}
+
+	return fi, nil
+}
+
+// Join joins any number of path elements into a single path, adding a
+// Separator if necessary. Join calls Clean on the result; in particular, all
+// empty strings are ignored.
+func (fs *TarReader) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// Separator returns the OS and FS dependent separator for dirs/subdirs/files.
+func (fs *TarReader) Separator() string {
+	return "/"
+}
+
+// IsAbs reports whether the path is absolute. For the TarReader file system,
+// this is always the case.
+func (fs *TarReader) IsAbs(p string) bool {
+	return true
+}
+
+// Abs returns an absolute representation of path. For the TarReader file
+// system, all paths are absolute.
+func (fs *TarReader) Abs(p string) (string, error) {
+	return tarPath(p), nil
+}
+
+// Clean returns the cleaned path. For details, see filepath.Clean.
+func (fs *TarReader) Clean(p string) string {
+	return path.Clean(p)
+}
+
+// Base returns the last element of p.
+func (fs *TarReader) Base(p string) string {
+	return path.Base(p)
+}
+
+// Dir returns p without the last element.
+func (fs *TarReader) Dir(p string) string {
+	return path.Dir(p)
+}
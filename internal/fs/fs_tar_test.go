@@ -0,0 +1,191 @@
+package fs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type tarTestEntry struct {
+	hdr     tar.Header
+	content string
+}
+
+func writeTestTar(t testing.TB, entries []tarTestEntry) []byte {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for _, entry := range entries {
+		hdr := entry.hdr
+		hdr.Size = int64(len(entry.content))
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// nextTarEntry advances fs to the next entry, which must be named name.
+func nextTarEntry(t testing.TB, fs *TarReader, name string) os.FileInfo {
+	next, fi, err := fs.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != name {
+		t.Fatalf("wrong entry, want %v, got %v", name, next)
+	}
+	return fi
+}
+
+func TestTarReader(t *testing.T) {
+	mtime := time.Unix(1500000000, 0)
+	longName := "dir/" + strings.Repeat("long", 40)
+
+	fs := NewTarReader(bytes.NewReader(writeTestTar(t, []tarTestEntry{
+		{hdr: tar.Header{Name: "./dir/", Typeflag: tar.TypeDir, Mode: 0700, ModTime: mtime}},
+		{hdr: tar.Header{Name: "./dir/file", Typeflag: tar.TypeReg, Mode: 0640, ModTime: mtime,
+			Uid: 1000, Gid: 100, Uname: "user", Gname: "users",
+			PAXRecords: map[string]string{"SCHILY.xattr.user.foo": "bar"}},
+			content: "file content"},
+		{hdr: tar.Header{Name: longName, Typeflag: tar.TypeReg, Mode: 0644, ModTime: mtime}, content: "long"},
+		{hdr: tar.Header{Name: "other/sub/link", Typeflag: tar.TypeSymlink, Linkname: "../../dir/file", ModTime: mtime}},
+		{hdr: tar.Header{Name: "other/hardlink", Typeflag: tar.TypeLink, Linkname: "./dir/file", Mode: 0640, ModTime: mtime}},
+		{hdr: tar.Header{Name: "other/missing", Typeflag: tar.TypeLink, Linkname: "nonexistent", ModTime: mtime}},
+		{hdr: tar.Header{Name: "global", Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "foo"}}},
+		{hdr: tar.Header{Name: "empty", Typeflag: tar.TypeReg, Mode: 0600, ModTime: mtime}},
+	})), time.Now())
+
+	fi := nextTarEntry(t, fs, "/dir")
+	checkFileInfo(t, fi, "dir", mtime, os.ModeDir|0700, true)
+
+	fi = nextTarEntry(t, fs, "/dir/file")
+	checkFileInfo(t, fi, "file", mtime, 0640, false)
+	entry := fi.Sys().(*TarEntry)
+	if entry.Header.Uname != "user" || entry.Header.PAXRecords["SCHILY.xattr.user.foo"] != "bar" {
+		t.Fatalf("wrong header for file: %+v", entry.Header)
+	}
+	verifyFileContentOpen(t, fs, "/dir/file", []byte("file content"))
+
+	// only the current entry is contained in the file system
+	if _, err := fs.Lstat("/dir"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error for /dir, got %v", err)
+	}
+
+	// the content of an entry does not need to be read before the next one
+	nextTarEntry(t, fs, "/"+longName)
+	fi = nextTarEntry(t, fs, "/other/sub/link")
+	if fi.Mode()&os.ModeSymlink == 0 || fi.Sys().(*TarEntry).Header.Linkname != "../../dir/file" {
+		t.Fatalf("wrong file info for symlink: %v", fi.Mode())
+	}
+
+	hardlink := nextTarEntry(t, fs, "/other/hardlink")
+	if hardlink.Size() != int64(len("file content")) {
+		t.Fatalf("wrong size for hard link, want %v, got %v", len("file content"), hardlink.Size())
+	}
+	linkEntry := hardlink.Sys().(*TarEntry)
+	if linkEntry.Inode != entry.Inode || fs.Links(entry.Inode) != 2 {
+		t.Fatalf("hard link does not share the inode: %+v %+v", entry, linkEntry)
+	}
+	if _, err := fs.Open("/other/hardlink"); err == nil {
+		t.Fatal("opening a hard link did not return an error")
+	}
+
+	missing := nextTarEntry(t, fs, "/other/missing")
+	if inode := missing.Sys().(*TarEntry).Inode; fs.Links(inode) != 1 {
+		t.Fatalf("hard link without target shares inode %v", inode)
+	}
+
+	// the global header is skipped
+	nextTarEntry(t, fs, "/empty")
+	verifyFileContentOpenFile(t, fs, "/empty", []byte{})
+
+	if _, _, err := fs.Next(); err != io.EOF {
+		t.Fatalf("expected EOF at the end of the archive, got %v", err)
+	}
+
+	for _, name := range []string{"/empty", "/global", "/nonexistent"} {
+		if _, err := fs.Lstat(name); !os.IsNotExist(err) {
+			t.Errorf("expected not exist error for %v, got %v", name, err)
+		}
+		if _, err := fs.Open(name); !os.IsNotExist(err) {
+			t.Errorf("expected not exist error for %v, got %v", name, err)
+		}
+	}
+
+	fi = fs.DirInfo("/other/sub")
+	if !fi.IsDir() || fi.Name() != "sub" {
+		t.Fatalf("wrong file info for directory not contained in the archive: %v %v", fi.Name(), fi.Mode())
+	}
+}
+
+func TestTarSparse(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not found")
+	}
+
+	tempdir, err := ioutil.TempDir("", "restic-test-tar-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	src := filepath.Join(tempdir, "src")
+	if err = os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// create a file with a hole in the middle
+	data := []byte("data after hole")
+	f, err := os.Create(filepath.Join(src, "sparse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteAt(data, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(src, "zzz"), []byte("after sparse file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := append(make([]byte, 1<<20), data...)
+
+	for _, format := range []string{"gnu", "posix"} {
+		t.Run(format, func(t *testing.T) {
+			archive := filepath.Join(tempdir, format+".tar")
+			out, err := exec.Command("tar", "--sparse", "--format="+format, "-C", src, "-cf", archive, "sparse", "zzz").CombinedOutput()
+			if err != nil {
+				t.Skipf("unable to create archive: %v: %s", err, out)
+			}
+
+			f, err := os.Open(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			fs := NewTarReader(f, time.Now())
+			fi := nextTarEntry(t, fs, "/sparse")
+			if fi.Size() != int64(len(want)) {
+				t.Fatalf("wrong size for sparse file, want %v, got %v", len(want), fi.Size())
+			}
+			verifyFileContentOpen(t, fs, "/sparse", want)
+			nextTarEntry(t, fs, "/zzz")
+			verifyFileContentOpen(t, fs, "/zzz", []byte("after sparse file"))
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// Extent is a range of a file.
+type Extent struct {
+	Offset int64
+	Length int64
+}
+
+// DataExtents returns the ranges of the file f with the given size which
+// contain data. The other ranges are holes, which read as zeros and need not
+// be read at all. If the file system or the file f does not report holes,
+// the whole file is returned as a single extent. The position of f is reset
+// to the start of the file.
+func DataExtents(f File, size int64) ([]Extent, error) {
+	whole := []Extent{{Offset: 0, Length: size}}
+	if seekData == 0 || size == 0 {
+		return whole, nil
+	}
+
+	var extents []Extent
+	var offset int64
+	for offset < size {
+		data, err := f.Seek(offset, seekData)
+		if pe, ok := err.(*os.PathError); ok && pe.Err == syscall.ENXIO {
+			// there is no data after offset
+			break
+		}
+		if err != nil {
+			// holes are not supported, read the whole file
+			if offset > 0 {
+				_, _ = f.Seek(0, io.SeekStart)
+			}
+			return whole, nil
+		}
+		if data >= size {
+			break
+		}
+
+		hole, err := f.Seek(data, seekHole)
+		if err != nil {
+			return nil, err
+		}
+		if hole > size {
+			hole = size
+		}
+
+		extents = append(extents, Extent{Offset: data, Length: hole - data})
+		offset = hole
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return extents, nil
+}
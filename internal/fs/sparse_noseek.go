@@ -0,0 +1,10 @@
+// +build !linux,!freebsd,!solaris
+
+package fs
+
+// Finding the holes in a sparse file is not supported on this platform, the
+// whole file is read.
+const (
+	seekData = 0
+	seekHole = 0
+)
@@ -0,0 +1,10 @@
+// +build linux freebsd solaris
+
+package fs
+
+// whence values for Seek to find the data and the holes in a sparse file, see
+// lseek(2).
+const (
+	seekData = 3
+	seekHole = 4
+)
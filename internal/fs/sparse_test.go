@@ -0,0 +1,86 @@
+package fs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	rtest "github.com/quinn/restic/internal/test"
+)
+
+func TestDataExtents(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	const size = 8 << 20
+	data := bytes.Repeat([]byte("data"), 1024)
+
+	// create a file with data at the start and in the middle, and holes
+	// before the middle and at the end
+	filename := filepath.Join(tempdir, "sparse")
+	f, err := os.Create(filename)
+	rtest.OK(t, err)
+	_, err = f.WriteAt(data, 0)
+	rtest.OK(t, err)
+	_, err = f.WriteAt(data, 4<<20)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Truncate(size))
+	rtest.OK(t, f.Close())
+
+	f, err = os.Open(filename)
+	rtest.OK(t, err)
+	defer f.Close()
+
+	extents, err := DataExtents(f, size)
+	rtest.OK(t, err)
+
+	// the position is reset to the start of the file
+	buf := make([]byte, len(data))
+	_, err = f.Read(buf)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+
+	if len(extents) == 1 && extents[0] == (Extent{Offset: 0, Length: size}) {
+		if runtime.GOOS == "linux" {
+			t.Log("file system does not report holes")
+		}
+		return
+	}
+
+	rtest.Equals(t, 2, len(extents))
+	rtest.Equals(t, int64(0), extents[0].Offset)
+	rtest.Assert(t, extents[0].Length >= int64(len(data)) && extents[0].Length < 4<<20,
+		"wrong first extent %v", extents[0])
+	rtest.Assert(t, extents[1].Offset <= 4<<20 && extents[1].Offset+extents[1].Length >= 4<<20+int64(len(data)),
+		"wrong second extent %v", extents[1])
+	rtest.Assert(t, extents[1].Offset+extents[1].Length < size, "no hole at the end: %v", extents[1])
+}
+
+func TestDataExtentsUnsupported(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	filename := filepath.Join(tempdir, "file")
+	rtest.OK(t, ioutil.WriteFile(filename, []byte("content"), 0644))
+
+	// files without holes and files which cannot seek are read completely
+	f, err := os.Open(filename)
+	rtest.OK(t, err)
+	defer f.Close()
+	extents, err := DataExtents(f, 7)
+	rtest.OK(t, err)
+	rtest.Equals(t, []Extent{{Offset: 0, Length: 7}}, extents)
+
+	fs := &Reader{
+		Name:       "foo",
+		ReadCloser: ioutil.NopCloser(bytes.NewReader([]byte("content"))),
+	}
+	rf, err := fs.Open("foo")
+	rtest.OK(t, err)
+	extents, err = DataExtents(rf, 7)
+	rtest.OK(t, err)
+	rtest.Equals(t, []Extent{{Offset: 0, Length: 7}}, extents)
+}
@@ -31,5 +31,9 @@ func ExtendedStat(fi os.FileInfo) ExtendedFileInfo {
 		panic("os.FileInfo is nil")
 	}
 
+	if entry, ok := fi.Sys().(*TarEntry); ok {
+		return entry.extendedStat(fi)
+	}
+
 	return extendedStat(fi)
 }
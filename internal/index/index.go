@@ -5,11 +5,13 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sort"
 	"sync"
 
 	"github.com/quinn/restic/internal/debug"
 	"github.com/quinn/restic/internal/errors"
 	"github.com/quinn/restic/internal/pack"
+	"github.com/quinn/restic/internal/repository"
 	"github.com/quinn/restic/internal/restic"
 	"golang.org/x/sync/errgroup"
 )
@@ -23,8 +25,13 @@ type Pack struct {
 
 // Index contains information about blobs and packs stored in a repo.
 type Index struct {
-	Packs    map[restic.ID]Pack
-	IndexIDs restic.IDSet
+	Packs        map[restic.ID]Pack
+	IndexIDs     restic.IDSet
+	ParityGroups []repository.ParityGroup
+
+	// DamagedPacks contains the packs New was unable to list, e.g. because
+	// their header is damaged.
+	DamagedPacks restic.IDs
 }
 
 func newIndex() *Index {
@@ -127,6 +134,7 @@ func New(ctx context.Context, repo Lister, ignorePacks restic.IDSet, p *restic.P
 			}
 
 			fmt.Fprintf(os.Stderr, "pack file cannot be listed %v: %v\n", res.PackID, res.Error)
+			idx.DamagedPacks = append(idx.DamagedPacks, res.PackID)
 			continue
 		}
 
@@ -151,39 +159,29 @@ func New(ctx context.Context, repo Lister, ignorePacks restic.IDSet, p *restic.P
 	return idx, invalidFiles, nil
 }
 
-type packJSON struct {
-	ID    restic.ID  `json:"id"`
-	Blobs []blobJSON `json:"blobs"`
-}
-
-type blobJSON struct {
-	ID     restic.ID       `json:"id"`
-	Type   restic.BlobType `json:"type"`
-	Offset uint            `json:"offset"`
-	Length uint            `json:"length"`
-}
-
-type indexJSON struct {
-	Supersedes restic.IDs `json:"supersedes,omitempty"`
-	Packs      []packJSON `json:"packs"`
-}
-
-// ListLoader allows listing files and their content, in addition to loading and unmarshaling JSON files.
+// ListLoader allows listing files and their content, in addition to loading and decrypting files.
 type ListLoader interface {
 	Lister
-	LoadJSONUnpacked(context.Context, restic.FileType, restic.ID, interface{}) error
+	LoadAndDecrypt(ctx context.Context, buf []byte, t restic.FileType, id restic.ID) ([]byte, error)
 }
 
-func loadIndexJSON(ctx context.Context, repo ListLoader, id restic.ID) (*indexJSON, error) {
+func loadIndexFile(ctx context.Context, repo ListLoader, id restic.ID) (*repository.Index, error) {
 	debug.Log("process index %v\n", id)
 
-	var idx indexJSON
-	err := repo.LoadJSONUnpacked(ctx, restic.IndexFile, id, &idx)
+	buf, err := repo.LoadAndDecrypt(ctx, nil, restic.IndexFile, id)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := repository.DecodeIndex(buf)
+	if err == repository.ErrOldIndexFormat {
+		idx, err = repository.DecodeOldIndex(buf)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return &idx, nil
+	return idx, nil
 }
 
 // Load creates an index by loading all index files from the repo.
@@ -202,37 +200,36 @@ func Load(ctx context.Context, repo ListLoader, p *restic.Progress) (*Index, err
 		p.Report(restic.Stat{Blobs: 1})
 
 		debug.Log("Load index %v", id)
-		idx, err := loadIndexJSON(ctx, repo, id)
+		idx, err := loadIndexFile(ctx, repo, id)
 		if err != nil {
 			return err
 		}
 
 		res := make(map[restic.ID]Pack)
 		supersedes[id] = restic.NewIDSet()
-		for _, sid := range idx.Supersedes {
+		for _, sid := range idx.Supersedes() {
 			debug.Log("  index %v supersedes %v", id, sid)
 			supersedes[id].Insert(sid)
 		}
 
-		for _, jpack := range idx.Packs {
-			entries := make([]restic.Blob, 0, len(jpack.Blobs))
-			for _, blob := range jpack.Blobs {
-				entry := restic.Blob{
-					ID:     blob.ID,
-					Type:   blob.Type,
-					Offset: blob.Offset,
-					Length: blob.Length,
-				}
-				entries = append(entries, entry)
-			}
+		packs := make(map[restic.ID][]restic.Blob)
+		for pb := range idx.Each(ctx) {
+			packs[pb.PackID] = append(packs[pb.PackID], pb.Blob)
+		}
 
-			if err = index.AddPack(jpack.ID, 0, entries); err != nil {
+		for packID, entries := range packs {
+			sort.Slice(entries, func(i, j int) bool {
+				return entries[i].Offset < entries[j].Offset
+			})
+
+			if err = index.AddPack(packID, 0, entries); err != nil {
 				return err
 			}
 		}
 
 		results[id] = res
 		index.IndexIDs.Insert(id)
+		index.ParityGroups = append(index.ParityGroups, idx.ParityGroups()...)
 
 		return nil
 	})
@@ -255,6 +252,29 @@ func Load(ctx context.Context, repo ListLoader, p *restic.Progress) (*Index, err
 	return index, nil
 }
 
+// LoadParityGroups returns the parity groups recorded in all index files of the
+// repo. Index files which cannot be loaded are skipped.
+func LoadParityGroups(ctx context.Context, repo ListLoader) ([]repository.ParityGroup, error) {
+	debug.Log("loading parity groups")
+
+	var groups []repository.ParityGroup
+	err := repo.List(ctx, restic.IndexFile, func(id restic.ID, size int64) error {
+		idx, err := loadIndexFile(ctx, repo, id)
+		if err != nil {
+			debug.Log("unable to load index %v: %v", id, err)
+			return nil
+		}
+
+		groups = append(groups, idx.ParityGroups()...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
 // AddPack adds a pack to the index. If this pack is already in the index, an
 // error is returned.
 func (idx *Index) AddPack(id restic.ID, size int64, entries []restic.Blob) error {
@@ -314,45 +334,37 @@ func (idx *Index) PacksForBlobs(blobs restic.BlobSet) (packs restic.IDSet) {
 
 const maxEntries = 3000
 
-// Saver saves structures as JSON.
-type Saver interface {
-	SaveJSONUnpacked(ctx context.Context, t restic.FileType, item interface{}) (restic.ID, error)
-}
-
 // Save writes the complete index to the repo.
-func (idx *Index) Save(ctx context.Context, repo Saver, supersedes restic.IDs) (restic.IDs, error) {
+func (idx *Index) Save(ctx context.Context, repo repository.IndexSaver, supersedes restic.IDs) (restic.IDs, error) {
 	debug.Log("pack files: %d\n", len(idx.Packs))
 
 	var indexIDs []restic.ID
 
 	packs := 0
-	jsonIDX := &indexJSON{
-		Supersedes: supersedes,
-		Packs:      make([]packJSON, 0, maxEntries),
+	newIndex := func() (*repository.Index, error) {
+		ridx := repository.NewIndex()
+		return ridx, ridx.AddToSupersedes(supersedes...)
 	}
 
-	for packID, pack := range idx.Packs {
-		debug.Log("%04d add pack %v with %d entries", packs, packID, len(pack.Entries))
-		b := make([]blobJSON, 0, len(pack.Entries))
-		for _, blob := range pack.Entries {
-			b = append(b, blobJSON{
-				ID:     blob.ID,
-				Type:   blob.Type,
-				Offset: blob.Offset,
-				Length: blob.Length,
-			})
-		}
+	ridx, err := newIndex()
+	if err != nil {
+		return nil, err
+	}
 
-		p := packJSON{
-			ID:    packID,
-			Blobs: b,
+	for _, group := range idx.ParityGroups {
+		err = ridx.StoreParityGroup(group)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		jsonIDX.Packs = append(jsonIDX.Packs, p)
+	for packID, pack := range idx.Packs {
+		debug.Log("%04d add pack %v with %d entries", packs, packID, len(pack.Entries))
+		ridx.StorePack(packID, pack.Entries)
 
 		packs++
 		if packs == maxEntries {
-			id, err := repo.SaveJSONUnpacked(ctx, restic.IndexFile, jsonIDX)
+			id, err := repository.SaveIndex(ctx, repo, ridx)
 			if err != nil {
 				return nil, err
 			}
@@ -360,12 +372,15 @@ func (idx *Index) Save(ctx context.Context, repo Saver, supersedes restic.IDs) (
 
 			indexIDs = append(indexIDs, id)
 			packs = 0
-			jsonIDX.Packs = jsonIDX.Packs[:0]
+			ridx, err = newIndex()
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	if packs > 0 {
-		id, err := repo.SaveJSONUnpacked(ctx, restic.IndexFile, jsonIDX)
+	if packs > 0 || (len(indexIDs) == 0 && len(idx.ParityGroups) > 0) {
+		id, err := repository.SaveIndex(ctx, repo, ridx)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,127 @@
+package migrations
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/quinn/restic/internal/debug"
+	"github.com/quinn/restic/internal/errors"
+	"github.com/quinn/restic/internal/repository"
+	"github.com/quinn/restic/internal/restic"
+)
+
+func init() {
+	register(&BinaryIndex{})
+}
+
+// BinaryIndex converts all index files of a repository to the binary index
+// format and configures the repository to write new index files in this
+// format.
+type BinaryIndex struct{}
+
+// Check tests whether the migration can be applied.
+func (m *BinaryIndex) Check(ctx context.Context, repo restic.Repository) (bool, error) {
+	if repo.Config().IndexFormat == restic.IndexFormatBinary {
+		debug.Log("repository already uses the binary index format")
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Apply runs the migration.
+func (m *BinaryIndex) Apply(ctx context.Context, repo restic.Repository) error {
+	// the repository version is raised before any index file is converted,
+	// so that older versions of restic, which cannot read the binary format,
+	// refuse to open the repository instead of ignoring these index files
+	cfg := repo.Config()
+	if cfg.Version < restic.RepoVersionBinaryIndex {
+		cfg.Version = restic.RepoVersionBinaryIndex
+		err := repo.SaveConfig(ctx, cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	var ids restic.IDs
+	err := repo.List(ctx, restic.IndexFile, func(id restic.ID, size int64) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err = m.convertIndex(ctx, repo, id)
+		if err != nil {
+			return errors.Wrapf(err, "index %v", id.Str())
+		}
+	}
+
+	// the index format is set last, so the migration can be run again if
+	// converting an index failed
+	cfg = repo.Config()
+	cfg.IndexFormat = restic.IndexFormatBinary
+	return repo.SaveConfig(ctx, cfg)
+}
+
+// convertIndex replaces the index file id with a new index file in the binary
+// format which supersedes it.
+func (m *BinaryIndex) convertIndex(ctx context.Context, repo restic.Repository, id restic.ID) error {
+	buf, err := repo.LoadAndDecrypt(ctx, nil, restic.IndexFile, id)
+	if err != nil {
+		return err
+	}
+
+	if repository.IsBinaryIndex(buf) {
+		debug.Log("index %v is already in binary format", id)
+		return nil
+	}
+
+	oldIdx, err := repository.DecodeIndex(buf)
+	if err == repository.ErrOldIndexFormat {
+		oldIdx, err = repository.DecodeOldIndex(buf)
+	}
+	if err != nil {
+		return err
+	}
+
+	idx := repository.NewIndex()
+	for pb := range oldIdx.Each(ctx) {
+		idx.Store(pb)
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	err = idx.AddToSupersedes(append(oldIdx.Supersedes(), id)...)
+	if err != nil {
+		return err
+	}
+
+	wr := bytes.NewBuffer(nil)
+	err = idx.EncodeBinary(wr)
+	if err != nil {
+		return err
+	}
+
+	newID, err := repo.SaveUnpacked(ctx, restic.IndexFile, wr.Bytes())
+	if err != nil {
+		return err
+	}
+	debug.Log("index %v converted to %v", id, newID)
+
+	return repo.Backend().Remove(ctx, restic.Handle{Type: restic.IndexFile, Name: id.String()})
+}
+
+// Name returns the name for this migration.
+func (m *BinaryIndex) Name() string {
+	return "binary_index"
+}
+
+// Desc returns a short description what the migration does.
+func (m *BinaryIndex) Desc() string {
+	return "convert index files to the binary index format (older versions of restic cannot read the repository afterwards)"
+}
@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/quinn/restic/internal/debug"
+	"github.com/quinn/restic/internal/restic"
+)
+
+func init() {
+	register(&Padding{})
+}
+
+// Padding configures the repository to pad all new packs.
+type Padding struct{}
+
+// Check tests whether the migration can be applied.
+func (m *Padding) Check(ctx context.Context, repo restic.Repository) (bool, error) {
+	if repo.Config().Padding {
+		debug.Log("repository already pads packs")
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Apply runs the migration.
+func (m *Padding) Apply(ctx context.Context, repo restic.Repository) error {
+	cfg := repo.Config()
+	cfg.Padding = true
+	return repo.SaveConfig(ctx, cfg)
+}
+
+// Name returns the name for this migration.
+func (m *Padding) Name() string {
+	return "padding"
+}
+
+// Desc returns a short description what the migration does.
+func (m *Padding) Desc() string {
+	return "pad new packs with random data, so that their sizes reveal less about the backed up files"
+}
@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/quinn/restic/internal/debug"
+	"github.com/quinn/restic/internal/restic"
+)
+
+func init() {
+	register(&Parity{})
+}
+
+// Default number of packs in a parity group and parity files written for each
+// group, this allows repairing up to two damaged packs out of ten with 20%
+// storage overhead.
+const (
+	defaultParityDataShards   = 10
+	defaultParityParityShards = 2
+)
+
+// Parity configures the repository to write parity files for all new packs.
+type Parity struct{}
+
+// Check tests whether the migration can be applied.
+func (m *Parity) Check(ctx context.Context, repo restic.Repository) (bool, error) {
+	if repo.Config().Parity != nil {
+		debug.Log("repository already writes parity files")
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Apply runs the migration.
+func (m *Parity) Apply(ctx context.Context, repo restic.Repository) error {
+	cfg := repo.Config()
+	cfg.Parity = &restic.ParityConfig{
+		DataShards:   defaultParityDataShards,
+		ParityShards: defaultParityParityShards,
+	}
+	return repo.SaveConfig(ctx, cfg)
+}
+
+// Name returns the name for this migration.
+func (m *Parity) Name() string {
+	return "parity"
+}
+
+// Desc returns a short description what the migration does.
+func (m *Parity) Desc() string {
+	return "write parity files for groups of 10 new packs, which allow repairing up to 2 damaged packs per group"
+}
@@ -2,9 +2,12 @@ package pack
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math/bits"
+	"sort"
 	"sync"
 
 	"github.com/quinn/restic/internal/debug"
@@ -22,6 +25,11 @@ type Packer struct {
 	k     *crypto.Key
 	wr    io.Writer
 
+	// pad is set when the pack is padded, padding is the number of padding
+	// bytes written
+	pad     bool
+	padding uint
+
 	m sync.Mutex
 }
 
@@ -30,6 +38,49 @@ func NewPacker(k *crypto.Key, wr io.Writer) *Packer {
 	return &Packer{k: k, wr: wr}
 }
 
+// EnablePadding configures the packer to pad the pack to the size returned by
+// PaddedSize. The blobs are stored without gaps, only the size of the whole
+// pack is visible to the backend. It must be called before Finalize.
+func (p *Packer) EnablePadding() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.pad = true
+}
+
+// PaddedSize returns the size data of the given size is padded to. Only the
+// floor(log2(floor(log2(size))))+1 most significant bits of the size are
+// kept, so a padded size reveals very little about the original size while
+// the overhead is limited to about 12%.
+func PaddedSize(size uint) uint {
+	if size < 2 {
+		return size
+	}
+
+	e := bits.Len(size) - 1
+	s := bits.Len(uint(e))
+	mask := uint(1)<<uint(e-s) - 1
+	return (size + mask) &^ mask
+}
+
+// writePadding writes n random bytes.
+func (p *Packer) writePadding(n uint) error {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(rand.Reader, buf)
+	if err != nil {
+		return errors.Wrap(err, "ReadFull")
+	}
+
+	_, err = p.wr.Write(buf)
+	if err != nil {
+		return errors.Wrap(err, "Write")
+	}
+
+	p.bytes += n
+	p.padding += n
+	return nil
+}
+
 // Add saves the data read from rd as a new blob to the packer. Returned is the
 // number of bytes written to the pack.
 func (p *Packer) Add(t restic.BlobType, id restic.ID, data []byte) (int, error) {
@@ -62,14 +113,25 @@ func (p *Packer) Finalize() (uint, error) {
 	p.m.Lock()
 	defer p.m.Unlock()
 
-	bytesWritten := p.bytes
-
 	hdrBuf := bytes.NewBuffer(nil)
 	bytesHeader, err := p.writeHeader(hdrBuf)
 	if err != nil {
 		return 0, err
 	}
 
+	// pad the pack by inserting random data between the last blob and the
+	// header, the padding is not referenced by the header and is therefore
+	// skipped by List
+	if p.pad {
+		size := p.bytes + uint(restic.CiphertextLength(int(bytesHeader))+headerLengthSize)
+		err = p.writePadding(PaddedSize(size) - size)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	bytesWritten := p.bytes
+
 	encryptedHeader := make([]byte, 0, hdrBuf.Len()+p.k.Overhead()+p.k.NonceSize())
 	nonce := crypto.NewRandomNonce()
 	encryptedHeader = append(encryptedHeader, nonce...)
@@ -135,6 +197,14 @@ func (p *Packer) Size() uint {
 	return p.bytes
 }
 
+// Padding returns the number of padding bytes written so far.
+func (p *Packer) Padding() uint {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return p.padding
+}
+
 // Count returns the number of blobs in this packer.
 func (p *Packer) Count() int {
 	p.m.Lock()
@@ -315,3 +385,143 @@ func List(k *crypto.Key, rd io.ReaderAt, size int64) (entries []restic.Blob, err
 
 	return entries, nil
 }
+
+// SalvagedBlob is a blob recovered from a damaged pack by Salvage.
+type SalvagedBlob struct {
+	restic.Blob
+	Plaintext []byte
+}
+
+// Salvage recovers the blobs from a pack whose header cannot be read. Starting
+// at the beginning of the pack, the shortest data which can be decrypted with k
+// is the next blob. The ID of a blob is computed from its plaintext. The type
+// is only recorded in the header, so it is set to restic.InvalidBlob and must
+// be determined by the caller, e.g. from the trees which reference the blob.
+//
+// When a blob is damaged, the search continues at the next offset after it at
+// which a blob may start. These offsets are taken from the header, which is
+// decrypted without authentication for this purpose, and from hints, e.g. the
+// offsets recorded in an old index. All blobs found this way are
+// authenticated. The number of bytes which could not be recovered is returned
+// in lost.
+func Salvage(k *crypto.Key, rd io.ReaderAt, size int64, hints []uint) (blobs []SalvagedBlob, lost uint, err error) {
+	buf := make([]byte, size)
+	if _, err := rd.ReadAt(buf, 0); err != nil {
+		return nil, 0, errors.Wrap(err, "ReadAt")
+	}
+
+	// the header was saved with its own nonce and could be mistaken for a
+	// blob, so only the data in front of it is scanned if its length is
+	// plausible
+	end := uint(len(buf))
+	entries := -1
+	dataEnd := uint(0)
+	candidates := append([]uint(nil), hints...)
+	if len(buf) >= headerLengthSize {
+		hlen := uint(binary.LittleEndian.Uint32(buf[len(buf)-headerLengthSize:]))
+		if hlen >= crypto.Extension && (hlen-crypto.Extension)%entrySize == 0 && hlen+uint(headerLengthSize) <= end {
+			end -= hlen + uint(headerLengthSize)
+			entries = int((hlen - crypto.Extension) / entrySize)
+			offsets := headerOffsets(k, buf[end:len(buf)-headerLengthSize])
+			if len(offsets) > 0 {
+				dataEnd = offsets[len(offsets)-1]
+			}
+			candidates = append(candidates, offsets...)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i] < candidates[j] })
+
+	// find returns the length of the blob at pos
+	find := func(pos uint) (uint, bool) {
+		n, ok := k.FindCiphertext(buf[pos:end])
+		if !ok || (end == uint(len(buf)) && pos+uint(n) == end-uint(headerLengthSize)) {
+			return 0, false
+		}
+		return uint(n), true
+	}
+
+	// resync returns the first candidate offset after pos at which a blob
+	// is found
+	resync := func(pos uint) (uint, uint, bool) {
+		for _, c := range candidates {
+			if c <= pos || c >= end {
+				continue
+			}
+			if n, ok := find(c); ok {
+				return c, n, true
+			}
+		}
+		return 0, 0, false
+	}
+
+	pos := uint(0)
+	for pos < end {
+		n, ok := find(pos)
+		if !ok {
+			next, nextLength, found := resync(pos)
+			if !found {
+				break
+			}
+			debug.Log("damaged data at offset %d, continuing at offset %d", pos, next)
+			lost += next - pos
+			pos, n = next, nextLength
+		}
+
+		ciphertext := buf[pos : pos+n]
+		plaintext, err := k.Open(nil, ciphertext[:k.NonceSize()], ciphertext[k.NonceSize():], nil)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		blobs = append(blobs, SalvagedBlob{
+			Blob: restic.Blob{
+				Type:   restic.InvalidBlob,
+				ID:     restic.Hash(plaintext),
+				Length: n,
+				Offset: pos,
+			},
+			Plaintext: plaintext,
+		})
+		debug.Log("salvaged blob %v at offset %d", blobs[len(blobs)-1].ID, pos)
+
+		pos += n
+	}
+
+	// the data between the last blob and the header of a padded pack is
+	// padding
+	switch {
+	case pos >= end || len(blobs) == entries:
+	case dataEnd > pos && dataEnd <= end:
+		lost += dataEnd - pos
+	case dataEnd != pos:
+		lost += end - pos
+	}
+
+	return blobs, lost, nil
+}
+
+// headerOffsets decrypts the damaged header buf without authentication and
+// returns the offsets of the blobs listed in it. The offsets may be wrong and
+// must be verified by the caller.
+func headerOffsets(k *crypto.Key, buf []byte) []uint {
+	if len(buf) < k.NonceSize()+k.Overhead() {
+		return nil
+	}
+
+	hdr, err := k.OpenUnauthenticated(nil, buf[:k.NonceSize()], buf[k.NonceSize():])
+	if err != nil {
+		return nil
+	}
+
+	var offsets []uint
+	pos := uint(0)
+	for len(hdr) >= int(entrySize) {
+		// the type is stored in the first byte, followed by the length
+		length := uint(binary.LittleEndian.Uint32(hdr[1:5]))
+		pos += length
+		offsets = append(offsets, pos)
+		hdr = hdr[entrySize:]
+	}
+
+	return offsets
+}
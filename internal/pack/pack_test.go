@@ -143,3 +143,198 @@ func TestShortPack(t *testing.T) {
 	rtest.OK(t, b.Save(context.TODO(), handle, restic.NewByteReader(packData)))
 	verifyBlobs(t, bufs, k, restic.ReaderAt(b, handle), packSize)
 }
+
+func TestPaddedSize(t *testing.T) {
+	rtest.Equals(t, uint(0), pack.PaddedSize(0))
+	rtest.Equals(t, uint(1), pack.PaddedSize(1))
+	rtest.Equals(t, uint(1024), pack.PaddedSize(1000))
+	rtest.Equals(t, uint(4*1024*1024), pack.PaddedSize(4*1024*1024))
+
+	var last uint
+	for size := uint(1); size < 10*1024*1024; size = size*3/2 + 1 {
+		padded := pack.PaddedSize(size)
+		rtest.Assert(t, padded >= size, "padded size %d for %d is too small", padded, size)
+		rtest.Assert(t, padded >= last, "padded size %d for %d is smaller than the previous one", padded, size)
+		rtest.Assert(t, padded-size <= size/8+1, "padding for %d is too large: %d", size, padded-size)
+		rtest.Equals(t, padded, pack.PaddedSize(padded))
+		last = padded
+	}
+}
+
+func TestPaddedPack(t *testing.T) {
+	k := crypto.NewRandomKey()
+
+	var bufs []Buf
+	p := pack.NewPacker(k, new(bytes.Buffer))
+	p.EnablePadding()
+	for _, l := range testLens {
+		b := make([]byte, l)
+		_, err := io.ReadFull(rand.Reader, b)
+		rtest.OK(t, err)
+		buf := Buf{data: b, id: sha256.Sum256(b)}
+		bufs = append(bufs, buf)
+
+		_, err = p.Add(restic.DataBlob, buf.id, buf.data)
+		rtest.OK(t, err)
+	}
+
+	size, err := p.Finalize()
+	rtest.OK(t, err)
+
+	packData := p.Writer().(*bytes.Buffer).Bytes()
+	rtest.Equals(t, uint(len(packData)), size)
+	rtest.Equals(t, pack.PaddedSize(size), size)
+	rtest.Assert(t, p.Padding() > 0, "no padding was written")
+
+	// the padding is invisible to List, also to the implementation from
+	// before padding was introduced
+	entries, err := pack.List(k, bytes.NewReader(packData), int64(len(packData)))
+	rtest.OK(t, err)
+	rtest.Equals(t, p.Blobs(), entries)
+	rtest.Equals(t, p.Blobs(), listUnpadded(t, k, packData))
+
+	var pos uint
+	for i, e := range entries {
+		rtest.Equals(t, bufs[i].id, e.ID)
+		rtest.Assert(t, bytes.Equal(bufs[i].data, packData[e.Offset:e.Offset+e.Length]),
+			"data for blob %v doesn't match", i)
+
+		// the blobs are stored without gaps
+		rtest.Equals(t, pos, e.Offset)
+		pos += e.Length
+	}
+}
+
+// listUnpadded parses the header of the pack in buf like pack.List did before
+// padding was introduced: the header length is read from the end of the pack
+// and the offsets of the blobs are the sums of the lengths of the preceding
+// entries.
+func listUnpadded(t testing.TB, k *crypto.Key, buf []byte) (entries []restic.Blob) {
+	hlen := binary.LittleEndian.Uint32(buf[len(buf)-4:])
+	hdr := buf[len(buf)-4-int(hlen) : len(buf)-4]
+
+	nonce, ciphertext := hdr[:k.NonceSize()], hdr[k.NonceSize():]
+	plaintext, err := k.Open(nil, nonce, ciphertext, nil)
+	rtest.OK(t, err)
+
+	rd := bytes.NewReader(plaintext)
+	pos := uint(0)
+	for rd.Len() > 0 {
+		var e struct {
+			Type   uint8
+			Length uint32
+			ID     restic.ID
+		}
+		rtest.OK(t, binary.Read(rd, binary.LittleEndian, &e))
+
+		entry := restic.Blob{
+			Length: uint(e.Length),
+			ID:     e.ID,
+			Offset: pos,
+		}
+
+		switch e.Type {
+		case 0:
+			entry.Type = restic.DataBlob
+		case 1:
+			entry.Type = restic.TreeBlob
+		default:
+			t.Fatalf("invalid type %d", e.Type)
+		}
+
+		entries = append(entries, entry)
+		pos += uint(e.Length)
+	}
+
+	return entries
+}
+
+func TestSalvage(t *testing.T) {
+	k := crypto.NewRandomKey()
+
+	for _, padded := range []bool{false, true} {
+		p := pack.NewPacker(k, new(bytes.Buffer))
+		if padded {
+			p.EnablePadding()
+		}
+
+		var plaintexts [][]byte
+		tree, err := json.Marshal(restic.NewTree())
+		rtest.OK(t, err)
+		plaintexts = append(plaintexts, tree)
+		for _, l := range testLens {
+			plaintexts = append(plaintexts, rtest.Random(l, l))
+		}
+
+		for i, plaintext := range plaintexts {
+			tpe := restic.DataBlob
+			if i == 0 {
+				tpe = restic.TreeBlob
+			}
+
+			nonce := crypto.NewRandomNonce()
+			ciphertext := k.Seal(append([]byte{}, nonce...), nonce, plaintext, nil)
+			_, err = p.Add(tpe, restic.Hash(plaintext), ciphertext)
+			rtest.OK(t, err)
+		}
+
+		_, err = p.Finalize()
+		rtest.OK(t, err)
+		packData := p.Writer().(*bytes.Buffer).Bytes()
+
+		// damage the header
+		packData[len(packData)-10] ^= 0xff
+		_, err = pack.List(k, bytes.NewReader(packData), int64(len(packData)))
+		rtest.Assert(t, err != nil, "damaged header was not detected")
+
+		blobs, lost, err := pack.Salvage(k, bytes.NewReader(packData), int64(len(packData)), nil)
+		rtest.OK(t, err)
+		rtest.Equals(t, len(p.Blobs()), len(blobs))
+		for i, blob := range p.Blobs() {
+			rtest.Equals(t, blob.ID, blobs[i].ID)
+			rtest.Equals(t, blob.Offset, blobs[i].Offset)
+			rtest.Equals(t, blob.Length, blobs[i].Length)
+			rtest.Equals(t, restic.InvalidBlob, blobs[i].Type)
+			rtest.Equals(t, plaintexts[i], blobs[i].Plaintext)
+		}
+		// the padding in front of the header is not lost data
+		rtest.Equals(t, uint(0), lost)
+
+		// the search continues after a damaged blob at the offsets listed in
+		// the header
+		damaged := p.Blobs()[4]
+		packData[damaged.Offset+damaged.Length/2] ^= 0xff
+		blobs, lost, err = pack.Salvage(k, bytes.NewReader(packData), int64(len(packData)), nil)
+		rtest.OK(t, err)
+		checkSalvaged(t, p.Blobs(), 4, blobs)
+		rtest.Equals(t, damaged.Length, lost)
+
+		// without a usable header, the offsets are taken from the hints
+		packData[len(packData)-1] ^= 0xff
+		blobs, _, err = pack.Salvage(k, bytes.NewReader(packData), int64(len(packData)), nil)
+		rtest.OK(t, err)
+		rtest.Equals(t, 4, len(blobs))
+
+		var hints []uint
+		for _, blob := range p.Blobs() {
+			hints = append(hints, blob.Offset)
+		}
+		blobs, lost, err = pack.Salvage(k, bytes.NewReader(packData), int64(len(packData)), hints)
+		rtest.OK(t, err)
+		checkSalvaged(t, p.Blobs(), 4, blobs)
+		rtest.Assert(t, lost > damaged.Length, "expected more than %d lost bytes, got %d", damaged.Length, lost)
+	}
+}
+
+// checkSalvaged checks that all blobs except the damaged one have been
+// salvaged.
+func checkSalvaged(t testing.TB, want []restic.Blob, damaged int, blobs []pack.SalvagedBlob) {
+	rtest.Equals(t, len(want)-1, len(blobs))
+	for i, blob := range blobs {
+		if i >= damaged {
+			i++
+		}
+		rtest.Equals(t, want[i].ID, blob.ID)
+		rtest.Equals(t, want[i].Offset, blob.Offset)
+	}
+}
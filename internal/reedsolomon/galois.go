@@ -0,0 +1,158 @@
+package reedsolomon
+
+// Arithmetic in the Galois field GF(2^8), using the primitive polynomial
+// x^8 + x^4 + x^3 + x^2 + 1 (0x11d). Addition and subtraction are XOR.
+
+const fieldPolynomial = 0x11d
+
+var (
+	expTable [510]byte
+	logTable [256]byte
+	mulTable [256][256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		expTable[i+255] = byte(x)
+		logTable[x] = byte(i)
+
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= fieldPolynomial
+		}
+	}
+
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			mulTable[a][b] = expTable[int(logTable[a])+int(logTable[b])]
+		}
+	}
+}
+
+// galMul returns the product of a and b.
+func galMul(a, b byte) byte {
+	return mulTable[a][b]
+}
+
+// galDiv returns a divided by b, b must not be zero.
+func galDiv(a, b byte) byte {
+	if b == 0 {
+		panic("division by zero")
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+255-int(logTable[b])]
+}
+
+// galExp returns a to the power of n.
+func galExp(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])*n)%255]
+}
+
+// galMulSliceXor adds c*in to out.
+func galMulSliceXor(c byte, in, out []byte) {
+	if c == 0 {
+		return
+	}
+
+	mt := &mulTable[c]
+	out = out[:len(in)]
+	for i, v := range in {
+		out[i] ^= mt[v]
+	}
+}
+
+// matrix is a matrix of field elements, stored in row-major order.
+type matrix [][]byte
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for i := range m {
+		m[i] = make([]byte, cols)
+	}
+	return m
+}
+
+// vandermonde returns a rows x cols Vandermonde matrix. Any cols rows of it
+// are linearly independent.
+func vandermonde(rows, cols int) matrix {
+	m := newMatrix(rows, cols)
+	for r := range m {
+		for c := range m[r] {
+			m[r][c] = galExp(byte(r), c)
+		}
+	}
+	return m
+}
+
+// mul returns the product m * other.
+func (m matrix) mul(other matrix) matrix {
+	res := newMatrix(len(m), len(other[0]))
+	for r := range res {
+		for c := range res[r] {
+			var v byte
+			for i := range other {
+				v ^= galMul(m[r][i], other[i][c])
+			}
+			res[r][c] = v
+		}
+	}
+	return res
+}
+
+// invert returns the inverse of the square matrix m, m is not modified.
+func (m matrix) invert() (matrix, error) {
+	n := len(m)
+
+	// Gauss-Jordan elimination on m augmented with the identity matrix
+	work := newMatrix(n, 2*n)
+	for r := range m {
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+
+	for c := 0; c < n; c++ {
+		// find a row with a non-zero pivot
+		pivot := -1
+		for r := c; r < n; r++ {
+			if work[r][c] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, errSingularMatrix
+		}
+		work[c], work[pivot] = work[pivot], work[c]
+
+		// scale the pivot row to 1
+		if v := work[c][c]; v != 1 {
+			for i := range work[c] {
+				work[c][i] = galDiv(work[c][i], v)
+			}
+		}
+
+		// eliminate the column in all other rows
+		for r := 0; r < n; r++ {
+			if r == c || work[r][c] == 0 {
+				continue
+			}
+			galMulSliceXor(work[r][c], work[c], work[r])
+		}
+	}
+
+	res := newMatrix(n, n)
+	for r := range res {
+		copy(res[r], work[r][n:])
+	}
+	return res, nil
+}
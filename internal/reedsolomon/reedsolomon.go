@@ -0,0 +1,186 @@
+// Package reedsolomon implements a systematic Reed-Solomon erasure code over
+// GF(2^8). Data is split into data shards, for which a number of parity shards
+// is computed. Any combination of shards which contains at least as many
+// shards as there are data shards is enough to reconstruct all other shards.
+package reedsolomon
+
+import (
+	"github.com/quinn/restic/internal/errors"
+)
+
+// MaxShards is the maximal number of data and parity shards of a code.
+const MaxShards = 256
+
+var errSingularMatrix = errors.New("matrix is singular")
+
+// ErrTooFewShards is returned by Reconstruct when not enough shards are
+// present to reconstruct the missing shards.
+var ErrTooFewShards = errors.New("too few shards to reconstruct the data")
+
+// Code encodes and reconstructs shards. It is safe for concurrent use.
+type Code struct {
+	dataShards   int
+	parityShards int
+
+	// encoding matrix with dataShards+parityShards rows and dataShards
+	// columns, the first dataShards rows are the identity matrix
+	matrix matrix
+}
+
+// New returns a code for the given number of data and parity shards.
+func New(dataShards, parityShards int) (*Code, error) {
+	if dataShards <= 0 || parityShards <= 0 {
+		return nil, errors.New("number of data and parity shards must be positive")
+	}
+
+	if dataShards+parityShards > MaxShards {
+		return nil, errors.Errorf("too many shards, at most %d are supported", MaxShards)
+	}
+
+	// multiply a Vandermonde matrix with the inverse of its top square, this
+	// yields a matrix which starts with the identity matrix and retains the
+	// property that any dataShards rows are linearly independent
+	v := vandermonde(dataShards+parityShards, dataShards)
+	top, err := v[:dataShards].invert()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Code{
+		dataShards:   dataShards,
+		parityShards: parityShards,
+		matrix:       v.mul(top),
+	}, nil
+}
+
+// DataShards returns the number of data shards.
+func (c *Code) DataShards() int {
+	return c.dataShards
+}
+
+// ParityShards returns the number of parity shards.
+func (c *Code) ParityShards() int {
+	return c.parityShards
+}
+
+func (c *Code) checkShards(shards [][]byte, allowMissing bool) (size int, err error) {
+	if len(shards) != c.dataShards+c.parityShards {
+		return 0, errors.Errorf("wrong number of shards, want %d, got %d", c.dataShards+c.parityShards, len(shards))
+	}
+
+	size = -1
+	for _, shard := range shards {
+		if len(shard) == 0 && allowMissing {
+			continue
+		}
+
+		if size >= 0 && len(shard) != size {
+			return 0, errors.New("shards have different sizes")
+		}
+		size = len(shard)
+	}
+
+	if size < 0 && allowMissing {
+		return 0, ErrTooFewShards
+	}
+
+	if size <= 0 {
+		return 0, errors.New("shards are empty")
+	}
+
+	return size, nil
+}
+
+// Encode computes the parity shards from the data shards. The first
+// DataShards() entries of shards contain the data, the remaining entries are
+// overwritten with the parity data. All shards must have the same size.
+func (c *Code) Encode(shards [][]byte) error {
+	if _, err := c.checkShards(shards, false); err != nil {
+		return err
+	}
+
+	c.encodeRows(c.matrix[c.dataShards:], shards[:c.dataShards], shards[c.dataShards:])
+	return nil
+}
+
+// encodeRows sets out[i] to the product of rows[i] and in.
+func (c *Code) encodeRows(rows matrix, in, out [][]byte) {
+	for i, row := range rows {
+		for j := range out[i] {
+			out[i][j] = 0
+		}
+
+		for j, shard := range in {
+			galMulSliceXor(row[j], shard, out[i])
+		}
+	}
+}
+
+// Reconstruct recreates all missing shards, which are represented by nil or
+// empty slices. The reconstructed shards are newly allocated. If fewer than
+// DataShards() shards are present, ErrTooFewShards is returned.
+func (c *Code) Reconstruct(shards [][]byte) error {
+	size, err := c.checkShards(shards, true)
+	if err != nil {
+		return err
+	}
+
+	// collect the first dataShards present shards and the matching rows of
+	// the encoding matrix
+	var (
+		rows    matrix
+		present [][]byte
+	)
+	for i, shard := range shards {
+		if len(shard) == 0 {
+			continue
+		}
+
+		rows = append(rows, c.matrix[i])
+		present = append(present, shard)
+		if len(present) == c.dataShards {
+			break
+		}
+	}
+
+	if len(present) < c.dataShards {
+		return ErrTooFewShards
+	}
+
+	decode, err := rows.invert()
+	if err != nil {
+		return err
+	}
+
+	// recreate the missing data shards
+	var (
+		missingRows matrix
+		missing     [][]byte
+	)
+	for i := 0; i < c.dataShards; i++ {
+		if len(shards[i]) != 0 {
+			continue
+		}
+
+		shards[i] = make([]byte, size)
+		missingRows = append(missingRows, decode[i])
+		missing = append(missing, shards[i])
+	}
+	c.encodeRows(missingRows, present, missing)
+
+	// recompute the missing parity shards from the data
+	missingRows = missingRows[:0]
+	missing = missing[:0]
+	for i := c.dataShards; i < len(shards); i++ {
+		if len(shards[i]) != 0 {
+			continue
+		}
+
+		shards[i] = make([]byte, size)
+		missingRows = append(missingRows, c.matrix[i])
+		missing = append(missing, shards[i])
+	}
+	c.encodeRows(missingRows, shards[:c.dataShards], missing)
+
+	return nil
+}
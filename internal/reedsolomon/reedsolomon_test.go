@@ -0,0 +1,144 @@
+package reedsolomon
+
+import (
+	"bytes"
+	"testing"
+
+	rtest "github.com/quinn/restic/internal/test"
+)
+
+func TestGalois(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			p := galMul(byte(a), byte(b))
+			if galDiv(p, byte(b)) != byte(a) {
+				t.Fatalf("(%d * %d) / %d != %d", a, b, b, a)
+			}
+		}
+	}
+}
+
+func TestMatrixInvert(t *testing.T) {
+	for n := 1; n < 20; n++ {
+		m := vandermonde(n, n)
+		inv, err := m.invert()
+		rtest.OK(t, err)
+
+		id := m.mul(inv)
+		for r := range id {
+			for c := range id[r] {
+				want := byte(0)
+				if r == c {
+					want = 1
+				}
+				if id[r][c] != want {
+					t.Fatalf("n=%d: m * inv(m) is not the identity matrix: %v", n, id)
+				}
+			}
+		}
+	}
+
+	_, err := matrix{{1, 2}, {1, 2}}.invert()
+	if err != errSingularMatrix {
+		t.Fatalf("expected errSingularMatrix, got %v", err)
+	}
+}
+
+func newShards(t testing.TB, code *Code, size int, seed int) [][]byte {
+	shards := make([][]byte, code.DataShards()+code.ParityShards())
+	for i := range shards {
+		if i < code.DataShards() {
+			shards[i] = rtest.Random(seed+i, size)
+		} else {
+			shards[i] = make([]byte, size)
+		}
+	}
+	rtest.OK(t, code.Encode(shards))
+	return shards
+}
+
+func TestReconstruct(t *testing.T) {
+	var tests = []struct {
+		data, parity int
+	}{
+		{1, 1},
+		{3, 2},
+		{10, 2},
+		{10, 4},
+		{17, 3},
+	}
+
+	for _, test := range tests {
+		code, err := New(test.data, test.parity)
+		rtest.OK(t, err)
+
+		shards := newShards(t, code, 1234, test.data)
+		total := len(shards)
+
+		// remove every combination of up to two shards (or of as many
+		// shards as there are parity shards if fewer)
+		for i := 0; i < total; i++ {
+			for j := i; j < total; j++ {
+				if j != i && test.parity < 2 {
+					continue
+				}
+
+				damaged := make([][]byte, total)
+				copy(damaged, shards)
+				damaged[i] = nil
+				damaged[j] = nil
+
+				rtest.OK(t, code.Reconstruct(damaged))
+				for k := range shards {
+					if !bytes.Equal(shards[k], damaged[k]) {
+						t.Fatalf("%d+%d: shard %d differs after removing shards %d and %d",
+							test.data, test.parity, k, i, j)
+					}
+				}
+			}
+		}
+
+		// removing more shards than there are parity shards must fail
+		damaged := make([][]byte, total)
+		copy(damaged, shards)
+		for i := 0; i <= test.parity; i++ {
+			damaged[i] = nil
+		}
+		err = code.Reconstruct(damaged)
+		if err != ErrTooFewShards {
+			t.Fatalf("%d+%d: expected ErrTooFewShards, got %v", test.data, test.parity, err)
+		}
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, test := range [][2]int{{0, 1}, {1, 0}, {-1, 2}, {200, 57}} {
+		_, err := New(test[0], test[1])
+		if err == nil {
+			t.Errorf("New(%d, %d) did not return an error", test[0], test[1])
+		}
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	code, err := New(3, 2)
+	rtest.OK(t, err)
+
+	rtest.Assert(t, code.Encode(make([][]byte, 4)) != nil, "wrong number of shards not detected")
+
+	shards := [][]byte{make([]byte, 4), make([]byte, 4), make([]byte, 5), make([]byte, 4), make([]byte, 4)}
+	rtest.Assert(t, code.Encode(shards) != nil, "different shard sizes not detected")
+}
+
+func BenchmarkEncode(b *testing.B) {
+	code, err := New(10, 2)
+	rtest.OK(b, err)
+
+	shards := newShards(b, code, 1<<20, 0)
+	b.SetBytes(10 << 20)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		rtest.OK(b, code.Encode(shards))
+	}
+}
@@ -0,0 +1,509 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/quinn/restic/internal/debug"
+	"github.com/quinn/restic/internal/errors"
+	"github.com/quinn/restic/internal/fs"
+	"github.com/quinn/restic/internal/restic"
+)
+
+// A DiskIndex is a read-only index which keeps its entries in a temporary file
+// instead of in memory. This allows working with large repositories on hosts
+// with little memory.
+//
+// The entries are stored sorted by blob type and ID, each entry uses
+// diskIndexEntrySize bytes:
+//
+//	type (1 byte) || blob ID (32 bytes) || pack ID (32 bytes) || offset || length
+//
+// Offset and length are stored as 32 bit big endian integers. The file is
+// divided into blocks of diskIndexBlockEntries entries, only the key of the
+// first entry of each block is kept in memory (about half a byte per blob). A
+// lookup then usually needs to read a single block from the file.
+type DiskIndex struct {
+	f       *os.File
+	entries int
+	count   [restic.NumBlobTypes]uint
+	fences  []diskIndexKey
+
+	ids       restic.IDs
+	treePacks restic.IDs
+	parity    []ParityGroup
+}
+
+const (
+	diskIndexEntrySize    = 1 + 2*len(restic.ID{}) + 4 + 4
+	diskIndexBlockEntries = 64
+	diskIndexBlockSize    = diskIndexEntrySize * diskIndexBlockEntries
+)
+
+var diskIndexBlockPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, diskIndexBlockSize)
+	},
+}
+
+// diskIndexKey is the sort key of an entry in a DiskIndex.
+type diskIndexKey struct {
+	tpe restic.BlobType
+	id  restic.ID
+}
+
+func (k diskIndexKey) less(other diskIndexKey) bool {
+	if k.tpe != other.tpe {
+		return k.tpe < other.tpe
+	}
+	return bytes.Compare(k.id[:], other.id[:]) < 0
+}
+
+func putDiskIndexEntry(buf []byte, pb restic.PackedBlob) {
+	buf[0] = byte(pb.Type)
+	copy(buf[1:], pb.ID[:])
+	copy(buf[1+len(pb.ID):], pb.PackID[:])
+	binary.BigEndian.PutUint32(buf[1+2*len(pb.ID):], uint32(pb.Offset))
+	binary.BigEndian.PutUint32(buf[1+2*len(pb.ID)+4:], uint32(pb.Length))
+}
+
+func decodeDiskIndexEntry(buf []byte) (pb restic.PackedBlob) {
+	pb.Type = restic.BlobType(buf[0])
+	copy(pb.ID[:], buf[1:])
+	copy(pb.PackID[:], buf[1+len(pb.ID):])
+	pb.Offset = uint(binary.BigEndian.Uint32(buf[1+2*len(pb.ID):]))
+	pb.Length = uint(binary.BigEndian.Uint32(buf[1+2*len(pb.ID)+4:]))
+	return pb
+}
+
+func diskIndexEntryKey(buf []byte) (k diskIndexKey) {
+	k.tpe = restic.BlobType(buf[0])
+	copy(k.id[:], buf[1:])
+	return k
+}
+
+// diskIndexWriter writes the entries of a new DiskIndex, which must be added
+// in sorted order.
+type diskIndexWriter struct {
+	idx *DiskIndex
+	wr  *bufio.Writer
+	buf [diskIndexEntrySize]byte
+
+	last diskIndexKey
+}
+
+func newDiskIndexWriter(dir string) (*diskIndexWriter, error) {
+	f, err := fs.TempFile(dir, "restic-index-")
+	if err != nil {
+		return nil, errors.Wrap(err, "fs.TempFile")
+	}
+
+	return &diskIndexWriter{
+		idx: &DiskIndex{f: f},
+		wr:  bufio.NewWriter(f),
+	}, nil
+}
+
+func (w *diskIndexWriter) add(pb restic.PackedBlob) error {
+	if pb.Offset > maxuint32 || pb.Length > maxuint32 {
+		return errors.New("offset or length does not fit in uint32")
+	}
+
+	key := diskIndexKey{tpe: pb.Type, id: pb.ID}
+	if w.idx.entries > 0 && key.less(w.last) {
+		return errors.Errorf("blob %v/%v added out of order", pb.Type, pb.ID.Str())
+	}
+	w.last = key
+
+	if w.idx.entries%diskIndexBlockEntries == 0 {
+		w.idx.fences = append(w.idx.fences, key)
+	}
+
+	putDiskIndexEntry(w.buf[:], pb)
+	_, err := w.wr.Write(w.buf[:])
+	if err != nil {
+		return errors.Wrap(err, "Write")
+	}
+
+	w.idx.entries++
+	w.idx.count[pb.Type]++
+	return nil
+}
+
+// finish flushes all entries to the file and returns the DiskIndex.
+func (w *diskIndexWriter) finish() (*DiskIndex, error) {
+	if err := w.wr.Flush(); err != nil {
+		_ = w.idx.Close()
+		return nil, errors.Wrap(err, "Flush")
+	}
+
+	debug.Log("disk index %v contains %d entries", w.idx.f.Name(), w.idx.entries)
+	return w.idx, nil
+}
+
+// abort removes the file of an unfinished DiskIndex.
+func (w *diskIndexWriter) abort() {
+	_ = w.idx.Close()
+}
+
+// diskIndexRunEntries is the number of entries which are sorted in memory at
+// once when an index is moved to disk.
+var diskIndexRunEntries = 64 * 1024
+
+// newDiskIndex writes the entries of the in-memory index idx to a new
+// DiskIndex in dir. The entries are sorted in runs of at most
+// diskIndexRunEntries entries, which are written to temporary files and merged
+// afterwards, so only a small part of the index is copied in memory.
+func newDiskIndex(dir string, idx *Index) (*DiskIndex, error) {
+	idx.m.Lock()
+	defer idx.m.Unlock()
+
+	var runs []*DiskIndex
+	defer func() {
+		for _, run := range runs {
+			_ = run.Close()
+		}
+	}()
+
+	list := make([]restic.PackedBlob, 0, diskIndexRunEntries)
+	saveRun := func() error {
+		sort.Slice(list, func(i, j int) bool {
+			return diskIndexKey{list[i].Type, list[i].ID}.less(diskIndexKey{list[j].Type, list[j].ID})
+		})
+
+		w, err := newDiskIndexWriter(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, pb := range list {
+			if err := w.add(pb); err != nil {
+				w.abort()
+				return err
+			}
+		}
+
+		run, err := w.finish()
+		if err != nil {
+			return err
+		}
+
+		runs = append(runs, run)
+		list = list[:0]
+		return nil
+	}
+
+	var err error
+	for typ := range idx.byType {
+		m := &idx.byType[typ]
+		m.foreach(func(e *indexEntry) bool {
+			list = append(list, idx.toPackedBlob(e, restic.BlobType(typ)))
+			if len(list) == cap(list) {
+				err = saveRun()
+			}
+			return err == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := saveRun(); err != nil {
+		return nil, err
+	}
+
+	var diskIdx *DiskIndex
+	if len(runs) == 1 {
+		diskIdx = runs[0]
+		runs = nil
+	} else {
+		diskIdx, err = mergeDiskIndexes(dir, runs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	diskIdx.ids = append(diskIdx.ids, idx.ids...)
+	diskIdx.treePacks = append(diskIdx.treePacks, idx.treePacks...)
+	diskIdx.parity = append(diskIdx.parity, idx.parity...)
+
+	return diskIdx, nil
+}
+
+// diskIndexSource is an index whose entries are read in order by
+// mergeDiskIndexes.
+type diskIndexSource struct {
+	rd  *bufio.Reader
+	buf [diskIndexEntrySize]byte
+	key diskIndexKey
+}
+
+// next reads the next entry, it returns false when all entries have been read.
+func (src *diskIndexSource) next() (bool, error) {
+	_, err := io.ReadFull(src.rd, src.buf[:])
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "ReadFull")
+	}
+
+	src.key = diskIndexEntryKey(src.buf[:])
+	return true, nil
+}
+
+// diskIndexHeap orders sources by the key of their current entry.
+type diskIndexHeap []*diskIndexSource
+
+func (h diskIndexHeap) Len() int            { return len(h) }
+func (h diskIndexHeap) Less(i, j int) bool  { return h[i].key.less(h[j].key) }
+func (h diskIndexHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *diskIndexHeap) Push(x interface{}) { *h = append(*h, x.(*diskIndexSource)) }
+func (h *diskIndexHeap) Pop() interface{} {
+	old := *h
+	src := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return src
+}
+
+// mergeDiskIndexes merges all entries of the indexes into a new DiskIndex in
+// dir. The indexes are not modified.
+func mergeDiskIndexes(dir string, indexes []*DiskIndex) (*DiskIndex, error) {
+	w, err := newDiskIndexWriter(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make(diskIndexHeap, 0, len(indexes))
+	for _, idx := range indexes {
+		src := &diskIndexSource{rd: bufio.NewReader(io.NewSectionReader(idx.f, 0, int64(idx.entries*diskIndexEntrySize)))}
+		ok, err := src.next()
+		if err != nil {
+			w.abort()
+			return nil, err
+		}
+		if ok {
+			sources = append(sources, src)
+		}
+
+		w.idx.ids = append(w.idx.ids, idx.ids...)
+		w.idx.treePacks = append(w.idx.treePacks, idx.treePacks...)
+		w.idx.parity = append(w.idx.parity, idx.parity...)
+	}
+
+	heap.Init(&sources)
+	for len(sources) > 0 {
+		src := sources[0]
+		if err := w.add(decodeDiskIndexEntry(src.buf[:])); err != nil {
+			w.abort()
+			return nil, err
+		}
+
+		ok, err := src.next()
+		if err != nil {
+			w.abort()
+			return nil, err
+		}
+
+		if ok {
+			heap.Fix(&sources, 0)
+		} else {
+			heap.Pop(&sources)
+		}
+	}
+
+	return w.finish()
+}
+
+// Close removes the file containing the index.
+func (idx *DiskIndex) Close() error {
+	name := idx.f.Name()
+	err := idx.f.Close()
+	if rerr := fs.RemoveIfExists(name); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// readFailed aborts after reading the file of the index failed. The methods of
+// restic.Index cannot return an error, and reporting the blobs as missing
+// would make backup save them again, and check and prune treat referenced
+// blobs as missing or unused, so there is nothing left to do.
+func (idx *DiskIndex) readFailed(err error) {
+	panic(errors.Wrapf(err, "reading index file %v failed", idx.f.Name()))
+}
+
+// foreachWithKey calls fn for all entries with the given key, until fn
+// returns false.
+func (idx *DiskIndex) foreachWithKey(key diskIndexKey, fn func(restic.PackedBlob) bool) {
+	// find the first block which may contain the key, entries with the key
+	// may also be stored in the block before the first fence which is not
+	// smaller than the key
+	block := sort.Search(len(idx.fences), func(i int) bool {
+		return !idx.fences[i].less(key)
+	})
+	if block > 0 {
+		block--
+	}
+
+	buf := diskIndexBlockPool.Get().([]byte)
+	defer diskIndexBlockPool.Put(buf)
+
+	for ; block < len(idx.fences); block++ {
+		if key.less(idx.fences[block]) {
+			return
+		}
+
+		n, err := idx.f.ReadAt(buf, int64(block*diskIndexBlockSize))
+		if err != nil && err != io.EOF {
+			idx.readFailed(err)
+		}
+
+		for i := 0; i+diskIndexEntrySize <= n; i += diskIndexEntrySize {
+			entry := buf[i : i+diskIndexEntrySize]
+			k := diskIndexEntryKey(entry)
+			if k.less(key) {
+				continue
+			}
+			if key.less(k) {
+				return
+			}
+
+			if !fn(decodeDiskIndexEntry(entry)) {
+				return
+			}
+		}
+	}
+}
+
+// Lookup queries the index for the blob ID and returns all entries.
+func (idx *DiskIndex) Lookup(id restic.ID, tpe restic.BlobType) (blobs []restic.PackedBlob, found bool) {
+	idx.foreachWithKey(diskIndexKey{tpe: tpe, id: id}, func(pb restic.PackedBlob) bool {
+		blobs = append(blobs, pb)
+		return true
+	})
+
+	return blobs, len(blobs) > 0
+}
+
+// Has returns true iff the id is listed in the index.
+func (idx *DiskIndex) Has(id restic.ID, tpe restic.BlobType) (found bool) {
+	idx.foreachWithKey(diskIndexKey{tpe: tpe, id: id}, func(pb restic.PackedBlob) bool {
+		found = true
+		return false
+	})
+
+	return found
+}
+
+// LookupSize returns the length of the plaintext content of the blob with the
+// given id.
+func (idx *DiskIndex) LookupSize(id restic.ID, tpe restic.BlobType) (plaintextLength uint, found bool) {
+	idx.foreachWithKey(diskIndexKey{tpe: tpe, id: id}, func(pb restic.PackedBlob) bool {
+		plaintextLength = uint(restic.PlaintextLength(int(pb.Length)))
+		found = true
+		return false
+	})
+
+	return plaintextLength, found
+}
+
+// Count returns the number of blobs of type t in the index.
+func (idx *DiskIndex) Count(t restic.BlobType) uint {
+	return idx.count[t]
+}
+
+// IDs returns the IDs of all index files contained in the index.
+func (idx *DiskIndex) IDs() restic.IDs {
+	return idx.ids
+}
+
+// TreePacks returns a list of packs that contain only tree blobs.
+func (idx *DiskIndex) TreePacks() restic.IDs {
+	return idx.treePacks
+}
+
+// ParityGroups returns the parity groups contained in the index.
+func (idx *DiskIndex) ParityGroups() []ParityGroup {
+	return idx.parity
+}
+
+// foreach calls fn for all entries in the index, until fn returns false.
+func (idx *DiskIndex) foreach(fn func(restic.PackedBlob) bool) error {
+	rd := bufio.NewReader(io.NewSectionReader(idx.f, 0, int64(idx.entries*diskIndexEntrySize)))
+	var buf [diskIndexEntrySize]byte
+	for i := 0; i < idx.entries; i++ {
+		_, err := io.ReadFull(rd, buf[:])
+		if err != nil {
+			return errors.Wrap(err, "ReadFull")
+		}
+
+		if !fn(decodeDiskIndexEntry(buf[:])) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// ListPack returns a list of blobs contained in a pack. This needs to read the
+// whole index file.
+func (idx *DiskIndex) ListPack(id restic.ID) (list []restic.PackedBlob) {
+	err := idx.foreach(func(pb restic.PackedBlob) bool {
+		if pb.PackID == id {
+			list = append(list, pb)
+		}
+		return true
+	})
+	if err != nil {
+		idx.readFailed(err)
+	}
+
+	return list
+}
+
+// Packs returns all packs in this index. This needs to read the whole index
+// file.
+func (idx *DiskIndex) Packs() restic.IDSet {
+	packs := restic.NewIDSet()
+	err := idx.foreach(func(pb restic.PackedBlob) bool {
+		packs.Insert(pb.PackID)
+		return true
+	})
+	if err != nil {
+		idx.readFailed(err)
+	}
+
+	return packs
+}
+
+// Each returns a channel that yields all blobs known to the index. When the
+// context is cancelled, the background goroutine terminates.
+func (idx *DiskIndex) Each(ctx context.Context) <-chan restic.PackedBlob {
+	ch := make(chan restic.PackedBlob)
+
+	go func() {
+		defer close(ch)
+
+		err := idx.foreach(func(pb restic.PackedBlob) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case ch <- pb:
+				return true
+			}
+		})
+		if err != nil {
+			idx.readFailed(err)
+		}
+	}()
+
+	return ch
+}
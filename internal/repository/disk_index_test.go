@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/quinn/restic/internal/restic"
+	rtest "github.com/quinn/restic/internal/test"
+)
+
+func TestNewDiskIndexRuns(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	// sort the entries in several runs, which are merged afterwards
+	defer func(n int) { diskIndexRunEntries = n }(diskIndexRunEntries)
+	diskIndexRunEntries = 7
+
+	rng := rand.New(rand.NewSource(0))
+	idx := NewIndex()
+	var blobs []restic.PackedBlob
+	for i := 0; i < 10; i++ {
+		var packID restic.ID
+		rng.Read(packID[:])
+		for j := 0; j < 10; j++ {
+			pb := restic.PackedBlob{
+				PackID: packID,
+				Blob: restic.Blob{
+					Type:   restic.BlobType(restic.DataBlob + restic.BlobType(j%2)),
+					Offset: uint(j * 100),
+					Length: uint(100 + j),
+				},
+			}
+			rng.Read(pb.ID[:])
+			idx.Store(pb)
+			blobs = append(blobs, pb)
+		}
+	}
+	idx.Finalize()
+
+	diskIdx, err := newDiskIndex(tempdir, idx)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, diskIdx.Close())
+	}()
+
+	for _, tpe := range []restic.BlobType{restic.DataBlob, restic.TreeBlob} {
+		rtest.Equals(t, idx.Count(tpe), diskIdx.Count(tpe))
+	}
+
+	for _, pb := range blobs {
+		list, found := diskIdx.Lookup(pb.ID, pb.Type)
+		rtest.Assert(t, found, "blob %v not found", pb.ID.Str())
+		rtest.Equals(t, []restic.PackedBlob{pb}, list)
+	}
+
+	var last diskIndexKey
+	n := 0
+	for pb := range diskIdx.Each(context.TODO()) {
+		key := diskIndexKey{tpe: pb.Type, id: pb.ID}
+		rtest.Assert(t, n == 0 || !key.less(last), "blob %v out of order", pb.ID.Str())
+		last = key
+		n++
+	}
+	rtest.Equals(t, len(blobs), n)
+}
+
+func TestDiskIndexReadError(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	idx := NewIndex()
+	pb := restic.PackedBlob{
+		PackID: restic.NewRandomID(),
+		Blob: restic.Blob{
+			Type:   restic.DataBlob,
+			ID:     restic.NewRandomID(),
+			Length: 100,
+		},
+	}
+	idx.Store(pb)
+	idx.Finalize()
+
+	diskIdx, err := newDiskIndex(tempdir, idx)
+	rtest.OK(t, err)
+
+	// reading the closed file fails, which must not be reported as a missing
+	// blob
+	rtest.OK(t, diskIdx.f.Close())
+	defer func() {
+		rtest.Assert(t, recover() != nil, "read error was not reported")
+	}()
+
+	found := diskIdx.Has(pb.ID, pb.Type)
+	t.Fatalf("Has returned %v after a read error", found)
+}
@@ -52,6 +52,7 @@ type Index struct {
 	final      bool       // set to true for all indexes read from the backend ("finalized")
 	ids        restic.IDs // set to the IDs of the contained finalized indexes
 	supersedes restic.IDs
+	parity     []ParityGroup
 	created    time.Time
 }
 
@@ -244,6 +245,25 @@ func (idx *Index) AddToSupersedes(ids ...restic.ID) error {
 	return nil
 }
 
+// ParityGroups returns the parity groups recorded in the index.
+func (idx *Index) ParityGroups() []ParityGroup {
+	return idx.parity
+}
+
+// StoreParityGroup records a group of packs for which parity files have been
+// saved. If the index has already been finalized, an error is returned.
+func (idx *Index) StoreParityGroup(group ParityGroup) error {
+	idx.m.Lock()
+	defer idx.m.Unlock()
+
+	if idx.final {
+		return errors.New("index already finalized")
+	}
+
+	idx.parity = append(idx.parity, group)
+	return nil
+}
+
 // Each returns a channel that yields all blobs known to the index. When the
 // context is cancelled, the background goroutine terminates. This blocks any
 // modification of the index.
@@ -352,8 +372,9 @@ func (idx *Index) generatePackList() ([]*packJSON, error) {
 }
 
 type jsonIndex struct {
-	Supersedes restic.IDs  `json:"supersedes,omitempty"`
-	Packs      []*packJSON `json:"packs"`
+	Supersedes restic.IDs    `json:"supersedes,omitempty"`
+	Packs      []*packJSON   `json:"packs"`
+	Parity     []ParityGroup `json:"parity,omitempty"`
 }
 
 // Encode writes the JSON serialization of the index to the writer w.
@@ -378,6 +399,7 @@ func (idx *Index) encode(w io.Writer) error {
 	idxJSON := jsonIndex{
 		Supersedes: idx.supersedes,
 		Packs:      list,
+		Parity:     idx.parity,
 	}
 	return enc.Encode(idxJSON)
 }
@@ -440,6 +462,7 @@ func (idx *Index) Dump(w io.Writer) error {
 	outer := jsonIndex{
 		Supersedes: idx.Supersedes(),
 		Packs:      list,
+		Parity:     idx.parity,
 	}
 
 	buf, err := json.MarshalIndent(outer, "", "  ")
@@ -490,6 +513,7 @@ func (idx *Index) merge(idx2 *Index) error {
 	idx.treePacks = append(idx.treePacks, idx2.treePacks...)
 	idx.ids = append(idx.ids, idx2.ids...)
 	idx.supersedes = append(idx.supersedes, idx2.supersedes...)
+	idx.parity = append(idx.parity, idx2.parity...)
 
 	return nil
 }
@@ -507,8 +531,13 @@ func isErrOldIndex(err error) bool {
 // ErrOldIndexFormat means an index with the old format was detected.
 var ErrOldIndexFormat = errors.New("index has old format")
 
-// DecodeIndex loads and unserializes an index from rd.
+// DecodeIndex loads and unserializes an index from rd. Both the JSON and the
+// binary index format are supported.
 func DecodeIndex(buf []byte) (idx *Index, err error) {
+	if IsBinaryIndex(buf) {
+		return DecodeBinaryIndex(buf)
+	}
+
 	debug.Log("Start decoding index")
 	idxJSON := &jsonIndex{}
 
@@ -550,6 +579,7 @@ func DecodeIndex(buf []byte) (idx *Index, err error) {
 		}
 	}
 	idx.supersedes = idxJSON.Supersedes
+	idx.parity = idxJSON.Parity
 	idx.final = true
 
 	debug.Log("done")
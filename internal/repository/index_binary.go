@@ -0,0 +1,287 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/quinn/restic/internal/debug"
+	"github.com/quinn/restic/internal/errors"
+	"github.com/quinn/restic/internal/restic"
+)
+
+// The binary index format stores the same information as the JSON index, but
+// avoids the overhead of parsing JSON and allocating intermediate structures
+// for every pack and blob. It is laid out as follows, all integers are
+// unsigned varints unless noted otherwise:
+//
+//   magic (4 bytes) || version (1 byte)
+//   number of superseded indexes || superseded index IDs (32 bytes each)
+//   number of packs
+//   for each pack:
+//     pack ID (32 bytes) || number of blobs
+//     for each blob:
+//       type (1 byte) || blob ID (32 bytes) || offset || length
+//
+// Version 2 is only written for indexes which contain parity groups, these
+// follow after the packs:
+//
+//   number of parity groups
+//   for each group:
+//     shard size || number of packs
+//     for each pack:
+//       pack ID (32 bytes) || size
+//     number of parity files || parity file IDs (32 bytes each)
+//
+// A JSON document never starts with the magic bytes, so both formats can be
+// distinguished by looking at the first bytes of the plaintext.
+
+var binaryIndexMagic = []byte("RIDX")
+
+const (
+	binaryIndexVersion       = 1
+	binaryIndexVersionParity = 2
+)
+
+// IsBinaryIndex returns true if buf contains an index in the binary format.
+func IsBinaryIndex(buf []byte) bool {
+	return bytes.HasPrefix(buf, binaryIndexMagic)
+}
+
+// EncodeBinary writes the binary serialization of the index to the writer w.
+func (idx *Index) EncodeBinary(w io.Writer) error {
+	debug.Log("encoding index in binary format")
+	idx.m.Lock()
+	defer idx.m.Unlock()
+
+	list, err := idx.generatePackList()
+	if err != nil {
+		return err
+	}
+
+	wr := bufio.NewWriter(w)
+	var scratch [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) {
+		n := binary.PutUvarint(scratch[:], v)
+		_, _ = wr.Write(scratch[:n])
+	}
+
+	version := byte(binaryIndexVersion)
+	if len(idx.parity) > 0 {
+		version = binaryIndexVersionParity
+	}
+
+	_, _ = wr.Write(binaryIndexMagic)
+	_ = wr.WriteByte(version)
+
+	putUvarint(uint64(len(idx.supersedes)))
+	for _, id := range idx.supersedes {
+		_, _ = wr.Write(id[:])
+	}
+
+	putUvarint(uint64(len(list)))
+	for _, p := range list {
+		_, _ = wr.Write(p.ID[:])
+		putUvarint(uint64(len(p.Blobs)))
+		for _, blob := range p.Blobs {
+			switch blob.Type {
+			case restic.DataBlob:
+				_ = wr.WriteByte(0)
+			case restic.TreeBlob:
+				_ = wr.WriteByte(1)
+			default:
+				return errors.Errorf("invalid blob type %v", blob.Type)
+			}
+			_, _ = wr.Write(blob.ID[:])
+			putUvarint(uint64(blob.Offset))
+			putUvarint(uint64(blob.Length))
+		}
+	}
+
+	if version == binaryIndexVersionParity {
+		putUvarint(uint64(len(idx.parity)))
+		for _, group := range idx.parity {
+			putUvarint(uint64(group.ShardSize))
+			putUvarint(uint64(len(group.Packs)))
+			for _, p := range group.Packs {
+				_, _ = wr.Write(p.ID[:])
+				putUvarint(uint64(p.Size))
+			}
+			putUvarint(uint64(len(group.Parity)))
+			for _, id := range group.Parity {
+				_, _ = wr.Write(id[:])
+			}
+		}
+	}
+
+	return errors.Wrap(wr.Flush(), "Write")
+}
+
+// binaryIndexReader decodes the primitives of the binary index format from a
+// buffer without copying it.
+type binaryIndexReader struct {
+	buf []byte
+	err error
+}
+
+var errBinaryIndexTruncated = errors.New("binary index is truncated")
+
+func (rd *binaryIndexReader) readUvarint() uint64 {
+	if rd.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(rd.buf)
+	if n <= 0 {
+		rd.err = errBinaryIndexTruncated
+		return 0
+	}
+	rd.buf = rd.buf[n:]
+	return v
+}
+
+func (rd *binaryIndexReader) readByte() byte {
+	if rd.err != nil {
+		return 0
+	}
+
+	if len(rd.buf) < 1 {
+		rd.err = errBinaryIndexTruncated
+		return 0
+	}
+	b := rd.buf[0]
+	rd.buf = rd.buf[1:]
+	return b
+}
+
+func (rd *binaryIndexReader) readID() (id restic.ID) {
+	if rd.err != nil {
+		return id
+	}
+
+	if len(rd.buf) < len(id) {
+		rd.err = errBinaryIndexTruncated
+		return id
+	}
+	copy(id[:], rd.buf)
+	rd.buf = rd.buf[len(id):]
+	return id
+}
+
+// readCount reads a number of items which each take up at least size bytes, and
+// checks that the remaining buffer is large enough to contain them.
+func (rd *binaryIndexReader) readCount(size int) int {
+	n := rd.readUvarint()
+	if rd.err == nil && n > uint64(len(rd.buf)/size) {
+		rd.err = errBinaryIndexTruncated
+		return 0
+	}
+	return int(n)
+}
+
+// minBinaryBlobSize is the minimal number of bytes an encoded blob needs.
+const minBinaryBlobSize = 1 + len(restic.ID{}) + 1 + 1
+
+// DecodeBinaryIndex unserializes an index in the binary format from buf.
+func DecodeBinaryIndex(buf []byte) (idx *Index, err error) {
+	debug.Log("Start decoding binary index")
+
+	if !IsBinaryIndex(buf) {
+		return nil, errors.New("Decode: index is not in binary format")
+	}
+
+	rd := &binaryIndexReader{buf: buf[len(binaryIndexMagic):]}
+	version := rd.readByte()
+	if rd.err == nil && version != binaryIndexVersion && version != binaryIndexVersionParity {
+		return nil, errors.Errorf("Decode: unsupported binary index version %d", version)
+	}
+
+	idx = NewIndex()
+
+	supersedes := rd.readCount(len(restic.ID{}))
+	if supersedes > 0 {
+		idx.supersedes = make(restic.IDs, 0, supersedes)
+	}
+	for i := 0; i < supersedes; i++ {
+		idx.supersedes = append(idx.supersedes, rd.readID())
+	}
+
+	packs := rd.readCount(len(restic.ID{}) + 1)
+	idx.packs = make(restic.IDs, 0, packs)
+	for i := 0; i < packs && rd.err == nil; i++ {
+		var data, tree bool
+		packID := rd.readID()
+		packIndex := idx.addToPacks(packID)
+
+		blobs := rd.readCount(minBinaryBlobSize)
+		for j := 0; j < blobs && rd.err == nil; j++ {
+			var tpe restic.BlobType
+			switch t := rd.readByte(); t {
+			case 0:
+				tpe = restic.DataBlob
+				data = true
+			case 1:
+				tpe = restic.TreeBlob
+				tree = true
+			default:
+				if rd.err == nil {
+					return nil, errors.Errorf("Decode: invalid blob type %d", t)
+				}
+			}
+
+			id := rd.readID()
+			offset := rd.readUvarint()
+			length := rd.readUvarint()
+			if rd.err != nil {
+				break
+			}
+
+			if offset > maxuint32 || length > maxuint32 {
+				return nil, errors.New("Decode: offset or length does not fit in uint32")
+			}
+
+			idx.byType[tpe].add(id, packIndex, uint32(offset), uint32(length))
+		}
+
+		if !data && tree {
+			idx.treePacks = append(idx.treePacks, packID)
+		}
+	}
+
+	if version == binaryIndexVersionParity {
+		groups := rd.readCount(1 + 1 + 1)
+		for i := 0; i < groups && rd.err == nil; i++ {
+			group := ParityGroup{ShardSize: uint(rd.readUvarint())}
+
+			packs := rd.readCount(len(restic.ID{}) + 1)
+			group.Packs = make([]ParityPack, 0, packs)
+			for j := 0; j < packs; j++ {
+				id := rd.readID()
+				group.Packs = append(group.Packs, ParityPack{ID: id, Size: uint(rd.readUvarint())})
+			}
+
+			parity := rd.readCount(len(restic.ID{}))
+			group.Parity = make(restic.IDs, 0, parity)
+			for j := 0; j < parity; j++ {
+				group.Parity = append(group.Parity, rd.readID())
+			}
+
+			idx.parity = append(idx.parity, group)
+		}
+	}
+
+	if rd.err != nil {
+		debug.Log("Error %v", rd.err)
+		return nil, errors.Wrap(rd.err, "Decode")
+	}
+
+	if len(rd.buf) != 0 {
+		return nil, errors.Errorf("Decode: %d bytes of trailing data", len(rd.buf))
+	}
+
+	idx.final = true
+
+	debug.Log("done")
+	return idx, nil
+}
@@ -2,6 +2,8 @@ package repository_test
 
 import (
 	"bytes"
+	"context"
+	"io"
 	"math/rand"
 	"sync"
 	"testing"
@@ -329,6 +331,128 @@ func TestIndexUnserialize(t *testing.T) {
 	}
 }
 
+func TestIndexSerializeBinary(t *testing.T) {
+	idx := repository.NewIndex()
+	rtest.OK(t, idx.AddToSupersedes(restic.NewRandomID(), restic.NewRandomID()))
+
+	var blobs []restic.PackedBlob
+	for i := 0; i < 20; i++ {
+		packID := restic.NewRandomID()
+
+		pos := uint(0)
+		for j := 0; j < 10; j++ {
+			tpe := restic.DataBlob
+			if j%3 == 0 {
+				tpe = restic.TreeBlob
+			}
+
+			pb := restic.PackedBlob{
+				Blob: restic.Blob{
+					Type:   tpe,
+					ID:     restic.NewRandomID(),
+					Offset: pos,
+					Length: uint(i*1000 + j),
+				},
+				PackID: packID,
+			}
+			idx.Store(pb)
+			blobs = append(blobs, pb)
+
+			pos += pb.Length
+		}
+	}
+
+	wr := bytes.NewBuffer(nil)
+	rtest.OK(t, idx.EncodeBinary(wr))
+	rtest.Assert(t, repository.IsBinaryIndex(wr.Bytes()), "encoded index is not detected as binary")
+
+	idx2, err := repository.DecodeIndex(wr.Bytes())
+	rtest.OK(t, err)
+	rtest.Assert(t, idx2.Final(), "decoded index is not final")
+	rtest.Equals(t, idx.Supersedes(), idx2.Supersedes())
+	rtest.Assert(t, idx.Packs().Equals(idx2.Packs()), "packs in decoded index do not match")
+
+	for _, pb := range blobs {
+		list, found := idx2.Lookup(pb.ID, pb.Type)
+		rtest.Assert(t, found, "Expected to find blob id %v", pb.ID.Str())
+		rtest.Equals(t, []restic.PackedBlob{pb}, list)
+	}
+
+	// the JSON and binary encodings must contain the same information
+	wrJSON := bytes.NewBuffer(nil)
+	rtest.OK(t, idx.Encode(wrJSON))
+	wrJSON2 := bytes.NewBuffer(nil)
+	rtest.OK(t, idx2.Encode(wrJSON2))
+
+	idx3, err := repository.DecodeIndex(wrJSON.Bytes())
+	rtest.OK(t, err)
+	idx4, err := repository.DecodeIndex(wrJSON2.Bytes())
+	rtest.OK(t, err)
+	for _, pb := range blobs {
+		list3, _ := idx3.Lookup(pb.ID, pb.Type)
+		list4, _ := idx4.Lookup(pb.ID, pb.Type)
+		rtest.Equals(t, list3, list4)
+	}
+}
+
+func TestIndexSerializeParity(t *testing.T) {
+	idx, _ := createRandomIndex(rand.New(rand.NewSource(0)), 10)
+
+	// createRandomIndex returns a final index, copy it to a new one
+	newIdx := repository.NewIndex()
+	for pb := range idx.Each(context.TODO()) {
+		newIdx.Store(pb)
+	}
+
+	var groups []repository.ParityGroup
+	for i := 0; i < 3; i++ {
+		group := repository.ParityGroup{ShardSize: uint(4000000 + i)}
+		for j := 0; j < 10; j++ {
+			group.Packs = append(group.Packs, repository.ParityPack{ID: restic.NewRandomID(), Size: uint(3000000 + j)})
+		}
+		group.Parity = restic.IDs{restic.NewRandomID(), restic.NewRandomID()}
+
+		rtest.OK(t, newIdx.StoreParityGroup(group))
+		groups = append(groups, group)
+	}
+
+	for _, encode := range []func(io.Writer) error{newIdx.Encode, newIdx.EncodeBinary} {
+		wr := bytes.NewBuffer(nil)
+		rtest.OK(t, encode(wr))
+
+		idx2, err := repository.DecodeIndex(wr.Bytes())
+		rtest.OK(t, err)
+		rtest.Equals(t, groups, idx2.ParityGroups())
+		rtest.Assert(t, newIdx.Packs().Equals(idx2.Packs()), "packs in decoded index do not match")
+	}
+
+	newIdx.Finalize()
+	rtest.Assert(t, newIdx.StoreParityGroup(groups[0]) != nil, "storing a parity group in a final index did not fail")
+}
+
+func TestDecodeBinaryIndexInvalid(t *testing.T) {
+	idx, _ := createRandomIndex(rand.New(rand.NewSource(0)), 10)
+	wr := bytes.NewBuffer(nil)
+	rtest.OK(t, idx.EncodeBinary(wr))
+	buf := wr.Bytes()
+
+	for i := len("RIDX"); i < len(buf); i += 7 {
+		_, err := repository.DecodeBinaryIndex(buf[:i])
+		rtest.Assert(t, err != nil, "truncated index with %d of %d bytes decoded without error", i, len(buf))
+	}
+
+	_, err := repository.DecodeBinaryIndex(append(buf[:len(buf):len(buf)], 0))
+	rtest.Assert(t, err != nil, "index with trailing data decoded without error")
+
+	invalid := append([]byte(nil), buf...)
+	invalid[len("RIDX")] = 23
+	_, err = repository.DecodeBinaryIndex(invalid)
+	rtest.Assert(t, err != nil, "index with unknown version decoded without error")
+
+	_, err = repository.DecodeBinaryIndex(docExample)
+	rtest.Assert(t, err != nil, "JSON index decoded as binary index")
+}
+
 var (
 	benchmarkIndexJSON     []byte
 	benchmarkIndexJSONOnce sync.Once
@@ -343,6 +467,7 @@ func initBenchmarkIndexJSON() {
 
 func BenchmarkDecodeIndex(b *testing.B) {
 	benchmarkIndexJSONOnce.Do(initBenchmarkIndexJSON)
+	b.ReportAllocs()
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -353,6 +478,7 @@ func BenchmarkDecodeIndex(b *testing.B) {
 
 func BenchmarkDecodeIndexParallel(b *testing.B) {
 	benchmarkIndexJSONOnce.Do(initBenchmarkIndexJSON)
+	b.ReportAllocs()
 	b.ResetTimer()
 
 	b.RunParallel(func(pb *testing.PB) {
@@ -363,6 +489,42 @@ func BenchmarkDecodeIndexParallel(b *testing.B) {
 	})
 }
 
+var (
+	benchmarkIndexBinary     []byte
+	benchmarkIndexBinaryOnce sync.Once
+)
+
+func initBenchmarkIndexBinary() {
+	idx, _ := createRandomIndex(rand.New(rand.NewSource(0)), 200000)
+	var buf bytes.Buffer
+	idx.EncodeBinary(&buf)
+	benchmarkIndexBinary = buf.Bytes()
+}
+
+func BenchmarkDecodeIndexBinary(b *testing.B) {
+	benchmarkIndexBinaryOnce.Do(initBenchmarkIndexBinary)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := repository.DecodeIndex(benchmarkIndexBinary)
+		rtest.OK(b, err)
+	}
+}
+
+func BenchmarkDecodeIndexBinaryParallel(b *testing.B) {
+	benchmarkIndexBinaryOnce.Do(initBenchmarkIndexBinary)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, err := repository.DecodeIndex(benchmarkIndexBinary)
+			rtest.OK(b, err)
+		}
+	})
+}
+
 func TestIndexUnserializeOld(t *testing.T) {
 	idx, err := repository.DecodeOldIndex(docOldExample)
 	rtest.OK(t, err)
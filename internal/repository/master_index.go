@@ -12,8 +12,12 @@ import (
 // MasterIndex is a collection of indexes and IDs of chunks that are in the process of being saved.
 type MasterIndex struct {
 	idx          []*Index
+	disk         []*DiskIndex
 	pendingBlobs restic.BlobSet
 	idxMutex     sync.RWMutex
+
+	diskDir      string
+	diskMaxBlobs uint
 }
 
 // NewMasterIndex creates a new master index.
@@ -26,6 +30,30 @@ func NewMasterIndex() *MasterIndex {
 	return &MasterIndex{idx: idx, pendingBlobs: restic.NewBlobSet()}
 }
 
+// maxDiskIndexes is the number of DiskIndexes after which they are merged
+// into a single one.
+const maxDiskIndexes = 4
+
+// UseDisk configures the master index to move the final indexes to files in
+// dir as soon as they contain more than maxBlobs blobs. If dir is empty, the
+// default directory for temporary files is used.
+func (mi *MasterIndex) UseDisk(dir string, maxBlobs uint) {
+	mi.idxMutex.Lock()
+	defer mi.idxMutex.Unlock()
+
+	mi.diskDir = dir
+	mi.diskMaxBlobs = maxBlobs
+}
+
+// UsesDisk returns true if UseDisk has been called, so that final indexes are
+// moved to disk.
+func (mi *MasterIndex) UsesDisk() bool {
+	mi.idxMutex.RLock()
+	defer mi.idxMutex.RUnlock()
+
+	return mi.diskMaxBlobs > 0
+}
+
 // Lookup queries all known Indexes for the ID and returns the first match.
 func (mi *MasterIndex) Lookup(id restic.ID, tpe restic.BlobType) (blobs []restic.PackedBlob, found bool) {
 	mi.idxMutex.RLock()
@@ -38,6 +66,13 @@ func (mi *MasterIndex) Lookup(id restic.ID, tpe restic.BlobType) (blobs []restic
 		}
 	}
 
+	for _, idx := range mi.disk {
+		blobs, found = idx.Lookup(id, tpe)
+		if found {
+			return
+		}
+	}
+
 	return nil, false
 }
 
@@ -52,6 +87,12 @@ func (mi *MasterIndex) LookupSize(id restic.ID, tpe restic.BlobType) (uint, bool
 		}
 	}
 
+	for _, idx := range mi.disk {
+		if size, found := idx.LookupSize(id, tpe); found {
+			return size, found
+		}
+	}
+
 	return 0, false
 }
 
@@ -68,6 +109,13 @@ func (mi *MasterIndex) ListPack(id restic.ID) (list []restic.PackedBlob) {
 		}
 	}
 
+	for _, idx := range mi.disk {
+		list := idx.ListPack(id)
+		if len(list) > 0 {
+			return list
+		}
+	}
+
 	return nil
 }
 
@@ -91,6 +139,12 @@ func (mi *MasterIndex) addPending(id restic.ID, tpe restic.BlobType) bool {
 		}
 	}
 
+	for _, idx := range mi.disk {
+		if idx.Has(id, tpe) {
+			return false
+		}
+	}
+
 	// really not known -> insert
 	mi.pendingBlobs.Insert(restic.BlobHandle{ID: id, Type: tpe})
 	return true
@@ -113,6 +167,12 @@ func (mi *MasterIndex) Has(id restic.ID, tpe restic.BlobType) bool {
 		}
 	}
 
+	for _, idx := range mi.disk {
+		if idx.Has(id, tpe) {
+			return true
+		}
+	}
+
 	return false
 }
 
@@ -126,6 +186,10 @@ func (mi *MasterIndex) Count(t restic.BlobType) (n uint) {
 		sum += idx.Count(t)
 	}
 
+	for _, idx := range mi.disk {
+		sum += idx.Count(t)
+	}
+
 	return sum
 }
 
@@ -159,6 +223,56 @@ func (mi *MasterIndex) StorePack(id restic.ID, blobs []restic.Blob) {
 	mi.idx = append(mi.idx, newIdx)
 }
 
+// StoreParityGroup records the parity group in an index which has not been
+// saved yet.
+func (mi *MasterIndex) StoreParityGroup(group ParityGroup) {
+	mi.idxMutex.Lock()
+	defer mi.idxMutex.Unlock()
+
+	for _, idx := range mi.idx {
+		if !idx.Final() {
+			// the index cannot be finalized concurrently, as this requires
+			// holding idxMutex
+			_ = idx.StoreParityGroup(group)
+			return
+		}
+	}
+
+	newIdx := NewIndex()
+	_ = newIdx.StoreParityGroup(group)
+	mi.idx = append(mi.idx, newIdx)
+}
+
+// ParityGroups returns all parity groups contained in the indexes.
+func (mi *MasterIndex) ParityGroups() []ParityGroup {
+	mi.idxMutex.RLock()
+	defer mi.idxMutex.RUnlock()
+
+	var groups []ParityGroup
+	for _, idx := range mi.idx {
+		groups = append(groups, idx.ParityGroups()...)
+	}
+
+	for _, idx := range mi.disk {
+		groups = append(groups, idx.ParityGroups()...)
+	}
+
+	return groups
+}
+
+// ParityGroup returns the parity group which contains the pack id.
+func (mi *MasterIndex) ParityGroup(id restic.ID) (ParityGroup, bool) {
+	for _, group := range mi.ParityGroups() {
+		for _, p := range group.Packs {
+			if p.ID.Equal(id) {
+				return group, true
+			}
+		}
+	}
+
+	return ParityGroup{}, false
+}
+
 // FinalizeNotFinalIndexes finalizes all indexes that
 // have not yet been saved and returns that list
 func (mi *MasterIndex) FinalizeNotFinalIndexes() []*Index {
@@ -205,7 +319,7 @@ func (mi *MasterIndex) FinalizeFullIndexes() []*Index {
 	return list
 }
 
-// All returns all indexes.
+// All returns all indexes kept in memory.
 func (mi *MasterIndex) All() []*Index {
 	mi.idxMutex.Lock()
 	defer mi.idxMutex.Unlock()
@@ -213,6 +327,74 @@ func (mi *MasterIndex) All() []*Index {
 	return mi.idx
 }
 
+// IDs returns the IDs of all index files contained in final indexes.
+func (mi *MasterIndex) IDs() restic.IDSet {
+	mi.idxMutex.RLock()
+	defer mi.idxMutex.RUnlock()
+
+	ids := restic.NewIDSet()
+	for _, idx := range mi.idx {
+		indexIDs, err := idx.IDs()
+		if err != nil {
+			debug.Log("not using index, ID() returned error %v", err)
+			continue
+		}
+		for _, id := range indexIDs {
+			ids.Insert(id)
+		}
+	}
+
+	for _, idx := range mi.disk {
+		for _, id := range idx.IDs() {
+			ids.Insert(id)
+		}
+	}
+
+	return ids
+}
+
+// Packs returns all packs contained in the indexes.
+func (mi *MasterIndex) Packs() restic.IDSet {
+	mi.idxMutex.RLock()
+	defer mi.idxMutex.RUnlock()
+
+	packs := restic.NewIDSet()
+	for _, idx := range mi.idx {
+		for id := range idx.Packs() {
+			packs.Insert(id)
+		}
+	}
+
+	for _, idx := range mi.disk {
+		for id := range idx.Packs() {
+			packs.Insert(id)
+		}
+	}
+
+	return packs
+}
+
+// TreePacks returns all packs which only contain tree blobs.
+func (mi *MasterIndex) TreePacks() restic.IDSet {
+	mi.idxMutex.RLock()
+	defer mi.idxMutex.RUnlock()
+
+	treePacks := restic.NewIDSet()
+	for _, idx := range mi.idx {
+		for _, id := range idx.TreePacks() {
+			treePacks.Insert(id)
+		}
+	}
+
+	for _, idx := range mi.disk {
+		for _, id := range idx.TreePacks() {
+			treePacks.Insert(id)
+		}
+	}
+
+	return treePacks
+}
+
 // Each returns a channel that yields all blobs known to the index. When the
 // context is cancelled, the background goroutine terminates. This blocks any
 // modification of the index.
@@ -237,6 +419,17 @@ func (mi *MasterIndex) Each(ctx context.Context) <-chan restic.PackedBlob {
 				}
 			}
 		}
+
+		for _, idx := range mi.disk {
+			idxCh := idx.Each(ctx)
+			for pb := range idxCh {
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- pb:
+				}
+			}
+		}
 	}()
 
 	return ch
@@ -246,9 +439,11 @@ func (mi *MasterIndex) Each(ctx context.Context) <-chan restic.PackedBlob {
 // After calling, there will be only one big final index in MasterIndex
 // containing all final index contents.
 // Indexes that are not final are left untouched.
-// This merging can only be called after all index files are loaded - as
-// removing of superseded index contents is only possible for unmerged indexes.
-func (mi *MasterIndex) MergeFinalIndexes() {
+// Superseded index contents are not removed when the index files are loaded,
+// so merging can also be done while index files are still being loaded. This
+// is only needed if UseDisk has been called: if the merged index is large
+// enough, it is moved to disk afterwards.
+func (mi *MasterIndex) MergeFinalIndexes() error {
 	mi.idxMutex.Lock()
 	defer mi.idxMutex.Unlock()
 
@@ -265,6 +460,45 @@ func (mi *MasterIndex) MergeFinalIndexes() {
 		}
 	}
 	mi.idx = newIdx
+
+	if mi.diskMaxBlobs == 0 || mi.idx[0].Count(restic.DataBlob)+mi.idx[0].Count(restic.TreeBlob) < mi.diskMaxBlobs {
+		return nil
+	}
+
+	return mi.moveToDisk()
+}
+
+// moveToDisk writes the merged final index to a new DiskIndex and replaces it
+// with an empty index. When too many DiskIndexes exist, they are merged.
+func (mi *MasterIndex) moveToDisk() error {
+	debug.Log("moving final index to disk")
+	diskIdx, err := newDiskIndex(mi.diskDir, mi.idx[0])
+	if err != nil {
+		return err
+	}
+
+	mi.disk = append(mi.disk, diskIdx)
+	mi.idx[0] = NewIndex()
+	mi.idx[0].Finalize()
+
+	if len(mi.disk) <= maxDiskIndexes {
+		return nil
+	}
+
+	debug.Log("merging %d disk indexes", len(mi.disk))
+	diskIdx, err = mergeDiskIndexes(mi.diskDir, mi.disk)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range mi.disk {
+		if err := idx.Close(); err != nil {
+			debug.Log("removing disk index failed: %v", err)
+		}
+	}
+	mi.disk = []*DiskIndex{diskIdx}
+
+	return nil
 }
 
 // RebuildIndex combines all known indexes to a new index, leaving out any
@@ -311,5 +545,22 @@ func (mi *MasterIndex) RebuildIndex(packBlacklist restic.IDSet) (*Index, error)
 		}
 	}
 
+	for i, idx := range mi.disk {
+		debug.Log("adding disk index %d", i)
+
+		for pb := range idx.Each(ctx) {
+			if packBlacklist.Has(pb.PackID) {
+				continue
+			}
+
+			newIndex.Store(pb)
+		}
+
+		err := newIndex.AddToSupersedes(idx.IDs()...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return newIndex, nil
 }
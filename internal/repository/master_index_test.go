@@ -1,6 +1,7 @@
 package repository_test
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"testing"
@@ -109,6 +110,94 @@ func TestMasterMergeFinalIndexes(t *testing.T) {
 	rtest.Assert(t, blobs == nil, "Expected no blobs when fetching with a random id")
 }
 
+func TestMasterIndexDisk(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	rng := rand.New(rand.NewSource(0))
+
+	mIdx := repository.NewMasterIndex()
+	mIdx.UseDisk(tempdir, 100)
+	memIdx := repository.NewMasterIndex()
+
+	var blobs []restic.PackedBlob
+	for i := 0; i < 30; i++ {
+		idx := repository.NewIndex()
+		for j := 0; j < 5; j++ {
+			packID := NewRandomTestID(rng)
+			for k := 0; k < 10; k++ {
+				pb := restic.PackedBlob{
+					PackID: packID,
+					Blob: restic.Blob{
+						Type:   restic.BlobType(restic.DataBlob + restic.BlobType(k%2)),
+						ID:     NewRandomTestID(rng),
+						Offset: uint(k * 100),
+						Length: uint(100 + k),
+					},
+				}
+
+				// store some blobs a second time in another pack
+				if i > 0 && k == 0 {
+					pb.Blob = blobs[rng.Intn(len(blobs))].Blob
+					pb.Offset = 0
+				}
+
+				idx.Store(pb)
+				blobs = append(blobs, pb)
+			}
+		}
+
+		idx.Finalize()
+		mIdx.Insert(idx)
+		rtest.OK(t, mIdx.MergeFinalIndexes())
+		memIdx.Insert(idx)
+	}
+	rtest.OK(t, memIdx.MergeFinalIndexes())
+
+	// at most 100 blobs may be kept in memory
+	for _, idx := range mIdx.All() {
+		n := idx.Count(restic.DataBlob) + idx.Count(restic.TreeBlob)
+		rtest.Assert(t, n < 100, "index with %d blobs kept in memory", n)
+	}
+
+	for _, tpe := range []restic.BlobType{restic.DataBlob, restic.TreeBlob} {
+		rtest.Equals(t, memIdx.Count(tpe), mIdx.Count(tpe))
+	}
+
+	for _, pb := range blobs {
+		rtest.Assert(t, mIdx.Has(pb.ID, pb.Type), "blob %v not found", pb.ID.Str())
+
+		// the master index returns the entries of the first index which
+		// contains the blob, the disk indexes may be split differently
+		list, found := mIdx.Lookup(pb.ID, pb.Type)
+		rtest.Assert(t, found, "blob %v not found", pb.ID.Str())
+		want, _ := memIdx.Lookup(pb.ID, pb.Type)
+		for _, entry := range list {
+			found := false
+			for _, w := range want {
+				found = found || w == entry
+			}
+			rtest.Assert(t, found, "unexpected entry %v for blob %v", entry, pb.ID.Str())
+		}
+
+		size, found := mIdx.LookupSize(pb.ID, pb.Type)
+		rtest.Assert(t, found, "size for blob %v not found", pb.ID.Str())
+		rtest.Equals(t, uint(restic.PlaintextLength(int(pb.Length))), size)
+	}
+
+	rtest.Assert(t, !mIdx.Has(restic.NewRandomID(), restic.DataBlob), "unknown blob found")
+	rtest.Assert(t, !mIdx.Has(blobs[0].ID, restic.TreeBlob), "blob found with wrong type")
+
+	rtest.Equals(t, 10, len(mIdx.ListPack(blobs[0].PackID)))
+	rtest.Assert(t, memIdx.Packs().Equals(mIdx.Packs()), "packs in disk index do not match")
+
+	n := 0
+	for range mIdx.Each(context.TODO()) {
+		n++
+	}
+	rtest.Equals(t, len(blobs), n)
+}
+
 func createRandomMasterIndex(rng *rand.Rand, num, size int) (*repository.MasterIndex, restic.ID) {
 	mIdx := repository.NewMasterIndex()
 	for i := 0; i < num-1; i++ {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"sync"
+	"sync/atomic"
 
 	"github.com/quinn/restic/internal/errors"
 	"github.com/quinn/restic/internal/hashing"
@@ -33,6 +34,7 @@ type Packer struct {
 type packerManager struct {
 	be      Saver
 	key     *crypto.Key
+	padding bool
 	pm      sync.Mutex
 	packers []*Packer
 }
@@ -70,6 +72,9 @@ func (r *packerManager) findPacker() (packer *Packer, err error) {
 
 	hw := hashing.NewWriter(tmpfile, sha256.New())
 	p := pack.NewPacker(r.key, hw)
+	if r.padding {
+		p.EnablePadding()
+	}
 	packer = &Packer{
 		Packer:  p,
 		hw:      hw,
@@ -91,11 +96,14 @@ func (r *packerManager) insertPacker(p *Packer) {
 // savePacker stores p in the backend.
 func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *Packer) error {
 	debug.Log("save packer for %v with %d blobs (%d bytes)\n", t, p.Packer.Count(), p.Packer.Size())
-	_, err := p.Packer.Finalize()
+	size, err := p.Packer.Finalize()
 	if err != nil {
 		return err
 	}
 
+	atomic.AddUint64(&r.paddingBytes, uint64(p.Packer.Padding()))
+	atomic.AddUint64(&r.packBytes, uint64(size))
+
 	id := restic.IDFromHash(p.hw.Sum(nil))
 	h := restic.Handle{Type: restic.DataFile, Name: id.String()}
 
@@ -112,7 +120,7 @@ func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *Packe
 
 	debug.Log("saved as %v", h)
 
-	if t == restic.TreeBlob && r.Cache != nil {
+	if t == restic.TreeBlob && r.Cache != nil && !r.dryRun {
 		debug.Log("saving tree pack file in cache")
 
 		_, err = p.tmpfile.Seek(0, 0)
@@ -126,20 +134,20 @@ func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *Packe
 		}
 	}
 
-	err = p.tmpfile.Close()
-	if err != nil {
-		return errors.Wrap(err, "close tempfile")
-	}
-
-	err = fs.RemoveIfExists(p.tmpfile.Name())
-	if err != nil {
-		return errors.Wrap(err, "Remove")
-	}
-
 	// update blobs in the index
 	debug.Log("  updating blobs %v to pack %v", p.Packer.Blobs(), id)
 	r.idx.StorePack(id, p.Packer.Blobs())
 
+	if r.cfg.Parity != nil {
+		// the pack file is kept until the parity files for its group are saved
+		err = r.addParityPack(ctx, id, p.tmpfile)
+	} else {
+		err = removeTempFile(p.tmpfile)
+	}
+	if err != nil {
+		return err
+	}
+
 	// Save index if full
 	if r.noAutoIndexUpdate {
 		return nil
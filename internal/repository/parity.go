@@ -0,0 +1,471 @@
+package repository
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/quinn/restic/internal/debug"
+	"github.com/quinn/restic/internal/errors"
+	"github.com/quinn/restic/internal/fs"
+	"github.com/quinn/restic/internal/hashing"
+	"github.com/quinn/restic/internal/reedsolomon"
+	"github.com/quinn/restic/internal/restic"
+
+	"github.com/minio/sha256-simd"
+)
+
+// ParityGroup describes a group of packs for which parity files have been
+// saved. The packs and the parity files are the shards of a Reed-Solomon code,
+// packs shorter than ShardSize are padded with zeroes. As long as no more
+// than len(Parity) of the files are damaged or missing, they can be
+// reconstructed from the remaining files.
+type ParityGroup struct {
+	Packs     []ParityPack `json:"packs"`
+	Parity    restic.IDs   `json:"parity"`
+	ShardSize uint         `json:"shard_size"`
+}
+
+// ParityPack is a pack contained in a parity group.
+type ParityPack struct {
+	ID   restic.ID `json:"id"`
+	Size uint      `json:"size"`
+}
+
+// ErrNoParity is returned by RepairPack when no parity files exist for a pack.
+var ErrNoParity = errors.New("no parity files exist for the pack")
+
+// parityChunkSize is the number of bytes of each shard which are processed at
+// once.
+const parityChunkSize = 1024 * 1024
+
+// pendingPack is a saved pack file which is kept around until the parity
+// files for its group have been computed.
+type pendingPack struct {
+	id   restic.ID
+	size int64
+	file *os.File
+}
+
+// parityWriter collects pack files until a parity group is complete.
+type parityWriter struct {
+	m       sync.Mutex
+	pending []pendingPack
+}
+
+// removeTempFile closes and removes the temporary file f.
+func removeTempFile(f *os.File) error {
+	err := f.Close()
+	if err != nil {
+		return errors.Wrap(err, "close tempfile")
+	}
+
+	return errors.Wrap(fs.RemoveIfExists(f.Name()), "Remove")
+}
+
+// addParityPack adds the saved pack file f to the current parity group. As
+// soon as the group is complete, its parity files are saved. The file is
+// closed and removed afterwards.
+func (r *Repository) addParityPack(ctx context.Context, id restic.ID, f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil {
+		_ = removeTempFile(f)
+		return errors.Wrap(err, "Stat")
+	}
+
+	r.parity.m.Lock()
+	r.parity.pending = append(r.parity.pending, pendingPack{id: id, size: fi.Size(), file: f})
+	var group []pendingPack
+	if len(r.parity.pending) >= r.cfg.Parity.DataShards {
+		group = r.parity.pending
+		r.parity.pending = nil
+	}
+	r.parity.m.Unlock()
+
+	if group == nil {
+		return nil
+	}
+
+	return r.saveParityGroup(ctx, group)
+}
+
+// flushParity saves the parity files for all pending packs, even if the group
+// is not complete yet.
+func (r *Repository) flushParity(ctx context.Context) error {
+	r.parity.m.Lock()
+	group := r.parity.pending
+	r.parity.pending = nil
+	r.parity.m.Unlock()
+
+	if len(group) == 0 {
+		return nil
+	}
+
+	return r.saveParityGroup(ctx, group)
+}
+
+// processShards reads the shards from files in chunks and calls fn for each
+// chunk with the offset and the data of all shards. A file may be shorter
+// than shardSize, the missing bytes are read as zeroes. For nil files, the
+// shard is passed as nil to fn.
+func processShards(ctx context.Context, files []*os.File, sizes []int64, shardSize int64, fn func(offset int64, shards [][]byte) error) error {
+	bufs := make([][]byte, len(files))
+	shards := make([][]byte, len(files))
+
+	for offset := int64(0); offset < shardSize; offset += parityChunkSize {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		n := shardSize - offset
+		if n > parityChunkSize {
+			n = parityChunkSize
+		}
+
+		for i, f := range files {
+			shards[i] = nil
+			if f == nil {
+				continue
+			}
+
+			if bufs[i] == nil {
+				bufs[i] = make([]byte, parityChunkSize)
+			}
+			shard := bufs[i][:n]
+
+			avail := sizes[i] - offset
+			if avail < 0 {
+				avail = 0
+			}
+			if avail > n {
+				avail = n
+			}
+
+			_, err := f.ReadAt(shard[:avail], offset)
+			if err != nil && err != io.EOF {
+				return errors.Wrap(err, "ReadAt")
+			}
+
+			for j := avail; j < n; j++ {
+				shard[j] = 0
+			}
+
+			shards[i] = shard
+		}
+
+		err := fn(offset, shards)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// saveParityGroup computes and saves the parity files for the pack files in
+// group and records the group in the index. All pack files are closed and
+// removed.
+func (r *Repository) saveParityGroup(ctx context.Context, group []pendingPack) error {
+	defer func() {
+		for _, p := range group {
+			if err := removeTempFile(p.file); err != nil {
+				debug.Log("unable to remove pack file: %v", err)
+			}
+		}
+	}()
+
+	code, err := reedsolomon.New(len(group), r.cfg.Parity.ParityShards)
+	if err != nil {
+		return errors.Wrap(err, "parity")
+	}
+
+	files := make([]*os.File, len(group)+code.ParityShards())
+	sizes := make([]int64, len(files))
+	var shardSize int64
+	for i, p := range group {
+		files[i] = p.file
+		sizes[i] = p.size
+		if p.size > shardSize {
+			shardSize = p.size
+		}
+	}
+
+	parityFiles := make([]*os.File, code.ParityShards())
+	writers := make([]*hashing.Writer, code.ParityShards())
+	bufs := make([][]byte, code.ParityShards())
+	defer func() {
+		for _, f := range parityFiles {
+			if f == nil {
+				continue
+			}
+			if err := removeTempFile(f); err != nil {
+				debug.Log("unable to remove parity file: %v", err)
+			}
+		}
+	}()
+
+	for i := range parityFiles {
+		parityFiles[i], err = fs.TempFile("", "restic-temp-parity-")
+		if err != nil {
+			return errors.Wrap(err, "fs.TempFile")
+		}
+		writers[i] = hashing.NewWriter(parityFiles[i], sha256.New())
+		bufs[i] = make([]byte, parityChunkSize)
+	}
+
+	debug.Log("computing %d parity files for %d packs, shard size %d", len(parityFiles), len(group), shardSize)
+	err = processShards(ctx, files, sizes, shardSize, func(offset int64, shards [][]byte) error {
+		n := len(shards[0])
+		for i := range bufs {
+			shards[len(group)+i] = bufs[i][:n]
+		}
+
+		err := code.Encode(shards)
+		if err != nil {
+			return err
+		}
+
+		for i, wr := range writers {
+			_, err = wr.Write(shards[len(group)+i])
+			if err != nil {
+				return errors.Wrap(err, "Write")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	pg := ParityGroup{ShardSize: uint(shardSize)}
+	for _, p := range group {
+		pg.Packs = append(pg.Packs, ParityPack{ID: p.id, Size: uint(p.size)})
+	}
+
+	saved := restic.NewIDSet()
+	for i, f := range parityFiles {
+		id := restic.IDFromHash(writers[i].Sum(nil))
+		pg.Parity = append(pg.Parity, id)
+
+		// for groups of a single pack all parity files are equal
+		if saved.Has(id) {
+			continue
+		}
+		saved.Insert(id)
+
+		h := restic.Handle{Type: restic.ParityFile, Name: id.String()}
+
+		rd, err := restic.NewFileReader(f)
+		if err != nil {
+			return err
+		}
+
+		err = r.be.Save(ctx, h, rd)
+		if err != nil {
+			debug.Log("Save(%v) error: %v", h, err)
+			return err
+		}
+	}
+
+	debug.Log("saved parity files %v", pg.Parity)
+	r.idx.StoreParityGroup(pg)
+
+	return nil
+}
+
+// RepairPack reconstructs the pack id from the other files of its parity group
+// and saves it in the backend, replacing a damaged copy if necessary. All other
+// packs of the group which are damaged or missing and still referenced by the
+// index are repaired as well. The IDs of the repaired packs are returned. If
+// the pack is not contained in a parity group, ErrNoParity is returned.
+func (r *Repository) RepairPack(ctx context.Context, id restic.ID) (restic.IDs, error) {
+	group, ok := r.idx.ParityGroup(id)
+	if !ok {
+		return nil, ErrNoParity
+	}
+
+	code, err := reedsolomon.New(len(group.Packs), len(group.Parity))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]*os.File, len(group.Packs)+len(group.Parity))
+	sizes := make([]int64, len(files))
+	exists := make([]bool, len(files))
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				_ = removeTempFile(f)
+			}
+		}
+	}()
+
+	// download all files of the group, damaged and missing files are left out
+	present := 0
+	load := func(i int, h restic.Handle, want restic.ID, wantSize int64) {
+		sizes[i] = wantSize
+
+		// test for missing files first, loading them would be retried
+		ok, err := r.be.Test(ctx, h)
+		if err != nil || !ok {
+			debug.Log("%v is missing: %v", h, err)
+			return
+		}
+		exists[i] = true
+
+		f, hash, size, err := DownloadAndHash(ctx, r.be, h)
+		if err != nil {
+			debug.Log("unable to load %v: %v", h, err)
+			return
+		}
+
+		if !hash.Equal(want) || size != wantSize {
+			debug.Log("%v is damaged", h)
+			_ = removeTempFile(f)
+			return
+		}
+
+		files[i] = f
+		present++
+	}
+
+	for i, p := range group.Packs {
+		load(i, restic.Handle{Type: restic.DataFile, Name: p.ID.String()}, p.ID, int64(p.Size))
+	}
+	for i, parityID := range group.Parity {
+		load(len(group.Packs)+i, restic.Handle{Type: restic.ParityFile, Name: parityID.String()}, parityID, int64(group.ShardSize))
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	// select the packs to reconstruct
+	var repair []int
+	for i, p := range group.Packs {
+		if files[i] != nil {
+			continue
+		}
+
+		if p.ID.Equal(id) || len(r.idx.ListPack(p.ID)) > 0 {
+			repair = append(repair, i)
+		}
+	}
+
+	if len(repair) == 0 {
+		return nil, errors.Errorf("pack %v is not damaged", id.Str())
+	}
+
+	if present < code.DataShards() {
+		return nil, errors.Errorf("unable to repair pack %v: only %d of %d required files of its parity group are intact",
+			id.Str(), present, code.DataShards())
+	}
+
+	outFiles := make([]*os.File, len(repair))
+	writers := make([]*hashing.Writer, len(repair))
+	defer func() {
+		for _, f := range outFiles {
+			if f != nil {
+				_ = removeTempFile(f)
+			}
+		}
+	}()
+
+	for i := range outFiles {
+		outFiles[i], err = fs.TempFile("", "restic-temp-pack-")
+		if err != nil {
+			return nil, errors.Wrap(err, "fs.TempFile")
+		}
+		writers[i] = hashing.NewWriter(outFiles[i], sha256.New())
+	}
+
+	err = processShards(ctx, files, sizes, int64(group.ShardSize), func(offset int64, shards [][]byte) error {
+		err := code.Reconstruct(shards)
+		if err != nil {
+			return err
+		}
+
+		for i, shard := range repair {
+			n := sizes[shard] - offset
+			if n <= 0 {
+				continue
+			}
+			if n > int64(len(shards[shard])) {
+				n = int64(len(shards[shard]))
+			}
+
+			_, err = writers[i].Write(shards[shard][:n])
+			if err != nil {
+				return errors.Wrap(err, "Write")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var repaired restic.IDs
+	for i, shard := range repair {
+		packID := group.Packs[shard].ID
+		hash := restic.IDFromHash(writers[i].Sum(nil))
+		if !hash.Equal(packID) {
+			return repaired, errors.Errorf("reconstructed pack %v has wrong hash %v", packID.Str(), hash.Str())
+		}
+
+		h := restic.Handle{Type: restic.DataFile, Name: packID.String()}
+		if exists[shard] {
+			err = r.be.Remove(ctx, h)
+			if err != nil {
+				return repaired, err
+			}
+		}
+
+		rd, err := restic.NewFileReader(outFiles[i])
+		if err != nil {
+			return repaired, err
+		}
+
+		err = r.be.Save(ctx, h, rd)
+		if err != nil {
+			return repaired, err
+		}
+
+		debug.Log("repaired pack %v", packID)
+		repaired = append(repaired, packID)
+	}
+
+	return repaired, nil
+}
+
+// FilterParityGroups splits the parity groups into the groups which can still
+// be used to reconstruct packs, given that only the packs in packs are
+// available, and the groups which have lost too many packs. Every group is
+// returned at most once.
+func FilterParityGroups(groups []ParityGroup, packs restic.IDSet) (keep, drop []ParityGroup) {
+	seen := restic.NewIDSet()
+	for _, group := range groups {
+		if len(group.Parity) == 0 || seen.Has(group.Parity[0]) {
+			continue
+		}
+		seen.Insert(group.Parity[0])
+
+		removed := 0
+		for _, p := range group.Packs {
+			if !packs.Has(p.ID) {
+				removed++
+			}
+		}
+
+		// a group which has lost as many packs as it has parity files cannot
+		// repair anything anymore
+		if removed < len(group.Parity) {
+			keep = append(keep, group)
+		} else {
+			drop = append(drop, group)
+		}
+	}
+
+	return keep, drop
+}
@@ -0,0 +1,116 @@
+package repository_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/quinn/restic/internal/repository"
+	"github.com/quinn/restic/internal/restic"
+	rtest "github.com/quinn/restic/internal/test"
+)
+
+// damagePack replaces the pack id in the backend with a copy in which one byte
+// is flipped.
+func damagePack(t testing.TB, be restic.Backend, id restic.ID) {
+	h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+	var buf []byte
+	rtest.OK(t, be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) (err error) {
+		buf, err = ioutil.ReadAll(rd)
+		return err
+	}))
+
+	buf[len(buf)/2] ^= 0xff
+	rtest.OK(t, be.Remove(context.TODO(), h))
+	rtest.OK(t, be.Save(context.TODO(), h, restic.NewByteReader(buf)))
+}
+
+func TestRepairPack(t *testing.T) {
+	r, cleanup := repository.TestRepository(t)
+	defer cleanup()
+	repo := r.(*repository.Repository)
+
+	cfg := repo.Config()
+	cfg.Parity = &restic.ParityConfig{DataShards: 3, ParityShards: 2}
+	rtest.OK(t, repo.SaveConfig(context.TODO(), cfg))
+
+	// every blob is large enough to end up in a pack of its own
+	var blobs [][]byte
+	for i := 0; i < 5; i++ {
+		buf := rtest.Random(i, 4*1024*1024+i*1000)
+		_, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, buf, restic.ID{}, false)
+		rtest.OK(t, err)
+		blobs = append(blobs, buf)
+	}
+	rtest.OK(t, repo.Flush(context.TODO()))
+
+	groups := repo.Index().(*repository.MasterIndex).ParityGroups()
+	rtest.Equals(t, 2, len(groups))
+
+	packs := restic.NewIDSet()
+	for _, group := range groups {
+		rtest.Equals(t, 2, len(group.Parity))
+		for _, p := range group.Packs {
+			packs.Insert(p.ID)
+		}
+	}
+	rtest.Equals(t, 5, len(packs))
+
+	// damage one pack and remove another one of the same group
+	group := groups[0]
+	rtest.Equals(t, 3, len(group.Packs))
+	damagePack(t, repo.Backend(), group.Packs[0].ID)
+	rtest.OK(t, repo.Backend().Remove(context.TODO(), restic.Handle{Type: restic.DataFile, Name: group.Packs[2].ID.String()}))
+
+	repaired, err := repo.RepairPack(context.TODO(), group.Packs[0].ID)
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.NewIDSet(group.Packs[0].ID, group.Packs[2].ID), restic.NewIDSet(repaired...))
+
+	for _, buf := range blobs {
+		data, err := repo.LoadBlob(context.TODO(), restic.DataBlob, restic.Hash(buf), nil)
+		rtest.OK(t, err)
+		rtest.Assert(t, bytes.Equal(buf, data), "blob data differs after repair")
+	}
+
+	// an intact pack cannot be repaired
+	_, err = repo.RepairPack(context.TODO(), group.Packs[1].ID)
+	rtest.Assert(t, err != nil, "repairing an intact pack did not return an error")
+
+	// with more damaged files than parity files, repairing must fail
+	for _, p := range group.Packs {
+		damagePack(t, repo.Backend(), p.ID)
+	}
+	_, err = repo.RepairPack(context.TODO(), group.Packs[0].ID)
+	rtest.Assert(t, err != nil, "repairing a group with too many damaged packs did not return an error")
+
+	_, err = repo.RepairPack(context.TODO(), restic.NewRandomID())
+	rtest.Equals(t, repository.ErrNoParity, err)
+}
+
+func TestFilterParityGroups(t *testing.T) {
+	var packs restic.IDs
+	for i := 0; i < 6; i++ {
+		packs = append(packs, restic.NewRandomID())
+	}
+
+	newGroup := func(ids ...restic.ID) repository.ParityGroup {
+		g := repository.ParityGroup{Parity: restic.IDs{restic.NewRandomID(), restic.NewRandomID()}}
+		for _, id := range ids {
+			g.Packs = append(g.Packs, repository.ParityPack{ID: id})
+		}
+		return g
+	}
+
+	intact := newGroup(packs[0], packs[1])
+	oneLost := newGroup(packs[2], packs[3])
+	allLost := newGroup(packs[4], packs[5])
+
+	keep, drop := repository.FilterParityGroups(
+		[]repository.ParityGroup{intact, oneLost, allLost, intact},
+		restic.NewIDSet(packs[0], packs[1], packs[2]))
+
+	rtest.Equals(t, []repository.ParityGroup{intact, oneLost}, keep)
+	rtest.Equals(t, []repository.ParityGroup{allLost}, drop)
+}
@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sync"
+	"sync/atomic"
 
+	"github.com/quinn/restic/internal/backend/dryrun"
 	"github.com/quinn/restic/internal/cache"
 	"github.com/quinn/restic/internal/crypto"
 	"github.com/quinn/restic/internal/debug"
@@ -30,9 +33,26 @@ type Repository struct {
 	idx     *MasterIndex
 	restic.Cache
 	noAutoIndexUpdate bool
+	dryRun            bool
+
+	// configFromBackup is set when the config is missing and has been loaded
+	// from a backup copy, see RecoverConfig
+	configFromBackup bool
 
 	treePM *packerManager
 	dataPM *packerManager
+	parity parityWriter
+
+	// flushM is held for reading while a blob is saved by SaveBlob and for
+	// writing by FlushPacks, so that all blobs for which SaveBlob returned
+	// are contained in the flushed packs even if other blobs are saved
+	// concurrently
+	flushM sync.RWMutex
+
+	// number of padding bytes and total bytes of the packs saved so far,
+	// accessed atomically
+	paddingBytes uint64
+	packBytes    uint64
 }
 
 // New returns a new repository with backend be.
@@ -56,6 +76,173 @@ func (r *Repository) Config() restic.Config {
 	return r.cfg
 }
 
+// SaveConfig replaces the config stored in the repository with cfg. The new
+// config is saved as a backup copy first, so that it can be restored when the
+// repository is opened if saving the config is interrupted after the previous
+// config has been removed. If saving the new config fails, the previous config
+// is restored. A config loaded from a backup copy is recovered first. The
+// caller must hold an exclusive lock.
+func (r *Repository) SaveConfig(ctx context.Context, cfg restic.Config) error {
+	if err := r.RecoverConfig(ctx); err != nil {
+		return err
+	}
+
+	// remove backup copies left by an interrupted change first, so that only
+	// the copy of cfg can be restored
+	if err := r.removeConfigBackups(ctx); err != nil {
+		return err
+	}
+
+	if _, err := r.SaveJSONUnpacked(ctx, restic.ConfigBackupFile, cfg); err != nil {
+		return err
+	}
+
+	h := restic.Handle{Type: restic.ConfigFile}
+	if err := r.be.Remove(ctx, h); err != nil {
+		return err
+	}
+
+	_, err := r.SaveJSONUnpacked(ctx, restic.ConfigFile, cfg)
+	if err != nil {
+		debug.Log("saving config failed, restoring previous config: %v", err)
+		if _, rerr := r.SaveJSONUnpacked(ctx, restic.ConfigFile, r.cfg); rerr != nil {
+			// the new config is loaded from the backup copy when the
+			// repository is opened the next time
+			debug.Log("restoring config failed: %v", rerr)
+			return err
+		}
+
+		if rerr := r.removeConfigBackups(ctx); rerr != nil {
+			debug.Log("removing config backup failed: %v", rerr)
+		}
+		return err
+	}
+
+	r.setConfig(cfg)
+
+	// a remaining backup copy is not used as long as the config exists
+	if err := r.removeConfigBackups(ctx); err != nil {
+		debug.Log("removing config backup failed: %v", err)
+	}
+	return nil
+}
+
+// removeConfigBackups removes all backup copies of the config.
+func (r *Repository) removeConfigBackups(ctx context.Context) error {
+	var ids restic.IDs
+	err := r.List(ctx, restic.ConfigBackupFile, func(id restic.ID, size int64) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		h := restic.Handle{Type: restic.ConfigBackupFile, Name: id.String()}
+		if err := r.be.Remove(ctx, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadConfigBackup returns the config from the backup copy saved by
+// SaveConfig. It is used when the config is missing because saving it was
+// interrupted.
+func (r *Repository) loadConfigBackup(ctx context.Context) (restic.Config, error) {
+	var ids restic.IDs
+	err := r.List(ctx, restic.ConfigBackupFile, func(id restic.ID, size int64) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		return restic.Config{}, err
+	}
+
+	for _, id := range ids {
+		cfg, err := restic.LoadConfigBackup(ctx, r, id)
+		if err != nil {
+			debug.Log("unable to load config backup %v: %v", id, err)
+			continue
+		}
+
+		debug.Log("using config from backup %v", id)
+		return cfg, nil
+	}
+
+	return restic.Config{}, errors.New("config file not found")
+}
+
+// ConfigFromBackup returns true if the config is missing and has been loaded
+// from a backup copy when the repository was opened.
+func (r *Repository) ConfigFromBackup() bool {
+	return r.configFromBackup
+}
+
+// RecoverConfig saves the config loaded from a backup copy as the config of
+// the repository and removes the backup copies. Nothing is done if the config
+// was not missing. The caller must hold an exclusive lock, so that no other
+// client changes the config concurrently.
+func (r *Repository) RecoverConfig(ctx context.Context) error {
+	if !r.configFromBackup {
+		return nil
+	}
+
+	debug.Log("restoring config from backup")
+	if _, err := r.SaveJSONUnpacked(ctx, restic.ConfigFile, r.cfg); err != nil {
+		return err
+	}
+	r.configFromBackup = false
+
+	return r.removeConfigBackups(ctx)
+}
+
+// HasConfigBackup returns true if be contains a backup copy of the config,
+// from which a missing config can be restored.
+func HasConfigBackup(ctx context.Context, be restic.Backend) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := false
+	err := be.List(ctx, restic.ConfigBackupFile, func(fi restic.FileInfo) error {
+		found = true
+		cancel()
+		return nil
+	})
+	if found {
+		return true, nil
+	}
+	return false, err
+}
+
+// setConfig sets the config used by the repository.
+func (r *Repository) setConfig(cfg restic.Config) {
+	r.cfg = cfg
+	r.dataPM.padding = cfg.Padding
+	r.treePM.padding = cfg.Padding
+}
+
+// PaddingOverhead returns the number of padding bytes written to new packs and
+// the total size of the new packs.
+func (r *Repository) PaddingOverhead() (padding, total uint64) {
+	return atomic.LoadUint64(&r.paddingBytes), atomic.LoadUint64(&r.packBytes)
+}
+
+// UseDiskIndex configures the repository to move the index to temporary
+// files in dir as soon as it contains more than maxBlobs blobs.
+func (r *Repository) UseDiskIndex(dir string, maxBlobs uint) {
+	r.idx.UseDisk(dir, maxBlobs)
+}
+
+// SetDryRun sets the repository into dry-run mode: all operations which would
+// modify the repository are silently ignored, and no tree packs are added to
+// the local cache.
+func (r *Repository) SetDryRun() {
+	r.be = dryrun.New(r.be)
+	r.dryRun = true
+}
+
 // UseCache replaces the backend with the wrapped cache.
 func (r *Repository) UseCache(c restic.Cache) {
 	if c == nil {
@@ -322,8 +509,12 @@ func (r *Repository) Flush(ctx context.Context) error {
 	return r.SaveIndex(ctx)
 }
 
-// FlushPacks saves all remaining packs.
+// FlushPacks saves all remaining packs. Blobs which are being saved
+// concurrently are added to the packs before they are flushed.
 func (r *Repository) FlushPacks(ctx context.Context) error {
+	r.flushM.Lock()
+	defer r.flushM.Unlock()
+
 	pms := []struct {
 		t  restic.BlobType
 		pm *packerManager
@@ -346,6 +537,10 @@ func (r *Repository) FlushPacks(ctx context.Context) error {
 		p.pm.packers = p.pm.packers[:0]
 		p.pm.pm.Unlock()
 	}
+
+	if r.cfg.Parity != nil {
+		return r.flushParity(ctx)
+	}
 	return nil
 }
 
@@ -363,26 +558,29 @@ func (r *Repository) Index() restic.Index {
 func (r *Repository) SetIndex(i restic.Index) error {
 	r.idx = i.(*MasterIndex)
 
-	ids := restic.NewIDSet()
-	for _, idx := range r.idx.All() {
-		indexIDs, err := idx.IDs()
-		if err != nil {
-			debug.Log("not using index, ID() returned error %v", err)
-			continue
-		}
-		for _, id := range indexIDs {
-			ids.Insert(id)
-		}
-	}
+	return r.PrepareCache(r.idx.IDs())
+}
 
-	return r.PrepareCache(ids)
+// IndexSaver saves index files to a repository.
+type IndexSaver interface {
+	Config() restic.Config
+	SaveUnpacked(ctx context.Context, t restic.FileType, buf []byte) (restic.ID, error)
 }
 
-// SaveIndex saves an index in the repository.
-func SaveIndex(ctx context.Context, repo restic.Repository, index *Index) (restic.ID, error) {
+// SaveIndex saves an index in the repository, using the index format
+// configured for the repository.
+func SaveIndex(ctx context.Context, repo IndexSaver, index *Index) (restic.ID, error) {
 	buf := bytes.NewBuffer(nil)
 
-	err := index.Encode(buf)
+	var err error
+	switch repo.Config().IndexFormat {
+	case restic.IndexFormatJSON:
+		err = index.Encode(buf)
+	case restic.IndexFormatBinary:
+		err = index.EncodeBinary(buf)
+	default:
+		err = errors.Errorf("unknown index format %q", repo.Config().IndexFormat)
+	}
 	if err != nil {
 		return restic.ID{}, err
 	}
@@ -402,9 +600,8 @@ func (r *Repository) saveIndex(ctx context.Context, indexes ...*Index) error {
 
 		debug.Log("Saved index %d as %v", i, sid)
 	}
-	r.idx.MergeFinalIndexes()
 
-	return nil
+	return r.idx.MergeFinalIndexes()
 }
 
 // SaveIndex saves all new indexes in the backend.
@@ -494,9 +691,16 @@ func (r *Repository) LoadIndex(ctx context.Context) error {
 			}
 
 			r.idx.Insert(idx)
+
+			// merge the indexes right away, so they can be moved to disk
+			// before all index files have been loaded
+			if r.idx.UsesDisk() {
+				if err := r.idx.MergeFinalIndexes(); err != nil {
+					return err
+				}
+			}
 		}
-		r.idx.MergeFinalIndexes()
-		return nil
+		return r.idx.MergeFinalIndexes()
 	})
 
 	err := wg.Wait()
@@ -528,25 +732,13 @@ func (r *Repository) PrepareCache(indexIDs restic.IDSet) error {
 		fmt.Fprintf(os.Stderr, "error clearing index files in cache: %v\n", err)
 	}
 
-	packs := restic.NewIDSet()
-	for _, idx := range r.idx.All() {
-		for id := range idx.Packs() {
-			packs.Insert(id)
-		}
-	}
-
 	// clear old data files
-	err = r.Cache.Clear(restic.DataFile, packs)
+	err = r.Cache.Clear(restic.DataFile, r.idx.Packs())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error clearing data files in cache: %v\n", err)
 	}
 
-	treePacks := restic.NewIDSet()
-	for _, idx := range r.idx.All() {
-		for _, id := range idx.TreePacks() {
-			treePacks.Insert(id)
-		}
-	}
+	treePacks := r.idx.TreePacks()
 
 	// use readahead
 	debug.Log("using readahead")
@@ -602,10 +794,25 @@ func (r *Repository) SearchKey(ctx context.Context, password string, maxKeys int
 	r.dataPM.key = key.master
 	r.treePM.key = key.master
 	r.keyName = key.Name()
-	r.cfg, err = restic.LoadConfig(ctx, r)
+
+	// a missing config is only read from its backup copy here, it is saved
+	// again by RecoverConfig under an exclusive lock
+	has, err := r.be.Test(ctx, restic.Handle{Type: restic.ConfigFile})
+	if err != nil {
+		return errors.Fatalf("config cannot be loaded: %v", err)
+	}
+
+	var cfg restic.Config
+	if has {
+		cfg, err = restic.LoadConfig(ctx, r)
+	} else {
+		cfg, err = r.loadConfigBackup(ctx)
+		r.configFromBackup = err == nil
+	}
 	if err != nil {
 		return errors.Fatalf("config cannot be loaded: %v", err)
 	}
+	r.setConfig(cfg)
 	return nil
 }
 
@@ -640,7 +847,7 @@ func (r *Repository) init(ctx context.Context, password string, cfg restic.Confi
 	r.dataPM.key = key.master
 	r.treePM.key = key.master
 	r.keyName = key.Name()
-	r.cfg = cfg
+	r.setConfig(cfg)
 	_, err = r.SaveJSONUnpacked(ctx, restic.ConfigFile, cfg)
 	return err
 }
@@ -680,6 +887,13 @@ func (r *Repository) ListPack(ctx context.Context, id restic.ID, size int64) ([]
 	return blobs, size, nil
 }
 
+// SalvagePack recovers the blobs from the pack id whose header cannot be read,
+// see pack.Salvage. hints are offsets at which blobs may start.
+func (r *Repository) SalvagePack(ctx context.Context, id restic.ID, size int64, hints []uint) ([]pack.SalvagedBlob, uint, error) {
+	h := restic.Handle{Type: restic.DataFile, Name: id.String()}
+	return pack.Salvage(r.Key(), restic.ReaderAt(r.Backend(), h), size, hints)
+}
+
 // Delete calls backend.Delete() if implemented, and returns an error
 // otherwise.
 func (r *Repository) Delete(ctx context.Context) error {
@@ -705,6 +919,9 @@ func (r *Repository) SaveBlob(ctx context.Context, t restic.BlobType, buf []byte
 		newID = id
 	}
 
+	r.flushM.RLock()
+	defer r.flushM.RUnlock()
+
 	// first try to add to pending blobs; if not successful, this blob is already known
 	known = !r.idx.addPending(newID, t)
 
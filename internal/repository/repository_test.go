@@ -13,6 +13,7 @@ import (
 	"github.com/quinn/restic/internal/archiver"
 	"github.com/quinn/restic/internal/errors"
 	"github.com/quinn/restic/internal/fs"
+	"github.com/quinn/restic/internal/pack"
 	"github.com/quinn/restic/internal/repository"
 	"github.com/quinn/restic/internal/restic"
 	rtest "github.com/quinn/restic/internal/test"
@@ -57,6 +58,101 @@ func TestSave(t *testing.T) {
 	}
 }
 
+func TestSavePadded(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	cfg := repo.Config()
+	cfg.Padding = true
+	rtest.OK(t, repo.SaveConfig(context.TODO(), cfg))
+
+	var ids restic.IDs
+	for _, size := range testSizes {
+		data := make([]byte, size)
+		_, err := io.ReadFull(rnd, data)
+		rtest.OK(t, err)
+
+		id, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{}, false)
+		rtest.OK(t, err)
+		ids = append(ids, id)
+	}
+
+	rtest.OK(t, repo.Flush(context.Background()))
+
+	padding, total := repo.(*repository.Repository).PaddingOverhead()
+	rtest.Assert(t, padding > 0 && padding < total, "invalid padding overhead %d of %d", padding, total)
+
+	// all packs are padded and can be listed
+	err := repo.List(context.TODO(), restic.DataFile, func(id restic.ID, size int64) error {
+		rtest.Equals(t, pack.PaddedSize(uint(size)), uint(size))
+
+		blobs, _, err := repo.ListPack(context.TODO(), id, size)
+		rtest.OK(t, err)
+		rtest.Assert(t, len(blobs) > 0, "pack %v contains no blobs", id.Str())
+		return nil
+	})
+	rtest.OK(t, err)
+
+	for i, id := range ids {
+		buf, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, nil)
+		rtest.OK(t, err)
+		rtest.Equals(t, testSizes[i], len(buf))
+		rtest.Equals(t, id, restic.Hash(buf))
+	}
+}
+
+func TestSaveConfigRecover(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	r := repo.(*repository.Repository)
+	cfg := repo.Config()
+	cfg.Padding = true
+	rtest.OK(t, r.SaveConfig(context.TODO(), cfg))
+
+	// no backup copy is left after the config has been saved
+	found, err := repository.HasConfigBackup(context.TODO(), repo.Backend())
+	rtest.OK(t, err)
+	rtest.Assert(t, !found, "config backup was not removed")
+
+	// simulate an interrupted change: the backup copy of the new config has
+	// been saved and the previous config removed
+	cfg.Padding = false
+	_, err = r.SaveJSONUnpacked(context.TODO(), restic.ConfigBackupFile, cfg)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Backend().Remove(context.TODO(), restic.Handle{Type: restic.ConfigFile}))
+
+	found, err = repository.HasConfigBackup(context.TODO(), repo.Backend())
+	rtest.OK(t, err)
+	rtest.Assert(t, found, "config backup not found")
+
+	// opening the repository only loads the config from the backup copy
+	r2 := repository.New(repo.Backend())
+	rtest.OK(t, r2.SearchKey(context.TODO(), rtest.TestPassword, 10, ""))
+	rtest.Equals(t, cfg, r2.Config())
+	rtest.Assert(t, r2.ConfigFromBackup(), "config was not loaded from the backup copy")
+
+	has, err := repo.Backend().Test(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+	rtest.OK(t, err)
+	rtest.Assert(t, !has, "config was restored when opening the repository")
+
+	rtest.OK(t, r2.RecoverConfig(context.TODO()))
+	rtest.Assert(t, !r2.ConfigFromBackup(), "config still marked as loaded from the backup copy")
+
+	has, err = repo.Backend().Test(context.TODO(), restic.Handle{Type: restic.ConfigFile})
+	rtest.OK(t, err)
+	rtest.Assert(t, has, "config was not restored")
+
+	found, err = repository.HasConfigBackup(context.TODO(), repo.Backend())
+	rtest.OK(t, err)
+	rtest.Assert(t, !found, "config backup was not removed")
+
+	r3 := repository.New(repo.Backend())
+	rtest.OK(t, r3.SearchKey(context.TODO(), rtest.TestPassword, 10, ""))
+	rtest.Equals(t, cfg, r3.Config())
+	rtest.Assert(t, !r3.ConfigFromBackup(), "restored config was not used")
+}
+
 func TestSaveFrom(t *testing.T) {
 	repo, cleanup := repository.TestRepository(t)
 	defer cleanup()
@@ -3,6 +3,7 @@ package restic
 import (
 	"context"
 	"io"
+	"time"
 )
 
 // Backend is used to store and access data.
@@ -58,4 +59,8 @@ type Backend interface {
 type FileInfo struct {
 	Size int64
 	Name string
+
+	// ModTime is the time the file was last modified, it is zero if the
+	// backend does not report it.
+	ModTime time.Time
 }
@@ -13,15 +13,43 @@ import (
 
 // Config contains the configuration for a repository.
 type Config struct {
-	Version           uint        `json:"version"`
-	ID                string      `json:"id"`
-	ChunkerPolynomial chunker.Pol `json:"chunker_polynomial"`
+	Version           uint          `json:"version"`
+	ID                string        `json:"id"`
+	ChunkerPolynomial chunker.Pol   `json:"chunker_polynomial"`
+	IndexFormat       string        `json:"index_format,omitempty"`
+	Parity            *ParityConfig `json:"parity,omitempty"`
+
+	// Padding is set when packs are padded to hide their sizes.
+	Padding bool `json:"padding,omitempty"`
 }
 
+// ParityConfig configures the parity files written for groups of packs. For
+// every DataShards packs, ParityShards parity files are written, which allows
+// reconstructing up to ParityShards damaged or missing packs of the group.
+type ParityConfig struct {
+	DataShards   int `json:"data_shards"`
+	ParityShards int `json:"parity_shards"`
+}
+
+// Index formats which can be set in the repository config. New index files are
+// written in the configured format, the empty string selects the JSON format.
+const (
+	IndexFormatJSON   = ""
+	IndexFormatBinary = "binary"
+)
+
 // RepoVersion is the version that is written to the config when a repository
 // is newly created with Init().
 const RepoVersion = 1
 
+// RepoVersionBinaryIndex is the version of repositories which may contain
+// index files in the binary format. Older versions of restic only support
+// RepoVersion and refuse to open such a repository.
+const RepoVersionBinaryIndex = 2
+
+// MaxRepoVersion is the highest repository version which is supported.
+const MaxRepoVersion = RepoVersionBinaryIndex
+
 // JSONUnpackedLoader loads unpacked JSON.
 type JSONUnpackedLoader interface {
 	LoadJSONUnpacked(context.Context, FileType, ID, interface{}) error
@@ -68,16 +96,25 @@ func TestDisableCheckPolynomial(t testing.TB) {
 
 // LoadConfig returns loads, checks and returns the config for a repository.
 func LoadConfig(ctx context.Context, r JSONUnpackedLoader) (Config, error) {
+	return loadConfig(ctx, r, ConfigFile, ID{})
+}
+
+// LoadConfigBackup loads, checks and returns the backup copy id of the config.
+func LoadConfigBackup(ctx context.Context, r JSONUnpackedLoader, id ID) (Config, error) {
+	return loadConfig(ctx, r, ConfigBackupFile, id)
+}
+
+func loadConfig(ctx context.Context, r JSONUnpackedLoader, t FileType, id ID) (Config, error) {
 	var (
 		cfg Config
 	)
 
-	err := r.LoadJSONUnpacked(ctx, ConfigFile, ID{}, &cfg)
+	err := r.LoadJSONUnpacked(ctx, t, id, &cfg)
 	if err != nil {
 		return Config{}, err
 	}
 
-	if cfg.Version != RepoVersion {
+	if cfg.Version < RepoVersion || cfg.Version > MaxRepoVersion {
 		return Config{}, errors.New("unsupported repository version")
 	}
 
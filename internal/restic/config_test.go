@@ -54,3 +54,32 @@ func TestConfig(t *testing.T) {
 	rtest.Assert(t, cfg1 == cfg2,
 		"configs aren't equal: %v != %v", cfg1, cfg2)
 }
+
+func TestConfigVersion(t *testing.T) {
+	for _, test := range []struct {
+		version uint
+		ok      bool
+	}{
+		{0, false},
+		{restic.RepoVersion, true},
+		{restic.RepoVersionBinaryIndex, true},
+		{restic.MaxRepoVersion + 1, false},
+	} {
+		cfg, err := restic.CreateConfig()
+		rtest.OK(t, err)
+		cfg.Version = test.version
+
+		load := func(ctx context.Context, tpe restic.FileType, id restic.ID, arg interface{}) error {
+			*arg.(*restic.Config) = cfg
+			return nil
+		}
+
+		_, err = restic.LoadConfig(context.TODO(), loader(load))
+		if test.ok && err != nil {
+			t.Errorf("version %v: unexpected error %v", test.version, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("version %v: unsupported version was accepted", test.version)
+		}
+	}
+}
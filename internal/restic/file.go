@@ -17,6 +17,11 @@ const (
 	SnapshotFile          = "snapshot"
 	IndexFile             = "index"
 	ConfigFile            = "config"
+	ParityFile            = "parity"
+	TombstoneFile         = "tombstone"
+	VerificationFile      = "verification"
+	TempFile              = "tmp"
+	ConfigBackupFile      = "config-backup"
 )
 
 // Handle is used to store and access data in a backend.
@@ -46,6 +51,11 @@ func (h Handle) Valid() error {
 	case SnapshotFile:
 	case IndexFile:
 	case ConfigFile:
+	case ParityFile:
+	case TombstoneFile:
+	case VerificationFile:
+	case TempFile:
+	case ConfigBackupFile:
 	default:
 		return errors.Errorf("invalid Type %q", h.Type)
 	}
@@ -0,0 +1,15 @@
+// +build !linux
+
+package restic
+
+// GetInodeFlags returns the inode flags of the file or directory path. Inode
+// flags are only supported on Linux, so zero is returned.
+func GetInodeFlags(path string) (uint32, error) {
+	return 0, nil
+}
+
+// SetInodeFlags sets the inode flags of the file or directory path. Inode flags
+// are only supported on Linux, nothing is done.
+func SetInodeFlags(path string, flags uint32) error {
+	return nil
+}
@@ -0,0 +1,73 @@
+package restic
+
+import (
+	"strings"
+
+	"github.com/quinn/restic/internal/errors"
+)
+
+// Label is a key/value pair attached to a snapshot.
+type Label struct {
+	Key   string
+	Value string
+}
+
+// ParseLabel parses a label given as key=value. The value may be empty.
+func ParseLabel(s string) (Label, error) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return Label{}, errors.Errorf("invalid label %q, must be key=value", s)
+	}
+
+	key := strings.TrimSpace(s[:i])
+	if key == "" {
+		return Label{}, errors.Errorf("invalid label %q, key is empty", s)
+	}
+
+	return Label{Key: key, Value: s[i+1:]}, nil
+}
+
+func (l Label) String() string {
+	return l.Key + "=" + l.Value
+}
+
+// LabelList is a list of labels.
+type LabelList []Label
+
+func (l LabelList) String() string {
+	labels := make([]string, 0, len(l))
+	for _, label := range l {
+		labels = append(labels, label.String())
+	}
+	return "[" + strings.Join(labels, ", ") + "]"
+}
+
+// Set parses s as a label and adds it to the LabelList.
+func (l *LabelList) Set(s string) error {
+	label, err := ParseLabel(s)
+	if err != nil {
+		return err
+	}
+
+	*l = append(*l, label)
+	return nil
+}
+
+// Type returns a description of the type.
+func (LabelList) Type() string {
+	return "LabelList"
+}
+
+// Map returns the labels as a map, a later label replaces an earlier one with
+// the same key. For an empty list, nil is returned.
+func (l LabelList) Map() map[string]string {
+	if len(l) == 0 {
+		return nil
+	}
+
+	m := make(map[string]string, len(l))
+	for _, label := range l {
+		m[label.Key] = label.Value
+	}
+	return m
+}
@@ -92,6 +92,29 @@ func TestExclusiveLockOnLockedRepo(t *testing.T) {
 	rtest.OK(t, elock.Unlock())
 }
 
+func TestPruneLock(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	plock, err := restic.NewPruneLock(context.TODO(), repo)
+	rtest.OK(t, err)
+
+	// normal locks can be acquired while prune runs
+	lock, err := restic.NewLock(context.TODO(), repo)
+	rtest.OK(t, err)
+
+	_, err = restic.NewPruneLock(context.TODO(), repo)
+	rtest.Assert(t, restic.IsAlreadyLocked(err),
+		"create second prune lock didn't return the correct error, got %v", err)
+
+	_, err = restic.NewExclusiveLock(context.TODO(), repo)
+	rtest.Assert(t, restic.IsAlreadyLocked(err),
+		"create exclusive lock with prune locked repo didn't return the correct error, got %v", err)
+
+	rtest.OK(t, lock.Unlock())
+	rtest.OK(t, plock.Unlock())
+}
+
 func createFakeLock(repo restic.Repository, t time.Time, pid int) (restic.ID, error) {
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -44,7 +44,8 @@ type Node struct {
 	Links              uint64              `json:"links,omitempty"`
 	LinkTarget         string              `json:"linktarget,omitempty"`
 	ExtendedAttributes []ExtendedAttribute `json:"extended_attributes,omitempty"`
-	Device             uint64              `json:"device,omitempty"` // in case of Type == "dev", stat.st_rdev
+	Device             uint64              `json:"device,omitempty"`      // in case of Type == "dev", stat.st_rdev
+	InodeFlags         uint32              `json:"inode_flags,omitempty"` // Linux inode flags, see chattr(1)
 	Content            IDs                 `json:"content"`
 	Subtree            *ID                 `json:"subtree,omitempty"`
 
@@ -53,6 +54,68 @@ type Node struct {
 	Path string `json:"-"`
 }
 
+// Linux inode flags which are saved in Node.InodeFlags, see chattr(1). The
+// other flags describe the internal state of the file system and are neither
+// saved nor restored.
+const (
+	InodeFlagCompress  = 0x00000004 // c
+	InodeFlagSync      = 0x00000008 // S
+	InodeFlagImmutable = 0x00000010 // i
+	InodeFlagAppend    = 0x00000020 // a
+	InodeFlagNoDump    = 0x00000040 // d
+	InodeFlagNoAtime   = 0x00000080 // A
+	InodeFlagDirSync   = 0x00010000 // D
+	InodeFlagTopDir    = 0x00020000 // T
+	InodeFlagNoCOW     = 0x00800000 // C
+
+	inodeFlagsMask = InodeFlagCompress | InodeFlagSync | InodeFlagImmutable |
+		InodeFlagAppend | InodeFlagNoDump | InodeFlagNoAtime | InodeFlagDirSync |
+		InodeFlagTopDir | InodeFlagNoCOW
+)
+
+// InodeFlagsFileInfo is an os.FileInfo together with the inode flags of the
+// file, see WithInodeFlags.
+type InodeFlagsFileInfo struct {
+	os.FileInfo
+	InodeFlags uint32
+}
+
+// WithInodeFlags reads the inode flags of the file or directory path and
+// returns them together with fi, so that they are read only once when they
+// are needed several times, e.g. to check the nodump flag and for the node.
+// For other types of files, files which are not on the local file system and
+// if the flags cannot be read, fi is returned unchanged.
+func WithInodeFlags(path string, fi os.FileInfo) os.FileInfo {
+	if _, ok := fi.(InodeFlagsFileInfo); ok {
+		return fi
+	}
+
+	if !fi.Mode().IsRegular() && !fi.IsDir() {
+		return fi
+	}
+
+	if _, ok := toStatT(fi.Sys()); !ok {
+		return fi
+	}
+
+	flags, err := GetInodeFlags(path)
+	if err != nil {
+		debug.Log("unable to get inode flags of %v: %v", path, err)
+		return fi
+	}
+
+	return InodeFlagsFileInfo{FileInfo: fi, InodeFlags: flags}
+}
+
+// InodeFlags returns the inode flags of the file or directory path. If fi has
+// been returned by WithInodeFlags, the flags are not read again.
+func InodeFlags(path string, fi os.FileInfo) (uint32, error) {
+	if ifi, ok := fi.(InodeFlagsFileInfo); ok {
+		return ifi.InodeFlags, nil
+	}
+	return GetInodeFlags(path)
+}
+
 // Nodes is a slice of nodes that can be sorted.
 type Nodes []*Node
 
@@ -223,6 +286,27 @@ func (node Node) restoreMetadata(path string) error {
 	return firsterr
 }
 
+// RestoreInodeFlags restores the Linux inode flags of the node. It must be
+// called after the content and all other metadata have been restored, because
+// the immutable and append-only flags prevent any further modification.
+func (node Node) RestoreInodeFlags(path string) error {
+	if node.InodeFlags == 0 || (node.Type != "file" && node.Type != "dir") {
+		return nil
+	}
+
+	flags := node.InodeFlags & inodeFlagsMask
+	err := SetInodeFlags(path, flags)
+	if err != nil && os.Geteuid() > 0 && os.IsPermission(err) {
+		// setting the immutable and append-only flags requires root
+		// privileges, restore the other flags at least
+		debug.Log("not running as root, ignoring immutable and append-only flags for %v: %v",
+			path, err)
+		err = SetInodeFlags(path, flags&^(InodeFlagImmutable|InodeFlagAppend))
+	}
+
+	return errors.Wrap(err, "SetInodeFlags")
+}
+
 func (node Node) restoreExtendedAttributes(path string) error {
 	for _, attr := range node.ExtendedAttributes {
 		err := Setxattr(path, attr.Name, attr.Value)
@@ -413,6 +497,9 @@ func (node Node) Equals(other Node) bool {
 	if node.Device != other.Device {
 		return false
 	}
+	if node.InodeFlags != other.InodeFlags {
+		return false
+	}
 	if !node.sameContent(other) {
 		return false
 	}
@@ -583,6 +670,10 @@ func lookupGroup(gid string) (string, error) {
 }
 
 func (node *Node) fillExtra(path string, fi os.FileInfo) error {
+	if entry, ok := fi.Sys().(*fs.TarEntry); ok {
+		return node.fillTarEntry(entry)
+	}
+
 	stat, ok := toStatT(fi.Sys())
 	if !ok {
 		// fill minimal info with current values for uid, gid
@@ -630,6 +721,26 @@ func (node *Node) fillExtra(path string, fi os.FileInfo) error {
 		return err
 	}
 
+	if err = node.fillInodeFlags(path, fi); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (node *Node) fillInodeFlags(path string, fi os.FileInfo) error {
+	// the flags can only be read from an opened file, devices and fifos
+	// must not be opened
+	if node.Type != "file" && node.Type != "dir" {
+		return nil
+	}
+
+	flags, err := InodeFlags(path, fi)
+	if err != nil {
+		return err
+	}
+
+	node.InodeFlags = flags & inodeFlagsMask
 	return nil
 }
 
@@ -1,6 +1,7 @@
 package restic
 
 import (
+	"os"
 	"path/filepath"
 	"syscall"
 
@@ -39,3 +40,83 @@ func (node Node) device() int {
 func (s statUnix) atim() syscall.Timespec { return s.Atim }
 func (s statUnix) mtim() syscall.Timespec { return s.Mtim }
 func (s statUnix) ctim() syscall.Timespec { return s.Ctim }
+
+// The ioctl requests FS_IOC_GETFLAGS and FS_IOC_SETFLAGS are not defined in
+// golang.org/x/sys/unix. They are encoded like BLKGETSIZE64 and BLKBSZSET
+// (read and write a value of the size of a long), which differs between
+// architectures.
+const (
+	fsIocGetFlags = unix.BLKGETSIZE64&^0xffff | 'f'<<8 | 1
+	fsIocSetFlags = unix.BLKBSZSET&^0xffff | 'f'<<8 | 2
+)
+
+// openForInodeFlags opens path so that the inode flags can be read or changed.
+func openForInodeFlags(path string) (int, error) {
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return fd, nil
+}
+
+// inodeFlagsUnsupported returns true if err is returned because the file
+// system does not support inode flags.
+func inodeFlagsUnsupported(err error) bool {
+	return err == unix.ENOTTY || err == unix.ENOTSUP || err == unix.EINVAL
+}
+
+// GetInodeFlags returns the inode flags of the file or directory path (see
+// chattr(1)). Zero is returned if the file system does not support them.
+func GetInodeFlags(path string) (uint32, error) {
+	fd, err := openForInodeFlags(path)
+	if err != nil {
+		return 0, err
+	}
+	defer unix.Close(fd)
+
+	// the kernel reads and writes an int, although the requests are defined
+	// with the size of a long
+	flags, err := unix.IoctlGetUint32(fd, fsIocGetFlags)
+	if inodeFlagsUnsupported(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, &os.PathError{Op: "ioctl", Path: path, Err: err}
+	}
+
+	return flags, nil
+}
+
+// SetInodeFlags sets the inode flags of the file or directory path which are
+// saved in Node.InodeFlags to flags, all other flags are left unchanged.
+// Nothing is done if the file system does not support inode flags.
+func SetInodeFlags(path string, flags uint32) error {
+	fd, err := openForInodeFlags(path)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	current, err := unix.IoctlGetUint32(fd, fsIocGetFlags)
+	if inodeFlagsUnsupported(err) {
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "ioctl", Path: path, Err: err}
+	}
+
+	flags |= current &^ inodeFlagsMask
+	if flags == current {
+		return nil
+	}
+
+	err = unix.IoctlSetPointerInt(fd, fsIocSetFlags, int(flags))
+	if inodeFlagsUnsupported(err) {
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "ioctl", Path: path, Err: err}
+	}
+
+	return nil
+}
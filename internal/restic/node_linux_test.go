@@ -0,0 +1,83 @@
+package restic_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/quinn/restic/internal/restic"
+	rtest "github.com/quinn/restic/internal/test"
+)
+
+func TestNodeInodeFlags(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	filename := filepath.Join(tempdir, "file")
+	rtest.OK(t, ioutil.WriteFile(filename, []byte("content"), 0644))
+
+	want := uint32(restic.InodeFlagNoDump | restic.InodeFlagNoAtime)
+	rtest.OK(t, restic.SetInodeFlags(filename, want))
+	flags, err := restic.GetInodeFlags(filename)
+	rtest.OK(t, err)
+	if flags&want != want {
+		t.Skip("file system does not support inode flags")
+	}
+
+	fi, err := os.Lstat(filename)
+	rtest.OK(t, err)
+	node, err := restic.NodeFromFileInfo(filename, fi)
+	rtest.OK(t, err)
+	rtest.Equals(t, want, node.InodeFlags)
+
+	// only the flags of the node are changed
+	restored := filepath.Join(tempdir, "restored")
+	rtest.OK(t, ioutil.WriteFile(restored, []byte("content"), 0644))
+	rtest.OK(t, restic.SetInodeFlags(restored, restic.InodeFlagSync))
+	rtest.OK(t, node.RestoreInodeFlags(restored))
+
+	flags, err = restic.GetInodeFlags(restored)
+	rtest.OK(t, err)
+	rtest.Equals(t, want, flags&want)
+	rtest.Assert(t, flags&restic.InodeFlagSync == 0, "sync flag was not removed")
+
+	if os.Geteuid() != 0 {
+		return
+	}
+
+	node.InodeFlags |= restic.InodeFlagImmutable
+	rtest.OK(t, node.RestoreInodeFlags(restored))
+	err = ioutil.WriteFile(restored, []byte("modified"), 0644)
+	rtest.Assert(t, err != nil, "immutable file was modified")
+
+	// remove the immutable flag so that the file can be removed
+	rtest.OK(t, restic.SetInodeFlags(restored, 0))
+}
+
+func TestNodeInodeFlagsFileInfo(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	filename := filepath.Join(tempdir, "file")
+	rtest.OK(t, ioutil.WriteFile(filename, []byte("content"), 0644))
+
+	fi, err := os.Lstat(filename)
+	rtest.OK(t, err)
+
+	fi = restic.WithInodeFlags(filename, fi)
+	ifi, ok := fi.(restic.InodeFlagsFileInfo)
+	rtest.Assert(t, ok, "inode flags were not read, got %T", fi)
+	rtest.Assert(t, restic.WithInodeFlags(filename, fi) == fi, "inode flags were read again")
+
+	// the flags which have been read before are used for the node
+	ifi.InodeFlags = restic.InodeFlagNoDump
+	flags, err := restic.InodeFlags(filename, ifi)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint32(restic.InodeFlagNoDump), flags)
+
+	node, err := restic.NodeFromFileInfo(filename, ifi)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint32(restic.InodeFlagNoDump), node.InodeFlags)
+	rtest.Equals(t, uint64(len("content")), node.Size)
+}
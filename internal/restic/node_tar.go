@@ -0,0 +1,94 @@
+package restic
+
+import (
+	"archive/tar"
+	"encoding/base64"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/quinn/restic/internal/errors"
+	"github.com/quinn/restic/internal/fs"
+)
+
+// fillTarEntry fills the node with the metadata of an entry in a tar archive.
+// Nothing is read from the local file system.
+func (node *Node) fillTarEntry(entry *fs.TarEntry) error {
+	hdr := entry.Header
+
+	node.Inode = entry.Inode
+	node.UID = uint32(hdr.Uid)
+	node.GID = uint32(hdr.Gid)
+	node.User = hdr.Uname
+	node.Group = hdr.Gname
+
+	node.AccessTime = hdr.AccessTime
+	if node.AccessTime.IsZero() {
+		node.AccessTime = node.ModTime
+	}
+	node.ChangeTime = hdr.ChangeTime
+	if node.ChangeTime.IsZero() {
+		node.ChangeTime = node.ModTime
+	}
+
+	switch node.Type {
+	case "file":
+		node.Links = entry.Links
+	case "dir":
+	case "symlink":
+		node.LinkTarget = hdr.Linkname
+		node.Links = entry.Links
+	case "dev", "chardev":
+		node.Device = mkdevLinux(uint64(hdr.Devmajor), uint64(hdr.Devminor))
+		node.Links = entry.Links
+	case "fifo":
+	default:
+		return errors.Errorf("invalid node type %q", node.Type)
+	}
+
+	return node.fillTarExtendedAttributes(hdr)
+}
+
+// fillTarExtendedAttributes sets the extended attributes from the PAX records
+// written by GNU tar ("SCHILY.xattr.") and libarchive ("LIBARCHIVE.xattr.").
+func (node *Node) fillTarExtendedAttributes(hdr *tar.Header) error {
+	if node.Type == "symlink" {
+		return nil
+	}
+
+	node.ExtendedAttributes = []ExtendedAttribute{}
+	for key, value := range hdr.PAXRecords {
+		var attr ExtendedAttribute
+		switch {
+		case strings.HasPrefix(key, "SCHILY.xattr."):
+			attr.Name = strings.TrimPrefix(key, "SCHILY.xattr.")
+			attr.Value = []byte(value)
+		case strings.HasPrefix(key, "LIBARCHIVE.xattr."):
+			name, err := url.PathUnescape(strings.TrimPrefix(key, "LIBARCHIVE.xattr."))
+			if err != nil {
+				return errors.Wrapf(err, "invalid extended attribute name %q", key)
+			}
+			buf, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(value, "="))
+			if err != nil {
+				return errors.Wrapf(err, "invalid value for extended attribute %q", name)
+			}
+			attr.Name, attr.Value = name, buf
+		default:
+			continue
+		}
+		node.ExtendedAttributes = append(node.ExtendedAttributes, attr)
+	}
+
+	sort.Slice(node.ExtendedAttributes, func(i, j int) bool {
+		return node.ExtendedAttributes[i].Name < node.ExtendedAttributes[j].Name
+	})
+
+	return nil
+}
+
+// mkdevLinux returns the device number for major and minor as encoded by
+// Linux, which is what archives usually come from.
+func mkdevLinux(major, minor uint64) uint64 {
+	return (major&0x00000fff)<<8 | (major&0xfffff000)<<32 |
+		(minor & 0x000000ff) | (minor&0xffffff00)<<12
+}
@@ -1,6 +1,8 @@
 package restic_test
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"io/ioutil"
 	"os"
@@ -9,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/quinn/restic/internal/fs"
 	"github.com/quinn/restic/internal/restic"
 	rtest "github.com/quinn/restic/internal/test"
 )
@@ -295,3 +298,60 @@ func TestFixTime(t *testing.T) {
 		})
 	}
 }
+
+func TestNodeFromTarEntry(t *testing.T) {
+	mtime := time.Unix(1500000000, 0)
+	atime := time.Unix(1500000100, 0)
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	headers := []*tar.Header{
+		{Name: "file", Typeflag: tar.TypeReg, Mode: 0640, Size: 3, ModTime: mtime, AccessTime: atime,
+			Uid: 1000, Gid: 100, Uname: "user", Gname: "users", Format: tar.FormatPAX,
+			PAXRecords: map[string]string{
+				"SCHILY.xattr.user.foo":       "bar",
+				"LIBARCHIVE.xattr.user.a%20b": "dmFsdWU=",
+			}},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "file", ModTime: mtime},
+		{Name: "null", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 3, ModTime: mtime},
+	}
+	for _, hdr := range headers {
+		rtest.OK(t, tw.WriteHeader(hdr))
+		_, err := tw.Write(make([]byte, hdr.Size))
+		rtest.OK(t, err)
+	}
+	rtest.OK(t, tw.Close())
+
+	tarFS := fs.NewTarReader(buf, time.Now())
+
+	node := func(name string) *restic.Node {
+		next, fi, err := tarFS.Next()
+		rtest.OK(t, err)
+		rtest.Equals(t, name, next)
+		node, err := restic.NodeFromFileInfo(name, fi)
+		rtest.OK(t, err)
+		return node
+	}
+
+	file := node("/file")
+	rtest.Equals(t, "file", file.Type)
+	rtest.Equals(t, os.FileMode(0640), file.Mode)
+	rtest.Equals(t, uint64(3), file.Size)
+	rtest.Equals(t, uint32(1000), file.UID)
+	rtest.Equals(t, "users", file.Group)
+	rtest.Assert(t, file.ModTime.Equal(mtime), "wrong mtime %v", file.ModTime)
+	rtest.Assert(t, file.AccessTime.Equal(atime), "wrong atime %v", file.AccessTime)
+	rtest.Assert(t, file.ChangeTime.Equal(mtime), "wrong ctime %v", file.ChangeTime)
+	rtest.Equals(t, uint64(1), file.Links)
+	rtest.Equals(t, []restic.ExtendedAttribute{
+		{Name: "user.a b", Value: []byte("value")},
+		{Name: "user.foo", Value: []byte("bar")},
+	}, file.ExtendedAttributes)
+
+	link := node("/link")
+	rtest.Equals(t, "symlink", link.Type)
+	rtest.Equals(t, "file", link.LinkTarget)
+
+	dev := node("/null")
+	rtest.Equals(t, "chardev", dev.Type)
+	rtest.Equals(t, uint64(259), dev.Device)
+}
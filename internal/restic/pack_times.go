@@ -0,0 +1,70 @@
+package restic
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/quinn/restic/internal/debug"
+)
+
+// PackTimes is the content of files which record a point in time for a list
+// of packs, e.g. tombstones and verification records.
+type PackTimes struct {
+	Packs []PackTime `json:"packs"`
+}
+
+// PackTime records a point in time for a pack.
+type PackTime struct {
+	ID   ID        `json:"id"`
+	Time time.Time `json:"time"`
+}
+
+// loadPackTimes loads all files of type t and merges the recorded times. If a
+// pack is listed several times, the time for which keep returns true when
+// compared to the other time is kept. The IDs of the files are returned in
+// files.
+func loadPackTimes(ctx context.Context, repo Repository, t FileType, keep func(a, b time.Time) bool) (packs map[ID]time.Time, files IDs, err error) {
+	packs = make(map[ID]time.Time)
+	err = repo.List(ctx, t, func(id ID, size int64) error {
+		var pt PackTimes
+		err := repo.LoadJSONUnpacked(ctx, t, id, &pt)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range pt.Packs {
+			if other, ok := packs[p.ID]; !ok || keep(p.Time, other) {
+				packs[p.ID] = p.Time
+			}
+		}
+
+		files = append(files, id)
+		return nil
+	})
+
+	// repositories created by older versions may lack the directory
+	if err != nil && repo.Backend().IsNotExist(err) {
+		debug.Log("no files of type %v found: %v", t, err)
+		return packs, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return packs, files, nil
+}
+
+// savePackTimes saves a file of type t which records packs and returns its ID.
+func savePackTimes(ctx context.Context, repo Repository, t FileType, packs map[ID]time.Time) (ID, error) {
+	var pt PackTimes
+	for id, tm := range packs {
+		pt.Packs = append(pt.Packs, PackTime{ID: id, Time: tm})
+	}
+
+	sort.Slice(pt.Packs, func(i, j int) bool {
+		return pt.Packs[i].ID.String() < pt.Packs[j].ID.String()
+	})
+
+	return repo.SaveJSONUnpacked(ctx, t, pt)
+}
@@ -23,6 +23,8 @@ type Repository interface {
 	LoadIndex(context.Context) error
 
 	Config() Config
+	// SaveConfig replaces the repository config with cfg.
+	SaveConfig(context.Context, Config) error
 
 	LookupBlobSize(ID, BlobType) (uint, bool)
 
@@ -24,6 +24,15 @@ type Snapshot struct {
 	Tags     []string  `json:"tags,omitempty"`
 	Original *ID       `json:"original,omitempty"`
 
+	// Checkpoint is set for the checkpoint snapshots saved while a backup is
+	// running, they are removed once the backup has been completed.
+	Checkpoint bool `json:"checkpoint,omitempty"`
+
+	Labels      map[string]string `json:"labels,omitempty"`
+	Description string            `json:"description,omitempty"`
+
+	Signature *SnapshotSignature `json:"signature,omitempty"`
+
 	id *ID // plaintext ID, used during restore
 }
 
@@ -142,6 +151,51 @@ func (sn *Snapshot) RemoveTags(removeTags []string) (changed bool) {
 	return
 }
 
+// SetLabels adds the given labels to the snapshot, existing labels with the
+// same keys are replaced. It returns true if any changes were made.
+func (sn *Snapshot) SetLabels(labels []Label) (changed bool) {
+	for _, label := range labels {
+		if value, ok := sn.Labels[label.Key]; ok && value == label.Value {
+			continue
+		}
+
+		if sn.Labels == nil {
+			sn.Labels = make(map[string]string)
+		}
+		sn.Labels[label.Key] = label.Value
+		changed = true
+	}
+	return
+}
+
+// RemoveLabels removes the labels with the given keys from the snapshot and
+// returns true if any changes were made.
+func (sn *Snapshot) RemoveLabels(keys []string) (changed bool) {
+	for _, key := range keys {
+		if _, ok := sn.Labels[key]; ok {
+			delete(sn.Labels, key)
+			changed = true
+		}
+	}
+
+	if len(sn.Labels) == 0 {
+		sn.Labels = nil
+	}
+	return
+}
+
+// HasLabels returns true if the snapshot has all the labels in l with the same
+// values.
+func (sn *Snapshot) HasLabels(l []Label) bool {
+	for _, label := range l {
+		if value, ok := sn.Labels[label.Key]; !ok || value != label.Value {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (sn *Snapshot) hasTag(tag string) bool {
 	for _, snTag := range sn.Tags {
 		if tag == snTag {
@@ -163,9 +217,9 @@ func (sn *Snapshot) HasTags(l []string) bool {
 }
 
 // HasTagList returns true if either
-// - the snapshot satisfies at least one TagList, so there is a TagList in l
-//   for which all tags are included in sn, or
-// - l is empty
+//   - the snapshot satisfies at least one TagList, so there is a TagList in l
+//     for which all tags are included in sn, or
+//   - l is empty
 func (sn *Snapshot) HasTagList(l []TagList) bool {
 	debug.Log("testing snapshot with tags %v against list: %v", sn.Tags, l)
 
@@ -13,8 +13,8 @@ import (
 // ErrNoSnapshotFound is returned when no snapshot for the given criteria could be found.
 var ErrNoSnapshotFound = errors.New("no snapshot found")
 
-// FindLatestSnapshot finds latest snapshot with optional target/directory, tags and hostname filters.
-func FindLatestSnapshot(ctx context.Context, repo Repository, targets []string, tagLists []TagList, hostnames []string) (ID, error) {
+// FindLatestSnapshot finds latest snapshot with optional target/directory, tags, labels and hostname filters.
+func FindLatestSnapshot(ctx context.Context, repo Repository, targets []string, tagLists []TagList, labels []Label, hostnames []string) (ID, error) {
 	var err error
 	absTargets := make([]string, 0, len(targets))
 	for _, target := range targets {
@@ -51,6 +51,10 @@ func FindLatestSnapshot(ctx context.Context, repo Repository, targets []string,
 			return nil
 		}
 
+		if !snapshot.HasLabels(labels) {
+			return nil
+		}
+
 		if !snapshot.HasPaths(absTargets) {
 			return nil
 		}
@@ -87,7 +91,7 @@ func FindSnapshot(repo Repository, s string) (ID, error) {
 
 // FindFilteredSnapshots yields Snapshots filtered from the list of all
 // snapshots.
-func FindFilteredSnapshots(ctx context.Context, repo Repository, hosts []string, tags []TagList, paths []string) (Snapshots, error) {
+func FindFilteredSnapshots(ctx context.Context, repo Repository, hosts []string, tags []TagList, labels []Label, paths []string) (Snapshots, error) {
 	results := make(Snapshots, 0, 20)
 
 	err := repo.List(ctx, SnapshotFile, func(id ID, size int64) error {
@@ -97,7 +101,7 @@ func FindFilteredSnapshots(ctx context.Context, repo Repository, hosts []string,
 			return nil
 		}
 
-		if !sn.HasHostname(hosts) || !sn.HasTagList(tags) || !sn.HasPaths(paths) {
+		if !sn.HasHostname(hosts) || !sn.HasTagList(tags) || !sn.HasLabels(labels) || !sn.HasPaths(paths) {
 			return nil
 		}
 
@@ -0,0 +1,104 @@
+package restic
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/quinn/restic/internal/errors"
+)
+
+// SnapshotSignature is an ed25519 signature of a snapshot. It covers the JSON
+// encoding of the snapshot without the signature.
+type SnapshotSignature struct {
+	PublicKey ed25519.PublicKey `json:"public_key"`
+	Signature []byte            `json:"signature"`
+}
+
+// Errors returned by VerifySignature.
+var (
+	ErrSnapshotUnsigned    = errors.New("snapshot is not signed")
+	ErrUntrustedSigningKey = errors.New("snapshot is signed by an untrusted key")
+	ErrInvalidSignature    = errors.New("snapshot signature is invalid")
+)
+
+// signedData returns the data covered by the signature of the snapshot.
+func (sn *Snapshot) signedData() ([]byte, error) {
+	unsigned := *sn
+	unsigned.Signature = nil
+
+	buf, err := json.Marshal(unsigned)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal")
+	}
+
+	return buf, nil
+}
+
+// Sign signs the snapshot with key, an existing signature is replaced.
+func (sn *Snapshot) Sign(key ed25519.PrivateKey) error {
+	if len(key) != ed25519.PrivateKeySize {
+		return errors.New("invalid signing key")
+	}
+
+	buf, err := sn.signedData()
+	if err != nil {
+		return err
+	}
+
+	sn.Signature = &SnapshotSignature{
+		PublicKey: key.Public().(ed25519.PublicKey),
+		Signature: ed25519.Sign(key, buf),
+	}
+
+	return nil
+}
+
+// VerifySignature checks that the snapshot carries a valid signature made with
+// one of the trusted keys.
+func (sn *Snapshot) VerifySignature(trusted []ed25519.PublicKey) error {
+	if sn.Signature == nil {
+		return ErrSnapshotUnsigned
+	}
+
+	var key ed25519.PublicKey
+	for _, k := range trusted {
+		if bytes.Equal(k, sn.Signature.PublicKey) {
+			key = k
+			break
+		}
+	}
+
+	if key == nil {
+		return ErrUntrustedSigningKey
+	}
+
+	buf, err := sn.signedData()
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(key, buf, sn.Signature.Signature) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// EncodePublicKey returns the textual representation of a public key.
+func EncodePublicKey(key ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// ParsePublicKey parses the textual representation of a public key as
+// returned by EncodePublicKey.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	buf, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(buf) != ed25519.PublicKeySize {
+		return nil, errors.Errorf("invalid public key %q", s)
+	}
+
+	return ed25519.PublicKey(buf), nil
+}
@@ -1,6 +1,8 @@
 package restic_test
 
 import (
+	"crypto/ed25519"
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -14,3 +16,83 @@ func TestNewSnapshot(t *testing.T) {
 	_, err := restic.NewSnapshot(paths, nil, "foo", time.Now())
 	rtest.OK(t, err)
 }
+
+func TestSnapshotSignature(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	rtest.OK(t, err)
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	rtest.OK(t, err)
+
+	sn, err := restic.NewSnapshot([]string{"/home/foobar"}, []string{"foo"}, "foo", time.Now())
+	rtest.OK(t, err)
+	tree := restic.NewRandomID()
+	sn.Tree = &tree
+
+	rtest.Equals(t, restic.ErrSnapshotUnsigned, sn.VerifySignature([]ed25519.PublicKey{pub}))
+
+	rtest.OK(t, sn.Sign(key))
+
+	// the signature must survive saving and loading the snapshot
+	buf, err := json.Marshal(sn)
+	rtest.OK(t, err)
+
+	var loaded restic.Snapshot
+	rtest.OK(t, json.Unmarshal(buf, &loaded))
+
+	rtest.OK(t, loaded.VerifySignature([]ed25519.PublicKey{otherPub, pub}))
+	rtest.Equals(t, restic.ErrUntrustedSigningKey, loaded.VerifySignature([]ed25519.PublicKey{otherPub}))
+	rtest.Equals(t, restic.ErrUntrustedSigningKey, loaded.VerifySignature(nil))
+
+	loaded.Tags = append(loaded.Tags, "bar")
+	rtest.Equals(t, restic.ErrInvalidSignature, loaded.VerifySignature([]ed25519.PublicKey{pub}))
+
+	// signing again replaces the old signature
+	rtest.OK(t, loaded.Sign(key))
+	rtest.OK(t, loaded.VerifySignature([]ed25519.PublicKey{pub}))
+}
+
+func TestParsePublicKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	rtest.OK(t, err)
+
+	parsed, err := restic.ParsePublicKey(restic.EncodePublicKey(pub))
+	rtest.OK(t, err)
+	rtest.Equals(t, pub, parsed)
+
+	for _, s := range []string{"", "foo", restic.EncodePublicKey(pub[:10])} {
+		_, err = restic.ParsePublicKey(s)
+		if err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestSnapshotLabels(t *testing.T) {
+	sn, err := restic.NewSnapshot([]string{"/home/foobar"}, nil, "foo", time.Now())
+	rtest.OK(t, err)
+
+	var labels restic.LabelList
+	rtest.OK(t, labels.Set("ticket=OPS-1234"))
+	rtest.OK(t, labels.Set("commit=abc=def"))
+	rtest.OK(t, labels.Set("empty="))
+	for _, s := range []string{"ticket", "=value", " =value"} {
+		rtest.Assert(t, labels.Set(s) != nil, "invalid label %q was accepted", s)
+	}
+	rtest.Equals(t, "[ticket=OPS-1234, commit=abc=def, empty=]", labels.String())
+
+	rtest.Assert(t, sn.SetLabels(labels), "setting labels did not change the snapshot")
+	rtest.Assert(t, !sn.SetLabels(labels[:1]), "setting an existing label changed the snapshot")
+	rtest.Equals(t, map[string]string{"ticket": "OPS-1234", "commit": "abc=def", "empty": ""}, sn.Labels)
+
+	rtest.Assert(t, sn.HasLabels(nil), "snapshot does not match an empty list of labels")
+	rtest.Assert(t, sn.HasLabels(labels), "snapshot does not have its labels")
+	rtest.Assert(t, !sn.HasLabels([]restic.Label{{Key: "ticket", Value: "OPS-1"}}), "snapshot matches a different value")
+	rtest.Assert(t, !sn.HasLabels([]restic.Label{{Key: "missing", Value: ""}}), "snapshot matches a missing label")
+
+	rtest.Assert(t, sn.SetLabels([]restic.Label{{Key: "ticket", Value: "OPS-1"}}), "replacing a label did not change the snapshot")
+	rtest.Equals(t, "OPS-1", sn.Labels["ticket"])
+
+	rtest.Assert(t, !sn.RemoveLabels([]string{"missing"}), "removing a missing label changed the snapshot")
+	rtest.Assert(t, sn.RemoveLabels([]string{"ticket", "commit", "empty"}), "removing labels did not change the snapshot")
+	rtest.Assert(t, sn.Labels == nil, "expected no labels, got %v", sn.Labels)
+}
@@ -0,0 +1,25 @@
+package restic
+
+import (
+	"context"
+	"time"
+)
+
+// Packs which are no longer needed are not deleted by prune right away, they
+// are marked for deletion in a tombstone file instead. A marked pack is not
+// referenced by the index any more, it is only deleted by a later run of prune
+// after a grace period has passed. This gives clients which uploaded a pack
+// but did not yet save the index for it the chance to finish, if the pack is
+// referenced again in the meantime it is resurrected.
+
+// LoadTombstones loads all tombstone files in the repository and returns the
+// marked packs together with the time they were marked first. The IDs of the
+// tombstone files are returned in files.
+func LoadTombstones(ctx context.Context, repo Repository) (packs map[ID]time.Time, files IDs, err error) {
+	return loadPackTimes(ctx, repo, TombstoneFile, time.Time.Before)
+}
+
+// SaveTombstone saves a tombstone file for packs and returns its ID.
+func SaveTombstone(ctx context.Context, repo Repository, packs map[ID]time.Time) (ID, error) {
+	return savePackTimes(ctx, repo, TombstoneFile, packs)
+}
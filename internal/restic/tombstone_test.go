@@ -0,0 +1,38 @@
+package restic_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/quinn/restic/internal/repository"
+	"github.com/quinn/restic/internal/restic"
+	rtest "github.com/quinn/restic/internal/test"
+)
+
+func TestTombstone(t *testing.T) {
+	repo, cleanup := repository.TestRepository(t)
+	defer cleanup()
+
+	packs, files, err := restic.LoadTombstones(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(packs))
+	rtest.Equals(t, 0, len(files))
+
+	id1, id2 := restic.NewRandomID(), restic.NewRandomID()
+	t1 := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+
+	_, err = restic.SaveTombstone(context.TODO(), repo, map[restic.ID]time.Time{id1: t2, id2: t2})
+	rtest.OK(t, err)
+	_, err = restic.SaveTombstone(context.TODO(), repo, map[restic.ID]time.Time{id1: t1})
+	rtest.OK(t, err)
+
+	// a pack marked several times keeps the earliest time
+	packs, files, err = restic.LoadTombstones(context.TODO(), repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(files))
+	rtest.Equals(t, 2, len(packs))
+	rtest.Assert(t, packs[id1].Equal(t1), "wrong time for pack 1: %v", packs[id1])
+	rtest.Assert(t, packs[id2].Equal(t2), "wrong time for pack 2: %v", packs[id2])
+}
@@ -0,0 +1,18 @@
+package restic
+
+import (
+	"context"
+	"time"
+)
+
+// LoadVerifications loads all verification files in the repository and
+// returns the time the data of each pack was last read and verified by check.
+// The IDs of the verification files are returned in files.
+func LoadVerifications(ctx context.Context, repo Repository) (packs map[ID]time.Time, files IDs, err error) {
+	return loadPackTimes(ctx, repo, VerificationFile, time.Time.After)
+}
+
+// SaveVerification saves a verification file for packs and returns its ID.
+func SaveVerification(ctx context.Context, repo Repository, packs map[ID]time.Time) (ID, error) {
+	return savePackTimes(ctx, repo, VerificationFile, packs)
+}
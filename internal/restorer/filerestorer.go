@@ -5,6 +5,7 @@ import (
 	"context"
 	"io"
 	"math"
+	"os"
 	"path/filepath"
 	"sync"
 
@@ -34,6 +35,7 @@ type fileInfo struct {
 	lock     sync.Mutex
 	flags    int
 	location string      // file on local filesystem relative to restorer basedir
+	size     int64       // size of the file
 	blobs    interface{} // blobs of the file
 }
 
@@ -74,8 +76,8 @@ func newFileRestorer(dst string,
 	}
 }
 
-func (r *fileRestorer) addFile(location string, content restic.IDs) {
-	r.files = append(r.files, &fileInfo{location: location, blobs: content})
+func (r *fileRestorer) addFile(location string, content restic.IDs, size int64) {
+	r.files = append(r.files, &fileInfo{location: location, size: size, blobs: content})
 }
 
 func (r *fileRestorer) targetPath(location string) string {
@@ -169,6 +171,28 @@ func (r *fileRestorer) restoreFiles(ctx context.Context) error {
 	close(downloadCh)
 	wg.Wait()
 
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// blobs which only contain zeros are not written, so files ending with
+	// a hole must be extended to their size
+	for _, file := range r.files {
+		if file.flags&fileError != 0 || file.size == 0 {
+			continue
+		}
+
+		target := r.targetPath(file.location)
+		fi, err := os.Stat(target)
+		if err == nil && fi.Size() < file.size {
+			err = os.Truncate(target, file.size)
+		}
+		if err != nil {
+			debug.Log("unable to extend %v to %d bytes: %v", target, file.size, err)
+			file.flags |= fileError
+		}
+	}
+
 	return nil
 }
 
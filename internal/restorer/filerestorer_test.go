@@ -126,7 +126,7 @@ func newTestRepo(content []TestFile) *TestRepo {
 		for _, blob := range file.blobs {
 			content = append(content, restic.Hash([]byte(blob.data)))
 		}
-		files = append(files, &fileInfo{location: file.name, blobs: content})
+		files = append(files, &fileInfo{location: file.name, size: int64(len(filesPathToContent[file.name])), blobs: content})
 	}
 
 	repo := &TestRepo{
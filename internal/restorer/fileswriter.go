@@ -1,6 +1,7 @@
 package restorer
 
 import (
+	"bytes"
 	"os"
 	"sync"
 
@@ -81,7 +82,12 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 		return err
 	}
 
-	_, err = wr.WriteAt(blob, offset)
+	// holes in sparse files are recreated by not writing blobs which only
+	// contain zeros, the file is extended to its size after all blobs have
+	// been written
+	if !isZero(blob) {
+		_, err = wr.WriteAt(blob, offset)
+	}
 
 	if err != nil {
 		releaseWriter(wr)
@@ -90,3 +96,20 @@ func (w *filesWriter) writeToFile(path string, blob []byte, offset int64, create
 
 	return releaseWriter(wr)
 }
+
+var zeroBlock [4096]byte
+
+// isZero returns true if buf only contains zeros.
+func isZero(buf []byte) bool {
+	for len(buf) > 0 {
+		n := len(buf)
+		if n > len(zeroBlock) {
+			n = len(zeroBlock)
+		}
+		if !bytes.Equal(buf[:n], zeroBlock[:n]) {
+			return false
+		}
+		buf = buf[n:]
+	}
+	return true
+}
@@ -239,7 +239,7 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 				idx.Add(node.Inode, node.DeviceID, location)
 			}
 
-			filerestorer.addFile(location, node.Content)
+			filerestorer.addFile(location, node.Content, int64(node.Size))
 
 			return nil
 		},
@@ -254,10 +254,22 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 		return err
 	}
 
+	// the inode flags are restored last, the immutable and append-only flags
+	// prevent creating hard links and restoring the metadata of directories
+	type flaggedNode struct {
+		node             *restic.Node
+		target, location string
+	}
+	var flagged []flaggedNode
+
 	// second tree pass: restore special files and filesystem metadata
-	return res.traverseTree(ctx, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
+	err = res.traverseTree(ctx, dst, string(filepath.Separator), *res.sn.Tree, treeVisitor{
 		enterDir: noop,
 		visitNode: func(node *restic.Node, target, location string) error {
+			if node.InodeFlags != 0 {
+				flagged = append(flagged, flaggedNode{node, target, location})
+			}
+
 			if node.Type != "file" {
 				return res.restoreNodeTo(ctx, node, target, location)
 			}
@@ -276,8 +288,29 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 
 			return res.restoreNodeMetadataTo(node, target, location)
 		},
-		leaveDir: restoreNodeMetadata,
+		leaveDir: func(node *restic.Node, target, location string) error {
+			if node.InodeFlags != 0 {
+				flagged = append(flagged, flaggedNode{node, target, location})
+			}
+
+			return restoreNodeMetadata(node, target, location)
+		},
 	})
+	if err != nil {
+		return err
+	}
+
+	for _, item := range flagged {
+		err = item.node.RestoreInodeFlags(item.target)
+		if err != nil {
+			debug.Log("node.RestoreInodeFlags(%s) error %v", item.target, err)
+			if err = res.Error(item.location, err); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
 }
 
 // Snapshot returns the snapshot this restorer is configured to use.
@@ -6,6 +6,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
 
@@ -59,3 +60,34 @@ func TestRestorerRestoreEmptyHardlinkedFileds(t *testing.T) {
 		rtest.Equals(t, s1.Ino, s2.Ino)
 	}
 }
+
+func TestFileRestorerSparse(t *testing.T) {
+	tempdir, cleanup := rtest.TempDir(t)
+	defer cleanup()
+
+	zeros := strings.Repeat("\x00", 1<<20)
+	restoreAndVerify(t, tempdir, []TestFile{
+		{
+			name: "sparse",
+			blobs: []TestBlob{
+				{"data", "pack1"},
+				{zeros, "pack1"},
+				{zeros, "pack1"},
+				{"more data", "pack2"},
+				{zeros, "pack2"},
+			},
+		},
+		{
+			name:  "zeros",
+			blobs: []TestBlob{{zeros, "pack1"}},
+		},
+	})
+
+	// the zero blobs are not written, so the files only use a few blocks
+	for _, name := range []string{"sparse", "zeros"} {
+		fi, err := os.Stat(filepath.Join(tempdir, name))
+		rtest.OK(t, err)
+		stat := fi.Sys().(*syscall.Stat_t)
+		rtest.Assert(t, stat.Blocks*512 < 1<<20, "%v is not sparse, %d blocks used", name, stat.Blocks)
+	}
+}
@@ -30,9 +30,10 @@ type Backup struct {
 
 	MinUpdatePause time.Duration
 
-	term  *termstatus.Terminal
-	v     uint
-	start time.Time
+	term   *termstatus.Terminal
+	v      uint
+	start  time.Time
+	dryRun bool
 
 	totalBytes uint64
 
@@ -290,7 +291,7 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 
 	if current.Type == "dir" {
 		if previous == nil {
-			b.VV("new       %v, saved in %.3fs (%v added, %v metadata)", item, d.Seconds(), formatBytes(s.DataSize), formatBytes(s.TreeSize))
+			b.VV("new       %v, %v", item, b.added(d, s, true))
 			b.summary.Lock()
 			b.summary.Dirs.New++
 			b.summary.Unlock()
@@ -303,7 +304,7 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 			b.summary.Dirs.Unchanged++
 			b.summary.Unlock()
 		} else {
-			b.VV("modified  %v, saved in %.3fs (%v added, %v metadata)", item, d.Seconds(), formatBytes(s.DataSize), formatBytes(s.TreeSize))
+			b.VV("modified  %v, %v", item, b.added(d, s, true))
 			b.summary.Lock()
 			b.summary.Dirs.Changed++
 			b.summary.Unlock()
@@ -317,7 +318,7 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 		}
 
 		if previous == nil {
-			b.VV("new       %v, saved in %.3fs (%v added)", item, d.Seconds(), formatBytes(s.DataSize))
+			b.VV("new       %v, %v", item, b.added(d, s, false))
 			b.summary.Lock()
 			b.summary.Files.New++
 			b.summary.Unlock()
@@ -330,7 +331,7 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 			b.summary.Files.Unchanged++
 			b.summary.Unlock()
 		} else {
-			b.VV("modified  %v, saved in %.3fs (%v added)", item, d.Seconds(), formatBytes(s.DataSize))
+			b.VV("modified  %v, %v", item, b.added(d, s, false))
 			b.summary.Lock()
 			b.summary.Files.Changed++
 			b.summary.Unlock()
@@ -338,6 +339,21 @@ func (b *Backup) CompleteItem(item string, previous, current *restic.Node, s arc
 	}
 }
 
+// added describes the data added to the repository for an item, metadata is
+// only included for directories.
+func (b *Backup) added(d time.Duration, s archiver.ItemStats, metadata bool) string {
+	switch {
+	case b.dryRun && metadata:
+		return fmt.Sprintf("would add %v, %v metadata", formatBytes(s.DataSize), formatBytes(s.TreeSize))
+	case b.dryRun:
+		return fmt.Sprintf("would add %v", formatBytes(s.DataSize))
+	case metadata:
+		return fmt.Sprintf("saved in %.3fs (%v added, %v metadata)", d.Seconds(), formatBytes(s.DataSize), formatBytes(s.TreeSize))
+	default:
+		return fmt.Sprintf("saved in %.3fs (%v added)", d.Seconds(), formatBytes(s.DataSize))
+	}
+}
+
 // ReportTotal sets the total stats up to now
 func (b *Backup) ReportTotal(item string, s archiver.ScanStats) {
 	select {
@@ -356,7 +372,7 @@ func (b *Backup) ReportTotal(item string, s archiver.ScanStats) {
 }
 
 // Finish prints the finishing messages.
-func (b *Backup) Finish(snapshotID restic.ID) {
+func (b *Backup) Finish(snapshotID restic.ID, skipped bool) {
 	close(b.finished)
 
 	b.P("\n")
@@ -364,13 +380,29 @@ func (b *Backup) Finish(snapshotID restic.ID) {
 	b.P("Dirs:        %5d new, %5d changed, %5d unmodified\n", b.summary.Dirs.New, b.summary.Dirs.Changed, b.summary.Dirs.Unchanged)
 	b.V("Data Blobs:  %5d new\n", b.summary.ItemStats.DataBlobs)
 	b.V("Tree Blobs:  %5d new\n", b.summary.ItemStats.TreeBlobs)
-	b.P("Added to the repo: %-5s\n", formatBytes(b.summary.ItemStats.DataSize+b.summary.ItemStats.TreeSize))
+	if b.dryRun {
+		b.P("Would add to the repo: %-5s\n", formatBytes(b.summary.ItemStats.DataSize+b.summary.ItemStats.TreeSize))
+	} else {
+		b.P("Added to the repo: %-5s\n", formatBytes(b.summary.ItemStats.DataSize+b.summary.ItemStats.TreeSize))
+	}
 	b.P("\n")
 	b.P("processed %v files, %v in %s",
 		b.summary.Files.New+b.summary.Files.Changed+b.summary.Files.Unchanged,
 		formatBytes(b.summary.ProcessedBytes),
 		formatDuration(time.Since(b.start)),
 	)
+	if skipped {
+		b.P("nothing changed since the parent snapshot, snapshot skipped\n")
+	}
+	if b.dryRun {
+		b.P("dry run, no snapshot was saved\n")
+	}
+}
+
+// SetDryRun marks the backup as a dry run, the summary then reports what
+// would have been added to the repository.
+func (b *Backup) SetDryRun() {
+	b.dryRun = true
 }
 
 // SetMinUpdatePause sets b.MinUpdatePause. It satisfies the
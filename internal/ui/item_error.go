@@ -0,0 +1,97 @@
+package ui
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/quinn/restic/internal/errors"
+)
+
+// Classes of the errors which occur while reading the items of a backup.
+const (
+	ErrorNotFound         = "not_found"
+	ErrorPermissionDenied = "permission_denied"
+	ErrorIO               = "io_error"
+	ErrorOther            = "other"
+)
+
+// ItemError describes an error which occurred for an item, e.g. a file which
+// could not be read.
+type ItemError struct {
+	Item      string `json:"item"`
+	During    string `json:"during"`
+	Operation string `json:"operation,omitempty"`
+	Class     string `json:"class"`
+	Errno     uint64 `json:"errno,omitempty"`
+	Message   string `json:"message"`
+}
+
+// NewItemError returns the description of err, which occurred for item during
+// the given phase of the backup (e.g. "scan" or "archival"). The operation and
+// the error number are taken from the underlying error returned by the
+// operating system, if any.
+func NewItemError(item, during string, err error) ItemError {
+	e := ItemError{
+		Item:    item,
+		During:  during,
+		Class:   ErrorOther,
+		Message: err.Error(),
+	}
+
+	cause := errors.Cause(err)
+	for inner := cause; inner != nil; {
+		switch v := inner.(type) {
+		case *os.PathError:
+			e.Operation = v.Op
+			inner = v.Err
+		case *os.LinkError:
+			e.Operation = v.Op
+			inner = v.Err
+		case *os.SyscallError:
+			if e.Operation == "" {
+				e.Operation = v.Syscall
+			}
+			inner = v.Err
+		case syscall.Errno:
+			e.Errno = uint64(v)
+			inner = nil
+		default:
+			inner = nil
+		}
+	}
+
+	switch {
+	case os.IsNotExist(cause):
+		e.Class = ErrorNotFound
+	case os.IsPermission(cause):
+		e.Class = ErrorPermissionDenied
+	case e.Errno != 0 && syscall.Errno(e.Errno) == syscall.EIO:
+		e.Class = ErrorIO
+	}
+
+	return e
+}
+
+// ErrorLog writes item errors to a file as JSON, one object per line. It is
+// safe for concurrent use.
+type ErrorLog struct {
+	m   sync.Mutex
+	enc *json.Encoder
+}
+
+// NewErrorLog returns an error log which writes to wr.
+func NewErrorLog(wr io.Writer) *ErrorLog {
+	return &ErrorLog{enc: json.NewEncoder(wr)}
+}
+
+// Add writes the description of err, which occurred for item during the given
+// phase of the backup, to the log.
+func (l *ErrorLog) Add(item, during string, err error) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.enc.Encode(NewItemError(item, during, err))
+}
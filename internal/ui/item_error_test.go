@@ -0,0 +1,116 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quinn/restic/internal/errors"
+)
+
+func TestNewItemError(t *testing.T) {
+	var tests = []struct {
+		err  error
+		want ItemError
+	}{
+		{
+			err: errors.Wrap(&os.PathError{Op: "open", Path: "/foo", Err: syscall.ENOENT}, "Open"),
+			want: ItemError{
+				Operation: "open",
+				Class:     ErrorNotFound,
+				Errno:     uint64(syscall.ENOENT),
+			},
+		},
+		{
+			err: &os.PathError{Op: "lstat", Path: "/foo", Err: syscall.EACCES},
+			want: ItemError{
+				Operation: "lstat",
+				Class:     ErrorPermissionDenied,
+				Errno:     uint64(syscall.EACCES),
+			},
+		},
+		{
+			err: errors.WithMessage(&os.PathError{Op: "read", Path: "/foo", Err: syscall.EIO}, "read failed"),
+			want: ItemError{
+				Operation: "read",
+				Class:     ErrorIO,
+				Errno:     uint64(syscall.EIO),
+			},
+		},
+		{
+			err: &os.SyscallError{Syscall: "getxattr", Err: syscall.EIO},
+			want: ItemError{
+				Operation: "getxattr",
+				Class:     ErrorIO,
+				Errno:     uint64(syscall.EIO),
+			},
+		},
+		{
+			err: errors.New("file changed type"),
+			want: ItemError{
+				Class: ErrorOther,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			test.want.Item = "/foo"
+			test.want.During = "archival"
+			test.want.Message = test.err.Error()
+
+			got := NewItemError("/foo", "archival", test.err)
+			if !cmp.Equal(test.want, got) {
+				t.Error(cmp.Diff(test.want, got))
+			}
+		})
+	}
+}
+
+func TestErrorLog(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := NewErrorLog(buf)
+
+	pathErr := &os.PathError{Op: "open", Path: "/foo", Err: syscall.ENOENT}
+	err := log.Add("/foo", "archival", pathErr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = log.Add("/bar", "archival", errors.New("file changed type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var items []ItemError
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var item ItemError
+		if err := dec.Decode(&item); err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, item)
+	}
+
+	want := []ItemError{
+		{
+			Item:      "/foo",
+			During:    "archival",
+			Operation: "open",
+			Class:     ErrorNotFound,
+			Errno:     uint64(syscall.ENOENT),
+			Message:   pathErr.Error(),
+		},
+		{
+			Item:    "/bar",
+			During:  "archival",
+			Class:   ErrorOther,
+			Message: "file changed type",
+		},
+	}
+	if !cmp.Equal(want, items) {
+		t.Error(cmp.Diff(want, items))
+	}
+}
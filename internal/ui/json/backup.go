@@ -31,9 +31,10 @@ type Backup struct {
 
 	MinUpdatePause time.Duration
 
-	term  *termstatus.Terminal
-	v     uint
-	start time.Time
+	term   *termstatus.Terminal
+	v      uint
+	start  time.Time
+	dryRun bool
 
 	totalBytes uint64
 
@@ -51,6 +52,7 @@ type Backup struct {
 			Unchanged uint
 		}
 		ProcessedBytes uint64
+		Errors         uint
 		archiver.ItemStats
 	}
 }
@@ -183,23 +185,16 @@ func (b *Backup) update(total, processed counter, errors uint, currentFiles map[
 // ScannerError is the error callback function for the scanner, it prints the
 // error in verbose mode and returns nil.
 func (b *Backup) ScannerError(item string, fi os.FileInfo, err error) error {
-	b.error(errorUpdate{
-		MessageType: "error",
-		Error:       err,
-		During:      "scan",
-		Item:        item,
-	})
+	b.error(newErrorUpdate(item, "scan", err))
 	return nil
 }
 
 // Error is the error callback function for the archiver, it prints the error and returns nil.
 func (b *Backup) Error(item string, fi os.FileInfo, err error) error {
-	b.error(errorUpdate{
-		MessageType: "error",
-		Error:       err,
-		During:      "archival",
-		Item:        item,
-	})
+	b.error(newErrorUpdate(item, "archival", err))
+	b.summary.Lock()
+	b.summary.Errors++
+	b.summary.Unlock()
 	b.errCh <- struct{}{}
 	return nil
 }
@@ -364,8 +359,15 @@ func (b *Backup) ReportTotal(item string, s archiver.ScanStats) {
 }
 
 // Finish prints the finishing messages.
-func (b *Backup) Finish(snapshotID restic.ID) {
+func (b *Backup) Finish(snapshotID restic.ID, skipped bool) {
 	close(b.finished)
+
+	// no snapshot is saved in a dry run or if it was skipped
+	id := snapshotID.Str()
+	if b.dryRun || skipped {
+		id = ""
+	}
+
 	b.print(summaryOutput{
 		MessageType:         "summary",
 		FilesNew:            b.summary.Files.New,
@@ -380,10 +382,19 @@ func (b *Backup) Finish(snapshotID restic.ID) {
 		TotalFilesProcessed: b.summary.Files.New + b.summary.Files.Changed + b.summary.Files.Unchanged,
 		TotalBytesProcessed: b.summary.ProcessedBytes,
 		TotalDuration:       time.Since(b.start).Seconds(),
-		SnapshotID:          snapshotID.Str(),
+		ErrorCount:          b.summary.Errors,
+		SnapshotID:          id,
+		DryRun:              b.dryRun,
+		SnapshotSkipped:     skipped,
 	})
 }
 
+// SetDryRun marks the backup as a dry run, no snapshot ID is reported in the
+// summary.
+func (b *Backup) SetDryRun() {
+	b.dryRun = true
+}
+
 // SetMinUpdatePause sets b.MinUpdatePause. It satisfies the
 // ArchiveProgressReporter interface.
 func (b *Backup) SetMinUpdatePause(d time.Duration) {
@@ -403,11 +414,33 @@ type statusUpdate struct {
 	CurrentFiles     []string `json:"current_files,omitempty"`
 }
 
+type errorMessage struct {
+	Message string `json:"message"`
+}
+
 type errorUpdate struct {
-	MessageType string `json:"message_type"` // "error"
-	Error       error  `json:"error"`
-	During      string `json:"during"`
-	Item        string `json:"item"`
+	MessageType string       `json:"message_type"` // "error"
+	Error       errorMessage `json:"error"`
+	During      string       `json:"during"`
+	Item        string       `json:"item"`
+	Operation   string       `json:"operation,omitempty"`
+	Class       string       `json:"class"`
+	Errno       uint64       `json:"errno,omitempty"`
+}
+
+// newErrorUpdate returns the message for err, which occurred for item during
+// the given phase of the backup.
+func newErrorUpdate(item, during string, err error) errorUpdate {
+	e := ui.NewItemError(item, during, err)
+	return errorUpdate{
+		MessageType: "error",
+		Error:       errorMessage{Message: e.Message},
+		During:      e.During,
+		Item:        e.Item,
+		Operation:   e.Operation,
+		Class:       e.Class,
+		Errno:       e.Errno,
+	}
 }
 
 type verboseUpdate struct {
@@ -434,5 +467,8 @@ type summaryOutput struct {
 	TotalFilesProcessed uint    `json:"total_files_processed"`
 	TotalBytesProcessed uint64  `json:"total_bytes_processed"`
 	TotalDuration       float64 `json:"total_duration"` // in seconds
-	SnapshotID          string  `json:"snapshot_id"`
+	ErrorCount          uint    `json:"error_count"`
+	SnapshotID          string  `json:"snapshot_id,omitempty"`
+	DryRun              bool    `json:"dry_run,omitempty"`
+	SnapshotSkipped     bool    `json:"snapshot_skipped,omitempty"`
 }